@@ -1,45 +1,116 @@
 package lis1a2
 
 import (
-	"bytes"
 	"context"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/therealriteshkudalkar/lis1a2/astm"
 	"github.com/therealriteshkudalkar/lis1a2/connection"
 	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
 )
 
+// ReceivedMessage is a message assembled by the receiver after EOT, its frames checksum verified,
+// or the error which made it fail
+type ReceivedMessage struct {
+	// Text is the message, one record per line, empty when Err is set
+	Text string
+	// Err is the error which made the message fail, like a frame number out of sequence or ErrReceiveTimeout
+	Err error
+	// Context carries the span of the reception when an ExchangeTracer is set, so that the processing
+	// downstream continues its trace, it is nil otherwise
+	Context context.Context
+	// Fingerprint identifies the message when duplicates are detected, see SetDuplicateOptions, so that the
+	// application can store it idempotently
+	Fingerprint string
+	// Duplicate tells that the message was received within the window of DuplicateOptions already
+	Duplicate bool
+	// pipeline is the record middleware of the connection, run by Parse
+	pipeline astm.Pipeline
+}
+
+// Parse parses the text of the message into typed records, see astm.ParseMessage, running the record
+// middleware of the connection on them
+func (message ReceivedMessage) Parse() (*astm.Message, error) {
+	return message.ParseWith(astm.ParseOptions{})
+}
+
+// ParseWith parses the text of the message like Parse with the options given, the record middleware of
+// the connection running after the pipeline of the options
+func (message ReceivedMessage) ParseWith(options astm.ParseOptions) (*astm.Message, error) {
+	if message.Err != nil {
+		return nil, message.Err
+	}
+	options.Pipeline = options.Pipeline.Then(message.pipeline...)
+	return astm.ParseMessage(message.Text, options)
+}
+
+// ASTMConnection runs the LIS1-A2 protocol over a Connection, receiving the messages of the peer with Listen
+// and sending messages with SendMessage. It is safe for concurrent use: a single go routine runs Listen while
+// any number of go routines send, their messages sent one after the other. The line is taken by one side at a
+// time, a message sent while one is being received fails with ErrBusy. The handlers and hooks run on the
+// go routine running Listen, a handler sending a message has to do it from another go routine, as
+// the replies to it are read by Listen.
 type ASTMConnection struct {
 	connection                connection.Connection
-	incomingMessage           chan string
+	incomingMessage           chan ReceivedMessage
 	status                    constants.LIS1A2ConnectionStatus
-	frameNumber               int
-	ackChan                   chan bool
-	buffer                    []byte
-	recordBuffer              string
-	messageBuffer             string
+	statusMutex               sync.Mutex
+	sender                    *Sender
+	receiver                  *Receiver
+	ackChan                   chan byte
 	numberOfConnectionRetries int
 	internalCtx               context.Context
 	internalCtxCancelFunc     context.CancelFunc
+	connected                 bool
+	connectedMutex            sync.Mutex
+	sendMutex                 sync.Mutex
+	shuttingDown              atomic.Bool
 	saveIncomingMessage       bool
 	incomingMessageSaveDir    string
+	hooks                     hooks
+	metrics                   linkMetrics
+	exchangeTracer            ExchangeTracer
+	reception                 *exchangeInProgress
+	onMessage                 func(message ReceivedMessage)
+	queryHandler              *queryHandler
+	queue                     *sendQueue
+	charset                   astm.Charset
+	journal                   Journal
+	health                    HealthOptions
+	inbound                   []FrameInterceptor
+	outbound                  []FrameInterceptor
+	pipeline                  astm.Pipeline
+	duplicates                *duplicateDetector
+	events                    eventBus
+	// progress is the phase of the protocol reported by the sender and the receiver, guarded by statusMutex
+	progress ProtocolState
 }
 
 func NewASTMConnection(conn connection.Connection, saveIncomingMessage bool, incomingMessageSaveDir ...string) *ASTMConnection {
 	astmConn := &ASTMConnection{
 		connection:                conn,
 		status:                    constants.Idle,
-		buffer:                    make([]byte, 0),
-		recordBuffer:              "",
-		messageBuffer:             "",
-		frameNumber:               0,
 		numberOfConnectionRetries: 0,
+		ackChan:                   make(chan byte, 1),
+		incomingMessage:           make(chan ReceivedMessage, 1),
+		queue:                     newSendQueue(),
+		health:                    healthOptionsWithDefaults(HealthOptions{}),
+	}
+	astmConn.internalCtx, astmConn.internalCtxCancelFunc = context.WithCancel(context.Background())
+	astmConn.sender = &Sender{link: astmConn, options: senderOptionsWithDefaults(nil)}
+	astmConn.receiver = &Receiver{
+		link:      astmConn,
+		options:   receiverOptionsWithDefaults(nil),
+		onMessage: astmConn.messageReceived,
+		buffer:    make([]byte, 0),
 	}
 	if saveIncomingMessage && len(incomingMessageSaveDir) > 0 {
 		astmConn.saveIncomingMessage = true
@@ -52,29 +123,82 @@ func NewASTMConnection(conn connection.Connection, saveIncomingMessage bool, inc
 
 // Connect runs connect method of underlying Connection object
 func (astmConn *ASTMConnection) Connect() error {
+	return astmConn.ConnectWithContext(context.Background())
+}
+
+// ConnectWithContext connects like Connect, giving up with ctx.Err() once ctx is done. The attempts
+// to connect are left to finish unless the Connection has a ConnectWithContext method too, like
+// the TCPConnection, which is then given ctx.
+func (astmConn *ASTMConnection) ConnectWithContext(ctx context.Context) error {
+	connect := astmConn.connection.Connect
+	if contextConnector, ok := (astmConn.connection).(interface{ ConnectWithContext(context.Context) error }); ok {
+		connect = func() error { return contextConnector.ConnectWithContext(ctx) }
+	}
 	astmConn.numberOfConnectionRetries = 0
-	err := astmConn.connection.Connect()
+	err := connect()
 	for err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		astmConn.numberOfConnectionRetries += 1
 		if astmConn.numberOfConnectionRetries > constants.MaxConnectionRetires {
 			return err
 		}
-		err = astmConn.connection.Connect()
+		err = connect()
 	}
+	astmConn.connectedMutex.Lock()
+	defer astmConn.connectedMutex.Unlock()
+	// the go routines the previous Listen started stop with its context, so that only one sends the queue
+	astmConn.internalCtxCancelFunc()
 	astmConn.internalCtx, astmConn.internalCtxCancelFunc = context.WithCancel(context.Background())
-	astmConn.ackChan = make(chan bool, 1)
-	astmConn.incomingMessage = make(chan string, 1)
+	astmConn.ackChan = make(chan byte, 1)
+	astmConn.incomingMessage = make(chan ReceivedMessage, 1)
+	astmConn.connected = true
+	astmConn.shuttingDown.Store(false)
+	astmConn.connectionMade()
 	return nil
 }
 
-// Disconnect runs the disconnect method of underlying Connection object and also closes channels
+// Shutdown stops accepting messages to send, waits for the message being sent to be ACKed and
+// terminated with EOT, and disconnects, letting the connection send what it has queued if it
+// supports it. When ctx is done first it disconnects right away and returns ctx.Err().
+func (astmConn *ASTMConnection) Shutdown(ctx context.Context) error {
+	astmConn.shuttingDown.Store(true)
+	idle := make(chan struct{})
+	go func() {
+		astmConn.sendMutex.Lock()
+		astmConn.sendMutex.Unlock()
+		close(idle)
+	}()
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if shutdowner, ok := (astmConn.connection).(interface{ Shutdown(context.Context) error }); ok && err == nil {
+		err = shutdowner.Shutdown(ctx)
+	}
+	if disconnectErr := astmConn.Disconnect(); err == nil {
+		err = disconnectErr
+	}
+	return err
+}
+
+// Disconnect runs the disconnect method of underlying Connection object and stops Listen, WaitForACK and ReadMessage.
+// It is safe to call Disconnect more than once and from multiple goroutines, only the first call has an effect.
 func (astmConn *ASTMConnection) Disconnect() error {
-	close(astmConn.incomingMessage)
-	close(astmConn.ackChan)
-	if err := (astmConn.connection).Disconnect(); err != nil {
-		return err
+	astmConn.connectedMutex.Lock()
+	defer astmConn.connectedMutex.Unlock()
+	if !astmConn.connected {
+		return nil
 	}
-	return nil
+	astmConn.connected = false
+	// the channels are left open, the Listen go routine may still be delivering on them
+	astmConn.internalCtxCancelFunc()
+	err := (astmConn.connection).Disconnect()
+	astmConn.connectionLost(nil)
+	return err
 }
 
 func (astmConn *ASTMConnection) IsConnected() bool {
@@ -82,79 +206,233 @@ func (astmConn *ASTMConnection) IsConnected() bool {
 }
 
 func (astmConn *ASTMConnection) ChangeStatus(status constants.LIS1A2ConnectionStatus) {
-	astmConn.status = status
+	astmConn.setStatus(status)
 }
 
+// WaitForACK waits up to 15 seconds for the reply of the receiver and tells whether it is an ACK
 func (astmConn *ASTMConnection) WaitForACK() bool {
-	timerInterrupt := time.NewTimer(time.Second * 15)
-	select {
-	case resp, ok := <-astmConn.ackChan:
-		if !ok {
-			slog.Error("Disconnected while waiting for ACK.")
-			return false
-		}
-		slog.Debug("ACK/NAK received.", "Type", resp)
-		if !timerInterrupt.Stop() {
-			slog.Debug("Draining the timer channel for WaitForACK.")
-			<-timerInterrupt.C
-			slog.Debug("Drained the timer channel for WaitForACK.")
-		}
-		slog.Debug("Stopped the timer.")
-		return resp
-	case <-timerInterrupt.C:
-		slog.Debug("Timer interrupt for WaitForACK.")
+	return astmConn.WaitForACKWithContext(context.Background())
+}
+
+// WaitForACKWithContext waits like WaitForACK, giving up once ctx is done
+func (astmConn *ASTMConnection) WaitForACKWithContext(ctx context.Context) bool {
+	reply, err := astmConn.awaitReply(ctx, establishmentTimeout)
+	if err != nil {
+		slog.Debug("No ACK received.", "Error", err)
 		return false
 	}
+	slog.Debug("ACK/NAK received.", "Type", reply)
+	return reply == constants.ACK
 }
 
+// StopSendMode sends EOT and returns to idle, see the termination phase of Sender
 func (astmConn *ASTMConnection) StopSendMode() {
-	data := string([]byte{constants.EOT})
-	(astmConn.connection).Write(data)
-	slog.Debug("Sending EOT.")
-	astmConn.status = constants.Idle
-	slog.Debug("Changed mode to Idle and stopped send mode.")
+	astmConn.sender.terminate()
 }
 
+// EstablishSendMode sends ENQ and waits for the receiver to ACK it, see the establishment phase of Sender
 func (astmConn *ASTMConnection) EstablishSendMode() bool {
-	astmConn.frameNumber = 1
-	if astmConn.status != constants.Idle {
-		slog.Error("Connection not in idle when trying to establish send mode.")
-		return false
+	return astmConn.EstablishSendModeWithContext(context.Background())
+}
+
+// EstablishSendModeWithContext establishes send mode like EstablishSendMode, giving up with EOT once ctx is done
+func (astmConn *ASTMConnection) EstablishSendModeWithContext(ctx context.Context) bool {
+	return astmConn.sender.establish(ctx) == nil
+}
+
+// postReply hands the reply of the receiver over to the sender waiting for it, if any
+func (astmConn *ASTMConnection) postReply(reply byte) {
+	select {
+	case astmConn.ackChan <- reply:
+	default:
+		slog.Debug("Dropped reply nobody is waiting for.", "Reply", reply)
 	}
-	astmConn.status = constants.Establishing
-	slog.Debug("Establishing send mode.")
-	(astmConn.connection).Write(string([]byte{constants.ENQ}))
-	slog.Debug("Sent ENQ.")
-	if !astmConn.WaitForACK() {
-		slog.Error("Could not establish send mode.")
-		astmConn.StopSendMode()
-		return false
+}
+
+func (astmConn *ASTMConnection) write(data []byte) error {
+	data, err := intercept(astmConn.outbound, data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFrameIntercepted, err)
 	}
-	astmConn.status = constants.Sending
-	slog.Debug("Changing status to sending.")
-	return true
+	astmConn.metrics.written(data)
+	return (astmConn.connection).Write(data)
 }
 
-// ReadMessage reads a single ASTM Message from the connection.
-func (astmConn *ASTMConnection) ReadMessage(timeout time.Duration) (error, string) {
+func (astmConn *ASTMConnection) awaitReply(ctx context.Context, timeout time.Duration) (byte, error) {
 	timerInterrupt := time.NewTimer(timeout)
+	defer timerInterrupt.Stop()
+	select {
+	case reply := <-astmConn.ackChan:
+		return reply, nil
+	case <-astmConn.internalCtx.Done():
+		slog.Error("Disconnected while waiting for ACK.")
+		return 0, fmt.Errorf("disconnected while waiting for a reply: %w", connection.ErrNotConnected)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-timerInterrupt.C:
+		slog.Debug("Timer interrupt for WaitForACK.")
+		return 0, ErrReplyTimeout
+	}
+}
+
+// discardReply discards an ACK or NAK that arrived after WaitForACK gave up on it
+func (astmConn *ASTMConnection) discardReply() {
 	select {
-	case newMessage, ok := <-astmConn.incomingMessage:
-		if !ok {
-			return errors.New("channel closed while reading"), ""
+	case <-astmConn.ackChan:
+	default:
+	}
+}
+
+func (astmConn *ASTMConnection) currentStatus() constants.LIS1A2ConnectionStatus {
+	astmConn.statusMutex.Lock()
+	defer astmConn.statusMutex.Unlock()
+	return astmConn.status
+}
+
+// connectionContext gives the context of the current connection, cancelled once it is disconnected
+func (astmConn *ASTMConnection) connectionContext() context.Context {
+	astmConn.connectedMutex.Lock()
+	defer astmConn.connectedMutex.Unlock()
+	return astmConn.internalCtx
+}
+
+func (astmConn *ASTMConnection) peerBusy() {
+	astmConn.metrics.naksReceived.Add(1)
+	if astmConn.hooks.onPeerBusy != nil {
+		astmConn.hooks.onPeerBusy()
+	}
+}
+
+func (astmConn *ASTMConnection) frameSent(raw string) {
+	astmConn.metrics.frameSent(raw)
+	astmConn.journalAppend(ExchangeSent, JournalFrame, raw)
+	if astmConn.hooks.onFrameSent != nil {
+		astmConn.hooks.onFrameSent(raw)
+	}
+}
+
+// SetSenderOptions tunes the Sender of the connection, like the number of attempts before a message is aborted.
+// It has to be called before sending.
+func (astmConn *ASTMConnection) SetSenderOptions(options SenderOptions) {
+	astmConn.sender.options = senderOptionsWithDefaults([]SenderOptions{options})
+}
+
+// SetReceiverOptions tunes the Receiver of the connection, like how long the instrument may go quiet in the
+// middle of a message. It has to be called before Listen.
+func (astmConn *ASTMConnection) SetReceiverOptions(options ReceiverOptions) {
+	astmConn.receiver.options = receiverOptionsWithDefaults([]ReceiverOptions{options})
+}
+
+// SetCharset makes the connection decode the messages received from the charset the instrument sends,
+// so that their Text is UTF-8, and encode the records sent into it. The messages are passed through as
+// they are by default. It has to be called before Listen.
+func (astmConn *ASTMConnection) SetCharset(charset astm.Charset) {
+	astmConn.charset = charset
+}
+
+// RequestInterrupt asks the instrument to stop sending, the next frame received is acknowledged with EOT
+// instead of ACK, see Receiver.RequestInterrupt
+func (astmConn *ASTMConnection) RequestInterrupt() {
+	astmConn.receiver.RequestInterrupt()
+}
+
+// UseRecordMiddleware adds record middleware which ReceivedMessage.Parse runs on every record of the messages
+// received, after the middleware added before, like normalizing the specimen IDs. It has to be called before Listen.
+func (astmConn *ASTMConnection) UseRecordMiddleware(middleware ...astm.RecordMiddleware) {
+	astmConn.pipeline = astmConn.pipeline.Then(middleware...)
+}
+
+// OnMessage registers a handler called with every message received, or the error which made it fail,
+// instead of handing them over to ReadMessage. It runs on the go routine calling Listen, which reads
+// nothing more until it returns, so a handler taking long should hand the message over to another go routine.
+// It has to be registered before Listen.
+func (astmConn *ASTMConnection) OnMessage(handler func(message ReceivedMessage)) {
+	astmConn.onMessage = handler
+}
+
+// OnConfirm registers a handler accepting or rejecting every message before the frame of its terminator record
+// is ACKed, like storing its results, see Receiver.OnConfirm. A message accepted is then handed over to
+// OnMessage or ReadMessage, one rejected is failed with ErrMessageRejected. It has to be registered before Listen.
+func (astmConn *ASTMConnection) OnConfirm(handler func(message ReceivedMessage) error) {
+	astmConn.receiver.OnConfirm(func(message string) error {
+		confirmed := ReceivedMessage{Text: astmConn.charset.Decode(message), pipeline: astmConn.pipeline}
+		if astmConn.reception != nil {
+			confirmed.Context = astmConn.reception.ctx
 		}
+		return handler(confirmed)
+	})
+}
+
+// ReadMessage reads a single ASTM Message from the connection, one record per line.
+// Records split over intermediate frames are reassembled, and an error is returned instead
+// of the message when its frame numbers did not increment modulo 8, or ErrReceiveTimeout when
+// the instrument stopped sending in the middle of it.
+func (astmConn *ASTMConnection) ReadMessage(timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	message, err := astmConn.ReadMessageWithContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Debug("Timer interrupt in ReadMessage.")
+		return "", errors.New("read message timer timed out")
+	}
+	return message, err
+}
+
+// ReadMessageWithContext reads a single ASTM Message like ReadMessage, waiting until ctx is done, in which
+// case ctx.Err() is returned
+func (astmConn *ASTMConnection) ReadMessageWithContext(ctx context.Context) (string, error) {
+	select {
+	case <-astmConn.internalCtx.Done():
+		return "", fmt.Errorf("disconnected while reading: %w", connection.ErrNotConnected)
+	case newMessage := <-astmConn.incomingMessage:
 		slog.Debug("New astm message arrived.")
-		if !timerInterrupt.Stop() {
-			slog.Debug("Draining timer channel for ReadMessage.")
-			<-timerInterrupt.C
-			slog.Debug("Drained timer channel for ReadMessage.")
+		return newMessage.Text, newMessage.Err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// deliverMessage hands a received message over to the OnMessage handler or to ReadMessage, giving up once disconnected
+func (astmConn *ASTMConnection) deliverMessage(message ReceivedMessage) {
+	if astmConn.onMessage != nil {
+		astmConn.onMessage(message)
+		return
+	}
+	select {
+	case astmConn.incomingMessage <- message:
+	case <-astmConn.internalCtx.Done():
+		slog.Debug("Dropped message received while disconnecting.")
+	}
+}
+
+// messageReceived saves the message received by the receiver if asked to and hands it over to the application
+func (astmConn *ASTMConnection) messageReceived(message string, err error) {
+	message = astmConn.charset.Decode(message)
+	traceCtx := astmConn.receptionEnded(message, err)
+	astmConn.emit(Event{Kind: EventMessageCompleted, Direction: ExchangeReceived, Data: message, Err: err})
+	if err != nil {
+		if astmConn.hooks.onProtocolError != nil {
+			astmConn.hooks.onProtocolError(err)
 		}
-		slog.Debug("Stopped timer!")
-		return nil, newMessage
-	case <-timerInterrupt.C:
-		slog.Debug("Timer interrupt in ReadMessage.")
-		return errors.New("read message timer timed out"), ""
+		astmConn.deliverMessage(ReceivedMessage{Err: err, Context: traceCtx})
+		return
 	}
+	astmConn.metrics.messagesReceived.Add(1)
+	astmConn.journalAppend(ExchangeReceived, JournalMessage, message)
+	if astmConn.saveIncomingMessage {
+		go astmConn.SaveIncomingMessage(message, astmConn.incomingMessageSaveDir)
+	}
+	if astmConn.hooks.onMessageReceived != nil {
+		astmConn.hooks.onMessageReceived(message)
+	}
+	if astmConn.handleQuery(message) {
+		return
+	}
+	received := ReceivedMessage{Text: message, Context: traceCtx, pipeline: astmConn.pipeline}
+	if astmConn.isDuplicate(&received) {
+		return
+	}
+	astmConn.deliverMessage(received)
 }
 
 func (astmConn *ASTMConnection) SaveIncomingMessage(message string, fileDir string) {
@@ -193,30 +471,35 @@ func (astmConn *ASTMConnection) SaveIncomingMessage(message string, fileDir stri
 }
 
 func (astmConn *ASTMConnection) CalculateChecksum(frame string) []byte {
-	byteFrame := []byte(frame)
-	var sum = 0
-	for _, bt := range byteFrame {
-		sum = (sum + int(bt)) % 256
-	}
-	calcChecksum := strings.ToUpper(hex.EncodeToString([]byte{byte(sum)}))
-	calcChecksumBytes := []byte(calcChecksum)
-	slog.Debug("Calculate Checksum.", "Checksum:", calcChecksum, "In bytes: ", calcChecksumBytes)
-	return calcChecksumBytes
+	return calculateChecksum(frame)
 }
 
 func (astmConn *ASTMConnection) IsFrameValid(frame string) bool {
-	byteFrame := []byte(frame)
-	frameLen := len(byteFrame)
-	etxOrEtb := byteFrame[frameLen-5]
-	if frameLen < 5 || byteFrame[0] != constants.STX || byteFrame[frameLen-1] != constants.LF ||
-		byteFrame[frameLen-2] != constants.CR || (etxOrEtb != constants.ETX && etxOrEtb != constants.ETB) ||
-		byteFrame[frameLen-6] != constants.CR {
-		return false
-	}
-	return true
+	return isFrameValid(frame)
 }
 
 func (astmConn *ASTMConnection) IsTheFrameIntermediate(frame string) bool {
+	return isTheFrameIntermediate(frame)
+}
+
+func (astmConn *ASTMConnection) CheckChecksum(frame string) bool {
+	return checkChecksum(frame)
+}
+
+// calculateChecksum gives the two uppercase hex characters of the modulo 256 sum of the frame
+func calculateChecksum(frame string) []byte {
+	checksum := protocol.ComputeChecksum([]byte(frame))
+	slog.Debug("Calculate Checksum.", "Checksum:", string(checksum[:]))
+	return checksum[:]
+}
+
+// isFrameValid checks the structure of a STX...LF frame, leaving its checksum aside
+func isFrameValid(frame string) bool {
+	return !errors.Is(protocol.ValidateFrame([]byte(frame)), protocol.ErrMalformedFrame)
+}
+
+// isTheFrameIntermediate tells whether a valid frame ends with ETB
+func isTheFrameIntermediate(frame string) bool {
 	byteFrame := []byte(frame)
 	byteFrameLen := len(byteFrame)
 	isIntermediate := byteFrame[byteFrameLen-5] == constants.ETB
@@ -224,156 +507,104 @@ func (astmConn *ASTMConnection) IsTheFrameIntermediate(frame string) bool {
 	return isIntermediate
 }
 
-func (astmConn *ASTMConnection) CheckChecksum(frame string) bool {
-	if !astmConn.IsFrameValid(frame) {
-		slog.Error("Checking checksum. Given frame is invalid.")
+// checkChecksum checks the structure of a frame and its checksum
+func checkChecksum(frame string) bool {
+	if err := protocol.ValidateFrame([]byte(frame)); err != nil {
+		slog.Error("Checking checksum. Given frame is invalid.", "Error", err)
 		return false
 	}
-	byteFrame := []byte(frame)
-	frameLen := len(byteFrame)
-	calculatedChecksum := astmConn.CalculateChecksum(string(byteFrame[1 : frameLen-4]))
-	receivedChecksum := byteFrame[frameLen-4 : frameLen-2]
-	doesCheckSumMatch := bytes.Equal(receivedChecksum, calculatedChecksum)
-	slog.Debug("Checking checksum.", "Received", receivedChecksum, "Calculated", calculatedChecksum)
-	return doesCheckSumMatch
+	return true
 }
 
-func (astmConn *ASTMConnection) sendString(frame string) {
-	if astmConn.status != constants.Sending {
-		slog.Error("Connection not in send mode when trying to send data.")
-		return
+// SendMessage sends the records of an ASTM Message, running the whole exchange:
+// it establishes send mode with ENQ, sends every record as numbered frames waiting for an ACK
+// on each, and terminates with EOT. The returned error tells which phase failed, it wraps
+// ErrTransmissionAborted when the receiver did not ACK ENQ or a frame after all the attempts.
+// Once ctx is done the exchange is terminated with EOT and ctx.Err() is returned wrapped in the error.
+// Messages sent from several go routines are sent one after the other, while a message is being
+// received ErrBusy is returned and after Shutdown connection.ErrShutdown is returned.
+func (astmConn *ASTMConnection) SendMessage(ctx context.Context, records []string) error {
+	if astmConn.shuttingDown.Load() {
+		return connection.ErrShutdown
 	}
-	var byteArr []byte
-	byteArr = append(byteArr, constants.STX)
-	byteArr = append(byteArr, []byte(frame)...)
-	byteArr = append(byteArr, astmConn.CalculateChecksum(frame)...)
-	byteArr = append(byteArr, constants.CR)
-	byteArr = append(byteArr, constants.LF)
-	tmpSendStr := string(byteArr)
-	(astmConn.connection).Write(tmpSendStr)
-	tryCounter := 0
-	for !astmConn.WaitForACK() {
-		tryCounter++
-		if tryCounter > 5 {
-			astmConn.StopSendMode()
-			slog.Error("Max number of send retires reached.")
-			return
-		}
-		(astmConn.connection).Write(tmpSendStr)
+	astmConn.sendMutex.Lock()
+	defer astmConn.sendMutex.Unlock()
+	if astmConn.shuttingDown.Load() {
+		return connection.ErrShutdown
 	}
-	slog.Debug("Frame sent successfully.")
-}
-
-func (astmConn *ASTMConnection) sendEndFrame(frameNumber int, frame string) {
-	slog.Debug("Sending ending frame with ETX.")
-	var byteArr []byte
-	hexFrameNumber := hex.EncodeToString([]byte{byte(frameNumber)})[1:]
-	byteArr = append(byteArr, []byte(hexFrameNumber)...)
-	byteArr = append(byteArr, []byte(frame)...)
-	byteArr = append(byteArr, constants.CR)
-	byteArr = append(byteArr, constants.ETX)
-	astmConn.sendString(string(byteArr))
+	if astmConn.internalCtx.Err() != nil {
+		// the state may still be the one the link was in when it was disconnected
+		return fmt.Errorf("disconnected before sending: %w", connection.ErrNotConnected)
+	}
+	ctx, exchange := astmConn.startSendExchange(ctx)
+	records = astmConn.encode(records)
+	err := astmConn.sender.SendRecords(ctx, records)
+	astmConn.endSendExchange(exchange, records, err)
+	if errors.Is(err, ErrReplyTimeout) {
+		astmConn.emit(Event{Kind: EventTimerExpired, Direction: ExchangeSent, Err: err})
+	}
+	astmConn.emit(Event{Kind: EventMessageCompleted, Direction: ExchangeSent, Data: strings.Join(records, "\n") + "\n", Err: err})
+	if err == nil {
+		astmConn.metrics.messagesSent.Add(1)
+		astmConn.journalAppend(ExchangeSent, JournalMessage, strings.Join(records, "\n")+"\n")
+	}
+	if astmConn.hooks.onSendComplete != nil {
+		astmConn.hooks.onSendComplete(err)
+	}
+	return err
 }
 
-func (astmConn *ASTMConnection) sendIntermediateFrame(frameNumber int, frame string) {
-	slog.Debug("Sending intermediate frame with ETB.")
-	var byteArr []byte
-	hexFrameNumber := hex.EncodeToString([]byte{byte(frameNumber)})[1:]
-	byteArr = append(byteArr, []byte(hexFrameNumber)...)
-	byteArr = append(byteArr, []byte(frame)...)
-	byteArr = append(byteArr, constants.ETB)
-	astmConn.sendString(string(byteArr))
+// encode encodes the records to be sent into the charset of the connection
+func (astmConn *ASTMConnection) encode(records []string) []string {
+	if astmConn.charset == astm.CharsetPassThrough {
+		return records
+	}
+	encoded := make([]string, len(records))
+	for index, record := range records {
+		encoded[index] = astmConn.charset.Encode(record)
+	}
+	return encoded
 }
 
-// SendMessage takes single ASTM Record as input and sends it as one or more frames over the connection
-func (astmConn *ASTMConnection) SendMessage(message string) {
-	byteMessage := []byte(message)
-	for len(byteMessage) > constants.MaxFrameSize {
-		// divide it in chunks
-		intermediateFrame := string(byteMessage[:constants.MaxFrameSize])
-		astmConn.sendIntermediateFrame(astmConn.frameNumber, intermediateFrame)
-		astmConn.frameNumber = (astmConn.frameNumber + 1) % 8
-		byteMessage = byteMessage[constants.MaxFrameSize:]
-	}
-	astmConn.sendEndFrame(astmConn.frameNumber, string(byteMessage))
-	astmConn.frameNumber = (astmConn.frameNumber + 1) % 8
+// SendParsedMessage sends a parsed ASTM Message like SendMessage, its records written with its delimiters
+func (astmConn *ASTMConnection) SendParsedMessage(ctx context.Context, message *astm.Message) error {
+	return astmConn.SendMessage(ctx, message.Lines())
 }
 
-func (astmConn *ASTMConnection) connectionDataReceived(data string) {
-	byteData := []byte(data)
+func (astmConn *ASTMConnection) connectionDataReceived(byteData []byte) {
 	lenOfData := len(byteData)
 
 	slog.Debug("Byte data arrived.", "Data", byteData)
-	slog.Debug("Current status of Automaton.", "State", astmConn.status)
+	slog.Debug("Current status of Automaton.", "State", astmConn.currentStatus())
 
 	if lenOfData > 0 {
 		for _, singleByte := range byteData {
-			switch astmConn.status {
+			switch astmConn.currentStatus() {
 			case constants.Idle:
-				if singleByte != constants.ENQ {
-					(astmConn.connection).Write(string([]byte{constants.NAK}))
-				} else {
-					slog.Info("Received ENQ in Idle state. Sending ACK.")
-					(astmConn.connection).Write(string([]byte{constants.ACK}))
-					astmConn.status = constants.Receiving
-					// TODO: Change it back to idle if nothing is received even after 15 seconds have passed
+				astmConn.receiver.handleByte(singleByte)
+				if singleByte == constants.ENQ && astmConn.currentStatus() == constants.Establishing {
+					// the sender took the line as the ENQ of the peer arrived, it resolves the contention
+					astmConn.postReply(constants.ENQ)
 				}
 			case constants.Sending:
-				receivedACK := singleByte == constants.ACK
-				slog.Debug("Waiting for ACK in sending state.")
-				astmConn.ackChan <- receivedACK
-				slog.Debug("Received.", "ACK type", receivedACK)
+				slog.Debug("Received reply in sending state.", "Reply", singleByte)
+				astmConn.postReply(singleByte)
 			case constants.Receiving:
-				if singleByte == constants.ENQ {
-					(astmConn.connection).Write(string([]byte{constants.NAK}))
-				} else if singleByte != constants.EOT {
-					if singleByte != constants.NUL {
-						astmConn.buffer = append(astmConn.buffer, singleByte)
-					}
-					if singleByte == constants.LF {
-						receivedFrame := string(astmConn.buffer)
-						receivedFrameLen := len(receivedFrame)
-						astmConn.buffer = make([]byte, 0)
-						if !astmConn.CheckChecksum(receivedFrame) {
-							slog.Error("Checksum did not match. Sending NAK.")
-							(astmConn.connection).Write(string([]byte{constants.NAK}))
-						} else {
-							slog.Debug("Checksum ok. Sending ACK.")
-							(astmConn.connection).Write(string([]byte{constants.ACK}))
-							if astmConn.IsTheFrameIntermediate(receivedFrame) {
-								partialRecord := receivedFrame[2 : receivedFrameLen-5]
-								astmConn.recordBuffer += partialRecord
-							} else {
-								partialRecord := receivedFrame[2 : receivedFrameLen-6]
-								astmConn.recordBuffer += partialRecord
-								astmConn.messageBuffer += astmConn.recordBuffer + "\n"
-								astmConn.recordBuffer = ""
-							}
-						}
-					}
-				} else {
-					slog.Debug("Received EOT in Receiving state. Going to Idle state.")
-					if len(astmConn.messageBuffer) != 0 {
-						if astmConn.saveIncomingMessage {
-							go astmConn.SaveIncomingMessage(astmConn.messageBuffer, astmConn.incomingMessageSaveDir)
-						}
-						astmConn.incomingMessage <- astmConn.messageBuffer
-						astmConn.messageBuffer = ""
-					}
-					astmConn.status = constants.Idle
-					slog.Debug("State changed to Idle.")
+				if astmConn.receiver.handleByte(singleByte) {
 					return
 				}
 			case constants.Establishing:
 				if singleByte == constants.ACK {
 					slog.Debug("Received ACK in Establishing state.")
-					astmConn.ackChan <- true
+					astmConn.postReply(constants.ACK)
+					return
+				} else if singleByte == constants.NAK {
+					slog.Debug("Received NAK in Establishing state.")
+					astmConn.postReply(constants.NAK)
 					return
 				} else if singleByte == constants.ENQ {
 					slog.Debug("Received ENQ in Establishing state.")
-					time.Sleep(time.Second * 1)
-					(astmConn.connection).Write(string([]byte{constants.ENQ}))
-					slog.Debug("Sent ENQ.")
+					astmConn.postReply(constants.ENQ)
 					return
 				} else {
 					continue
@@ -385,22 +616,64 @@ func (astmConn *ASTMConnection) connectionDataReceived(data string) {
 	}
 }
 
-// Listen listens to the incoming messages over the connection
+// Listen listens to the incoming messages over the connection and sends the messages enqueued
 func (astmConn *ASTMConnection) Listen() {
 	(astmConn.connection).Listen()
+	// the context of the connection listened to, a later Connect does not hand it over to this Listen
+	astmConn.connectedMutex.Lock()
+	ctx, cancel := astmConn.internalCtx, astmConn.internalCtxCancelFunc
+	astmConn.connectedMutex.Unlock()
+	go astmConn.sendQueued(ctx)
+	if astmConn.health.IdleAfter > 0 {
+		go astmConn.watchIdle(ctx)
+	}
+	if astmConn.health.KeepAlive > 0 {
+		go astmConn.keepAlive(ctx)
+	}
+	reader := &connectionReader{connection: astmConn.connection}
 	for {
-		str, err := (astmConn.connection).ReadStringFromConnection()
-		if err != nil {
-			slog.Error("Stopped listening.", "Error", err)
-			return
-		}
-		astmConn.connectionDataReceived(str)
-		select {
-		case <-astmConn.internalCtx.Done():
+		data, err := astmConn.receiver.read(ctx, reader)
+		astmConn.metrics.read(data)
+		if ctx.Err() != nil {
 			slog.Debug("Ceasing Listen operation on ASTM connection.")
 			return
-		default:
+		}
+		data, err = astmConn.interceptInbound(data, err)
+		if errors.Is(err, errFrameIntercepted) {
+			// the sender gets no ACK for the frame and sends it again
+			slog.Info("Dropped a frame received.", "Error", err, "Data", data)
 			continue
 		}
+		if errors.Is(err, connection.ErrChecksumMismatch) && astmConn.receiver.tolerates(data) {
+			// a frame of an older analyzer which Tolerant accepts all the same
+			err = nil
+		}
+		if errors.Is(err, ErrReceiveTimeout) {
+			astmConn.emit(Event{Kind: EventTimerExpired, Direction: ExchangeReceived, Err: err})
+			continue
+		} else if errors.Is(err, connection.ErrChecksumMismatch) {
+			// the corrupt frame is still handed over so that it gets NAKed below
+			slog.Debug("Received frame with checksum mismatch.", "Error", err)
+			astmConn.metrics.checksumFailures.Add(1)
+			astmConn.frameError(string(data), err)
+		} else if errors.Is(err, connection.ErrIncompleteFrame) || errors.Is(err, connection.ErrReadOverflow) {
+			// the sender gets no ACK for the frame and sends it again
+			slog.Error("Dropped incomplete data.", "Error", err, "Data", data)
+			if errors.Is(err, connection.ErrIncompleteFrame) {
+				astmConn.frameError(string(data), err)
+			}
+			continue
+		} else if err != nil {
+			slog.Error("Stopped listening.", "Error", err)
+			// the go routines sending the queue and watching the line stop along, until connected again
+			cancel()
+			astmConn.connectionLost(err)
+			return
+		}
+		if err == nil && len(data) > 0 && data[0] == constants.STX {
+			astmConn.emit(Event{Kind: EventFrameReceived, Direction: ExchangeReceived, Data: string(data)})
+		}
+		astmConn.dataReceived(data)
+		astmConn.connectionDataReceived(data)
 	}
 }
@@ -0,0 +1,96 @@
+package astm
+
+import "errors"
+
+// OrderMessageBuilder builds the H, P, O and L message downloading a worklist to an instrument in batch
+// mode, taking care of the sequence numbers, the delimiters and the terminator
+type OrderMessageBuilder struct {
+	header          *HeaderRecord
+	patients        []*Patient
+	comments        *[]*CommentRecord
+	terminationCode string
+	err             error
+}
+
+// NewOrderMessage starts an order message, declaring the default delimiters and terminated normally
+func NewOrderMessage() *OrderMessageBuilder {
+	return &OrderMessageBuilder{
+		header:          &HeaderRecord{Delimiters: DefaultDelimiters},
+		terminationCode: TerminationNormal,
+	}
+}
+
+// Header sets the header record of the message, identifying the sender and the receiver,
+// a header without delimiters declares the default ones
+func (builder *OrderMessageBuilder) Header(header *HeaderRecord) *OrderMessageBuilder {
+	headerCopy := *header
+	if headerCopy.Delimiters == (Delimiters{}) {
+		headerCopy.Delimiters = builder.header.Delimiters
+	}
+	builder.header = &headerCopy
+	return builder
+}
+
+// Delimiters sets the delimiters the header declares and the message is written with
+func (builder *OrderMessageBuilder) Delimiters(delimiters Delimiters) *OrderMessageBuilder {
+	headerCopy := *builder.header
+	headerCopy.Delimiters = delimiters
+	builder.header = &headerCopy
+	return builder
+}
+
+// Patient adds a patient, the orders added next are the orders of this patient
+func (builder *OrderMessageBuilder) Patient(patient *PatientRecord) *OrderMessageBuilder {
+	added := &Patient{PatientRecord: patient}
+	builder.patients = append(builder.patients, added)
+	builder.comments = &added.Comments
+	return builder
+}
+
+// Order adds an order of the patient added last
+func (builder *OrderMessageBuilder) Order(order *OrderRecord) *OrderMessageBuilder {
+	if len(builder.patients) == 0 {
+		builder.fail(errors.New("order added before any patient"))
+		return builder
+	}
+	patient := builder.patients[len(builder.patients)-1]
+	added := &Order{OrderRecord: order}
+	patient.Orders = append(patient.Orders, added)
+	builder.comments = &added.Comments
+	return builder
+}
+
+// Comment adds a comment on the patient or the order added last
+func (builder *OrderMessageBuilder) Comment(comment *CommentRecord) *OrderMessageBuilder {
+	if builder.comments == nil {
+		builder.fail(errors.New("comment added before any patient"))
+		return builder
+	}
+	*builder.comments = append(*builder.comments, comment)
+	return builder
+}
+
+// TerminationCode sets the termination code of the terminator record, TerminationNormal by default
+func (builder *OrderMessageBuilder) TerminationCode(code string) *OrderMessageBuilder {
+	builder.terminationCode = code
+	return builder
+}
+
+// Build assembles the message, see NewMessage, or returns the first error made building it, like an
+// order added before any patient
+func (builder *OrderMessageBuilder) Build() (*Message, error) {
+	if builder.err != nil {
+		return nil, builder.err
+	}
+	if len(builder.patients) == 0 {
+		return nil, errors.New("order message without patients")
+	}
+	return NewMessage(builder.header, builder.patients, builder.terminationCode), nil
+}
+
+// fail keeps the first error made building the message
+func (builder *OrderMessageBuilder) fail(err error) {
+	if builder.err == nil {
+		builder.err = err
+	}
+}
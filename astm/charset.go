@@ -0,0 +1,108 @@
+package astm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Charset tells how the bytes of the field values an instrument sends are decoded into Go strings,
+// as many older instruments send ISO 8859-1 or Windows-1252 text in patient names and comments
+type Charset int
+
+const (
+	// CharsetPassThrough keeps the bytes of a value as they were received
+	CharsetPassThrough Charset = iota
+	// CharsetUTF8 takes the values as UTF-8, replacing the invalid sequences with U+FFFD
+	CharsetUTF8
+	// CharsetLatin1 decodes the values as ISO 8859-1, every byte standing for the code point of the same value
+	CharsetLatin1
+	// CharsetWindows1252 decodes the values as Windows-1252, ISO 8859-1 with printable characters like € and
+	// curly quotes in place of the C1 control characters 0x80 to 0x9F
+	CharsetWindows1252
+	// CharsetASCII keeps the ASCII characters of the values and replaces every other byte with U+FFFD
+	CharsetASCII
+)
+
+// windows1252 maps the bytes 0x80 to 0x9F of Windows-1252 to their code points, the five bytes it leaves
+// undefined are mapped to the C1 control characters of the same value, like ISO 8859-1 does
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// Decode decodes text received in the charset into a UTF-8 string
+func (charset Charset) Decode(text string) string {
+	switch charset {
+	case CharsetUTF8:
+		return strings.ToValidUTF8(text, string(utf8.RuneError))
+	case CharsetLatin1, CharsetWindows1252, CharsetASCII:
+		if isASCII(text) {
+			return text
+		}
+		var decoded strings.Builder
+		for index := 0; index < len(text); index++ {
+			decoded.WriteRune(charset.decodeByte(text[index]))
+		}
+		return decoded.String()
+	default:
+		return text
+	}
+}
+
+// decodeByte gives the code point a byte stands for in a single byte charset
+func (charset Charset) decodeByte(bt byte) rune {
+	switch {
+	case bt < utf8.RuneSelf:
+		return rune(bt)
+	case charset == CharsetASCII:
+		return utf8.RuneError
+	case charset == CharsetWindows1252 && bt <= 0x9f:
+		return windows1252[bt-0x80]
+	default:
+		return rune(bt)
+	}
+}
+
+// Encode encodes a UTF-8 string into the charset, to be sent to the instrument. The characters the
+// charset cannot represent are replaced with '?', text is returned as it is by CharsetPassThrough and CharsetUTF8.
+func (charset Charset) Encode(text string) string {
+	if charset != CharsetLatin1 && charset != CharsetWindows1252 && charset != CharsetASCII || isASCII(text) {
+		return text
+	}
+	encoded := make([]byte, 0, len(text))
+	for _, character := range text {
+		encoded = append(encoded, charset.encodeRune(character))
+	}
+	return string(encoded)
+}
+
+// encodeRune gives the byte standing for a code point in a single byte charset, '?' when there is none
+func (charset Charset) encodeRune(character rune) byte {
+	switch {
+	case character < utf8.RuneSelf:
+		return byte(character)
+	case charset == CharsetASCII:
+		return '?'
+	case charset == CharsetWindows1252 && (character < 0xa0 || character > 0xff):
+		for index, mapped := range windows1252 {
+			if mapped == character {
+				return byte(0x80 + index)
+			}
+		}
+		return '?'
+	case character <= 0xff:
+		return byte(character)
+	default:
+		return '?'
+	}
+}
+
+// isASCII tells whether text only holds ASCII characters, which all the charsets keep as they are
+func isASCII(text string) bool {
+	for index := 0; index < len(text); index++ {
+		if text[index] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
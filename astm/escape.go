@@ -0,0 +1,125 @@
+package astm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownEscape is returned by ParseMessage for an escape sequence the standard does not define,
+// when the parse options ask to reject them
+var ErrUnknownEscape = errors.New("unknown escape sequence")
+
+// EscapePolicy tells the parser what to do with an escape sequence it does not know
+type EscapePolicy int
+
+const (
+	// EscapePassThrough keeps an unknown escape sequence in the value as it was received
+	EscapePassThrough EscapePolicy = iota
+	// EscapeReject fails the parsing with ErrUnknownEscape
+	EscapeReject
+)
+
+// unescape decodes the escape sequences of a value: &F&, &S&, &R& and &E& stand for the field, component,
+// repeat and escape delimiters, &Xhh& for the bytes given as hexadecimal pairs, with & the escape delimiter
+func unescape(value string, delimiters Delimiters, policy EscapePolicy) (string, error) {
+	if strings.IndexByte(value, delimiters.Escape) < 0 {
+		return value, nil
+	}
+	var unescaped strings.Builder
+	for len(value) > 0 {
+		start := strings.IndexByte(value, delimiters.Escape)
+		if start < 0 {
+			unescaped.WriteString(value)
+			break
+		}
+		unescaped.WriteString(value[:start])
+		length := strings.IndexByte(value[start+1:], delimiters.Escape)
+		if length < 0 {
+			// an escape delimiter which is never closed
+			if policy == EscapeReject {
+				return "", fmt.Errorf("%w %q", ErrUnknownEscape, value[start:])
+			}
+			unescaped.WriteString(value[start:])
+			break
+		}
+		sequence := value[start+1 : start+1+length]
+		switch decoded, ok := decodeEscape(sequence, delimiters); {
+		case ok:
+			unescaped.WriteString(decoded)
+		case policy == EscapeReject:
+			return "", fmt.Errorf("%w %q", ErrUnknownEscape, value[start:start+length+2])
+		default:
+			unescaped.WriteString(value[start : start+length+2])
+		}
+		value = value[start+length+2:]
+	}
+	return unescaped.String(), nil
+}
+
+// decodeEscape decodes the sequence between two escape delimiters, telling whether it is one it knows
+func decodeEscape(sequence string, delimiters Delimiters) (string, bool) {
+	switch sequence {
+	case "F":
+		return string(delimiters.Field), true
+	case "S":
+		return string(delimiters.Component), true
+	case "R":
+		return string(delimiters.Repeat), true
+	case "E":
+		return string(delimiters.Escape), true
+	}
+	if len(sequence) > 1 && sequence[0] == 'X' {
+		if decoded, err := hex.DecodeString(sequence[1:]); err == nil {
+			return string(decoded), true
+		}
+	}
+	return "", false
+}
+
+// escape encodes the delimiters and control characters of a value as escape sequences, the reverse of unescape
+func escape(value string, delimiters Delimiters) string {
+	if !needsEscaping(value, delimiters) {
+		return value
+	}
+	var escaped strings.Builder
+	for index := 0; index < len(value); index++ {
+		character := value[index]
+		var sequence string
+		switch {
+		case character == delimiters.Field:
+			sequence = "F"
+		case character == delimiters.Component:
+			sequence = "S"
+		case character == delimiters.Repeat:
+			sequence = "R"
+		case character == delimiters.Escape:
+			sequence = "E"
+		case character < ' ':
+			sequence = fmt.Sprintf("X%02X", character)
+		default:
+			escaped.WriteByte(character)
+			continue
+		}
+		escaped.WriteByte(delimiters.Escape)
+		escaped.WriteString(sequence)
+		escaped.WriteByte(delimiters.Escape)
+	}
+	return escaped.String()
+}
+
+// needsEscaping tells whether a value holds a delimiter or a control character
+func needsEscaping(value string, delimiters Delimiters) bool {
+	for index := 0; index < len(value); index++ {
+		switch character := value[index]; character {
+		case delimiters.Field, delimiters.Component, delimiters.Repeat, delimiters.Escape:
+			return true
+		default:
+			if character < ' ' {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package astm
+
+// Field holds the repeats of a record field, each repeat split into its components.
+// Repeats and components are numbered from 1 like the fields of a record, so the test code of
+// the Universal Test ID ^^^GLU is its component 4.
+type Field [][]string
+
+// NewField creates a field with a single repeat made of the components given
+func NewField(components ...string) Field {
+	return Field{append([]string(nil), components...)}
+}
+
+// Repeats gives the number of repeats of the field, zero for an empty field
+func (field Field) Repeats() int {
+	return len(field)
+}
+
+// Repeat gives the repeat with the given number as a field of its own, or nil when there is none,
+// so that field.Repeat(2).Component(4) reads component 4 of the second repeat
+func (field Field) Repeat(index int) Field {
+	if index < 1 || index > len(field) {
+		return nil
+	}
+	return Field{field[index-1]}
+}
+
+// Component gives the component with the given number of the first repeat, or an empty string when there is none
+func (field Field) Component(index int) string {
+	if len(field) == 0 || index < 1 || index > len(field[0]) {
+		return ""
+	}
+	return field[0][index-1]
+}
+
+// SetComponent sets the component with the given number of the first repeat, adding the components up to it
+func (field *Field) SetComponent(index int, value string) {
+	field.SetRepeatComponent(1, index, value)
+}
+
+// SetRepeatComponent sets the component with the given number of the repeat with the given number,
+// adding the repeats and components up to it. Numbers below 1 are ignored.
+func (field *Field) SetRepeatComponent(repeat int, component int, value string) {
+	if repeat < 1 || component < 1 {
+		return
+	}
+	for len(*field) < repeat {
+		*field = append(*field, []string{""})
+	}
+	components := (*field)[repeat-1]
+	for len(components) < component {
+		components = append(components, "")
+	}
+	components[component-1] = value
+	(*field)[repeat-1] = components
+}
+
+// AddRepeat appends a repeat made of the components given
+func (field *Field) AddRepeat(components ...string) {
+	*field = append(*field, append([]string(nil), components...))
+}
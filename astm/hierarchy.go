@@ -0,0 +1,131 @@
+package astm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Patient is a patient record along with the comments and orders which follow it
+type Patient struct {
+	*PatientRecord
+	Comments []*CommentRecord
+	Orders   []*Order
+}
+
+// Order is an order record along with the comments and results which follow it
+type Order struct {
+	*OrderRecord
+	Comments []*CommentRecord
+	Results  []*Result
+}
+
+// Result is a result record along with the comments which follow it
+type Result struct {
+	*ResultRecord
+	Comments []*CommentRecord
+}
+
+// ErrMalformedRecord is matched by every *ParseError, errors.Is(err, ErrMalformedRecord) tells that a
+// message could not be parsed because of one of its records
+var ErrMalformedRecord = errors.New("malformed record")
+
+// ParseError tells which record of a message, and which field of it, could not be parsed
+type ParseError struct {
+	// Line is the position of the record in the message, starting at 1
+	Line int
+	// Field is the position of the field in the record, as numbered by the standard starting with
+	// the record type at 1, or 0 when the record as a whole is at fault
+	Field int
+	Err   error
+}
+
+func (err *ParseError) Error() string {
+	if err.Field == 0 {
+		return fmt.Sprintf("line %v: %v", err.Line, err.Err)
+	}
+	return fmt.Sprintf("line %v, field %v: %v", err.Line, err.Field, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// Is makes every ParseError match ErrMalformedRecord
+func (err *ParseError) Is(target error) bool {
+	return target == ErrMalformedRecord
+}
+
+// hierarchyBuilder places the records of a message in its tree as they are parsed, checking their order
+type hierarchyBuilder struct {
+	message    *Message
+	strictness Strictness
+	patient    *Patient
+	order      *Order
+	result     *Result
+	// comments is where the next comment record goes, the comments of the record preceding it,
+	// nil when that record was left out of the hierarchy
+	comments *[]*CommentRecord
+}
+
+func newHierarchyBuilder(message *Message, strictness Strictness) *hierarchyBuilder {
+	return &hierarchyBuilder{message: message, strictness: strictness}
+}
+
+// add places a record under the records preceding it, a header has to come first and a terminator last.
+// The violations the strictness accepts leave the record out of the hierarchy.
+func (builder *hierarchyBuilder) add(record Record) error {
+	message := builder.message
+	if message.Terminator != nil {
+		return builder.violation(fmt.Errorf("record %c after the terminator record", record.RecordType()), Lenient)
+	}
+	switch typed := record.(type) {
+	case *HeaderRecord:
+		if message.Header != nil {
+			return builder.violation(errors.New("unexpected header record"), Lenient)
+		}
+		message.Header = typed
+		builder.comments = &message.Comments
+	case *PatientRecord:
+		builder.patient = &Patient{PatientRecord: typed}
+		builder.order, builder.result = nil, nil
+		message.Patients = append(message.Patients, builder.patient)
+		builder.comments = &builder.patient.Comments
+	case *OrderRecord:
+		if builder.patient == nil {
+			builder.order, builder.result, builder.comments = nil, nil, nil
+			return builder.violation(errors.New("order record without a patient record before it"), Strict)
+		}
+		builder.order = &Order{OrderRecord: typed}
+		builder.result = nil
+		builder.patient.Orders = append(builder.patient.Orders, builder.order)
+		builder.comments = &builder.order.Comments
+	case *ResultRecord:
+		if builder.order == nil {
+			builder.result, builder.comments = nil, nil
+			return builder.violation(errors.New("result record without an order record before it"), Strict)
+		}
+		builder.result = &Result{ResultRecord: typed}
+		builder.order.Results = append(builder.order.Results, builder.result)
+		builder.comments = &builder.result.Comments
+	case *CommentRecord:
+		if builder.comments != nil {
+			*builder.comments = append(*builder.comments, typed)
+		}
+	case *RequestRecord:
+		builder.patient, builder.order, builder.result = nil, nil, nil
+		message.Requests = append(message.Requests, typed)
+		builder.comments = &message.Comments
+	case *TerminatorRecord:
+		message.Terminator = typed
+	}
+	return nil
+}
+
+// violation fails the parsing with err when the strictness is at least the one given, otherwise the
+// record is only left out of the hierarchy
+func (builder *hierarchyBuilder) violation(err error, failsUpTo Strictness) error {
+	if builder.strictness <= failsUpTo {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package astm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// messageJSON is the JSON shape of a message, see Message.MarshalJSON
+type messageJSON struct {
+	Delimiters delimitersJSON `json:"delimiters"`
+	Records    []recordJSON   `json:"records"`
+}
+
+// delimitersJSON is the JSON shape of the delimiters of a message, each a string of a single character
+type delimitersJSON struct {
+	Field     string `json:"field"`
+	Repeat    string `json:"repeat"`
+	Component string `json:"component"`
+	Escape    string `json:"escape"`
+}
+
+// recordJSON is the JSON shape of a record
+type recordJSON struct {
+	Type   string  `json:"type"`
+	Fields []Field `json:"fields"`
+}
+
+// MarshalJSON writes the message as JSON, in a shape which stays the same across versions:
+//
+//	{
+//	  "delimiters": {"field": "|", "repeat": "\\", "component": "^", "escape": "&"},
+//	  "records": [
+//	    {"type": "H", "fields": [[["H"]], [["\\^&"]], [], [], [["Analyzer", "1.0"]]]},
+//	    {"type": "R", "fields": [[["R"]], [["1"]], [["", "", "", "GLU"]], [["5.4"]]]}
+//	  ]
+//	}
+//
+// Every record holds all its fields as SerializeRecord writes them, the record type first, so that the field
+// numbered n by the standard is fields[n-1]. A field is an array of repeats, a repeat an array of components,
+// an empty field an empty array. The values are unescaped and the delimiter definition of the header is kept
+// as a single value.
+func (message *Message) MarshalJSON() ([]byte, error) {
+	lines := message.Lines()
+	delimiters := message.declaredDelimiters()
+	encoded := messageJSON{
+		Delimiters: delimitersJSON{
+			Field:     string(delimiters.Field),
+			Repeat:    string(delimiters.Repeat),
+			Component: string(delimiters.Component),
+			Escape:    string(delimiters.Escape),
+		},
+		Records: make([]recordJSON, 0, len(lines)),
+	}
+	for index, line := range lines {
+		if line == "" {
+			return nil, fmt.Errorf("record %v of the message is empty", index+1)
+		}
+		fields, _, err := splitFields(line, delimiters, EscapePassThrough, CharsetPassThrough)
+		if err != nil {
+			return nil, err
+		}
+		for position, recordField := range fields {
+			if isEmptyField(recordField) {
+				fields[position] = Field{}
+			}
+		}
+		encoded.Records = append(encoded.Records, recordJSON{Type: line[:1], Fields: fields})
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON reads a message written by MarshalJSON, the records typed like ParseMessage types them,
+// including the record types registered with RegisterRecordType. The records are not checked, like
+// ParseMessage does with Strictness Off, but the message has to start with a header record.
+func (message *Message) UnmarshalJSON(data []byte) error {
+	var decoded messageJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	delimiters, err := decoded.Delimiters.delimiters()
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0, len(decoded.Records))
+	for index, record := range decoded.Records {
+		if len(record.Type) != 1 {
+			return fmt.Errorf("record %v has an invalid record type %q", index+1, record.Type)
+		}
+		fields := append([]Field(nil), record.Fields...)
+		if len(fields) == 0 {
+			fields = append(fields, nil)
+		}
+		fields[0] = Field{{record.Type}}
+		isHeader := record.Type == "H"
+		if isHeader {
+			for len(fields) < 2 {
+				fields = append(fields, nil)
+			}
+			fields[1] = Field{{string([]byte{delimiters.Repeat, delimiters.Component, delimiters.Escape})}}
+		}
+		lines = append(lines, joinFields(fields, delimiters, isHeader))
+	}
+	parsed, err := ParseMessage(strings.Join(lines, "\n"), ParseOptions{Strictness: Off})
+	if err != nil {
+		return err
+	}
+	*message = *parsed
+	return nil
+}
+
+// delimiters checks that the delimiters are single characters and gives them
+func (decoded delimitersJSON) delimiters() (Delimiters, error) {
+	values := []string{decoded.Field, decoded.Repeat, decoded.Component, decoded.Escape}
+	for _, value := range values {
+		if len(value) != 1 {
+			return Delimiters{}, errors.New("delimiters have to be single characters")
+		}
+	}
+	return Delimiters{Field: decoded.Field[0], Repeat: decoded.Repeat[0], Component: decoded.Component[0], Escape: decoded.Escape[0]}, nil
+}
@@ -0,0 +1,275 @@
+package astm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	fieldType = reflect.TypeOf(Field(nil))
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// Unmarshal parses a record line into the struct v points to, following the astm tags of its fields.
+// A tag gives the position of the value as field.repeat.component, numbered from 1 with the record type
+// at field 1, like `astm:"5.1.4"` for the test code of the Universal Test ID of an order. A repeat or
+// component left out is the first one. Tagged fields can be strings, integers, floats and time.Time,
+// which hold a single component, []string, which holds the components of a repeat, and Field, which
+// holds a whole field or, with a repeat given, a single repeat. Escape sequences are decoded.
+// The line is split with the delimiters given, the default ones otherwise.
+func Unmarshal(recordLine string, v any, delimiters ...Delimiters) error {
+	if len(recordLine) == 0 {
+		return errors.New("empty record line")
+	}
+	fields, _, err := splitFields(recordLine, delimitersOrDefault(delimiters), EscapePassThrough, CharsetPassThrough)
+	if err != nil {
+		return err
+	}
+	return UnmarshalFields(fields, v)
+}
+
+// UnmarshalFields sets the struct v points to from the fields of a record already split, like the ones
+// a RecordFactory gets, see Unmarshal
+func UnmarshalFields(fields []Field, v any) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unmarshal target has to be a non nil pointer to a struct, got %T", v)
+	}
+	return forEachTagged(target.Elem(), func(name string, value reflect.Value, tag fieldTag) error {
+		recordField := field(fields, tag.field)
+		switch value.Type() {
+		case fieldType:
+			if tag.repeat > 0 {
+				recordField = recordField.Repeat(tag.repeat)
+			}
+			value.Set(reflect.ValueOf(recordField))
+		case reflect.TypeOf([]string(nil)):
+			components := recordField.Repeat(max(tag.repeat, 1))
+			if len(components) > 0 {
+				value.Set(reflect.ValueOf(append([]string(nil), components[0]...)))
+			}
+		default:
+			text := recordField.Repeat(max(tag.repeat, 1)).Component(max(tag.component, 1))
+			if err := setScalar(value, text); err != nil {
+				return fmt.Errorf("field %v: %w", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Marshal writes the struct v, or the struct it points to, as a record line following the astm tags of
+// its fields, see Unmarshal, leaving out the empty fields at the end. The record type has to be tagged
+// at field 1. A tag with the omitempty option, like `astm:"4,omitempty"`, leaves a zero number empty
+// instead of writing 0.
+// Delimiters and control characters in the values are written as escape sequences with the delimiters
+// given, the default ones otherwise, which a header record also declares in its field 2.
+func Marshal(v any, delimiters ...Delimiters) (string, error) {
+	source := reflect.ValueOf(v)
+	if source.Kind() == reflect.Pointer && !source.IsNil() {
+		source = source.Elem()
+	}
+	if source.Kind() != reflect.Struct {
+		return "", fmt.Errorf("marshal source has to be a struct or a pointer to one, got %T", v)
+	}
+	chosen := delimitersOrDefault(delimiters)
+	var fields []Field
+	err := forEachTagged(source, func(name string, value reflect.Value, tag fieldTag) error {
+		for len(fields) < tag.field {
+			fields = append(fields, nil)
+		}
+		recordField := &fields[tag.field-1]
+		switch value.Type() {
+		case fieldType:
+			if tag.repeat == 0 {
+				*recordField = value.Interface().(Field)
+				return nil
+			}
+			// a Field tagged with a repeat is written as that repeat, from its first one
+			if repeat := value.Interface().(Field); len(repeat) > 0 {
+				for component, text := range repeat[0] {
+					recordField.SetRepeatComponent(tag.repeat, component+1, text)
+				}
+			}
+		case reflect.TypeOf([]string(nil)):
+			for component, text := range value.Interface().([]string) {
+				recordField.SetRepeatComponent(max(tag.repeat, 1), component+1, text)
+			}
+		default:
+			text, err := formatScalar(value, tag.omitEmpty)
+			if err != nil {
+				return fmt.Errorf("field %v: %w", name, err)
+			}
+			recordField.SetRepeatComponent(max(tag.repeat, 1), max(tag.component, 1), text)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	// the fields after the last value are left out
+	for len(fields) > 0 && isEmptyField(fields[len(fields)-1]) {
+		fields = fields[:len(fields)-1]
+	}
+	recordType := field(fields, 1).Component(1)
+	if len(recordType) != 1 {
+		return "", fmt.Errorf("record type at field 1 has to be a single character, got %q", recordType)
+	}
+	isHeader := recordType == "H"
+	if isHeader {
+		for len(fields) < 2 {
+			fields = append(fields, nil)
+		}
+		fields[1] = Field{{string([]byte{chosen.Repeat, chosen.Component, chosen.Escape})}}
+	}
+	return joinFields(fields, chosen, isHeader), nil
+}
+
+// isEmptyField tells whether a field holds no value at all
+func isEmptyField(recordField Field) bool {
+	for _, repeat := range recordField {
+		for _, component := range repeat {
+			if component != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fieldTag is the position an astm tag gives, a repeat or component of zero is not given
+type fieldTag struct {
+	field     int
+	repeat    int
+	component int
+	omitEmpty bool
+}
+
+// parseTag parses an astm tag like "3", "3.2" or "3.2.1,omitempty"
+func parseTag(tag string) (fieldTag, error) {
+	position, option, _ := strings.Cut(tag, ",")
+	parsed := fieldTag{omitEmpty: option == "omitempty"}
+	if option != "" && !parsed.omitEmpty {
+		return fieldTag{}, fmt.Errorf("unknown tag option %q", option)
+	}
+	parts := strings.Split(position, ".")
+	if len(parts) > 3 {
+		return fieldTag{}, fmt.Errorf("tag %q has more than field, repeat and component", tag)
+	}
+	numbers := make([]int, 3)
+	for index, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 1 {
+			return fieldTag{}, fmt.Errorf("tag %q has an invalid position %q", tag, part)
+		}
+		numbers[index] = number
+	}
+	parsed.field, parsed.repeat, parsed.component = numbers[0], numbers[1], numbers[2]
+	return parsed, nil
+}
+
+// forEachTagged calls handle with every exported field of the struct carrying an astm tag, which is checked
+// against the type of the field
+func forEachTagged(structValue reflect.Value, handle func(name string, value reflect.Value, tag fieldTag) error) error {
+	structType := structValue.Type()
+	for index := 0; index < structType.NumField(); index++ {
+		structField := structType.Field(index)
+		rawTag, ok := structField.Tag.Lookup("astm")
+		if !ok || rawTag == "-" || !structField.IsExported() {
+			continue
+		}
+		tag, err := parseTag(rawTag)
+		if err != nil {
+			return fmt.Errorf("field %v: %w", structField.Name, err)
+		}
+		switch structField.Type {
+		case fieldType:
+			if tag.component > 0 {
+				return fmt.Errorf("field %v: a Field cannot be tagged with a component", structField.Name)
+			}
+		case reflect.TypeOf([]string(nil)):
+			if tag.component > 0 {
+				return fmt.Errorf("field %v: a []string holds a repeat and cannot be tagged with a component", structField.Name)
+			}
+		}
+		if err := handle(structField.Name, structValue.Field(index), tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setScalar sets a string, number or time.Time from the text of a component, an empty text sets the zero value
+func setScalar(value reflect.Value, text string) error {
+	if text == "" {
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	}
+	if value.Type() == timeType {
+		parsed, err := ParseTimestamp(text)
+		if err != nil {
+			return err
+		}
+		value.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		value.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", text)
+		}
+		value.SetUint(number)
+	case reflect.Float32, reflect.Float64:
+		number, err := strconv.ParseFloat(text, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		value.SetFloat(number)
+	default:
+		return fmt.Errorf("unsupported type %v", value.Type())
+	}
+	return nil
+}
+
+// formatScalar writes a string, number or time.Time as the text of a component, a zero time.Time is left empty
+func formatScalar(value reflect.Value, omitEmpty bool) (string, error) {
+	if value.Type() == timeType {
+		return FormatTimestamp(value.Interface().(time.Time)), nil
+	}
+	if omitEmpty && value.IsZero() {
+		return "", nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, value.Type().Bits()), nil
+	default:
+		return "", fmt.Errorf("unsupported type %v", value.Type())
+	}
+}
+
+// delimitersOrDefault gives the delimiters given, the default ones otherwise
+func delimitersOrDefault(delimiters []Delimiters) Delimiters {
+	if len(delimiters) > 0 {
+		return delimiters[0]
+	}
+	return DefaultDelimiters
+}
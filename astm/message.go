@@ -0,0 +1,82 @@
+package astm
+
+// The termination codes of the terminator record, telling why the message ended
+const (
+	TerminationNormal          = "N"
+	TerminationSenderAborted   = "T"
+	TerminationReceiverAborted = "R"
+	TerminationUnknownError    = "E"
+	// TerminationQueryError answers a request record which could not be processed
+	TerminationQueryError = "Q"
+	// TerminationNoInformation answers a request record for which there is nothing
+	TerminationNoInformation = "I"
+	// TerminationQueryProcessed answers a request record which was processed
+	TerminationQueryProcessed = "F"
+)
+
+// NewMessage assembles a message from a header, the patients with their orders, results and comments,
+// and a terminator with the termination code given, numbering the records as the standard wants.
+// The records are copied, the ones given are left as they are. A nil header declares the default delimiters.
+func NewMessage(header *HeaderRecord, patients []*Patient, terminationCode string) *Message {
+	if header == nil {
+		header = &HeaderRecord{Delimiters: DefaultDelimiters}
+	}
+	headerCopy := *header
+	if headerCopy.Delimiters == (Delimiters{}) {
+		headerCopy.Delimiters = DefaultDelimiters
+	}
+	message := &Message{Delimiters: headerCopy.Delimiters, Header: &headerCopy}
+	message.Records = append(message.Records, message.Header)
+
+	for patientIndex, patient := range patients {
+		patientRecord := PatientRecord{}
+		if patient.PatientRecord != nil {
+			patientRecord = *patient.PatientRecord
+		}
+		patientRecord.SequenceNumber = patientIndex + 1
+		numberedPatient := &Patient{PatientRecord: &patientRecord}
+		message.Records = append(message.Records, numberedPatient.PatientRecord)
+		numberedPatient.Comments = message.addComments(patient.Comments)
+
+		for orderIndex, order := range patient.Orders {
+			orderRecord := OrderRecord{}
+			if order.OrderRecord != nil {
+				orderRecord = *order.OrderRecord
+			}
+			orderRecord.SequenceNumber = orderIndex + 1
+			numberedOrder := &Order{OrderRecord: &orderRecord}
+			message.Records = append(message.Records, numberedOrder.OrderRecord)
+			numberedOrder.Comments = message.addComments(order.Comments)
+
+			for resultIndex, result := range order.Results {
+				resultRecord := ResultRecord{}
+				if result.ResultRecord != nil {
+					resultRecord = *result.ResultRecord
+				}
+				resultRecord.SequenceNumber = resultIndex + 1
+				numberedResult := &Result{ResultRecord: &resultRecord}
+				message.Records = append(message.Records, numberedResult.ResultRecord)
+				numberedResult.Comments = message.addComments(result.Comments)
+				numberedOrder.Results = append(numberedOrder.Results, numberedResult)
+			}
+			numberedPatient.Orders = append(numberedPatient.Orders, numberedOrder)
+		}
+		message.Patients = append(message.Patients, numberedPatient)
+	}
+
+	message.Terminator = &TerminatorRecord{SequenceNumber: 1, TerminationCode: NewField(terminationCode)}
+	message.Records = append(message.Records, message.Terminator)
+	return message
+}
+
+// addComments appends copies of the comments of a record to the records of the message, numbered from 1
+func (message *Message) addComments(comments []*CommentRecord) []*CommentRecord {
+	var numbered []*CommentRecord
+	for index, comment := range comments {
+		commentRecord := *comment
+		commentRecord.SequenceNumber = index + 1
+		message.Records = append(message.Records, &commentRecord)
+		numbered = append(numbered, &commentRecord)
+	}
+	return numbered
+}
@@ -0,0 +1,248 @@
+package astm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseOptions tunes the parsing of a message, a field left at its zero value keeps its default
+type ParseOptions struct {
+	// UnknownEscapes tells what to do with the escape sequences the standard does not define, they are passed through by default
+	UnknownEscapes EscapePolicy
+	// Strictness tells which violations of the standard fail the parsing, all of them by default
+	Strictness Strictness
+	// Charset tells how the bytes of the values are decoded, they are kept as they were received by default
+	Charset Charset
+	// Pipeline runs on the records once the message is parsed, transforming or rejecting them
+	Pipeline Pipeline
+}
+
+// Strictness tells how closely a message has to follow the standard to be parsed, as many instruments
+// do not follow it to the letter. The message has to start with a header record in any case, as it
+// declares the delimiters.
+type Strictness int
+
+const (
+	// Strict checks that the sequence numbers restart at 1 under every parent record, that the orders
+	// follow a patient and the results an order, and that the message ends with a terminator record
+	Strict Strictness = iota
+	// Lenient accepts wrong sequence numbers, orders and results without a parent, which are left out
+	// of the hierarchy but kept in Records, and a missing terminator record. A second header record or
+	// a record after the terminator still fail the parsing.
+	Lenient
+	// Off checks nothing, the records which do not fit in the hierarchy are only kept in Records
+	Off
+)
+
+// ParseMessage parses an assembled ASTM message, one record per line, into typed records.
+// The delimiters are taken from the header record the message has to start with and the escape
+// sequences of the values are decoded. The sequence numbers and the order of the records are checked
+// as strictly as the options tell, a failure is returned as a *ParseError telling which record and
+// field are at fault.
+// It is optionally tuned by options.
+func ParseMessage(raw string, options ...ParseOptions) (*Message, error) {
+	var parseOptions ParseOptions
+	if len(options) > 0 {
+		parseOptions = options[0]
+	}
+	lines := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == '\n' || r == '\r'
+	})
+	if len(lines) == 0 {
+		return nil, errors.New("empty message")
+	}
+	delimiters, err := DetectDelimiters(lines[0])
+	if err != nil {
+		return nil, &ParseError{Line: 1, Err: err}
+	}
+
+	message := &Message{Delimiters: delimiters}
+	hierarchy := newHierarchyBuilder(message, parseOptions.Strictness)
+	sequence := newSequenceTracker()
+	for lineNumber, line := range lines {
+		fields, position, err := splitFields(line, delimiters, parseOptions.UnknownEscapes, parseOptions.Charset)
+		if err != nil {
+			return nil, &ParseError{Line: lineNumber + 1, Field: position, Err: err}
+		}
+		record, position, err := parseRecord(line[0], fields, sequence, delimiters, parseOptions.Strictness)
+		if err != nil {
+			return nil, &ParseError{Line: lineNumber + 1, Field: position, Err: err}
+		}
+		if err := hierarchy.add(record); err != nil {
+			return nil, &ParseError{Line: lineNumber + 1, Err: err}
+		}
+		message.Records = append(message.Records, record)
+	}
+	if parseOptions.Strictness == Strict && message.Terminator == nil {
+		return nil, &ParseError{Line: len(lines), Err: errors.New("message does not end with a terminator record")}
+	}
+	if err := parseOptions.Pipeline.Process(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// DetectDelimiters reads the field, repeat, component and escape delimiters an instrument declares
+// in positions 2 to 5 of its header record, like `|\^&` in `H|\^&|||Analyzer`. The delimiters
+// have to differ from each other and cannot be letters, digits, spaces or line breaks.
+func DetectDelimiters(header string) (Delimiters, error) {
+	if len(header) < 5 || header[0] != 'H' {
+		return Delimiters{}, errors.New("message does not start with a header record")
+	}
+	delimiters := Delimiters{Field: header[1], Repeat: header[2], Component: header[3], Escape: header[4]}
+	declared := header[1:5]
+	for index := 0; index < len(declared); index++ {
+		if !isValidDelimiter(declared[index]) {
+			return Delimiters{}, fmt.Errorf("header record declares invalid delimiter %q", declared[index])
+		}
+		if strings.IndexByte(declared[index+1:], declared[index]) >= 0 {
+			return Delimiters{}, fmt.Errorf("header record declares duplicate delimiters %q", declared)
+		}
+	}
+	return delimiters, nil
+}
+
+// isValidDelimiter tells whether a character can delimit, letters, digits, spaces and control characters cannot
+func isValidDelimiter(character byte) bool {
+	isAlphanumeric := character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' || character >= '0' && character <= '9'
+	return !isAlphanumeric && character > ' ' && character < 0x7f
+}
+
+// splitFields splits a record line into its fields, repeats and components, decodes their escape
+// sequences and then their charset, failing with the position of the field holding an escape sequence the
+// policy rejects. The delimiter definition of a header record is kept as a single value.
+func splitFields(line string, delimiters Delimiters, policy EscapePolicy, charset Charset) ([]Field, int, error) {
+	rawFields := strings.Split(line, string(delimiters.Field))
+	fields := make([]Field, 0, len(rawFields))
+	for index, rawField := range rawFields {
+		if line[0] == 'H' && index == 1 {
+			fields = append(fields, Field{{rawField}})
+			continue
+		}
+		var field Field
+		for _, repeat := range strings.Split(rawField, string(delimiters.Repeat)) {
+			components := strings.Split(repeat, string(delimiters.Component))
+			for componentIndex, component := range components {
+				unescaped, err := unescape(component, delimiters, policy)
+				if err != nil {
+					return nil, index + 1, err
+				}
+				components[componentIndex] = charset.Decode(unescaped)
+			}
+			field = append(field, components)
+		}
+		fields = append(fields, field)
+	}
+	return fields, 0, nil
+}
+
+// parseRecord builds the record of a line from its fields, with the factory registered for the record type
+// or as a standard record, failing with the position of the field at fault. Wrong sequence numbers are only
+// failed when strict.
+func parseRecord(recordType byte, fields []Field, sequence *sequenceTracker, delimiters Delimiters, strictness Strictness) (Record, int, error) {
+	if factory := registeredRecordType(recordType); factory != nil {
+		record, err := factory(fields)
+		if err == nil && record == nil {
+			err = fmt.Errorf("factory of record type %q returned no record", recordType)
+		}
+		return record, 0, err
+	}
+	sequenceNumber := 0
+	if recordType != 'H' {
+		var err error
+		if sequenceNumber, err = sequence.next(recordType, fields); err != nil && strictness == Strict {
+			return nil, 2, err
+		}
+	}
+	record, err := newRecord(recordType, sequenceNumber, fields, delimiters)
+	if err != nil {
+		return nil, 1, err
+	}
+	return record, 0, nil
+}
+
+// newRecord builds the typed record for the record type
+func newRecord(recordType byte, sequenceNumber int, fields []Field, delimiters Delimiters) (Record, error) {
+	var record Record
+	switch recordType {
+	case 'H':
+		record = &HeaderRecord{Delimiters: delimiters}
+	case 'P':
+		record = &PatientRecord{SequenceNumber: sequenceNumber}
+	case 'O':
+		record = &OrderRecord{SequenceNumber: sequenceNumber}
+	case 'R':
+		record = &ResultRecord{SequenceNumber: sequenceNumber}
+	case 'C':
+		record = &CommentRecord{SequenceNumber: sequenceNumber}
+	case 'Q':
+		record = &RequestRecord{SequenceNumber: sequenceNumber}
+	case 'M':
+		record = &ManufacturerRecord{SequenceNumber: sequenceNumber}
+	case 'S':
+		record = &ScientificRecord{SequenceNumber: sequenceNumber}
+	case 'L':
+		record = &TerminatorRecord{SequenceNumber: sequenceNumber}
+	default:
+		return nil, fmt.Errorf("unknown record type %q", recordType)
+	}
+	recordLayout := recordLayout(record)
+	*recordLayout.fields = fields
+	for position, named := range recordLayout.named {
+		*named = field(fields, position)
+	}
+	return record, nil
+}
+
+// sequenceTracker checks the sequence numbers of the records of a message, they start at 1 and
+// increment for every record of the same type, restarting whenever a higher level record appears
+type sequenceTracker struct {
+	last map[byte]int
+}
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{last: make(map[byte]int)}
+}
+
+// next checks the sequence number of the record and returns it, along with the error when it is not the
+// one expected, or zero when it is not a number
+func (tracker *sequenceTracker) next(recordType byte, fields []Field) (int, error) {
+	rawSequenceNumber := ""
+	if sequenceField := field(fields, 2); len(sequenceField) > 0 {
+		rawSequenceNumber = sequenceField[0][0]
+	}
+	sequenceNumber, err := strconv.Atoi(rawSequenceNumber)
+	if err != nil {
+		return 0, fmt.Errorf("record %c has an invalid sequence number %q", recordType, rawSequenceNumber)
+	}
+	expected := tracker.last[recordType] + 1
+	if recordType == 'L' {
+		expected = 1
+	}
+	tracker.last[recordType] = sequenceNumber
+
+	// lower level records restart their numbering under a new parent
+	switch recordType {
+	case 'P':
+		delete(tracker.last, 'O')
+		delete(tracker.last, 'R')
+		delete(tracker.last, 'C')
+		delete(tracker.last, 'M')
+		delete(tracker.last, 'S')
+	case 'O':
+		delete(tracker.last, 'R')
+		delete(tracker.last, 'C')
+		delete(tracker.last, 'M')
+		delete(tracker.last, 'S')
+	case 'R':
+		delete(tracker.last, 'C')
+		delete(tracker.last, 'M')
+		delete(tracker.last, 'S')
+	}
+	if sequenceNumber != expected {
+		return sequenceNumber, fmt.Errorf("record %c has sequence number %v, expected %v", recordType, sequenceNumber, expected)
+	}
+	return sequenceNumber, nil
+}
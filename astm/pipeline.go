@@ -0,0 +1,77 @@
+package astm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRecordRejected is wrapped by the *ParseError of a record a RecordMiddleware rejected
+var ErrRecordRejected = errors.New("record rejected")
+
+// RecordMiddleware transforms a record of a parsed message in place, or validates it, an error rejecting
+// the message. The record is one of the typed records, like *OrderRecord, or the record a RecordFactory built.
+type RecordMiddleware func(record Record) error
+
+// Pipeline is a chain of RecordMiddleware run on every record of a message, in the order of the records,
+// each record going through the middleware in the order of the chain once the message is parsed
+type Pipeline []RecordMiddleware
+
+// NewPipeline chains the middleware given
+func NewPipeline(middleware ...RecordMiddleware) Pipeline {
+	return append(Pipeline(nil), middleware...)
+}
+
+// Then gives a pipeline running the middleware given after the ones of the pipeline, which is left as it is
+func (pipeline Pipeline) Then(middleware ...RecordMiddleware) Pipeline {
+	chained := make(Pipeline, 0, len(pipeline)+len(middleware))
+	return append(append(chained, pipeline...), middleware...)
+}
+
+// Process runs the pipeline on the records of the message, stopping at the first record rejected with a
+// *ParseError telling which one, wrapping ErrRecordRejected and the error of the middleware
+func (pipeline Pipeline) Process(message *Message) error {
+	for index, record := range message.Records {
+		for _, middleware := range pipeline {
+			if err := middleware(record); err != nil {
+				return &ParseError{Line: index + 1, Err: fmt.Errorf("%w: %w", ErrRecordRejected, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// NormalizeSpecimenIDs rewrites the specimen IDs of the orders with normalize, like strings.TrimSpace or
+// stripping the leading zeros an analyzer pads the barcode with
+func NormalizeSpecimenIDs(normalize func(specimenID string) string) RecordMiddleware {
+	return func(record Record) error {
+		if order, ok := record.(*OrderRecord); ok {
+			normalizeValues(order.SpecimenID, normalize)
+			normalizeValues(order.InstrumentSpecimenID, normalize)
+		}
+		return nil
+	}
+}
+
+// RedactPatientNames replaces the names of the patients, and of their mothers, with mask, so that a message
+// written to a log carries no name
+func RedactPatientNames(mask string) RecordMiddleware {
+	return func(record Record) error {
+		if patient, ok := record.(*PatientRecord); ok {
+			redact := func(string) string { return mask }
+			normalizeValues(patient.Name, redact)
+			normalizeValues(patient.MothersMaidenName, redact)
+		}
+		return nil
+	}
+}
+
+// normalizeValues rewrites the components of the field which are not empty
+func normalizeValues(field Field, normalize func(value string) string) {
+	for _, repeat := range field {
+		for index, value := range repeat {
+			if value != "" {
+				repeat[index] = normalize(value)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package astm
+
+// Delimiters are the field, repeat, component and escape delimiters declared in the header record
+type Delimiters struct {
+	Field     byte
+	Repeat    byte
+	Component byte
+	Escape    byte
+}
+
+// DefaultDelimiters are the delimiters recommended by the standard, declared as H|\^&
+var DefaultDelimiters = Delimiters{Field: '|', Repeat: '\\', Component: '^', Escape: '&'}
+
+// Record is implemented by all the typed records of a Message
+type Record interface {
+	// RecordType returns the record type identifier, like 'H' or 'R'
+	RecordType() byte
+}
+
+// HeaderRecord (H) identifies the sender and receiver and declares the delimiters of the message
+type HeaderRecord struct {
+	Delimiters       Delimiters
+	MessageControlID Field
+	AccessPassword   Field
+	SenderName       Field
+	ReceiverID       Field
+	Comment          Field
+	ProcessingID     Field
+	Version          Field
+	Timestamp        Field
+	Fields           []Field
+}
+
+// PatientRecord (P) carries the patient information
+type PatientRecord struct {
+	SequenceNumber       int
+	PracticePatientID    Field
+	LaboratoryPatientID  Field
+	PatientIDNumber3     Field
+	Name                 Field
+	MothersMaidenName    Field
+	Birthdate            Field
+	Sex                  Field
+	Race                 Field
+	Address              Field
+	Telephone            Field
+	AttendingPhysicianID Field
+	Height               Field
+	Weight               Field
+	Diagnosis            Field
+	Location             Field
+	Fields               []Field
+}
+
+// OrderRecord (O) carries a test order for a specimen
+type OrderRecord struct {
+	SequenceNumber       int
+	SpecimenID           Field
+	InstrumentSpecimenID Field
+	UniversalTestID      Field
+	Priority             Field
+	RequestedAt          Field
+	CollectedAt          Field
+	ActionCode           Field
+	SpecimenDescriptor   Field
+	OrderingPhysician    Field
+	ReportedAt           Field
+	ReportTypes          Field
+	Fields               []Field
+}
+
+// ResultRecord (R) carries the result of a single test
+type ResultRecord struct {
+	SequenceNumber  int
+	UniversalTestID Field
+	Value           Field
+	Units           Field
+	ReferenceRange  Field
+	AbnormalFlags   Field
+	AbnormalityType Field
+	Status          Field
+	OperatorID      Field
+	StartedAt       Field
+	CompletedAt     Field
+	InstrumentID    Field
+	Fields          []Field
+}
+
+// CommentRecord (C) carries a comment on the record preceding it
+type CommentRecord struct {
+	SequenceNumber int
+	Source         Field
+	Text           Field
+	Type           Field
+	Fields         []Field
+}
+
+// RequestRecord (Q) asks for information, like the orders of a specimen a host query asks the LIS for
+type RequestRecord struct {
+	SequenceNumber      int
+	StartingRangeID     Field
+	EndingRangeID       Field
+	UniversalTestID     Field
+	TimeLimits          Field
+	BeginningRequestAt  Field
+	EndingRequestAt     Field
+	RequestingPhysician Field
+	PhysicianTelephone  Field
+	UserField1          Field
+	UserField2          Field
+	StatusCodes         Field
+	Fields              []Field
+}
+
+// ManufacturerRecord (M) carries manufacturer defined information, only its record type and sequence number are standard
+type ManufacturerRecord struct {
+	SequenceNumber int
+	Fields         []Field
+}
+
+// ScientificRecord (S) carries the details of a result gathered for method evaluation
+type ScientificRecord struct {
+	SequenceNumber     int
+	AnalyticalMethod   Field
+	Instrumentation    Field
+	Reagents           Field
+	UnitsOfMeasure     Field
+	QualityControl     Field
+	SpecimenDescriptor Field
+	Container          Field
+	SpecimenID         Field
+	Analyte            Field
+	Result             Field
+	ResultUnits        Field
+	CollectedAt        Field
+	ResultedAt         Field
+	PreprocessingSteps Field
+	PatientDiagnosis   Field
+	PatientBirthdate   Field
+	PatientSex         Field
+	PatientRace        Field
+	Fields             []Field
+}
+
+// TerminatorRecord (L) ends the message
+type TerminatorRecord struct {
+	SequenceNumber  int
+	TerminationCode Field
+	Fields          []Field
+}
+
+func (record *HeaderRecord) RecordType() byte       { return 'H' }
+func (record *PatientRecord) RecordType() byte      { return 'P' }
+func (record *OrderRecord) RecordType() byte        { return 'O' }
+func (record *ResultRecord) RecordType() byte       { return 'R' }
+func (record *CommentRecord) RecordType() byte      { return 'C' }
+func (record *RequestRecord) RecordType() byte      { return 'Q' }
+func (record *ManufacturerRecord) RecordType() byte { return 'M' }
+func (record *ScientificRecord) RecordType() byte   { return 'S' }
+func (record *TerminatorRecord) RecordType() byte   { return 'L' }
+
+// Message is a parsed ASTM message, the records are kept in the order they were received in Records
+// and arranged in the hierarchy of the standard in Header, Patients and Terminator: every patient holds
+// its orders, every order its results, and every record the comments which follow it.
+type Message struct {
+	Delimiters Delimiters
+	Records    []Record
+	Header     *HeaderRecord
+	// Comments are the comments which follow the header or a request record
+	Comments   []*CommentRecord
+	Patients   []*Patient
+	Requests   []*RequestRecord
+	Terminator *TerminatorRecord
+}
+
+// layout gives access to the fields of a record: its sequence number, all the fields it was parsed from
+// and its named fields by position, as numbered by the standard starting with the record type at 1
+type layout struct {
+	sequenceNumber *int
+	fields         *[]Field
+	named          map[int]*Field
+}
+
+// recordLayout gives the layout of a record, the parser fills the named fields from it and the serializer writes them
+func recordLayout(record Record) layout {
+	switch typed := record.(type) {
+	case *HeaderRecord:
+		return layout{fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.MessageControlID, 4: &typed.AccessPassword, 5: &typed.SenderName, 10: &typed.ReceiverID,
+			11: &typed.Comment, 12: &typed.ProcessingID, 13: &typed.Version, 14: &typed.Timestamp,
+		}}
+	case *PatientRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.PracticePatientID, 4: &typed.LaboratoryPatientID, 5: &typed.PatientIDNumber3, 6: &typed.Name,
+			7: &typed.MothersMaidenName, 8: &typed.Birthdate, 9: &typed.Sex, 10: &typed.Race, 11: &typed.Address,
+			13: &typed.Telephone, 14: &typed.AttendingPhysicianID, 17: &typed.Height, 18: &typed.Weight,
+			19: &typed.Diagnosis, 26: &typed.Location,
+		}}
+	case *OrderRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.SpecimenID, 4: &typed.InstrumentSpecimenID, 5: &typed.UniversalTestID, 6: &typed.Priority,
+			7: &typed.RequestedAt, 8: &typed.CollectedAt, 12: &typed.ActionCode, 16: &typed.SpecimenDescriptor,
+			17: &typed.OrderingPhysician, 23: &typed.ReportedAt, 26: &typed.ReportTypes,
+		}}
+	case *ResultRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.UniversalTestID, 4: &typed.Value, 5: &typed.Units, 6: &typed.ReferenceRange,
+			7: &typed.AbnormalFlags, 8: &typed.AbnormalityType, 9: &typed.Status, 11: &typed.OperatorID,
+			12: &typed.StartedAt, 13: &typed.CompletedAt, 14: &typed.InstrumentID,
+		}}
+	case *CommentRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.Source, 4: &typed.Text, 5: &typed.Type,
+		}}
+	case *RequestRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.StartingRangeID, 4: &typed.EndingRangeID, 5: &typed.UniversalTestID, 6: &typed.TimeLimits,
+			7: &typed.BeginningRequestAt, 8: &typed.EndingRequestAt, 9: &typed.RequestingPhysician,
+			10: &typed.PhysicianTelephone, 11: &typed.UserField1, 12: &typed.UserField2, 13: &typed.StatusCodes,
+		}}
+	case *ManufacturerRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields}
+	case *ScientificRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.AnalyticalMethod, 4: &typed.Instrumentation, 5: &typed.Reagents, 6: &typed.UnitsOfMeasure,
+			7: &typed.QualityControl, 8: &typed.SpecimenDescriptor, 10: &typed.Container, 11: &typed.SpecimenID,
+			12: &typed.Analyte, 13: &typed.Result, 14: &typed.ResultUnits, 15: &typed.CollectedAt,
+			16: &typed.ResultedAt, 17: &typed.PreprocessingSteps, 18: &typed.PatientDiagnosis,
+			19: &typed.PatientBirthdate, 20: &typed.PatientSex, 21: &typed.PatientRace,
+		}}
+	case *TerminatorRecord:
+		return layout{sequenceNumber: &typed.SequenceNumber, fields: &typed.Fields, named: map[int]*Field{
+			3: &typed.TerminationCode,
+		}}
+	default:
+		return layout{}
+	}
+}
+
+// field returns the field with the given position, as numbered by the standard starting with
+// the record type at 1, or nil if the record is shorter than that
+func field(fields []Field, position int) Field {
+	if position < 1 || position > len(fields) {
+		return nil
+	}
+	return fields[position-1]
+}
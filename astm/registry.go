@@ -0,0 +1,78 @@
+package astm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RecordFactory builds a custom record from the fields of its record line, numbered by the standard from
+// the record type at 1, with the escape sequences decoded. An error fails the parsing of the message.
+type RecordFactory func(fields []Field) (Record, error)
+
+// FieldsRecord is implemented by custom records which can be serialized, RecordFields gives all the
+// fields of the record from the record type at 1
+type FieldsRecord interface {
+	Record
+	RecordFields() []Field
+}
+
+var (
+	registryMutex sync.RWMutex
+	customRecords = make(map[byte]RecordFactory)
+)
+
+// structuralRecordTypes are the record types the hierarchy of a message is made of, they cannot be replaced
+const structuralRecordTypes = "HPORCQL"
+
+// RegisterRecordType makes ParseMessage build the records of a type with the factory instead of failing on
+// an unknown record type, or instead of the standard M and S records for vendors giving them their own
+// meaning. The records are kept in Message.Records, their sequence numbers are left to the factory to check.
+// The record types structuring the message, H, P, O, R, C, Q and L, cannot be registered.
+// A nil factory removes the registration. It is safe to call from any go routine.
+func RegisterRecordType(recordType byte, factory RecordFactory) error {
+	for index := 0; index < len(structuralRecordTypes); index++ {
+		if structuralRecordTypes[index] == recordType {
+			return fmt.Errorf("record type %q is part of the message hierarchy and cannot be registered", recordType)
+		}
+	}
+	if !isValidRecordType(recordType) {
+		return fmt.Errorf("record type %q has to be a letter", recordType)
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if factory == nil {
+		delete(customRecords, recordType)
+	} else {
+		customRecords[recordType] = factory
+	}
+	return nil
+}
+
+// registeredRecordType gives the factory registered for the record type, or nil
+func registeredRecordType(recordType byte) RecordFactory {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return customRecords[recordType]
+}
+
+// isValidRecordType tells whether a record type identifier is a letter
+func isValidRecordType(recordType byte) bool {
+	return recordType >= 'A' && recordType <= 'Z' || recordType >= 'a' && recordType <= 'z'
+}
+
+// GenericRecord is a record kept as its fields, without a meaning given to them, for record types
+// registered with NewGenericRecord to be passed along rather than failing the parsing
+type GenericRecord struct {
+	Type   byte
+	Fields []Field
+}
+
+// NewGenericRecord is a RecordFactory keeping the record as a GenericRecord
+func NewGenericRecord(fields []Field) (Record, error) {
+	return &GenericRecord{Type: field(fields, 1).Component(1)[0], Fields: fields}, nil
+}
+
+func (record *GenericRecord) RecordType() byte { return record.Type }
+
+// RecordFields gives the fields the record was parsed from
+func (record *GenericRecord) RecordFields() []Field { return record.Fields }
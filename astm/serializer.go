@@ -0,0 +1,90 @@
+package astm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Lines writes the records of the message as record lines, ready to be sent with SendMessage, with the
+// delimiters declared by its header record, see SerializeRecord
+func (message *Message) Lines() []string {
+	delimiters := message.declaredDelimiters()
+	lines := make([]string, 0, len(message.Records))
+	for _, record := range message.Records {
+		lines = append(lines, SerializeRecord(record, delimiters))
+	}
+	return lines
+}
+
+// declaredDelimiters gives the delimiters the header record of the message declares, the default ones when it declares none
+func (message *Message) declaredDelimiters() Delimiters {
+	delimiters := message.Delimiters
+	for _, record := range message.Records {
+		if header, ok := record.(*HeaderRecord); ok && header.Delimiters != (Delimiters{}) {
+			delimiters = header.Delimiters
+			break
+		}
+	}
+	if delimiters == (Delimiters{}) {
+		delimiters = DefaultDelimiters
+	}
+	return delimiters
+}
+
+// SerializeRecord writes a record as a record line with the delimiters given. The sequence number and the
+// named fields which are set are written at their position, the other positions keep the Fields the record
+// was parsed from, so that a record parsed, changed and written back keeps the fields which have no name.
+// A custom record is written from its RecordFields when it implements FieldsRecord.
+// Delimiters and control characters in the values are written as escape sequences. A header record
+// declares the delimiters in its second field.
+func SerializeRecord(record Record, delimiters Delimiters) string {
+	recordLayout := recordLayout(record)
+	_, isHeader := record.(*HeaderRecord)
+	var fields []Field
+	if recordLayout.fields != nil {
+		fields = append(fields, *recordLayout.fields...)
+	} else if custom, ok := record.(FieldsRecord); ok {
+		fields = append(fields, custom.RecordFields()...)
+	}
+	set := func(position int, value Field) {
+		for len(fields) < position {
+			fields = append(fields, nil)
+		}
+		fields[position-1] = value
+	}
+
+	set(1, Field{{string(record.RecordType())}})
+	if isHeader {
+		set(2, Field{{string([]byte{delimiters.Repeat, delimiters.Component, delimiters.Escape})}})
+	} else if recordLayout.sequenceNumber != nil && *recordLayout.sequenceNumber > 0 {
+		set(2, Field{{strconv.Itoa(*recordLayout.sequenceNumber)}})
+	}
+	for position, named := range recordLayout.named {
+		if *named != nil {
+			set(position, *named)
+		}
+	}
+	return joinFields(fields, delimiters, isHeader)
+}
+
+// joinFields is the reverse of splitFields, it joins the fields, repeats and components of a record line
+// escaping their values, but for the delimiter definition of a header record
+func joinFields(fields []Field, delimiters Delimiters, isHeader bool) string {
+	rawFields := make([]string, 0, len(fields))
+	for index, field := range fields {
+		escapeValue := !(isHeader && index == 1)
+		repeats := make([]string, 0, len(field))
+		for _, components := range field {
+			if escapeValue {
+				escaped := make([]string, 0, len(components))
+				for _, component := range components {
+					escaped = append(escaped, escape(component, delimiters))
+				}
+				components = escaped
+			}
+			repeats = append(repeats, strings.Join(components, string(delimiters.Component)))
+		}
+		rawFields = append(rawFields, strings.Join(repeats, string(delimiters.Repeat)))
+	}
+	return strings.Join(rawFields, string(delimiters.Field))
+}
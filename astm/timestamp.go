@@ -0,0 +1,88 @@
+package astm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timestampLayout is the date and time format of the standard, YYYYMMDDHHMMSS
+const timestampLayout = "20060102150405"
+
+// dateLayout is the date format of the standard, YYYYMMDD, used for birthdates
+const dateLayout = "20060102"
+
+// ParseTimestamp parses a date or date and time of the standard, like 20240101 or 20240101123000,
+// in UTC unless it carries a time zone offset like 20240101123000+0100, see ParseTimestampInLocation
+func ParseTimestamp(value string) (time.Time, error) {
+	return ParseTimestampInLocation(value, time.UTC)
+}
+
+// ParseTimestampInLocation parses a date or date and time of the standard in the location given, used when
+// it carries no time zone offset. Timestamps cut short, down to the year, are accepted as analyzers
+// leave out the seconds or the time, the parts left out are zero. An empty value gives the zero time.
+func ParseTimestampInLocation(value string, location *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	digits, offset := value, ""
+	if index := strings.IndexAny(value, "+-"); index >= 0 {
+		digits, offset = value[:index], value[index:]
+	}
+	if len(digits) < 4 || len(digits) > len(timestampLayout) || len(digits)%2 != 0 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	layout := timestampLayout[:len(digits)]
+	if offset != "" {
+		if len(offset) != 5 {
+			return time.Time{}, fmt.Errorf("invalid time zone offset in timestamp %q", value)
+		}
+		layout += "-0700"
+	}
+	parsed, err := time.ParseInLocation(layout, digits+offset, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	return parsed, nil
+}
+
+// FormatTimestamp formats the date and time as YYYYMMDDHHMMSS, in the location of the time given, a zero time is left empty
+func FormatTimestamp(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.Format(timestampLayout)
+}
+
+// FormatTimestampWithZone formats the date and time as YYYYMMDDHHMMSS followed by its time zone offset, like +0100
+func FormatTimestampWithZone(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.Format(timestampLayout + "-0700")
+}
+
+// FormatDate formats the date as YYYYMMDD, a zero time is left empty
+func FormatDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(dateLayout)
+}
+
+// Time parses the first component of the field as a timestamp, see ParseTimestamp, like the
+// Timestamp of a header, the Birthdate of a patient or the CompletedAt of a result
+func (field Field) Time() (time.Time, error) {
+	return ParseTimestamp(field.Component(1))
+}
+
+// NewTimestampField creates a field holding the date and time formatted as YYYYMMDDHHMMSS
+func NewTimestampField(timestamp time.Time) Field {
+	return NewField(FormatTimestamp(timestamp))
+}
+
+// NewDateField creates a field holding the date formatted as YYYYMMDD, like the Birthdate of a patient
+func NewDateField(date time.Time) Field {
+	return NewField(FormatDate(date))
+}
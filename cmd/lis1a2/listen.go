@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+)
+
+// listen accepts the instruments dialing in on TCP and dumps the messages they send until interrupted
+func listen(args []string) error {
+	flags, verbose := newFlagSet("listen", "")
+	host := flags.String("host", "0.0.0.0", "address to listen on")
+	port := flags.String("port", "", "TCP port to listen on")
+	raw := flags.Bool("raw", false, "print the records as received as well")
+	trace := flags.Bool("trace", false, "trace the control characters and frames sent and received to stderr")
+	captureDir := flags.String("capture", "", "directory to write a capture file of the raw bytes of every instrument to, which sniff prints")
+	_ = flags.Parse(args)
+	setUpLogging(*verbose)
+	if *port == "" {
+		flags.Usage()
+		return errors.New("-port is required")
+	}
+
+	listener, err := connection.NewTCPListener(*host, *port)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, func() { _ = listener.Close() })
+	fmt.Printf("Listening on %s.\n", listener.Addr())
+
+	// printMutex keeps the messages of instruments connected at once from interleaving
+	var printMutex sync.Mutex
+	var routines sync.WaitGroup
+	defer routines.Wait()
+	for connected := 1; ; connected++ {
+		tcpConn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		tcpConn.Listen()
+		peer := fmt.Sprintf("Instrument %d", connected)
+		fmt.Printf("%s connected.\n", peer)
+		var conn connection.Connection = tcpConn
+		if *trace {
+			conn = connection.NewTracingConnection(tcpConn, os.Stderr)
+		}
+		var capture *os.File
+		if *captureDir != "" {
+			capture, err = os.Create(filepath.Join(*captureDir, fmt.Sprintf("instrument-%d.cap", connected)))
+			if err != nil {
+				tcpConn.Disconnect()
+				return err
+			}
+			conn = connection.NewCaptureConnection(conn, capture)
+		}
+		receiver := lis1a2.NewReceiver(conn, func(message string, err error) {
+			printMutex.Lock()
+			defer printMutex.Unlock()
+			fmt.Printf("%s Message from %s\n", time.Now().Format(time.DateTime), peer)
+			if err != nil {
+				fmt.Printf("  Failed: %v\n", err)
+				return
+			}
+			if *raw {
+				fmt.Print(message)
+			}
+			printMessage(os.Stdout, message)
+		})
+		routines.Add(1)
+		go func() {
+			defer routines.Done()
+			defer tcpConn.Disconnect()
+			if capture != nil {
+				defer capture.Close()
+			}
+			if err := receiver.Listen(ctx); err != nil && ctx.Err() == nil {
+				fmt.Printf("%s disconnected: %v\n", peer, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,67 @@
+// Command lis1a2 talks LIS1-A2 without writing Go: it sends ASTM message files to a host, listens as a host
+// dumping the messages received, and pretty prints raw captures of the line
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const usage = `Usage: lis1a2 <command> [flags] [arguments]
+
+Commands:
+  send    sends the message of a file, one record per line, to a host
+  listen  listens as a host and dumps the messages received, decoded
+  sniff   pretty prints a raw capture of the line or a capture file, frame by frame
+
+Run lis1a2 <command> -h for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "send":
+		err = send(os.Args[2:])
+	case "listen":
+		err = listen(os.Args[2:])
+	case "sniff":
+		err = sniff(os.Args[2:], os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lis1a2 %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet creates the flags of a command, with the -v flag every command has
+func newFlagSet(name string, arguments string) (*flag.FlagSet, *bool) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: lis1a2 %s [flags] %s\n\nFlags:\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	verbose := flags.Bool("v", false, "log what the library does to stderr")
+	return flags, verbose
+}
+
+// setUpLogging keeps the logs of the library quiet unless verbose
+func setUpLogging(verbose bool) {
+	if verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+}
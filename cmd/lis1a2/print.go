@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// printMessage prints a message received, one record per line, decoded as the patients, orders and
+// results it holds. A message which does not parse is printed as it is, with the reason.
+func printMessage(out io.Writer, text string) {
+	message, err := astm.ParseMessage(text, astm.ParseOptions{Strictness: astm.Lenient})
+	if err != nil {
+		fmt.Fprintf(out, "  Not a valid ASTM message: %v\n", err)
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+		return
+	}
+	printer := messagePrinter{out: out, delimiters: message.Delimiters}
+	if header := message.Header; header != nil {
+		printer.line(1, "Header", named("sender", header.SenderName), named("receiver", header.ReceiverID),
+			named("processing", header.ProcessingID), named("version", header.Version), named("at", header.Timestamp))
+	}
+	printer.comments(2, message.Comments)
+	for _, request := range message.Requests {
+		printer.line(1, fmt.Sprintf("Request %d", request.SequenceNumber), named("starting", request.StartingRangeID),
+			named("ending", request.EndingRangeID), named("tests", request.UniversalTestID), named("status", request.StatusCodes))
+	}
+	for _, patient := range message.Patients {
+		printer.line(1, fmt.Sprintf("Patient %d", patient.SequenceNumber), named("practice ID", patient.PracticePatientID),
+			named("laboratory ID", patient.LaboratoryPatientID), named("name", patient.Name), named("born", patient.Birthdate), named("sex", patient.Sex))
+		printer.comments(2, patient.Comments)
+		for _, order := range patient.Orders {
+			printer.line(2, fmt.Sprintf("Order %d", order.SequenceNumber), named("specimen", order.SpecimenID),
+				named("test", order.UniversalTestID), named("priority", order.Priority), named("action", order.ActionCode), named("report", order.ReportTypes))
+			printer.comments(3, order.Comments)
+			for _, result := range order.Results {
+				printer.line(3, fmt.Sprintf("Result %d", result.SequenceNumber), named("test", result.UniversalTestID),
+					named("value", result.Value), named("units", result.Units), named("range", result.ReferenceRange),
+					named("flags", result.AbnormalFlags), named("status", result.Status), named("completed", result.CompletedAt))
+				printer.comments(4, result.Comments)
+			}
+		}
+	}
+	if terminator := message.Terminator; terminator != nil {
+		printer.line(1, "Terminator", named("code", terminator.TerminationCode))
+	}
+}
+
+// messagePrinter prints records as their name followed by the fields which are set
+type messagePrinter struct {
+	out        io.Writer
+	delimiters astm.Delimiters
+}
+
+// namedField is a field of a record along with the name it is printed with
+type namedField struct {
+	name  string
+	field astm.Field
+}
+
+func named(name string, field astm.Field) namedField {
+	return namedField{name: name, field: field}
+}
+
+// line prints a record indented by depth, followed by the fields given which are set
+func (printer messagePrinter) line(depth int, record string, fields ...namedField) {
+	var builder strings.Builder
+	builder.WriteString(strings.Repeat("  ", depth) + record)
+	for _, field := range fields {
+		if value := printer.format(field.field); value != "" {
+			fmt.Fprintf(&builder, ", %s %s", field.name, value)
+		}
+	}
+	fmt.Fprintln(printer.out, builder.String())
+}
+
+func (printer messagePrinter) comments(depth int, comments []*astm.CommentRecord) {
+	for _, comment := range comments {
+		printer.line(depth, fmt.Sprintf("Comment %d", comment.SequenceNumber), named("source", comment.Source),
+			named("text", comment.Text), named("type", comment.Type))
+	}
+}
+
+// format writes a field back with the delimiters of the message, its repeats and components joined
+func (printer messagePrinter) format(field astm.Field) string {
+	repeats := make([]string, 0, len(field))
+	for _, components := range field {
+		repeats = append(repeats, strings.Join(components, string(printer.delimiters.Component)))
+	}
+	value := strings.Join(repeats, string(printer.delimiters.Repeat))
+	if strings.Trim(value, string([]byte{printer.delimiters.Component, printer.delimiters.Repeat})) == "" {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+)
+
+// send sends the message of a file to a host, over TCP or a serial port
+func send(args []string) error {
+	flags, verbose := newFlagSet("send", "<message file>")
+	host := flags.String("host", "localhost", "host to connect to")
+	port := flags.String("port", "", "TCP port to connect to")
+	serialPort := flags.String("serial", "", "serial port to send over instead of TCP, like /dev/ttyUSB0 or COM3")
+	baudRate := flags.Int("baud", 9600, "baud rate of the serial port, 8 data bits, no parity and 1 stop bit")
+	timeout := flags.Duration("timeout", time.Minute, "how long sending may take, connecting included")
+	frameSize := flags.Int("frame-size", 0, "most characters of text per frame, defaults to the standard 240")
+	trace := flags.Bool("trace", false, "trace the control characters and frames sent and received to stderr")
+	capturePath := flags.String("capture", "", "write the raw bytes sent and received to a capture file, which sniff prints")
+	_ = flags.Parse(args)
+	setUpLogging(*verbose)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected a single message file")
+	}
+	if *port == "" && *serialPort == "" {
+		return errors.New("either -port or -serial is required")
+	}
+
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	records := splitRecords(string(content))
+	if len(records) == 0 {
+		return fmt.Errorf("no records in %s", flags.Arg(0))
+	}
+
+	conn, err := connect(*host, *port, *serialPort, *baudRate, *timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Disconnect()
+	if *trace {
+		conn = connection.NewTracingConnection(conn, os.Stderr)
+	}
+	if *capturePath != "" {
+		capture, err := os.Create(*capturePath)
+		if err != nil {
+			return err
+		}
+		defer capture.Close()
+		conn = connection.NewCaptureConnection(conn, capture)
+	}
+	conn.Listen()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	sender := lis1a2.NewSender(conn, lis1a2.SenderOptions{MaxFrameSize: *frameSize})
+	if err := sender.SendRecords(ctx, records); err != nil {
+		return err
+	}
+	fmt.Printf("Sent %d records.\n", len(records))
+	return nil
+}
+
+// connect connects to the serial port when one is given, to the TCP host and port otherwise
+func connect(host string, port string, serialPort string, baudRate int, timeout time.Duration) (connection.Connection, error) {
+	if serialPort != "" {
+		serialConn := connection.NewSerialConnection(serialPort, baudRate, 8, serial.NoParity, serial.OneStopBit)
+		if err := serialConn.Connect(); err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", serialPort, err)
+		}
+		return &serialConn, nil
+	}
+	tcpConn := connection.NewTCPConnection(host, port, connection.Options{DialTimeout: timeout})
+	if err := tcpConn.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", net.JoinHostPort(host, port), err)
+	}
+	return &tcpConn, nil
+}
+
+// splitRecords splits the content of a message file into records, lines ending in CR, LF or both
+func splitRecords(content string) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\r", "\n")
+	var records []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			records = append(records, line)
+		}
+	}
+	return records
+}
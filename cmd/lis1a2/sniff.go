@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// captureTimeFormat is the timestamp starting the lines of a capture written by a CaptureConnection
+const captureTimeFormat = "2006-01-02 15:04:05.000000"
+
+// sniff pretty prints a raw capture of the line, or a capture file written by a CaptureConnection, read from
+// a file or from stdin for "-": the control characters by name, the frames with their number and checksum,
+// and the messages they carry decoded
+func sniff(args []string, out io.Writer) error {
+	flags, verbose := newFlagSet("sniff", "<capture file or - for stdin>")
+	_ = flags.Parse(args)
+	setUpLogging(*verbose)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected a single capture file")
+	}
+	var capture []byte
+	var err error
+	if flags.Arg(0) == "-" {
+		capture, err = io.ReadAll(os.Stdin)
+	} else {
+		capture, err = os.ReadFile(flags.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	printCapture(out, capture)
+	return nil
+}
+
+// printCapture prints the capture one control character, frame or run of other bytes per line,
+// and every message once its EOT is reached. A capture written by a CaptureConnection is printed chunk by
+// chunk, each line starting with the time and the direction of the chunk, the bytes of each direction
+// decoded on their own.
+func printCapture(out io.Writer, capture []byte) {
+	reader, err := connection.NewCaptureReader(bytes.NewReader(capture))
+	if err != nil {
+		printer := &linePrinter{out: out}
+		printer.print(capture)
+		printer.flush()
+		return
+	}
+	printers := map[connection.CaptureDirection]*linePrinter{
+		connection.CaptureSent:     {out: out},
+		connection.CaptureReceived: {out: out},
+	}
+	for {
+		chunk, err := reader.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintf(out, "Capture unreadable from there: %v\n", err)
+			}
+			break
+		}
+		printer := printers[chunk.Direction]
+		printer.prefix = chunk.Time.Format(captureTimeFormat) + " " + chunk.Direction.String() + " "
+		printer.print(chunk.Data)
+	}
+	printers[connection.CaptureSent].flush()
+	printers[connection.CaptureReceived].flush()
+}
+
+// linePrinter prints the bytes of one direction of the line as they come, keeping a frame split over
+// chunks until its end arrives and the records of the message being received until its EOT
+type linePrinter struct {
+	out io.Writer
+	// prefix starts every line describing bytes of the line
+	prefix          string
+	pending         []byte
+	record, message strings.Builder
+}
+
+// print prints the data after the bytes pending, keeping the start of a frame without its end pending
+func (printer *linePrinter) print(data []byte) {
+	capture := append(printer.pending, data...)
+	printer.pending = nil
+	out := printer.out
+	for len(capture) > 0 {
+		switch capture[0] {
+		case constants.STX:
+			end := bytes.IndexByte(capture, constants.LF)
+			if end < 0 {
+				printer.pending = capture
+				return
+			}
+			raw := capture[:end+1]
+			capture = capture[end+1:]
+			frame, err := protocol.DecodeFrame(raw)
+			if err != nil {
+				fmt.Fprintf(out, "%s%s    %v\n", printer.prefix, protocol.Describe(raw), err)
+				continue
+			}
+			checksum := "checksum ok"
+			if !frame.ChecksumValid {
+				checksum = "checksum mismatch"
+			}
+			fmt.Fprintf(out, "%s%s    frame %d, %s\n", printer.prefix, protocol.Describe(raw), frame.FrameNumber, checksum)
+			if frame.ChecksumValid {
+				printer.record.Write(frame.Text)
+				if frame.IsLast() {
+					printer.message.WriteString(strings.TrimSuffix(printer.record.String(), "\r") + "\n")
+					printer.record.Reset()
+				}
+			}
+		case constants.ENQ, constants.ACK, constants.NAK, constants.EOT:
+			fmt.Fprintln(out, printer.prefix+protocol.Describe(capture[:1]))
+			if capture[0] == constants.EOT && printer.message.Len() > 0 {
+				fmt.Fprintf(out, "Message\n%s", printer.message.String())
+				printMessage(out, printer.message.String())
+				printer.message.Reset()
+			}
+			if capture[0] == constants.EOT || capture[0] == constants.ENQ {
+				printer.record.Reset()
+			}
+			capture = capture[1:]
+		default:
+			end := 0
+			for end < len(capture) && !isLineControl(capture[end]) {
+				end++
+			}
+			fmt.Fprintf(out, "%s%s    not part of a frame\n", printer.prefix, protocol.Describe(capture[:end]))
+			capture = capture[end:]
+		}
+	}
+}
+
+// flush prints the start of a frame whose end never arrived
+func (printer *linePrinter) flush() {
+	if len(printer.pending) > 0 {
+		fmt.Fprintf(printer.out, "%sIncomplete frame %s\n", printer.prefix, protocol.Describe(printer.pending))
+		printer.pending = nil
+	}
+}
+
+// isLineControl tells whether a byte starts a frame or is a control character of its own
+func isLineControl(bt byte) bool {
+	switch bt {
+	case constants.STX, constants.ENQ, constants.ACK, constants.NAK, constants.EOT:
+		return true
+	}
+	return false
+}
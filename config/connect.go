@@ -0,0 +1,296 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/profiles"
+)
+
+// NewConnection builds the transport of the instrument, not connected yet
+func (instrument Instrument) NewConnection() (connection.Connection, error) {
+	if err := instrument.Validate(); err != nil {
+		return nil, err
+	}
+	options := instrument.connectionOptions()
+	switch instrument.Transport {
+	case "tcp":
+		host, port, _ := net.SplitHostPort(instrument.Address)
+		if reconnect := instrument.Reconnect; reconnect != nil {
+			tcpConn := connection.NewTCPConnectionWithReconnect(host, port, connection.ReconnectOptions{
+				MaxRetries:     reconnect.MaxRetries,
+				InitialBackoff: time.Duration(reconnect.InitialBackoff),
+				MaxBackoff:     time.Duration(reconnect.MaxBackoff),
+			}, options)
+			return &tcpConn, nil
+		}
+		tcpConn := connection.NewTCPConnection(host, port, options)
+		return &tcpConn, nil
+	case "tls":
+		host, port, _ := net.SplitHostPort(instrument.Address)
+		var tlsOptions connection.TLSOptions
+		if instrument.TLS != nil {
+			tlsOptions = connection.TLSOptions{
+				RootCAFile:     instrument.TLS.RootCAFile,
+				ServerName:     instrument.TLS.ServerName,
+				ClientCertFile: instrument.TLS.ClientCertFile,
+				ClientKeyFile:  instrument.TLS.ClientKeyFile,
+			}
+		}
+		tlsConfig, err := tlsOptions.Config()
+		if err != nil {
+			return nil, fmt.Errorf("instrument %v: %w", instrument.Name, err)
+		}
+		tlsConn := connection.NewTLSConnection(host, port, tlsConfig, options)
+		return &tlsConn, nil
+	case "mllp":
+		host, port, _ := net.SplitHostPort(instrument.Address)
+		mllpConn := connection.NewMLLPConnection(host, port, options)
+		return &mllpConn, nil
+	case "unix":
+		unixConn := connection.NewUnixConnection(instrument.Address, options)
+		return &unixConn, nil
+	default:
+		settings := instrument.Serial
+		baudRate, dataBits := settings.BaudRate, settings.DataBits
+		if baudRate <= 0 {
+			baudRate = 9600
+		}
+		if dataBits <= 0 {
+			dataBits = 8
+		}
+		parity, _ := parity(settings.Parity)
+		stopBits, _ := stopBits(settings.StopBits)
+		options.FlowControl, _ = flowControl(settings.FlowControl)
+		serialConn := connection.NewSerialConnection(settings.Port, baudRate, dataBits, parity, stopBits, options)
+		return &serialConn, nil
+	}
+}
+
+// NewASTMConnection builds the ASTMConnection of the instrument over its transport, its sender, receiver and
+// charset configured, ready to Connect and Listen. The record types of its profile are registered.
+func (instrument Instrument) NewASTMConnection() (*lis1a2.ASTMConnection, error) {
+	conn, err := instrument.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+	astmConn := lis1a2.NewASTMConnection(conn, false)
+	profile := instrument.profile()
+	profile.Sender = instrument.SenderOptions()
+	profile.Receiver = instrument.ReceiverOptions()
+	profile.Charset = instrument.charset()
+	if err := profile.Apply(astmConn); err != nil {
+		return nil, err
+	}
+	return astmConn, nil
+}
+
+// SenderOptions gives the options of the Sender of the instrument, the ones of its profile for the fields
+// left unset and the unknown names left at their default
+func (instrument Instrument) SenderOptions() lis1a2.SenderOptions {
+	sender := instrument.Sender
+	options := instrument.profile().Sender
+	if sender.MaxAttempts > 0 {
+		options.MaxAttempts = sender.MaxAttempts
+	}
+	if sender.BusyBackoff > 0 {
+		options.BusyBackoff = time.Duration(sender.BusyBackoff)
+	}
+	if sender.FrameReplyTimeout > 0 {
+		options.FrameReplyTimeout = time.Duration(sender.FrameReplyTimeout)
+	}
+	if sender.MaxFrameSize > 0 {
+		options.MaxFrameSize = sender.MaxFrameSize
+	}
+	if sender.Interrupt != "" {
+		options.InterruptPolicy, _ = interruptPolicy(sender.Interrupt)
+	}
+	if sender.Role != "" {
+		options.Role, _ = role(sender.Role)
+	}
+	if sender.ContentionBackoff > 0 {
+		options.ContentionBackoff = time.Duration(sender.ContentionBackoff)
+	}
+	return options
+}
+
+// ReceiverOptions gives the options of the Receiver of the instrument, the ones of its profile for the fields
+// left unset and the unknown names left at their default
+func (instrument Instrument) ReceiverOptions() lis1a2.ReceiverOptions {
+	receiver := instrument.Receiver
+	options := instrument.profile().Receiver
+	if receiver.ReceiveTimeout > 0 {
+		options.ReceiveTimeout = time.Duration(receiver.ReceiveTimeout)
+	}
+	if receiver.Compatibility != "" {
+		options.Compatibility, _ = compatibility(receiver.Compatibility)
+	}
+	options.WarnOnQuirks = options.WarnOnQuirks || receiver.WarnOnQuirks
+	if receiver.BusyAfterRejection > 0 {
+		options.BusyAfterRejection = time.Duration(receiver.BusyAfterRejection)
+	}
+	return options
+}
+
+// profile gives the profile the instrument names, the zero Profile when it names none
+func (instrument Instrument) profile() profiles.Profile {
+	profile, _ := profiles.Lookup(instrument.Profile)
+	return profile
+}
+
+// charset gives the charset of the instrument, the one of its profile when it is not configured
+func (instrument Instrument) charset() astm.Charset {
+	if instrument.Charset == "" {
+		return instrument.profile().Charset
+	}
+	charset, _ := charset(instrument.Charset)
+	return charset
+}
+
+// connectionOptions gives the options of the transport of the instrument, but its flow control
+func (instrument Instrument) connectionOptions() connection.Options {
+	overflow, _ := overflowPolicy(instrument.Connection.Overflow)
+	family, _ := addressFamily(instrument.Connection.AddressFamily)
+	options := connection.Options{
+		WriteBufferSize:     instrument.Connection.WriteBufferSize,
+		ReadBufferSize:      instrument.Connection.ReadBufferSize,
+		OverflowPolicy:      overflow,
+		DialTimeout:         time.Duration(instrument.Connection.DialTimeout),
+		ReadIdleTimeout:     time.Duration(instrument.Connection.ReadIdleTimeout),
+		WriteTimeout:        time.Duration(instrument.Connection.WriteTimeout),
+		AddressFamily:       family,
+		KeepAlive:           time.Duration(instrument.Connection.KeepAlive),
+		Nagle:               instrument.Connection.Nagle,
+		Linger:              time.Duration(instrument.Connection.Linger),
+		SocketReceiveBuffer: instrument.Connection.SocketReceiveBuffer,
+		SocketSendBuffer:    instrument.Connection.SocketSendBuffer,
+	}
+	if instrument.Connection.Proxy != "" {
+		options.Proxy, _ = connection.ParseProxyURL(instrument.Connection.Proxy)
+	}
+	return options
+}
+
+func overflowPolicy(name string) (connection.OverflowPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "block":
+		return connection.OverflowBlock, nil
+	case "drop":
+		return connection.OverflowDrop, nil
+	case "error":
+		return connection.OverflowError, nil
+	case "nak":
+		return connection.OverflowNAK, nil
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q, expected block, drop, error or nak", name)
+}
+
+func addressFamily(name string) (connection.AddressFamily, error) {
+	switch strings.ToLower(name) {
+	case "", "any":
+		return connection.AnyFamily, nil
+	case "prefer_ipv4":
+		return connection.PreferIPv4, nil
+	case "prefer_ipv6":
+		return connection.PreferIPv6, nil
+	case "ipv4":
+		return connection.IPv4Only, nil
+	case "ipv6":
+		return connection.IPv6Only, nil
+	}
+	return 0, fmt.Errorf("unknown address family %q, expected any, prefer_ipv4, prefer_ipv6, ipv4 or ipv6", name)
+}
+
+func interruptPolicy(name string) (lis1a2.InterruptPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "after_message":
+		return lis1a2.InterruptAfterMessage, nil
+	case "immediately":
+		return lis1a2.InterruptImmediately, nil
+	}
+	return 0, fmt.Errorf("unknown interrupt policy %q, expected after_message or immediately", name)
+}
+
+func role(name string) (constants.Role, error) {
+	switch strings.ToLower(name) {
+	case "", "instrument":
+		return constants.Instrument, nil
+	case "computer_system":
+		return constants.ComputerSystem, nil
+	}
+	return 0, fmt.Errorf("unknown role %q, expected instrument or computer_system", name)
+}
+
+func compatibility(name string) (lis1a2.Compatibility, error) {
+	switch strings.ToLower(name) {
+	case "", "strict":
+		return lis1a2.Strict, nil
+	case "tolerant":
+		return lis1a2.Tolerant, nil
+	}
+	return 0, fmt.Errorf("unknown compatibility %q, expected strict or tolerant", name)
+}
+
+func charset(name string) (astm.Charset, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return astm.CharsetPassThrough, nil
+	case "utf-8", "utf8":
+		return astm.CharsetUTF8, nil
+	case "latin1", "iso-8859-1":
+		return astm.CharsetLatin1, nil
+	case "windows-1252", "cp1252":
+		return astm.CharsetWindows1252, nil
+	case "ascii", "us-ascii":
+		return astm.CharsetASCII, nil
+	}
+	return 0, fmt.Errorf("unknown charset %q, expected utf-8, latin1, windows-1252 or ascii", name)
+}
+
+func parity(name string) (serial.Parity, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return serial.NoParity, nil
+	case "odd":
+		return serial.OddParity, nil
+	case "even":
+		return serial.EvenParity, nil
+	case "mark":
+		return serial.MarkParity, nil
+	case "space":
+		return serial.SpaceParity, nil
+	}
+	return 0, fmt.Errorf("unknown parity %q, expected none, odd, even, mark or space", name)
+}
+
+func stopBits(bits float64) (serial.StopBits, error) {
+	switch bits {
+	case 0, 1:
+		return serial.OneStopBit, nil
+	case 1.5:
+		return serial.OnePointFiveStopBits, nil
+	case 2:
+		return serial.TwoStopBits, nil
+	}
+	return 0, fmt.Errorf("unknown stop bits %v, expected 1, 1.5 or 2", bits)
+}
+
+func flowControl(name string) (connection.FlowControl, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return connection.NoFlowControl, nil
+	case "hardware":
+		return connection.HardwareFlowControl, nil
+	case "software":
+		return connection.SoftwareFlowControl, nil
+	}
+	return 0, fmt.Errorf("unknown flow control %q, expected none, hardware or software", name)
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+)
+
+// managerRetryInterval is how long the Manager waits before connecting an instrument again after connecting
+// failed or the link was lost
+const managerRetryInterval = 5 * time.Second
+
+// Manager runs the connections to the instruments of a configuration, connecting each one and listening to it,
+// connecting it again whenever the link is lost. Apply changes the instruments at runtime, a new analyzer going
+// live or one moving to another address, without restarting the process. It is safe for concurrent use.
+type Manager struct {
+	// applyMutex makes the calls to Apply one after the other, mutex guards links, which only Apply changes
+	applyMutex sync.Mutex
+	mutex      sync.Mutex
+	setUp      func(instrument Instrument, astmConn *lis1a2.ASTMConnection)
+	links      map[string]*managedLink
+}
+
+// managedLink is the connection to an instrument the go routine running it connects and listens to
+type managedLink struct {
+	instrument Instrument
+	astmConn   *lis1a2.ASTMConnection
+	// cancel stops connecting, stop hands the go routine the context to shut the connection down with,
+	// and stopped gives back the error of the shutdown once the go routine returned
+	cancel  context.CancelFunc
+	stop    chan context.Context
+	stopped chan error
+}
+
+// NewManager creates a manager running no instrument yet. setUp, when not nil, is called with every connection
+// built before it is connected, to register its handlers and hooks like OnMessage, it must not block.
+func NewManager(setUp func(instrument Instrument, astmConn *lis1a2.ASTMConnection)) *Manager {
+	return &Manager{setUp: setUp, links: make(map[string]*managedLink)}
+}
+
+// Apply reconciles the connections running with the instruments of config: the ones gone are shut down,
+// letting the message being sent finish, the ones whose configuration changed are shut down and connected
+// again with it, the new ones are connected and the ones left unchanged keep running untouched. When ctx is
+// done the connections being shut down are disconnected right away. Nothing changes when an instrument of
+// config fails to validate or to be built, otherwise the errors of the shutdowns are returned.
+func (manager *Manager) Apply(ctx context.Context, config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	manager.applyMutex.Lock()
+	defer manager.applyMutex.Unlock()
+
+	wanted := make(map[string]Instrument, len(config.Instruments))
+	var started []*managedLink
+	for _, instrument := range config.Instruments {
+		wanted[instrument.Name] = instrument
+		if running, ok := manager.links[instrument.Name]; ok && reflect.DeepEqual(running.instrument, instrument) {
+			continue
+		}
+		astmConn, err := instrument.NewASTMConnection()
+		if err != nil {
+			return err
+		}
+		started = append(started, &managedLink{instrument: instrument, astmConn: astmConn})
+	}
+
+	var errs []error
+	for name, running := range manager.links {
+		if instrument, ok := wanted[name]; ok && reflect.DeepEqual(running.instrument, instrument) {
+			continue
+		}
+		slog.Info("Shutting the connection to an instrument down.", "Instrument", name)
+		manager.mutex.Lock()
+		delete(manager.links, name)
+		manager.mutex.Unlock()
+		if err := running.shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("instrument %v: %w", name, err))
+		}
+	}
+	for _, link := range started {
+		if manager.setUp != nil {
+			manager.setUp(link.instrument, link.astmConn)
+		}
+		manager.mutex.Lock()
+		manager.links[link.instrument.Name] = link
+		manager.mutex.Unlock()
+		link.start()
+	}
+	return errors.Join(errs...)
+}
+
+// Connection gives the connection to the instrument named, nil when it is not running
+func (manager *Manager) Connection(name string) *lis1a2.ASTMConnection {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if link, ok := manager.links[name]; ok {
+		return link.astmConn
+	}
+	return nil
+}
+
+// Instruments gives the names of the instruments running, sorted
+func (manager *Manager) Instruments() []string {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	names := make([]string, 0, len(manager.links))
+	for name := range manager.links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close shuts every connection down, like applying a configuration without instruments
+func (manager *Manager) Close(ctx context.Context) error {
+	return manager.Apply(ctx, &Config{})
+}
+
+// start starts the go routine connecting the instrument and listening to it
+func (link *managedLink) start() {
+	var ctx context.Context
+	ctx, link.cancel = context.WithCancel(context.Background())
+	link.stop = make(chan context.Context, 1)
+	link.stopped = make(chan error, 1)
+	go link.run(ctx)
+}
+
+// shutdown stops the go routine, shutting the connection down with ctx, and waits for it to return
+func (link *managedLink) shutdown(ctx context.Context) error {
+	link.stop <- ctx
+	link.cancel()
+	return <-link.stopped
+}
+
+// run connects the instrument and listens to it until stopped, connecting it again once the link is lost
+func (link *managedLink) run(ctx context.Context) {
+	name := link.instrument.Name
+	for {
+		err := link.astmConn.ConnectWithContext(ctx)
+		if ctx.Err() != nil {
+			if err == nil {
+				_ = link.astmConn.Disconnect()
+			}
+			link.stopped <- nil
+			return
+		}
+		if err == nil {
+			slog.Info("Connected to an instrument.", "Instrument", name)
+			listening := make(chan struct{})
+			go func() {
+				link.astmConn.Listen()
+				close(listening)
+			}()
+			select {
+			case shutdownCtx := <-link.stop:
+				err := link.astmConn.Shutdown(shutdownCtx)
+				<-listening
+				link.stopped <- err
+				return
+			case <-listening:
+				slog.Error("Lost the connection to an instrument.", "Instrument", name)
+				_ = link.astmConn.Disconnect()
+			}
+		} else {
+			slog.Error("Failed to connect to an instrument.", "Instrument", name, "Error", err)
+		}
+		select {
+		case <-time.After(managerRetryInterval):
+		case <-ctx.Done():
+			link.stopped <- nil
+			return
+		}
+	}
+}
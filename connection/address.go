@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// AddressFamily tells which IP versions a TCPConnection dials and listens on
+type AddressFamily int
+
+const (
+	// AnyFamily dials the addresses of the host in the order of the resolver, racing IPv4 against IPv6
+	AnyFamily AddressFamily = iota
+	// PreferIPv4 dials the IPv4 addresses of the host first, and its IPv6 ones when none answers
+	PreferIPv4
+	// PreferIPv6 dials the IPv6 addresses of the host first, and its IPv4 ones when none answers
+	PreferIPv6
+	// IPv4Only dials and listens on IPv4 only
+	IPv4Only
+	// IPv6Only dials and listens on IPv6 only
+	IPv6Only
+)
+
+// network gives the network of the family to dial and listen on
+func (family AddressFamily) network() string {
+	switch family {
+	case IPv4Only:
+		return "tcp4"
+	case IPv6Only:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// joinHostPort joins a host and a port into an address, an IPv6 literal written in brackets, like [::1],
+// is accepted as well as a bare one
+func joinHostPort(host string, port string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// familyDialer dials the addresses of a host in the order of a preferred family
+type familyDialer struct {
+	dialer *net.Dialer
+	family AddressFamily
+	// options are the socket options set on the connections dialed
+	options Options
+}
+
+// DialContext dials the address and sets the socket options on the connection
+func (dialer *familyDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	conn, err := dialer.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketOptions(conn, dialer.options); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dial dials the address, resolving its host and trying its addresses in turn, the ones of the preferred
+// family first, when a family is preferred. Only the addresses of the family are dialed when it is
+// restricted to one.
+func (dialer *familyDialer) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	if network == "tcp" {
+		network = dialer.family.network()
+	}
+	if network != "tcp" || (dialer.family != PreferIPv4 && dialer.family != PreferIPv6) {
+		return dialer.dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return dialer.dialer.DialContext(ctx, network, address)
+	}
+	resolver := dialer.dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addresses, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	preferIPv4 := dialer.family == PreferIPv4
+	sort.SliceStable(addresses, func(i, j int) bool {
+		return addresses[i].Unmap().Is4() == preferIPv4 && addresses[j].Unmap().Is4() != preferIPv4
+	})
+	var errs []error
+	for _, ip := range addresses {
+		conn, err := dialer.dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no address found for %v", host)
+	}
+	return nil, errors.Join(errs...)
+}
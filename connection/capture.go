@@ -0,0 +1,211 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// The header starting a capture and the limits of its chunks, see CaptureWriter
+const (
+	captureMagic   = "LIS1A2CP"
+	captureVersion = 1
+	// captureHeaderSize is the size of the file header, captureChunkHeaderSize the one before the data of a chunk
+	captureHeaderSize      = len(captureMagic) + 2
+	captureChunkHeaderSize = 8 + 1 + 4
+	// maxCaptureChunk is the longest chunk read, longer ones mean the file is not a capture
+	maxCaptureChunk = 1 << 24
+)
+
+// ErrNotACapture is returned by NewCaptureReader for a file not starting with the header of a capture
+var ErrNotACapture = errors.New("not a capture")
+
+// CaptureDirection tells whether a CaptureChunk was sent or received
+type CaptureDirection byte
+
+const (
+	// CaptureSent is data written to the connection
+	CaptureSent CaptureDirection = 0
+	// CaptureReceived is data read from the connection
+	CaptureReceived CaptureDirection = 1
+)
+
+// String gives the arrow traces mark the direction with, -> for sent and <- for received
+func (direction CaptureDirection) String() string {
+	if direction == CaptureReceived {
+		return "<-"
+	}
+	return "->"
+}
+
+// CaptureChunk is data sent or received at once, along with the time it went over the line
+type CaptureChunk struct {
+	Time      time.Time
+	Direction CaptureDirection
+	Data      []byte
+}
+
+// CaptureWriter writes a capture, a file of the raw bytes of the line, sent and received, each chunk with
+// the time it went over. It starts with a header of 10 bytes, the magic LIS1A2CP followed by the version of
+// the format as a big endian uint16, currently 1. The chunks follow one after the other, each one:
+//
+//	8 bytes  the time, in nanoseconds since the Unix epoch, as a big endian int64
+//	1 byte   the direction, 0 for sent and 1 for received
+//	4 bytes  the length of the data, as a big endian uint32
+//	n bytes  the data, as written to or read from the Connection
+//
+// A chunk is a single write or read, a control character or a frame mostly, but nothing is assumed about it.
+// It is safe for concurrent use.
+type CaptureWriter struct {
+	writer        io.Writer
+	mutex         sync.Mutex
+	headerWritten bool
+}
+
+// NewCaptureWriter writes a capture to writer, like a file, the header being written with the first chunk
+func NewCaptureWriter(writer io.Writer) *CaptureWriter {
+	return &CaptureWriter{writer: writer}
+}
+
+// WriteChunk appends a chunk to the capture, in a single write to the underlying writer
+func (captureWriter *CaptureWriter) WriteChunk(chunk CaptureChunk) error {
+	captureWriter.mutex.Lock()
+	defer captureWriter.mutex.Unlock()
+	buffer := make([]byte, 0, captureHeaderSize+captureChunkHeaderSize+len(chunk.Data))
+	if !captureWriter.headerWritten {
+		buffer = append(buffer, captureMagic...)
+		buffer = binary.BigEndian.AppendUint16(buffer, captureVersion)
+	}
+	buffer = binary.BigEndian.AppendUint64(buffer, uint64(chunk.Time.UnixNano()))
+	buffer = append(buffer, byte(chunk.Direction))
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(chunk.Data)))
+	buffer = append(buffer, chunk.Data...)
+	if _, err := captureWriter.writer.Write(buffer); err != nil {
+		return err
+	}
+	captureWriter.headerWritten = true
+	return nil
+}
+
+// CaptureReader reads the chunks of a capture written by a CaptureWriter
+type CaptureReader struct {
+	reader *bufio.Reader
+}
+
+// NewCaptureReader reads the header of the capture, returning ErrNotACapture when reader does not start with one
+func NewCaptureReader(reader io.Reader) (*CaptureReader, error) {
+	buffered := bufio.NewReader(reader)
+	header := make([]byte, captureHeaderSize)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotACapture
+		}
+		return nil, err
+	}
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, ErrNotACapture
+	}
+	if version := binary.BigEndian.Uint16(header[len(captureMagic):]); version != captureVersion {
+		return nil, fmt.Errorf("unsupported capture version %v", version)
+	}
+	return &CaptureReader{reader: buffered}, nil
+}
+
+// Next reads the next chunk, returning io.EOF once all were read and io.ErrUnexpectedEOF for a chunk cut short
+func (captureReader *CaptureReader) Next() (CaptureChunk, error) {
+	header := make([]byte, captureChunkHeaderSize)
+	if _, err := io.ReadFull(captureReader.reader, header); err != nil {
+		return CaptureChunk{}, err
+	}
+	chunk := CaptureChunk{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header))),
+		Direction: CaptureDirection(header[8]),
+	}
+	if chunk.Direction != CaptureSent && chunk.Direction != CaptureReceived {
+		return CaptureChunk{}, fmt.Errorf("invalid direction %v in capture", header[8])
+	}
+	length := binary.BigEndian.Uint32(header[9:])
+	if length > maxCaptureChunk {
+		return CaptureChunk{}, fmt.Errorf("chunk of %v bytes in capture is too long", length)
+	}
+	chunk.Data = make([]byte, length)
+	if _, err := io.ReadFull(captureReader.reader, chunk.Data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return CaptureChunk{}, err
+	}
+	return chunk, nil
+}
+
+// CaptureConnection wraps a Connection, writing everything written to and read from it to a capture,
+// an exact reproduction of an exchange to send the vendor of a misbehaving instrument. Pass it wherever
+// the wrapped Connection would go, to NewASTMConnection for instance. A chunk which fails to be written
+// to the capture is logged, the connection goes on.
+type CaptureConnection struct {
+	Connection
+	capture *CaptureWriter
+}
+
+// NewCaptureConnection wraps the connection, writing its capture to capture, like a file
+func NewCaptureConnection(conn Connection, capture io.Writer) *CaptureConnection {
+	return &CaptureConnection{Connection: conn, capture: NewCaptureWriter(capture)}
+}
+
+// ConnectWithContext connects the wrapped connection, with ctx if it has a ConnectWithContext method
+func (captureConn *CaptureConnection) ConnectWithContext(ctx context.Context) error {
+	if contextConnector, ok := captureConn.Connection.(interface{ ConnectWithContext(context.Context) error }); ok {
+		return contextConnector.ConnectWithContext(ctx)
+	}
+	return captureConn.Connection.Connect()
+}
+
+// Shutdown shuts the wrapped connection down if it has a Shutdown method, and disconnects it otherwise
+func (captureConn *CaptureConnection) Shutdown(ctx context.Context) error {
+	if shutdowner, ok := captureConn.Connection.(interface{ Shutdown(context.Context) error }); ok {
+		return shutdowner.Shutdown(ctx)
+	}
+	return captureConn.Connection.Disconnect()
+}
+
+// Write captures the data, then writes it to the wrapped connection
+func (captureConn *CaptureConnection) Write(data []byte) error {
+	// captured before writing, the reply of the peer could otherwise be captured first
+	captureConn.captureChunk(CaptureSent, data)
+	return captureConn.Connection.Write(data)
+}
+
+// ReadStringFromConnection reads from the wrapped connection and captures what was read
+func (captureConn *CaptureConnection) ReadStringFromConnection() (string, error) {
+	data, err := captureConn.Connection.ReadStringFromConnection()
+	captureConn.captureChunk(CaptureReceived, []byte(data))
+	return data, err
+}
+
+// ReadBytesFromConnection reads like ReadStringFromConnection, through the ReadBytesFromConnection method
+// of the wrapped connection if it has one
+func (captureConn *CaptureConnection) ReadBytesFromConnection() ([]byte, error) {
+	bytesConn, ok := captureConn.Connection.(BytesConnection)
+	if !ok {
+		data, err := captureConn.ReadStringFromConnection()
+		return []byte(data), err
+	}
+	data, err := bytesConn.ReadBytesFromConnection()
+	captureConn.captureChunk(CaptureReceived, data)
+	return data, err
+}
+
+func (captureConn *CaptureConnection) captureChunk(direction CaptureDirection, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if err := captureConn.capture.WriteChunk(CaptureChunk{Time: time.Now(), Direction: direction, Data: data}); err != nil {
+		slog.Error("Failed to write to the capture.", "Error", err)
+	}
+}
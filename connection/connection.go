@@ -1,10 +1,69 @@
 package connection
 
+import (
+	"context"
+	"errors"
+)
+
+// errClosedChannel is returned when reading from a connection which has been disconnected
+var errClosedChannel = errors.New("reading from a closed channel")
+
+// ErrConnectionClosed is returned by the reads of a connection the peer closed
+var ErrConnectionClosed = errors.New("connection closed by peer")
+
+// ErrPeerReset is returned by the reads of a connection the peer reset
+var ErrPeerReset = errors.New("connection reset by peer")
+
+// ErrNotConnected is returned when writing to a connection which is not connected
+var ErrNotConnected = errors.New("connection is not connected")
+
+// Connection is the transport the ASTM layer runs on, TCPConnection and SerialConnection implement it.
+// Custom transports like TLS tunnels or mocks for tests only have to implement it to be used with NewASTMConnection.
 type Connection interface {
+	// Connect establishes the connection, it is called before Listen
 	Connect() error
+	// IsConnected tells whether the connection is established and usable
 	IsConnected() bool
+	// Listen starts reading from and writing to the connection in the background, it does not block
 	Listen()
-	Write(data string)
+	// Write writes the data to the connection, it returns an error if the data could not be written
+	Write(data []byte) error
+	// ReadStringFromConnection blocks until a control byte or a complete STX...LF frame is read,
+	// it returns an error once the connection is disconnected
 	ReadStringFromConnection() (string, error)
+	// Disconnect closes the connection, it has to be safe to call more than once
 	Disconnect() error
 }
+
+// BytesConnection is a Connection which hands over what it reads as bytes, TCPConnection, SerialConnection
+// and MockConnection implement it. The ASTM layer reads through ReadBytesFromConnection when the connection
+// has it, so that the bytes of a frame reach the parser exactly as they came over the line, whatever their encoding.
+type BytesConnection interface {
+	Connection
+	// ReadBytesFromConnection blocks until a control byte or a complete STX...LF frame is read,
+	// it returns an error once the connection is disconnected
+	ReadBytesFromConnection() ([]byte, error)
+}
+
+// writeRequest is data handed over to the write go routine, which reports the outcome on done
+type writeRequest struct {
+	data []byte
+	done chan error
+}
+
+// queueWrite hands the data over to the write go routine and waits until it was written.
+// It returns ErrNotConnected when ctx is done first.
+func queueWrite(ctx context.Context, writeChannel chan writeRequest, data []byte) error {
+	request := writeRequest{data: append([]byte(nil), data...), done: make(chan error, 1)}
+	select {
+	case writeChannel <- request:
+	case <-ctx.Done():
+		return ErrNotConnected
+	}
+	select {
+	case err := <-request.done:
+		return err
+	case <-ctx.Done():
+		return ErrNotConnected
+	}
+}
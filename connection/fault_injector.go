@@ -0,0 +1,196 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// FaultDirection tells which way the faults of a FaultInjector are injected in
+type FaultDirection int
+
+const (
+	// FaultBoth injects the faults into what is written and into what is read
+	FaultBoth FaultDirection = iota
+	// FaultOutbound only injects them into what is written, the peer sees them
+	FaultOutbound
+	// FaultInbound only injects them into what is read, the ASTM layer sees them
+	FaultInbound
+)
+
+// FaultOptions are the faults a FaultInjector injects, each with the probability from 0, never, to 1, always,
+// that a frame or control character it applies to gets it
+type FaultOptions struct {
+	// CorruptChecksum corrupts the checksum of a frame, a frame read corrupted is returned with ErrChecksumMismatch
+	CorruptChecksum float64
+	// DropACK drops an ACK, the side waiting for it times out and aborts the transfer
+	DropACK float64
+	// Delay holds a frame or control character back for DelayBy, to go past the timeouts of the other side
+	Delay   float64
+	DelayBy time.Duration
+	// Sever cuts the link in the middle of a frame: half of it is written, or none of it is read, and the
+	// wrapped connection is disconnected
+	Sever     float64
+	Direction FaultDirection
+	// Seed seeds the faults injected, so that a run can be repeated, a seed of 0 is taken from the time
+	Seed int64
+}
+
+// injectedFault is the fault inject injected, a link severed being handled before
+type injectedFault int
+
+const (
+	noFault injectedFault = iota
+	droppedACK
+	corruptedChecksum
+)
+
+// FaultInjector wraps a Connection, injecting the faults of a link gone bad into the control characters and
+// frames written and read: corrupt checksums, dropped ACKs, replies arriving too late and a link severed
+// in the middle of a frame, to check the retries, timeouts and alerts of an application without a flaky
+// instrument. Pass it wherever the wrapped Connection would go, to NewASTMConnection for instance.
+type FaultInjector struct {
+	Connection
+	mutex   sync.Mutex
+	options FaultOptions
+	random  *rand.Rand
+}
+
+// NewFaultInjector wraps the connection, injecting the faults of options
+func NewFaultInjector(conn Connection, options FaultOptions) *FaultInjector {
+	faultInjector := &FaultInjector{Connection: conn}
+	faultInjector.SetFaults(options)
+	return faultInjector
+}
+
+// SetFaults changes the faults injected, like to break the link once connected, safe to call from any go routine
+func (faultInjector *FaultInjector) SetFaults(options FaultOptions) {
+	seed := options.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	faultInjector.mutex.Lock()
+	defer faultInjector.mutex.Unlock()
+	faultInjector.options = options
+	faultInjector.random = rand.New(rand.NewSource(seed))
+}
+
+// ConnectWithContext connects the wrapped connection, with ctx if it has a ConnectWithContext method
+func (faultInjector *FaultInjector) ConnectWithContext(ctx context.Context) error {
+	if contextConnector, ok := faultInjector.Connection.(interface{ ConnectWithContext(context.Context) error }); ok {
+		return contextConnector.ConnectWithContext(ctx)
+	}
+	return faultInjector.Connection.Connect()
+}
+
+// Shutdown shuts the wrapped connection down if it has a Shutdown method, and disconnects it otherwise
+func (faultInjector *FaultInjector) Shutdown(ctx context.Context) error {
+	if shutdowner, ok := faultInjector.Connection.(interface{ Shutdown(context.Context) error }); ok {
+		return shutdowner.Shutdown(ctx)
+	}
+	return faultInjector.Connection.Disconnect()
+}
+
+// Write injects the faults into the data, then writes what is left of it to the wrapped connection
+func (faultInjector *FaultInjector) Write(data []byte) error {
+	if !faultInjector.injects(FaultOutbound) {
+		return faultInjector.Connection.Write(data)
+	}
+	if isWholeFrame(data) && faultInjector.draw(func(options FaultOptions) float64 { return options.Sever }) {
+		_ = faultInjector.Connection.Write(data[:len(data)/2])
+		_ = faultInjector.Connection.Disconnect()
+		return fmt.Errorf("link severed in the middle of a frame: %w", ErrNotConnected)
+	}
+	data, fault := faultInjector.inject(data)
+	if fault == droppedACK {
+		return nil
+	}
+	return faultInjector.Connection.Write(data)
+}
+
+// ReadStringFromConnection reads from the wrapped connection and injects the faults into what was read
+func (faultInjector *FaultInjector) ReadStringFromConnection() (string, error) {
+	data, err := faultInjector.ReadBytesFromConnection()
+	return string(data), err
+}
+
+// ReadBytesFromConnection reads like ReadStringFromConnection, through the ReadBytesFromConnection method
+// of the wrapped connection if it has one. An ACK dropped is skipped, the next data read being returned.
+func (faultInjector *FaultInjector) ReadBytesFromConnection() ([]byte, error) {
+	for {
+		data, err := faultInjector.readFromConnection()
+		if err != nil || !faultInjector.injects(FaultInbound) {
+			return data, err
+		}
+		if isWholeFrame(data) && faultInjector.draw(func(options FaultOptions) float64 { return options.Sever }) {
+			_ = faultInjector.Connection.Disconnect()
+			return nil, fmt.Errorf("link severed in the middle of a frame: %w", ErrConnectionClosed)
+		}
+		data, fault := faultInjector.inject(data)
+		if fault == droppedACK {
+			continue
+		}
+		if fault == corruptedChecksum {
+			return data, ErrChecksumMismatch
+		}
+		return data, nil
+	}
+}
+
+// readFromConnection makes a single read from the wrapped connection
+func (faultInjector *FaultInjector) readFromConnection() ([]byte, error) {
+	if bytesConn, ok := faultInjector.Connection.(BytesConnection); ok {
+		return bytesConn.ReadBytesFromConnection()
+	}
+	data, err := faultInjector.Connection.ReadStringFromConnection()
+	return []byte(data), err
+}
+
+// inject delays the data, corrupts the checksum of a frame or drops an ACK, telling which fault it injected
+func (faultInjector *FaultInjector) inject(data []byte) ([]byte, injectedFault) {
+	if len(data) == 1 && data[0] == constants.ACK && faultInjector.draw(func(options FaultOptions) float64 { return options.DropACK }) {
+		return data, droppedACK
+	}
+	if faultInjector.draw(func(options FaultOptions) float64 { return options.Delay }) {
+		faultInjector.mutex.Lock()
+		delay := faultInjector.options.DelayBy
+		faultInjector.mutex.Unlock()
+		time.Sleep(delay)
+	}
+	if isWholeFrame(data) && faultInjector.draw(func(options FaultOptions) float64 { return options.CorruptChecksum }) {
+		corrupted := append([]byte(nil), data...)
+		// the first checksum character, right before CR LF and the second one
+		position := len(corrupted) - 4
+		if corrupted[position] == '0' {
+			corrupted[position] = '1'
+		} else {
+			corrupted[position] = '0'
+		}
+		return corrupted, corruptedChecksum
+	}
+	return data, noFault
+}
+
+// injects tells whether the faults are injected into the direction given
+func (faultInjector *FaultInjector) injects(direction FaultDirection) bool {
+	faultInjector.mutex.Lock()
+	defer faultInjector.mutex.Unlock()
+	return faultInjector.options.Direction == FaultBoth || faultInjector.options.Direction == direction
+}
+
+// draw tells whether a fault is injected, given the probability of it among the options
+func (faultInjector *FaultInjector) draw(probability func(options FaultOptions) float64) bool {
+	faultInjector.mutex.Lock()
+	defer faultInjector.mutex.Unlock()
+	chance := probability(faultInjector.options)
+	return chance > 0 && faultInjector.random.Float64() < chance
+}
+
+// isWholeFrame tells whether the data is a whole frame, from STX to the CR LF after its checksum
+func isWholeFrame(data []byte) bool {
+	return len(data) >= 7 && data[0] == constants.STX && data[len(data)-2] == constants.CR && data[len(data)-1] == constants.LF
+}
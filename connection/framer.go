@@ -0,0 +1,91 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// ErrIncompleteFrame is returned along with the bytes of a frame which was abandoned before its LF,
+// because a control byte or another STX arrived, or with bytes which arrived outside of a frame
+var ErrIncompleteFrame = errors.New("incomplete frame")
+
+// readResult is a control byte or frame read from a transport, or the framing error it ran into
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// frameAssembler groups the raw bytes read from a transport into
+// control bytes (ENQ, ACK, NAK, EOT) and complete STX...LF frames
+type frameAssembler struct {
+	buffer []byte
+}
+
+// newFrameAssembler creates an empty frame assembler
+func newFrameAssembler() *frameAssembler {
+	return &frameAssembler{buffer: make([]byte, 0)}
+}
+
+// feed adds a single byte to the assembler and returns what it completes, if anything: a control byte,
+// a frame along with its checksum verification, or the partial frame it abandoned with ErrIncompleteFrame.
+// After a control byte, an abandoned frame or a complete frame the assembler is back to waiting for STX.
+func (assembler *frameAssembler) feed(bt byte) []readResult {
+	var results []readResult
+	if bt == constants.NUL {
+		return nil
+	}
+	if bt == constants.ENQ || bt == constants.ACK || bt == constants.NAK || bt == constants.EOT {
+		results = assembler.abandon()
+		results = append(results, readResult{data: []byte{bt}})
+	} else if bt == constants.STX {
+		// start of frame
+		results = assembler.abandon()
+		assembler.buffer = append(assembler.buffer, bt)
+	} else if bt == constants.LF {
+		assembler.buffer = append(assembler.buffer, bt)
+		if assembler.buffer[0] != constants.STX {
+			return assembler.abandon()
+		}
+		frame := assembler.buffer
+		assembler.buffer = make([]byte, 0)
+		results = append(results, readResult{data: frame, err: verifyFrameChecksum(frame)})
+	} else {
+		assembler.buffer = append(assembler.buffer, bt)
+	}
+	return results
+}
+
+// abandon drops the bytes buffered so far, reporting them with ErrIncompleteFrame if there were any
+func (assembler *frameAssembler) abandon() []readResult {
+	if len(assembler.buffer) == 0 {
+		return nil
+	}
+	partial := assembler.buffer
+	assembler.buffer = make([]byte, 0)
+	return []readResult{{data: partial, err: ErrIncompleteFrame}}
+}
+
+// ErrChecksumMismatch is returned along with a frame whose checksum does not match its content,
+// or which is malformed, so that the receiver NAKs it either way
+var ErrChecksumMismatch = protocol.ErrChecksumMismatch
+
+// ErrMalformedFrame is wrapped along with ErrChecksumMismatch for a STX...LF frame which is not structured
+// like a frame, like one missing CR ETX or ETB, or one whose LF came early
+var ErrMalformedFrame = protocol.ErrMalformedFrame
+
+// verifyFrameChecksum checks the structure and checksum of a STX...LF frame with protocol.ValidateFrame,
+// the error of a malformed frame wraps both ErrChecksumMismatch and ErrMalformedFrame.
+// Control bytes are not frames and are always considered valid.
+func verifyFrameChecksum(frame []byte) error {
+	if len(frame) == 0 || frame[0] != constants.STX {
+		return nil
+	}
+	err := protocol.ValidateFrame(frame)
+	if err != nil && !errors.Is(err, ErrChecksumMismatch) {
+		return fmt.Errorf("%w: %w", ErrChecksumMismatch, err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package connection
+
+import (
+	"net"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// NewMLLPConnection creates a new TCP connection to the server provided which carries the LIS1-A2 protocol
+// inside of MLLP envelopes, for the interface engines expecting MLLP even for ASTM. Every write, a control
+// character or a frame, is sent as the block of an envelope, VT, the data, FS and CR, and the envelope bytes
+// received are dropped before framing, so that ENQ, ACK, NAK, EOT and the frames go over the link unchanged.
+// The connection behaves exactly like a TCPConnection otherwise.
+func NewMLLPConnection(serverHost string, serverPort string, options ...Options) TCPConnection {
+	return TCPConnection{
+		serverHost: serverHost,
+		serverPort: serverPort,
+		options:    withDefaults(options),
+		mllp:       true,
+	}
+}
+
+// NewMLLPListener starts listening for incoming TCP connections on the host and port provided,
+// the accepted connections carry the protocol inside of MLLP envelopes, see NewMLLPConnection
+func NewMLLPListener(host string, port string, options ...Options) (*TCPListener, error) {
+	merged := withDefaults(options)
+	listener, err := net.Listen(merged.AddressFamily.network(), joinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	return &TCPListener{listener: listener, options: merged, mllp: true}, nil
+}
+
+// mllpConn wraps every write in an MLLP envelope and drops the envelope bytes read
+type mllpConn struct {
+	net.Conn
+	// endOfBlock is set once FS was read, the CR closing the envelope comes next
+	endOfBlock bool
+}
+
+// newMLLPConn wraps the net.Conn of an MLLP connection
+func newMLLPConn(conn net.Conn) *mllpConn {
+	return &mllpConn{Conn: conn}
+}
+
+// Read reads the data of the blocks received, a CR is part of the data unless it follows FS
+func (conn *mllpConn) Read(data []byte) (int, error) {
+	for {
+		count, err := conn.Conn.Read(data)
+		kept := 0
+		for _, bt := range data[:count] {
+			endOfBlock := conn.endOfBlock
+			conn.endOfBlock = bt == constants.FS
+			if bt == constants.VT || bt == constants.FS || (bt == constants.CR && endOfBlock) {
+				continue
+			}
+			data[kept] = bt
+			kept++
+		}
+		// a read of envelope bytes only would look like a read of nothing, read on instead
+		if kept > 0 || err != nil || count == 0 {
+			return kept, err
+		}
+	}
+}
+
+// Write sends the data as a single block, the count is the one of the data written
+func (conn *mllpConn) Write(data []byte) (int, error) {
+	block := make([]byte, 0, len(data)+3)
+	block = append(block, constants.VT)
+	block = append(block, data...)
+	block = append(block, constants.FS, constants.CR)
+	count, err := conn.Conn.Write(block)
+	return min(max(count-1, 0), len(data)), err
+}
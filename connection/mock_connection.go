@@ -0,0 +1,148 @@
+package connection
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// MockConnection is an in-memory Connection for testing protocol logic without sockets.
+// Inbound bytes are injected with Inject and go through the same framing as the real transports,
+// outbound bytes are captured and can be inspected with Written or answered with OnWrite.
+type MockConnection struct {
+	isConnected   atomic.Bool
+	readChannel   chan readResult
+	ctx           context.Context
+	ctxCancelFunc context.CancelFunc
+	options       Options
+	overflow      readOverflow
+	assembler     *frameAssembler
+	injectMutex   sync.Mutex
+	writeMutex    sync.Mutex
+	written       []byte
+	onWrite       func(data []byte)
+}
+
+// NewMockConnection creates a new in-memory connection, optionally tuned by options
+func NewMockConnection(options ...Options) MockConnection {
+	return MockConnection{
+		options: withDefaults(options),
+	}
+}
+
+// Connect prepares the connection for injecting and writing data, it never fails
+func (mockConn *MockConnection) Connect() error {
+	mockConn.ctx, mockConn.ctxCancelFunc = context.WithCancel(context.Background())
+	mockConn.readChannel = make(chan readResult, mockConn.options.ReadBufferSize)
+	mockConn.assembler = newFrameAssembler()
+	mockConn.isConnected.Store(true)
+	return nil
+}
+
+// IsConnected gives connection status
+func (mockConn *MockConnection) IsConnected() bool {
+	return mockConn.isConnected.Load()
+}
+
+// Listen does nothing, injected data is made available to the reader right away
+func (mockConn *MockConnection) Listen() {}
+
+// Disconnect stops the connection, pending and later reads return an error.
+// It is safe to call Disconnect more than once and from multiple goroutines, only the first call has an effect.
+func (mockConn *MockConnection) Disconnect() error {
+	if !mockConn.isConnected.CompareAndSwap(true, false) {
+		return nil
+	}
+	mockConn.ctxCancelFunc()
+	return nil
+}
+
+// ReadBytesFromConnection is a blocking call that reads the injected data.
+// A frame whose checksum does not match is still returned, along with ErrChecksumMismatch,
+// and the bytes of an abandoned frame are returned along with ErrIncompleteFrame.
+func (mockConn *MockConnection) ReadBytesFromConnection() ([]byte, error) {
+	return mockConn.ReadBytesFromConnectionContext(context.Background())
+}
+
+// ReadBytesFromConnectionContext reads the injected data until some arrives, the connection is
+// disconnected or ctx is done, in which case ctx.Err() is returned.
+func (mockConn *MockConnection) ReadBytesFromConnectionContext(ctx context.Context) ([]byte, error) {
+	if mockConn.ctx == nil {
+		return nil, errClosedChannel
+	}
+	if mockConn.overflow.pending.CompareAndSwap(true, false) {
+		return nil, ErrReadOverflow
+	}
+	select {
+	case result := <-mockConn.readChannel:
+		return result.data, result.err
+	case <-mockConn.ctx.Done():
+		return nil, errClosedChannel
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ReadStringFromConnection reads like ReadBytesFromConnection, returning the bytes read as a string
+func (mockConn *MockConnection) ReadStringFromConnection() (string, error) {
+	data, err := mockConn.ReadBytesFromConnection()
+	return string(data), err
+}
+
+// ReadStringFromConnectionContext reads like ReadBytesFromConnectionContext, returning the bytes read as a string
+func (mockConn *MockConnection) ReadStringFromConnectionContext(ctx context.Context) (string, error) {
+	data, err := mockConn.ReadBytesFromConnectionContext(ctx)
+	return string(data), err
+}
+
+// Write captures the data and hands it to the OnWrite callback, it returns ErrNotConnected after a disconnect
+func (mockConn *MockConnection) Write(data []byte) error {
+	if !mockConn.isConnected.Load() {
+		return ErrNotConnected
+	}
+	mockConn.writeMutex.Lock()
+	mockConn.written = append(mockConn.written, data...)
+	onWrite := mockConn.onWrite
+	mockConn.writeMutex.Unlock()
+	if onWrite != nil {
+		onWrite(append([]byte(nil), data...))
+	}
+	return nil
+}
+
+// Inject makes the data readable from the connection as if the peer had sent it.
+// It blocks like a transport would when the read channel is full and the overflow policy is OverflowBlock.
+func (mockConn *MockConnection) Inject(data []byte) error {
+	if !mockConn.isConnected.Load() {
+		return ErrNotConnected
+	}
+	mockConn.injectMutex.Lock()
+	defer mockConn.injectMutex.Unlock()
+	for _, bt := range data {
+		for _, result := range mockConn.assembler.feed(bt) {
+			if !postRead(mockConn.ctx, mockConn.readChannel, result, mockConn.options.OverflowPolicy, &mockConn.overflow, mockConn.Write) {
+				return ErrNotConnected
+			}
+		}
+	}
+	return nil
+}
+
+// ReadsDropped counts the frames and control bytes which did not fit in the read channel, see OverflowPolicy
+func (mockConn *MockConnection) ReadsDropped() int64 {
+	return mockConn.overflow.dropped.Load()
+}
+
+// Written returns a copy of all the data written to the connection so far
+func (mockConn *MockConnection) Written() []byte {
+	mockConn.writeMutex.Lock()
+	defer mockConn.writeMutex.Unlock()
+	return append([]byte(nil), mockConn.written...)
+}
+
+// OnWrite registers a callback called with the data of every Write, it can Inject the reply of the peer
+func (mockConn *MockConnection) OnWrite(callback func(data []byte)) {
+	mockConn.writeMutex.Lock()
+	defer mockConn.writeMutex.Unlock()
+	mockConn.onWrite = callback
+}
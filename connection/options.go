@@ -0,0 +1,151 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// OverflowPolicy decides what the read go routine does with data when the read channel is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the consumer to make room, nothing is lost but the socket is not drained
+	// in the meantime, so a slow consumer can make the peer time out. Disconnect always unblocks it.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop drops the data which does not fit and carries on reading
+	OverflowDrop
+	// OverflowError drops the data which does not fit and makes the next read return ErrReadOverflow
+	OverflowError
+	// OverflowNAK answers a frame or an ENQ which does not fit with NAK, so that the sender sends the frame
+	// again or retries establishing later, as it does with a busy receiver. The other data waits for the
+	// consumer like with OverflowBlock, the peer waits for nothing while it does.
+	OverflowNAK
+)
+
+// ErrReadIdleTimeout is returned by the reads of a connection dropped because nothing was received within ReadIdleTimeout
+var ErrReadIdleTimeout = errors.New("nothing received within the read idle timeout")
+
+// ErrWriteTimeout is returned by the reads of a connection dropped because a write did not complete within WriteTimeout
+var ErrWriteTimeout = errors.New("write did not complete within the write timeout")
+
+// ErrReadOverflow is returned by the read after data was dropped because the read channel was full
+var ErrReadOverflow = errors.New("read channel overflowed, data was dropped")
+
+const (
+	defaultWriteBufferSize = 64
+	defaultReadBufferSize  = 8
+)
+
+// Options tunes a connection, the zero value of a field keeps its default
+type Options struct {
+	// WriteBufferSize is the number of writes queued while another one is being sent, defaults to 64.
+	// Every Write waits for its data to be sent, so it only matters with several writing go routines.
+	WriteBufferSize int
+	// ReadBufferSize is the number of frames and control bytes queued for the consumer, defaults to 8.
+	// A larger buffer absorbs bursts from fast analyzers at the cost of memory.
+	ReadBufferSize int
+	// OverflowPolicy applies once ReadBufferSize frames are waiting, defaults to OverflowBlock
+	OverflowPolicy OverflowPolicy
+	// DialTimeout bounds how long connecting a TCPConnection waits for the server, including the TLS handshake,
+	// zero waits as long as the operating system does
+	DialTimeout time.Duration
+	// ReadIdleTimeout drops a TCPConnection from which nothing was received for that long, re-dialing it when
+	// reconnecting is enabled, so that a silently dead analyzer is noticed. Zero waits forever.
+	ReadIdleTimeout time.Duration
+	// WriteTimeout drops a TCPConnection which could not write a byte for that long, zero waits forever
+	WriteTimeout time.Duration
+	// FlowControl paces the writes of a SerialConnection, defaults to NoFlowControl
+	FlowControl FlowControl
+	// Proxy is the SOCKS5 or HTTP proxy a TCPConnection dials through, TLS and WebSocket connections included,
+	// nil dials the server directly. Connections in server mode and Unix connections ignore it.
+	Proxy *Proxy
+	// AddressFamily tells which IP versions a TCPConnection dials and listens on, defaults to AnyFamily
+	AddressFamily AddressFamily
+	// KeepAlive is the idle time before TCP keep-alive probes are sent, and between them, so that the operating
+	// system notices a link broken by a flaky network. Zero keeps the default of Go, 15 seconds, a negative
+	// duration disables keep-alive.
+	KeepAlive time.Duration
+	// Nagle turns TCP_NODELAY off, letting the operating system gather small writes into fewer packets at the
+	// cost of latency, Go sends every write right away by default
+	Nagle bool
+	// Linger is how long closing a TCP connection waits for the data not sent yet, rounded up to seconds.
+	// Zero keeps the default of the operating system, which sends it in the background, a negative duration
+	// discards it and resets the connection.
+	Linger time.Duration
+	// SocketReceiveBuffer and SocketSendBuffer are the sizes in bytes of the socket buffers of a TCP
+	// connection, SO_RCVBUF and SO_SNDBUF, zero keeps the defaults of the operating system
+	SocketReceiveBuffer int
+	SocketSendBuffer    int
+}
+
+// withDefaults merges the options given to a constructor into the default options
+func withDefaults(options []Options) Options {
+	merged := Options{}
+	if len(options) > 0 {
+		merged = options[0]
+	}
+	if merged.WriteBufferSize <= 0 {
+		merged.WriteBufferSize = defaultWriteBufferSize
+	}
+	if merged.ReadBufferSize <= 0 {
+		merged.ReadBufferSize = defaultReadBufferSize
+	}
+	return merged
+}
+
+// readOverflow is the state of the overflow policy of a connection
+type readOverflow struct {
+	// pending makes the next read return ErrReadOverflow
+	pending atomic.Bool
+	// dropped counts the frames and control bytes dropped, NAKed ones included
+	dropped atomic.Int64
+}
+
+// postRead hands data from the read go routine over to the consumer, applying the overflow policy.
+// write sends the NAK of OverflowNAK, from another go routine so that the read go routine never waits for it.
+// It returns false when ctx is done while waiting, the read go routine should then stop.
+func postRead(ctx context.Context, readChannel chan readResult, result readResult, policy OverflowPolicy, overflow *readOverflow, write func(data []byte) error) bool {
+	if policy == OverflowBlock || policy == OverflowNAK && !isNAKable(result) {
+		select {
+		case readChannel <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case readChannel <- result:
+		return true
+	default:
+	}
+	overflow.dropped.Add(1)
+	switch policy {
+	case OverflowError:
+		slog.Error("Read channel full. Dropped data.", "Data", result.data)
+		overflow.pending.Store(true)
+	case OverflowNAK:
+		slog.Warn("Read channel full. Answered with NAK.", "Data", result.data)
+		go func() {
+			if err := write([]byte{constants.NAK}); err != nil {
+				slog.Error("Failed to NAK data which did not fit in the read channel.", "Error", err)
+			}
+		}()
+	default:
+		slog.Error("Read channel full. Dropped data.", "Data", result.data)
+	}
+	return true
+}
+
+// isNAKable tells whether the sender of the data takes a NAK as a request to send it again, which is the
+// case of a frame and of an ENQ
+func isNAKable(result readResult) bool {
+	if len(result.data) == 0 {
+		return false
+	}
+	return result.data[0] == constants.STX || len(result.data) == 1 && result.data[0] == constants.ENQ
+}
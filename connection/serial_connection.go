@@ -0,0 +1,243 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+
+	"go.bug.st/serial"
+)
+
+type SerialConnection struct {
+	isConnected   atomic.Bool
+	port          serial.Port
+	portName      string
+	mode          serial.Mode
+	writeChannel  chan writeRequest
+	readChannel   chan readResult
+	ctx           context.Context
+	ctxCancelFunc context.CancelFunc
+	options       Options
+	overflow      readOverflow
+	paused        atomic.Bool
+	writeGate     writeGate
+}
+
+// NewSerialConnection creates a new serial connection to the device provided, optionally tuned by options
+func NewSerialConnection(portName string, baudRate int, dataBits int, parity serial.Parity, stopBits serial.StopBits, options ...Options) SerialConnection {
+	return SerialConnection{
+		portName: portName,
+		mode: serial.Mode{
+			BaudRate: baudRate,
+			DataBits: dataBits,
+			Parity:   parity,
+			StopBits: stopBits,
+		},
+		options: withDefaults(options),
+	}
+}
+
+// Connect opens the serial port
+func (serialConn *SerialConnection) Connect() error {
+	serialConn.applyFlowControl()
+	port, err := serial.Open(serialConn.portName, &serialConn.mode)
+	if err != nil {
+		return err
+	}
+	serialConn.port = port
+	serialConn.ctx, serialConn.ctxCancelFunc = context.WithCancel(context.Background())
+	serialConn.writeChannel = make(chan writeRequest, serialConn.options.WriteBufferSize)
+	serialConn.readChannel = make(chan readResult, serialConn.options.ReadBufferSize)
+	serialConn.paused.Store(false)
+	serialConn.writeGate.open()
+	serialConn.isConnected.Store(true)
+	return nil
+}
+
+// IsConnected gives connection status
+func (serialConn *SerialConnection) IsConnected() bool {
+	return serialConn.isConnected.Load()
+}
+
+// Listen listens to the incoming messages and writes outgoing messages to the serial port
+func (serialConn *SerialConnection) Listen() {
+	go serialConn.readFromSerialPortAndPostItOnReadChannel()
+	go serialConn.writeToSerialPortFromChannel()
+}
+
+// Disconnect closes the serial port and cancels all internal contexts, which stops the read and write go routines.
+// It is safe to call Disconnect more than once and from multiple goroutines, only the first call has an effect.
+func (serialConn *SerialConnection) Disconnect() error {
+	if !serialConn.isConnected.CompareAndSwap(true, false) {
+		return nil
+	}
+	serialConn.ctxCancelFunc()
+	if err := serialConn.port.Close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting writes, waits for the writes in progress to be sent and disconnects.
+// When ctx is done first it disconnects right away and returns ctx.Err().
+func (serialConn *SerialConnection) Shutdown(ctx context.Context) error {
+	err := serialConn.writeGate.drain(ctx)
+	if disconnectErr := serialConn.Disconnect(); err == nil {
+		err = disconnectErr
+	}
+	return err
+}
+
+// ReadBytesFromConnection is a blocking call that reads from a channel.
+// A frame whose checksum does not match is still returned, along with ErrChecksumMismatch,
+// and the bytes of an abandoned frame are returned along with ErrIncompleteFrame.
+func (serialConn *SerialConnection) ReadBytesFromConnection() ([]byte, error) {
+	return serialConn.ReadBytesFromConnectionContext(context.Background())
+}
+
+// ReadBytesFromConnectionContext reads from a channel until data arrives, the connection is
+// disconnected or ctx is done, in which case ctx.Err() is returned.
+// A frame whose checksum does not match is still returned, along with ErrChecksumMismatch,
+// and the bytes of an abandoned frame are returned along with ErrIncompleteFrame.
+func (serialConn *SerialConnection) ReadBytesFromConnectionContext(ctx context.Context) ([]byte, error) {
+	if serialConn.ctx == nil {
+		return nil, errClosedChannel
+	}
+	if serialConn.overflow.pending.CompareAndSwap(true, false) {
+		return nil, ErrReadOverflow
+	}
+	select {
+	case result, ok := <-serialConn.readChannel:
+		if !ok {
+			return nil, errClosedChannel
+		}
+		return result.data, result.err
+	case <-serialConn.ctx.Done():
+		return nil, errClosedChannel
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ReadStringFromConnection reads like ReadBytesFromConnection, returning the bytes read as a string
+func (serialConn *SerialConnection) ReadStringFromConnection() (string, error) {
+	data, err := serialConn.ReadBytesFromConnection()
+	return string(data), err
+}
+
+// ReadStringFromConnectionContext reads like ReadBytesFromConnectionContext, returning the bytes read as a string
+func (serialConn *SerialConnection) ReadStringFromConnectionContext(ctx context.Context) (string, error) {
+	data, err := serialConn.ReadBytesFromConnectionContext(ctx)
+	return string(data), err
+}
+
+// Write writes the data to the serial port and returns once it was written by the go routine started by Listen.
+// The error of the port is returned if writing failed, ErrNotConnected after a disconnect
+// and ErrShutdown once Shutdown was called.
+func (serialConn *SerialConnection) Write(data []byte) error {
+	if !serialConn.isConnected.Load() {
+		return ErrNotConnected
+	}
+	if !serialConn.writeGate.enter() {
+		return ErrShutdown
+	}
+	defer serialConn.writeGate.leave()
+	return queueWrite(serialConn.ctx, serialConn.writeChannel, data)
+}
+
+// ReadsDropped counts the frames and control bytes which did not fit in the read channel, see OverflowPolicy
+func (serialConn *SerialConnection) ReadsDropped() int64 {
+	return serialConn.overflow.dropped.Load()
+}
+
+// readFromSerialPortAndPostItOnReadChannel reads bytes from the serial port and posts it on the string channel
+func (serialConn *SerialConnection) readFromSerialPortAndPostItOnReadChannel() {
+	// the read goroutine is the only sender on the read channel, so it is the one closing it
+	defer close(serialConn.readChannel)
+	var assembler = newFrameAssembler()
+	var readBuffer = make([]byte, 256)
+	for {
+		count, err := serialConn.port.Read(readBuffer)
+		if err != nil {
+			var portErr *serial.PortError
+			if errors.As(err, &portErr) && portErr.Code() == serial.PortClosed {
+				slog.Info("Serial port was closed. Stopped reading.")
+				return
+			}
+			if err := serialConn.Disconnect(); err != nil {
+				slog.Error("Error occurred while reading from serial port. Error occurred while disconnecting.", "Error", err)
+				return
+			}
+			slog.Info("Error occurred while reading from serial port. Disconnected successfully.", "Error", err)
+			return
+		}
+
+		for _, bt := range readBuffer[:count] {
+			if serialConn.handleFlowControlByte(bt) {
+				continue
+			}
+			for _, result := range assembler.feed(bt) {
+				if !postRead(serialConn.ctx, serialConn.readChannel, result, serialConn.options.OverflowPolicy, &serialConn.overflow, serialConn.Write) {
+					slog.Info("Ending read go routine, disconnected while waiting for the consumer.")
+					return
+				}
+			}
+		}
+
+		select {
+		case <-serialConn.ctx.Done():
+			slog.Info("Ending readFromSerialPortAndPostItOnReadChannel Go routine.")
+			return
+		default:
+			continue
+		}
+	}
+}
+
+// writeToSerialPortFromChannel writes the data put on the write channel
+func (serialConn *SerialConnection) writeToSerialPortFromChannel() {
+	for {
+		select {
+		case <-serialConn.ctx.Done():
+			slog.Info("Ending writeToSerialPortFromChannel Go routine.")
+			return
+		case request := <-serialConn.writeChannel:
+			err := serialConn.writeToPort(request.data)
+			request.done <- err
+			if errors.Is(err, ErrNotConnected) {
+				slog.Info("Ending writeToSerialPortFromChannel Go routine.")
+				return
+			}
+			if err != nil {
+				slog.Error("Failed to send data over serial port.", "Error", err)
+			}
+		}
+	}
+}
+
+// writeToPort writes the data of a single Write to the serial port. With flow control it is written
+// byte by byte, so that the peer can pause it anywhere.
+func (serialConn *SerialConnection) writeToPort(data []byte) error {
+	if serialConn.options.FlowControl == NoFlowControl {
+		for len(data) > 0 {
+			count, err := serialConn.port.Write(data)
+			if err != nil {
+				return err
+			}
+			data = data[count:]
+		}
+		return nil
+	}
+	for _, byteToBeSent := range data {
+		if !serialConn.waitUntilClearToSend() {
+			return ErrNotConnected
+		}
+		count, err := serialConn.port.Write([]byte{byteToBeSent})
+		if err != nil {
+			return err
+		}
+		slog.Debug("Byte sent successfully.", "Byte", byteToBeSent, "Count", count)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package connection
+
+import (
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"go.bug.st/serial"
+)
+
+// FlowControl selects how a SerialConnection paces its writes, it is ignored by other connections
+type FlowControl int
+
+const (
+	// NoFlowControl writes as soon as data is available
+	NoFlowControl FlowControl = iota
+	// HardwareFlowControl asserts RTS and only writes while the device asserts CTS
+	HardwareFlowControl
+	// SoftwareFlowControl stops writing when the device sends XOFF and resumes on XON,
+	// both bytes are consumed by the connection and never reach the reader
+	SoftwareFlowControl
+)
+
+// flowControlPollInterval is how often a paused writer checks whether it may write again
+const flowControlPollInterval = 10 * time.Millisecond
+
+// applyFlowControl sets up the modem output bits flow control needs when opening the port
+func (serialConn *SerialConnection) applyFlowControl() {
+	if serialConn.options.FlowControl == HardwareFlowControl {
+		serialConn.mode.InitialStatusBits = &serial.ModemOutputBits{RTS: true, DTR: true}
+	}
+}
+
+// handleFlowControlByte consumes XON and XOFF under software flow control, it reports whether it did
+func (serialConn *SerialConnection) handleFlowControlByte(bt byte) bool {
+	if serialConn.options.FlowControl != SoftwareFlowControl {
+		return false
+	}
+	switch bt {
+	case constants.XOFF:
+		serialConn.paused.Store(true)
+		return true
+	case constants.XON:
+		serialConn.paused.Store(false)
+		return true
+	}
+	return false
+}
+
+// waitUntilClearToSend blocks the write go routine while the device asks us to hold off,
+// it returns false if the connection is disconnected in the meantime
+func (serialConn *SerialConnection) waitUntilClearToSend() bool {
+	for !serialConn.clearToSend() {
+		select {
+		case <-serialConn.ctx.Done():
+			return false
+		case <-time.After(flowControlPollInterval):
+		}
+	}
+	return true
+}
+
+// clearToSend tells whether the device is ready to receive data
+func (serialConn *SerialConnection) clearToSend() bool {
+	switch serialConn.options.FlowControl {
+	case HardwareFlowControl:
+		status, err := serialConn.port.GetModemStatusBits()
+		return err != nil || status.CTS
+	case SoftwareFlowControl:
+		return !serialConn.paused.Load()
+	default:
+		return true
+	}
+}
@@ -0,0 +1,57 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrShutdown is returned when writing to a connection which is shutting down
+var ErrShutdown = errors.New("connection is shutting down")
+
+// writeGate lets Shutdown wait for the writes in progress while refusing new ones
+type writeGate struct {
+	mutex        sync.RWMutex
+	shuttingDown atomic.Bool
+}
+
+// enter admits a write, it returns false once shutting down, the write must call leave when done otherwise
+func (gate *writeGate) enter() bool {
+	if gate.shuttingDown.Load() {
+		return false
+	}
+	gate.mutex.RLock()
+	if gate.shuttingDown.Load() {
+		gate.mutex.RUnlock()
+		return false
+	}
+	return true
+}
+
+// leave tells that an admitted write is done
+func (gate *writeGate) leave() {
+	gate.mutex.RUnlock()
+}
+
+// open admits writes again after the connection is connected
+func (gate *writeGate) open() {
+	gate.shuttingDown.Store(false)
+}
+
+// drain refuses new writes and waits for the admitted ones to be done, or ctx to be done
+func (gate *writeGate) drain(ctx context.Context) error {
+	gate.shuttingDown.Store(true)
+	drained := make(chan struct{})
+	go func() {
+		gate.mutex.Lock()
+		gate.mutex.Unlock()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
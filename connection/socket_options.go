@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// applySocketOptions sets the socket options of the options on a TCP connection, the other connections
+// are left as they are
+func applySocketOptions(conn net.Conn, options Options) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if options.KeepAlive < 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return fmt.Errorf("failed to disable keep-alive: %w", err)
+		}
+	} else if options.KeepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return fmt.Errorf("failed to enable keep-alive: %w", err)
+		}
+		if err := tcpConn.SetKeepAlivePeriod(options.KeepAlive); err != nil {
+			return fmt.Errorf("failed to set the keep-alive period: %w", err)
+		}
+	}
+	if options.Nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			return fmt.Errorf("failed to unset TCP_NODELAY: %w", err)
+		}
+	}
+	if options.Linger < 0 {
+		if err := tcpConn.SetLinger(0); err != nil {
+			return fmt.Errorf("failed to set SO_LINGER: %w", err)
+		}
+	} else if options.Linger > 0 {
+		// SO_LINGER counts whole seconds, a fraction of one is rounded up
+		if err := tcpConn.SetLinger(int((options.Linger + time.Second - 1) / time.Second)); err != nil {
+			return fmt.Errorf("failed to set SO_LINGER: %w", err)
+		}
+	}
+	if options.SocketReceiveBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(options.SocketReceiveBuffer); err != nil {
+			return fmt.Errorf("failed to set SO_RCVBUF: %w", err)
+		}
+	}
+	if options.SocketSendBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(options.SocketSendBuffer); err != nil {
+			return fmt.Errorf("failed to set SO_SNDBUF: %w", err)
+		}
+	}
+	return nil
+}
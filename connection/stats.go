@@ -0,0 +1,71 @@
+package connection
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// Stats is a snapshot of the traffic of a connection, see TCPConnection.Stats.
+// The counters add up from the creation of the connection, over reconnects.
+type Stats struct {
+	BytesRead    int64
+	BytesWritten int64
+	// FramesRead and FramesWritten count the STX...LF frames, the ones with a checksum mismatch included
+	FramesRead    int64
+	FramesWritten int64
+	// LastActivity is when a byte was last read or written, the zero time before that
+	LastActivity time.Time
+	// Reconnects counts the times the connection was re-established after the link was lost
+	Reconnects int64
+	// ReadsDropped counts the frames and control bytes which did not fit in the read channel, see OverflowPolicy
+	ReadsDropped int64
+}
+
+// connectionStats are the counters behind Stats, updated by the read and write go routines
+type connectionStats struct {
+	bytesRead     atomic.Int64
+	bytesWritten  atomic.Int64
+	framesRead    atomic.Int64
+	framesWritten atomic.Int64
+	// lastActivity is in Unix nanoseconds, zero before any traffic
+	lastActivity atomic.Int64
+	reconnects   atomic.Int64
+}
+
+func (stats *connectionStats) snapshot() Stats {
+	snapshot := Stats{
+		BytesRead:     stats.bytesRead.Load(),
+		BytesWritten:  stats.bytesWritten.Load(),
+		FramesRead:    stats.framesRead.Load(),
+		FramesWritten: stats.framesWritten.Load(),
+		Reconnects:    stats.reconnects.Load(),
+	}
+	if lastActivity := stats.lastActivity.Load(); lastActivity != 0 {
+		snapshot.LastActivity = time.Unix(0, lastActivity)
+	}
+	return snapshot
+}
+
+// read counts a byte read, and a frame once the assembler gave it
+func (stats *connectionStats) read(results []readResult) {
+	stats.bytesRead.Add(1)
+	stats.lastActivity.Store(time.Now().UnixNano())
+	for _, result := range results {
+		complete := result.err == nil || errors.Is(result.err, ErrChecksumMismatch)
+		if complete && len(result.data) > 0 && result.data[0] == constants.STX {
+			stats.framesRead.Add(1)
+		}
+	}
+}
+
+// written counts the data of a write, a frame when it starts with STX
+func (stats *connectionStats) written(data []byte) {
+	stats.bytesWritten.Add(int64(len(data)))
+	stats.lastActivity.Store(time.Now().UnixNano())
+	if len(data) > 0 && data[0] == constants.STX {
+		stats.framesWritten.Add(1)
+	}
+}
@@ -3,57 +3,151 @@ package connection
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
-	"strings"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	"github.com/therealriteshkudalkar/lis1a2/constants"
 )
 
 // NOTE: It's okay to copy the context object and the net.Conn object,
 // because their underlying data is passed by reference
 
 type TCPConnection struct {
-	isConnected       bool
-	serverConn        net.Conn
-	serverHost        string
-	serverPort        string
-	writeChannel      chan byte
-	readChannelString chan string
-	ctx               context.Context
-	ctxCancelFunc     context.CancelFunc
-}
-
-// NewTCPConnection creates a new TCP connection to the server provided
-func NewTCPConnection(serverHost string, serverPort string) TCPConnection {
+	isConnected      atomic.Bool
+	serverConn       net.Conn
+	serverHost       string
+	serverPort       string
+	writeChannel     chan writeRequest
+	readChannel      chan readResult
+	ctx              context.Context
+	ctxCancelFunc    context.CancelFunc
+	options          Options
+	overflow         readOverflow
+	accepted         bool
+	connMutex        sync.Mutex
+	reconnectOptions *ReconnectOptions
+	reconnecting     atomic.Bool
+	tlsConfig        *tls.Config
+	serverMode       bool
+	closeErr         error
+	writeGate        writeGate
+	stats            connectionStats
+	mllp             bool
+	webSocket        *webSocketTarget
+	unixSocket       bool
+}
+
+// NewTCPConnection creates a new TCP connection to the server provided, optionally tuned by options
+func NewTCPConnection(serverHost string, serverPort string, options ...Options) TCPConnection {
 	return TCPConnection{
-		isConnected: false,
-		serverHost:  serverHost,
-		serverPort:  serverPort,
+		serverHost: serverHost,
+		serverPort: serverPort,
+		options:    withDefaults(options),
 	}
 }
 
-// Connect connects to the tcp server
+// Connect connects to the tcp server, connections accepted by a TCPListener are already connected
 func (tcpConn *TCPConnection) Connect() error {
-	serverAddress := fmt.Sprintf("%v:%v", tcpConn.serverHost, tcpConn.serverPort)
-	conn, err := net.Dial("tcp", serverAddress)
+	return tcpConn.ConnectWithContext(context.Background())
+}
+
+// ConnectWithContext connects to the tcp server, giving up with ctx.Err() once ctx is done.
+// The wait is bounded by the DialTimeout option as well, in server mode only ctx stops waiting for the instrument.
+func (tcpConn *TCPConnection) ConnectWithContext(ctx context.Context) error {
+	if tcpConn.accepted {
+		if !tcpConn.IsConnected() {
+			return errors.New("connection accepted by a listener cannot be reconnected")
+		}
+		return nil
+	}
+	conn, err := tcpConn.dial(ctx)
 	if err != nil {
 		return err
 	}
-	tcpConn.serverConn = conn
-	tcpConn.ctx, tcpConn.ctxCancelFunc = context.WithCancel(context.Background())
-	tcpConn.isConnected = true
-	tcpConn.writeChannel = make(chan byte, 64)
-	tcpConn.readChannelString = make(chan string, 8)
+	tcpConn.start(conn)
 	return nil
 }
 
-// IsConnected gives connection status
+// dial opens a new net.Conn to the tcp server, doing the TLS handshake for TLS connections,
+// in server mode it waits for the instrument to connect instead. The net.Conn of an MLLP
+// connection wraps and unwraps the envelopes.
+func (tcpConn *TCPConnection) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := tcpConn.dialTransport(ctx)
+	if err != nil || !tcpConn.mllp {
+		return conn, err
+	}
+	return newMLLPConn(conn), nil
+}
+
+// dialTransport opens the net.Conn dial gives, before any envelope
+func (tcpConn *TCPConnection) dialTransport(ctx context.Context) (net.Conn, error) {
+	network, serverAddress := tcpConn.address()
+	if tcpConn.serverMode {
+		conn, err := acceptOne(ctx, network, serverAddress)
+		if err != nil {
+			return nil, err
+		}
+		if err := applySocketOptions(conn, tcpConn.options); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	var dialer contextDialer = &familyDialer{
+		dialer:  &net.Dialer{Timeout: tcpConn.options.DialTimeout},
+		family:  tcpConn.options.AddressFamily,
+		options: tcpConn.options,
+	}
+	if tcpConn.options.Proxy != nil && !tcpConn.unixSocket {
+		dialer = &proxyDialer{proxy: *tcpConn.options.Proxy, forward: dialer, timeout: tcpConn.options.DialTimeout}
+	}
+	if tcpConn.webSocket != nil {
+		return dialWebSocket(ctx, dialer, tcpConn.options.DialTimeout, tcpConn.webSocket)
+	}
+	if tcpConn.tlsConfig != nil {
+		return dialTLS(ctx, dialer, tcpConn.options.DialTimeout, serverAddress, tcpConn.tlsConfig)
+	}
+	return dialer.DialContext(ctx, network, serverAddress)
+}
+
+// address gives the network and the address of the server, the socket path of a Unix connection
+func (tcpConn *TCPConnection) address() (string, string) {
+	if tcpConn.unixSocket {
+		return "unix", tcpConn.serverHost
+	}
+	return tcpConn.options.AddressFamily.network(), joinHostPort(tcpConn.serverHost, tcpConn.serverPort)
+}
+
+// currentConn gives the net.Conn in use, which changes when the connection is re-established
+func (tcpConn *TCPConnection) currentConn() net.Conn {
+	tcpConn.connMutex.Lock()
+	defer tcpConn.connMutex.Unlock()
+	return tcpConn.serverConn
+}
+
+// start sets up the internal channels and context for an established net.Conn
+func (tcpConn *TCPConnection) start(conn net.Conn) {
+	tcpConn.ctx, tcpConn.ctxCancelFunc = context.WithCancel(context.Background())
+	tcpConn.writeChannel = make(chan writeRequest, tcpConn.options.WriteBufferSize)
+	tcpConn.readChannel = make(chan readResult, tcpConn.options.ReadBufferSize)
+	tcpConn.connMutex.Lock()
+	tcpConn.serverConn = conn
+	tcpConn.closeErr = nil
+	tcpConn.connMutex.Unlock()
+	tcpConn.writeGate.open()
+	tcpConn.isConnected.Store(true)
+}
+
+// IsConnected gives connection status, it is false while the connection is being re-established
 func (tcpConn *TCPConnection) IsConnected() bool {
-	return tcpConn.isConnected
+	return tcpConn.isConnected.Load() && !tcpConn.reconnecting.Load()
 }
 
 // Listen listens to the incoming messages and writes outgoing messages to the connection
@@ -62,93 +156,166 @@ func (tcpConn *TCPConnection) Listen() {
 	go tcpConn.writeToTCPConnectionFromChannel()
 }
 
-// Disconnect disconnects form the tcp server and closes all internal channels and cancel all internal contexts
+// Disconnect disconnects form the tcp server and cancels all internal contexts, which stops the read and write go routines.
+// It is safe to call Disconnect more than once and from multiple goroutines, only the first call has an effect.
 func (tcpConn *TCPConnection) Disconnect() error {
+	if !tcpConn.isConnected.CompareAndSwap(true, false) {
+		return nil
+	}
 	tcpConn.ctxCancelFunc()
-	close(tcpConn.writeChannel)
-	close(tcpConn.readChannelString)
-	tcpConn.isConnected = false
-	if err := (tcpConn.serverConn).Close(); err != nil {
+	if err := tcpConn.currentConn().Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
-// ReadStringFromConnection is a blocking call that reads from a channel
-func (tcpConn *TCPConnection) ReadStringFromConnection() (string, error) {
-	str, ok := <-tcpConn.readChannelString
-	if !ok {
-		return "", errors.New("reading from a closed channel")
+// Shutdown stops accepting writes, waits for the writes in progress to be sent and disconnects.
+// When ctx is done first it disconnects right away and returns ctx.Err().
+func (tcpConn *TCPConnection) Shutdown(ctx context.Context) error {
+	err := tcpConn.writeGate.drain(ctx)
+	if disconnectErr := tcpConn.Disconnect(); err == nil {
+		err = disconnectErr
+	}
+	return err
+}
+
+// disconnectWithError disconnects and records the reason, which the reads return from then on
+func (tcpConn *TCPConnection) disconnectWithError(cause error) error {
+	tcpConn.connMutex.Lock()
+	if tcpConn.closeErr == nil && tcpConn.isConnected.Load() {
+		tcpConn.closeErr = cause
 	}
-	return str, nil
+	tcpConn.connMutex.Unlock()
+	return tcpConn.Disconnect()
+}
+
+// closeError gives the reason the connection was disconnected for, if it was recorded
+func (tcpConn *TCPConnection) closeError() error {
+	tcpConn.connMutex.Lock()
+	defer tcpConn.connMutex.Unlock()
+	if tcpConn.closeErr != nil {
+		return tcpConn.closeErr
+	}
+	return errClosedChannel
+}
+
+// ReadBytesFromConnection is a blocking call that reads from a channel.
+// A frame whose checksum does not match is still returned, along with ErrChecksumMismatch,
+// and the bytes of an abandoned frame are returned along with ErrIncompleteFrame.
+func (tcpConn *TCPConnection) ReadBytesFromConnection() ([]byte, error) {
+	return tcpConn.ReadBytesFromConnectionContext(context.Background())
+}
+
+// ReadBytesFromConnectionContext reads from a channel until data arrives, the connection is
+// disconnected or ctx is done, in which case ctx.Err() is returned.
+// A frame whose checksum does not match is still returned, along with ErrChecksumMismatch,
+// and the bytes of an abandoned frame are returned along with ErrIncompleteFrame.
+// Once the connection was dropped because of a timeout, ErrReadIdleTimeout or ErrWriteTimeout is returned.
+func (tcpConn *TCPConnection) ReadBytesFromConnectionContext(ctx context.Context) ([]byte, error) {
+	if tcpConn.ctx == nil {
+		return nil, errClosedChannel
+	}
+	if tcpConn.overflow.pending.CompareAndSwap(true, false) {
+		return nil, ErrReadOverflow
+	}
+	select {
+	case result, ok := <-tcpConn.readChannel:
+		if !ok {
+			return nil, tcpConn.closeError()
+		}
+		return result.data, result.err
+	case <-tcpConn.ctx.Done():
+		return nil, tcpConn.closeError()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ReadStringFromConnection reads like ReadBytesFromConnection, returning the bytes read as a string
+func (tcpConn *TCPConnection) ReadStringFromConnection() (string, error) {
+	data, err := tcpConn.ReadBytesFromConnection()
+	return string(data), err
+}
+
+// ReadStringFromConnectionContext reads like ReadBytesFromConnectionContext, returning the bytes read as a string
+func (tcpConn *TCPConnection) ReadStringFromConnectionContext(ctx context.Context) (string, error) {
+	data, err := tcpConn.ReadBytesFromConnectionContext(ctx)
+	return string(data), err
 }
 
-// Write writes the string data to the TCP connection
-func (tcpConn *TCPConnection) Write(data string) {
-	dataBytes := []byte(data)
-	for _, dataByte := range dataBytes {
-		tcpConn.writeChannel <- dataByte
+// Write writes the data to the TCP connection and returns once it was written by the go routine started by Listen.
+// The error of the socket is returned if writing failed, ErrNotConnected after a disconnect
+// and ErrShutdown once Shutdown was called.
+func (tcpConn *TCPConnection) Write(data []byte) error {
+	if !tcpConn.isConnected.Load() {
+		return ErrNotConnected
+	}
+	if !tcpConn.writeGate.enter() {
+		return ErrShutdown
 	}
+	defer tcpConn.writeGate.leave()
+	return queueWrite(tcpConn.ctx, tcpConn.writeChannel, data)
+}
+
+// Stats gives a snapshot of the traffic of the connection, safe to call from any go routine
+func (tcpConn *TCPConnection) Stats() Stats {
+	stats := tcpConn.stats.snapshot()
+	stats.ReadsDropped = tcpConn.ReadsDropped()
+	return stats
+}
+
+// ReadsDropped counts the frames and control bytes which did not fit in the read channel, see OverflowPolicy
+func (tcpConn *TCPConnection) ReadsDropped() int64 {
+	return tcpConn.overflow.dropped.Load()
 }
 
 // readFromTCPConnectionAndPostItOnReadChannel reads bytes from TCP Connection and posts it on the string channel
 func (tcpConn *TCPConnection) readFromTCPConnectionAndPostItOnReadChannel() {
-	var buffer = make([]byte, 0)
+	// the read goroutine is the only sender on the read channel, so it is the one closing it
+	defer close(tcpConn.readChannel)
+	var assembler = newFrameAssembler()
 	var errorOccurred = false
-	var reader = bufio.NewReader(tcpConn.serverConn)
+	var conn = tcpConn.currentConn()
+	var reader = bufio.NewReader(conn)
 	for {
 		if errorOccurred {
 			errorOccurred = false
 			time.Sleep(time.Second * 1)
 		}
+		if tcpConn.options.ReadIdleTimeout > 0 && reader.Buffered() == 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(tcpConn.options.ReadIdleTimeout))
+		}
 		bt, err := reader.ReadByte()
 		if err != nil {
-			errorMessage := err.Error()
-			if strings.Contains(errorMessage, "EOF") {
-				if err := tcpConn.Disconnect(); err != nil {
-					slog.Error("End of file encountered! Error occurred while disconnecting.", "Error", err)
-					return
-				}
-				slog.Info("End of file encountered! Disconnected successfully.")
-				return
-			} else if strings.Contains(errorMessage, "connection reset by peer") {
-				if err := tcpConn.Disconnect(); err != nil {
-					slog.Error("Connection was reset by peers. Error occurred while disconnecting.", "Error", err)
-					return
-				}
-				slog.Info("Connection was reset by peers. Disconnected successfully.")
-				return
-			} else if strings.Contains(errorMessage, "use of closed network connection") {
-				if err := tcpConn.Disconnect(); err != nil {
-					slog.Error("Stopped using closed network connection. Error occurred while disconnecting. ", "Error", err)
-					return
+			cause, linkLost, fatal := tcpConn.classifyReadError(err)
+			if linkLost && tcpConn.reconnectOptions != nil && tcpConn.ctx.Err() == nil {
+				if newConn, ok := tcpConn.reconnect(cause); ok {
+					conn = newConn
+					reader = bufio.NewReader(conn)
+					assembler = newFrameAssembler()
+					continue
 				}
-				slog.Info("Stopped using closed network connection. Disconnected successfully.")
-				return
-			} else {
+			}
+			if !fatal {
 				slog.Error("Some error occurred while reading a byte.", "Error", err)
 				errorOccurred = true
 				continue
 			}
+			if err := tcpConn.disconnectWithError(cause); err != nil {
+				slog.Error("Stopped reading. Error occurred while disconnecting.", "Reason", cause, "Error", err)
+				return
+			}
+			slog.Info("Stopped reading. Disconnected successfully.", "Reason", cause)
+			return
 		}
 
-		if bt == constants.NUL {
-			continue
-		}
-		if bt == constants.ENQ || bt == constants.ACK || bt == constants.NAK || bt == constants.EOT {
-			buffer = make([]byte, 0)
-			buffer = append(buffer, bt)
-			tcpConn.readChannelString <- string(buffer)
-			buffer = make([]byte, 0)
-		} else if bt == constants.STX {
-			// start of frame
-			buffer = make([]byte, 0)
-			buffer = append(buffer, bt)
-		} else if bt == constants.LF {
-			buffer = append(buffer, bt)
-			tcpConn.readChannelString <- string(buffer)
-		} else {
-			buffer = append(buffer, bt)
+		results := assembler.feed(bt)
+		tcpConn.stats.read(results)
+		for _, result := range results {
+			if !postRead(tcpConn.ctx, tcpConn.readChannel, result, tcpConn.options.OverflowPolicy, &tcpConn.overflow, tcpConn.Write) {
+				slog.Info("Ending read go routine, disconnected while waiting for the consumer.")
+				return
+			}
 		}
 
 		select {
@@ -161,15 +328,69 @@ func (tcpConn *TCPConnection) readFromTCPConnectionAndPostItOnReadChannel() {
 	}
 }
 
+// classifyReadError tells what a read error means for the connection: the error the reads return once
+// disconnected because of it, whether the link to the server was lost and can be re-established, and
+// whether reading has to stop. The other errors are retried after a pause.
+func (tcpConn *TCPConnection) classifyReadError(err error) (cause error, linkLost bool, fatal bool) {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrReadIdleTimeout, true, true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrConnectionClosed, true, true
+	case errors.Is(err, syscall.ECONNRESET):
+		return fmt.Errorf("%w: %w", ErrPeerReset, err), true, true
+	case errors.Is(err, net.ErrClosed):
+		// closed by Disconnect
+		return errClosedChannel, false, true
+	case tcpConn.tlsConfig != nil || tcpConn.webSocket != nil:
+		// a TLS connection keeps failing with the same error once the record layer failed,
+		// and so does a WebSocket once a frame was invalid
+		return err, false, true
+	default:
+		return err, false, false
+	}
+}
+
 // writeToTCPConnectionFromChannel writes the data put on the write channel
 func (tcpConn *TCPConnection) writeToTCPConnectionFromChannel() {
-	for byteToBeSent := range tcpConn.writeChannel {
-		count, err := (tcpConn.serverConn).Write([]byte{byteToBeSent})
-		if err != nil {
-			slog.Error("Failed to send byte over TCP.")
-			continue
+	for {
+		select {
+		case <-tcpConn.ctx.Done():
+			slog.Info("Ending writeToTCPConnectionFromChannel Go routine.")
+			return
+		case request := <-tcpConn.writeChannel:
+			err := tcpConn.writeToConn(request.data)
+			request.done <- err
+			if errors.Is(err, ErrWriteTimeout) {
+				// the peer stopped reading, what comes next cannot be delivered either
+				if err := tcpConn.disconnectWithError(ErrWriteTimeout); err != nil {
+					slog.Error("Write timed out. Error occurred while disconnecting.", "Error", err)
+					return
+				}
+				slog.Info("Write timed out. Disconnected successfully.")
+				return
+			}
+			if err != nil {
+				slog.Error("Failed to send data over TCP.", "Error", err)
+			}
 		}
-		slog.Debug("Byte sent successfully.", "Byte", byteToBeSent, "Count", count)
 	}
-	slog.Info("Ending writeToTCPConnectionFromChannel Go routine.")
+}
+
+// writeToConn writes the data of a single Write, usually a whole frame, to the net.Conn in use at once
+func (tcpConn *TCPConnection) writeToConn(data []byte) error {
+	conn := tcpConn.currentConn()
+	if tcpConn.options.WriteTimeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(tcpConn.options.WriteTimeout))
+	}
+	count, err := conn.Write(data)
+	tcpConn.stats.written(data[:count])
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrWriteTimeout
+	}
+	if err != nil {
+		return err
+	}
+	slog.Debug("Data sent successfully.", "Data", data, "Count", count)
+	return nil
 }
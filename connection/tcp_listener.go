@@ -0,0 +1,103 @@
+package connection
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+// TCPListener accepts connections from instruments which dial into the LIS
+type TCPListener struct {
+	listener   net.Listener
+	options    Options
+	mllp       bool
+	unixSocket bool
+}
+
+// NewTCPListener starts listening for incoming TCP connections on the host and port provided,
+// the options apply to every accepted connection. The host is an IPv4 or IPv6 address, bare or in
+// brackets, or a name, the AddressFamily option restricting the IP versions listened on.
+func NewTCPListener(host string, port string, options ...Options) (*TCPListener, error) {
+	merged := withDefaults(options)
+	listener, err := net.Listen(merged.AddressFamily.network(), joinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	return &TCPListener{listener: listener, options: merged}, nil
+}
+
+// Accept waits for the next incoming connection and returns it connected and ready to Listen,
+// every accepted connection has its own channels and context
+func (tcpListener *TCPListener) Accept() (*TCPConnection, error) {
+	conn, err := tcpListener.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketOptions(conn, tcpListener.options); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	host, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if tcpListener.unixSocket {
+		// the peers of a Unix socket are usually unnamed, the path tells where they came from
+		host, port = tcpListener.listener.Addr().String(), ""
+	}
+	tcpConn := &TCPConnection{
+		serverHost: host,
+		serverPort: port,
+		accepted:   true,
+		options:    tcpListener.options,
+		mllp:       tcpListener.mllp,
+		unixSocket: tcpListener.unixSocket,
+	}
+	if tcpListener.mllp {
+		conn = newMLLPConn(conn)
+	}
+	tcpConn.start(conn)
+	return tcpConn, nil
+}
+
+// Addr returns the address the listener is bound to
+func (tcpListener *TCPListener) Addr() net.Addr {
+	return tcpListener.listener.Addr()
+}
+
+// Close stops listening, connections already accepted stay open
+func (tcpListener *TCPListener) Close() error {
+	return tcpListener.listener.Close()
+}
+
+// NewTCPServerConnection creates a TCP connection in server mode, Connect listens on the host and
+// port provided and waits for an instrument to connect. It is a Connection like any other, so it
+// can be wrapped by NewASTMConnection unchanged, and connecting again after a disconnect waits for
+// the next incoming connection. Nothing listens in between, a TCPListener is the way to accept
+// several instruments or keep accepting while connected.
+func NewTCPServerConnection(host string, port string, options ...Options) TCPConnection {
+	return TCPConnection{
+		serverHost: host,
+		serverPort: port,
+		serverMode: true,
+		options:    withDefaults(options),
+	}
+}
+
+// acceptOne listens on the address until a single connection comes in or ctx is done
+func acceptOne(ctx context.Context, network string, address string) (net.Conn, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := listener.Close(); err != nil && ctx.Err() == nil {
+			slog.Error("Error occurred while closing listener.", "Error", err)
+		}
+	}()
+	// closing the listener is the only way to interrupt Accept
+	stop := context.AfterFunc(ctx, func() { _ = listener.Close() })
+	defer stop()
+	conn, err := listener.Accept()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
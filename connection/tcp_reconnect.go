@@ -0,0 +1,121 @@
+package connection
+
+import (
+	"log/slog"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ReconnectOptions configures how a TCPConnection re-establishes itself after the link is lost
+type ReconnectOptions struct {
+	// MaxRetries is the number of dial attempts before giving up, zero retries forever
+	MaxRetries int
+	// InitialBackoff is the wait before the first attempt, it doubles after every failed attempt, defaults to 1 second
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts, defaults to 1 minute
+	MaxBackoff time.Duration
+	// OnDisconnected is called with the read error as soon as the link is lost, before re-dialing
+	OnDisconnected func(err error)
+	// OnReconnected is called with the number of attempts it took once the connection is re-established
+	OnReconnected func(attempts int)
+	// OnAbandoned is called with the last dial error once MaxRetries attempts have failed, the connection is then disconnected
+	OnAbandoned func(err error)
+}
+
+// NewTCPConnectionWithReconnect creates a new TCP connection to the server provided which re-dials
+// with exponential backoff and jitter when the server closes or resets the connection.
+// The read and write channels and the go routines started by Listen survive the reconnect, so readers
+// and writers do not notice it, but writes not sent yet when the link was lost fail with the reason it was lost.
+// The OnDisconnected, OnReconnected and OnAbandoned callbacks tell the caller about the link.
+func NewTCPConnectionWithReconnect(serverHost string, serverPort string, reconnectOptions ReconnectOptions, options ...Options) TCPConnection {
+	if reconnectOptions.InitialBackoff <= 0 {
+		reconnectOptions.InitialBackoff = time.Second
+	}
+	if reconnectOptions.MaxBackoff <= 0 {
+		reconnectOptions.MaxBackoff = time.Minute
+	}
+	if reconnectOptions.MaxBackoff < reconnectOptions.InitialBackoff {
+		reconnectOptions.MaxBackoff = reconnectOptions.InitialBackoff
+	}
+	return TCPConnection{
+		serverHost:       serverHost,
+		serverPort:       serverPort,
+		reconnectOptions: &reconnectOptions,
+		options:          withDefaults(options),
+	}
+}
+
+// reconnect re-dials the tcp server until it succeeds, the retries run out or the connection is disconnected,
+// it is called with the error which ended the link from the read go routine, which carries on reading from the
+// returned net.Conn
+func (tcpConn *TCPConnection) reconnect(cause error) (net.Conn, bool) {
+	options := tcpConn.reconnectOptions
+	tcpConn.reconnecting.Store(true)
+	defer tcpConn.reconnecting.Store(false)
+	_ = tcpConn.currentConn().Close()
+	slog.Info("Connection lost. Reconnecting.", "Host", tcpConn.serverHost, "Port", tcpConn.serverPort, "Error", cause)
+	if options.OnDisconnected != nil {
+		options.OnDisconnected(cause)
+	}
+
+	backoff := options.InitialBackoff
+	var err error
+	for attempt := 1; options.MaxRetries <= 0 || attempt <= options.MaxRetries; attempt++ {
+		// wait between half and the whole backoff, so that many connections do not re-dial in lockstep
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-tcpConn.ctx.Done():
+			return nil, false
+		case <-time.After(wait):
+		}
+
+		var conn net.Conn
+		conn, err = tcpConn.dial(tcpConn.ctx)
+		if err == nil {
+			tcpConn.connMutex.Lock()
+			if tcpConn.ctx.Err() != nil {
+				tcpConn.connMutex.Unlock()
+				_ = conn.Close()
+				return nil, false
+			}
+			tcpConn.serverConn = conn
+			tcpConn.connMutex.Unlock()
+			tcpConn.dropPendingWrites(cause)
+			tcpConn.stats.reconnects.Add(1)
+			slog.Info("Reconnected successfully.", "Attempts", attempt)
+			if options.OnReconnected != nil {
+				options.OnReconnected(attempt)
+			}
+			return conn, true
+		}
+		slog.Debug("Failed to reconnect.", "Attempt", attempt, "Error", err)
+		backoff *= 2
+		if backoff > options.MaxBackoff {
+			backoff = options.MaxBackoff
+		}
+	}
+
+	slog.Error("Gave up reconnecting.", "Error", err)
+	if options.OnAbandoned != nil {
+		options.OnAbandoned(err)
+	}
+	return nil, false
+}
+
+// dropPendingWrites fails the writes which were queued before the link was lost and not sent yet with its cause
+func (tcpConn *TCPConnection) dropPendingWrites(cause error) {
+	dropped := 0
+	for {
+		select {
+		case request := <-tcpConn.writeChannel:
+			request.done <- cause
+			dropped++
+		default:
+			if dropped > 0 {
+				slog.Info("Dropped writes not sent before the connection was lost.", "Count", dropped)
+			}
+			return
+		}
+	}
+}
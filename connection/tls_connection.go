@@ -0,0 +1,158 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TLSOptions builds the tls.Config of a TLS connection from files on disk
+type TLSOptions struct {
+	// RootCAFile is a PEM file with the CAs trusted to sign the server certificate, the system pool is used when empty
+	RootCAFile string
+	// ServerName is verified against the server certificate, it defaults to the host connected to
+	ServerName string
+	// ClientCertFile and ClientKeyFile are the PEM files of the certificate presented to servers requiring mutual TLS
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// Config loads the files and builds the tls.Config to pass to NewTLSConnection
+func (tlsOptions TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: tlsOptions.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tlsOptions.RootCAFile != "" {
+		pem, err := os.ReadFile(tlsOptions.RootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in root CA file " + tlsOptions.RootCAFile)
+		}
+		config.RootCAs = rootCAs
+	}
+	if tlsOptions.ClientCertFile != "" || tlsOptions.ClientKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(tlsOptions.ClientCertFile, tlsOptions.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// ErrClientCertificateRejected is returned by Connect when the server does not accept the client certificate
+var ErrClientCertificateRejected = errors.New("client certificate rejected by server")
+
+// clientCertificateProbeTimeout is how long dialTLS waits for the server to reject the client certificate
+const clientCertificateProbeTimeout = 250 * time.Millisecond
+
+// dialTLS dials the server and completes the handshake. With TLS 1.3 the server checks the client
+// certificate after the client is done with the handshake, so when the server asks for one it waits
+// briefly for a rejection to fail fast instead of on the first read.
+func dialTLS(ctx context.Context, dialer contextDialer, timeout time.Duration, address string, config *tls.Config) (net.Conn, error) {
+	var certificateRequested atomic.Bool
+	config = config.Clone()
+	getClientCertificate := config.GetClientCertificate
+	certificates := config.Certificates
+	config.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		certificateRequested.Store(true)
+		if getClientCertificate != nil {
+			return getClientCertificate(info)
+		}
+		for index := range certificates {
+			if info.SupportsCertificate(&certificates[index]) == nil {
+				return &certificates[index], nil
+			}
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	conn, err := dialTLSConn(ctx, dialer, timeout, address, config)
+	if err != nil {
+		if isCertificateRejection(err) {
+			return nil, fmt.Errorf("%w: %v", ErrClientCertificateRejected, err)
+		}
+		return nil, err
+	}
+	if !certificateRequested.Load() || conn.ConnectionState().Version < tls.VersionTLS13 {
+		return conn, nil
+	}
+
+	probe := make([]byte, 1)
+	_ = conn.SetReadDeadline(time.Now().Add(clientCertificateProbeTimeout))
+	count, err := conn.Read(probe)
+	_ = conn.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		_ = conn.Close()
+		if isCertificateRejection(err) {
+			return nil, fmt.Errorf("%w: %v", ErrClientCertificateRejected, err)
+		}
+		return nil, err
+	}
+	return &probedConn{Conn: conn, pending: probe[:count]}, nil
+}
+
+// isCertificateRejection tells whether the error is an alert sent by the server for our certificate
+func isCertificateRejection(err error) bool {
+	errorMessage := err.Error()
+	if !strings.Contains(errorMessage, "remote error: tls:") {
+		return false
+	}
+	for _, alert := range []string{"bad certificate", "certificate required", "unknown certificate authority",
+		"certificate expired", "certificate revoked", "unsupported certificate", "certificate unknown", "access denied"} {
+		if strings.Contains(errorMessage, alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// probedConn hands out the data read while probing for a rejection before reading from the connection again
+type probedConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (conn *probedConn) Read(data []byte) (int, error) {
+	if len(conn.pending) > 0 {
+		count := copy(data, conn.pending)
+		conn.pending = conn.pending[count:]
+		return count, nil
+	}
+	return conn.Conn.Read(data)
+}
+
+// NewTLSConnection creates a new TLS connection to the server provided, it behaves exactly
+// like a TCPConnection once connected. The config carries the root CAs and server name used
+// to verify the server and the client certificates, when ServerName is empty it is taken from the host.
+func NewTLSConnection(serverHost string, serverPort string, config *tls.Config, options ...Options) TCPConnection {
+	return TCPConnection{
+		serverHost: serverHost,
+		serverPort: serverPort,
+		tlsConfig:  config,
+		options:    withDefaults(options),
+	}
+}
+
+// NewTLSListener starts listening for incoming TLS connections on the host and port provided,
+// the config has to carry the server certificates
+func NewTLSListener(host string, port string, config *tls.Config, options ...Options) (*TCPListener, error) {
+	merged := withDefaults(options)
+	listener, err := tls.Listen(merged.AddressFamily.network(), joinHostPort(host, port), config)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPListener{listener: listener, options: merged}, nil
+}
@@ -0,0 +1,116 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// traceTimeFormat is the timestamp starting every line of a trace, down to the millisecond
+const traceTimeFormat = "2006-01-02 15:04:05.000"
+
+// TracingConnection wraps a Connection, writing a line to the trace for every control character and
+// frame sent, marked ->, and received, marked <-, with a timestamp and the control characters by name:
+//
+//	2026-10-14 10:15:00.120 -> <ENQ>
+//	2026-10-14 10:15:00.152 <- <ACK>
+//	2026-10-14 10:15:00.153 -> <STX>1H|\^&<CR><ETX>E5<CR><LF>
+//
+// It is what service engineers ask for when debugging an analyzer interface. Pass it wherever the
+// wrapped Connection would go, to NewASTMConnection for instance.
+type TracingConnection struct {
+	Connection
+	trace io.Writer
+	mutex sync.Mutex
+}
+
+// NewTracingConnection wraps the connection, writing its trace to trace, like os.Stderr or a log file
+func NewTracingConnection(conn Connection, trace io.Writer) *TracingConnection {
+	return &TracingConnection{Connection: conn, trace: trace}
+}
+
+// ConnectWithContext connects the wrapped connection, with ctx if it has a ConnectWithContext method
+func (tracingConn *TracingConnection) ConnectWithContext(ctx context.Context) error {
+	if contextConnector, ok := tracingConn.Connection.(interface{ ConnectWithContext(context.Context) error }); ok {
+		return contextConnector.ConnectWithContext(ctx)
+	}
+	return tracingConn.Connection.Connect()
+}
+
+// Shutdown shuts the wrapped connection down if it has a Shutdown method, and disconnects it otherwise
+func (tracingConn *TracingConnection) Shutdown(ctx context.Context) error {
+	if shutdowner, ok := tracingConn.Connection.(interface{ Shutdown(context.Context) error }); ok {
+		return shutdowner.Shutdown(ctx)
+	}
+	return tracingConn.Connection.Disconnect()
+}
+
+// Write traces the data, then writes it to the wrapped connection, a failure is traced as well
+func (tracingConn *TracingConnection) Write(data []byte) error {
+	// traced before writing, the reply of the peer could otherwise be traced first
+	tracingConn.traceLine("->", data, nil)
+	err := tracingConn.Connection.Write(data)
+	if err != nil {
+		tracingConn.traceLine("->", nil, err)
+	}
+	return err
+}
+
+// ReadStringFromConnection reads from the wrapped connection and traces what was read,
+// along with the error when the data is returned with one, like ErrChecksumMismatch
+func (tracingConn *TracingConnection) ReadStringFromConnection() (string, error) {
+	data, err := tracingConn.Connection.ReadStringFromConnection()
+	tracingConn.traceRead([]byte(data), err)
+	return data, err
+}
+
+// ReadStringFromConnectionContext reads like ReadStringFromConnection, giving up once ctx is done if the
+// wrapped connection has a ReadStringFromConnectionContext method
+func (tracingConn *TracingConnection) ReadStringFromConnectionContext(ctx context.Context) (string, error) {
+	contextReader, ok := tracingConn.Connection.(interface {
+		ReadStringFromConnectionContext(context.Context) (string, error)
+	})
+	if !ok {
+		return tracingConn.ReadStringFromConnection()
+	}
+	data, err := contextReader.ReadStringFromConnectionContext(ctx)
+	tracingConn.traceRead([]byte(data), err)
+	return data, err
+}
+
+// ReadBytesFromConnection reads like ReadStringFromConnection, through the ReadBytesFromConnection method
+// of the wrapped connection if it has one
+func (tracingConn *TracingConnection) ReadBytesFromConnection() ([]byte, error) {
+	bytesConn, ok := tracingConn.Connection.(BytesConnection)
+	if !ok {
+		data, err := tracingConn.ReadStringFromConnection()
+		return []byte(data), err
+	}
+	data, err := bytesConn.ReadBytesFromConnection()
+	tracingConn.traceRead(data, err)
+	return data, err
+}
+
+func (tracingConn *TracingConnection) traceRead(data []byte, err error) {
+	if len(data) > 0 {
+		tracingConn.traceLine("<-", data, err)
+	}
+}
+
+// traceLine writes a line of the trace, the data described followed by the error if there is one
+func (tracingConn *TracingConnection) traceLine(direction string, data []byte, err error) {
+	line := time.Now().Format(traceTimeFormat) + " " + direction
+	if len(data) > 0 {
+		line += " " + protocol.Describe(data)
+	}
+	if err != nil {
+		line += fmt.Sprintf(" (%v)", err)
+	}
+	tracingConn.mutex.Lock()
+	defer tracingConn.mutex.Unlock()
+	_, _ = fmt.Fprintln(tracingConn.trace, line)
+}
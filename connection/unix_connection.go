@@ -0,0 +1,35 @@
+package connection
+
+import "net"
+
+// NewUnixConnection creates a new connection to the Unix domain socket at path, for the gateway processes on
+// the same host, like a serial to socket bridge. It frames, reconnects aside, exactly like a TCPConnection.
+func NewUnixConnection(path string, options ...Options) TCPConnection {
+	return TCPConnection{
+		serverHost: path,
+		unixSocket: true,
+		options:    withDefaults(options),
+	}
+}
+
+// NewUnixServerConnection creates a Unix connection in server mode, Connect listens on the socket at path and
+// waits for a process to connect, see NewTCPServerConnection. The socket file is removed once connected.
+func NewUnixServerConnection(path string, options ...Options) TCPConnection {
+	return TCPConnection{
+		serverHost: path,
+		serverMode: true,
+		unixSocket: true,
+		options:    withDefaults(options),
+	}
+}
+
+// NewUnixListener starts listening for incoming connections on the Unix domain socket at path, the options
+// apply to every accepted connection. The socket file is removed when the listener is closed, it has to be
+// removed beforehand when a process which crashed left it behind.
+func NewUnixListener(path string, options ...Options) (*TCPListener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPListener{listener: listener, options: withDefaults(options), unixSocket: true}, nil
+}
@@ -0,0 +1,325 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWebSocketHandshake is wrapped by the error of a connection the server did not upgrade to a WebSocket
+var ErrWebSocketHandshake = errors.New("websocket handshake failed")
+
+// errWebSocketProtocol is wrapped by the error of a read the server sent an invalid frame for
+var errWebSocketProtocol = errors.New("websocket protocol violated by the server")
+
+// webSocketGUID is appended to the key of the handshake before hashing it, see RFC 6455
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketCloseTimeout bounds how long sending the close frame takes when disconnecting
+const webSocketCloseTimeout = time.Second
+
+// The opcodes of the WebSocket frames
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocketOptions tunes the handshake of a WebSocket connection
+type WebSocketOptions struct {
+	// TLSConfig verifies the server of a wss:// URL, the system roots and the host of the URL by default
+	TLSConfig *tls.Config
+	// Header is sent along with the handshake, like an Authorization header
+	Header http.Header
+	// Subprotocols are offered to the server, which has to pick one of them when set
+	Subprotocols []string
+}
+
+// webSocketTarget is the server a WebSocket connection dials
+type webSocketTarget struct {
+	url     string
+	options WebSocketOptions
+}
+
+// NewWebSocketConnection creates a new connection tunneling the LIS1-A2 byte stream to the ws:// or wss://
+// URL provided, for the gateways behind firewalls which only let HTTP through. Every write is sent as a binary
+// message and the data of the messages received is read as a stream, framed exactly like a TCPConnection,
+// which it behaves like once connected. The ping frames of the server are answered, a close frame disconnects
+// with ErrConnectionClosed. A server refusing the upgrade makes Connect fail with ErrWebSocketHandshake.
+func NewWebSocketConnection(rawURL string, webSocketOptions WebSocketOptions, options ...Options) TCPConnection {
+	var host, port string
+	if location, err := url.Parse(rawURL); err == nil {
+		host, port = location.Hostname(), location.Port()
+	}
+	return TCPConnection{
+		serverHost: host,
+		serverPort: port,
+		webSocket:  &webSocketTarget{url: rawURL, options: webSocketOptions},
+		options:    withDefaults(options),
+	}
+}
+
+// dialWebSocket dials the server of the URL and upgrades the connection to a WebSocket, the handshake
+// is bounded by the timeout of the dialer and by ctx
+func dialWebSocket(ctx context.Context, dialer contextDialer, timeout time.Duration, target *webSocketTarget) (net.Conn, error) {
+	location, err := url.Parse(target.url)
+	if err != nil {
+		return nil, err
+	}
+	port := location.Port()
+	switch location.Scheme {
+	case "ws":
+		if port == "" {
+			port = "80"
+		}
+	case "wss":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme %q, expected ws or wss", location.Scheme)
+	}
+	address := net.JoinHostPort(location.Hostname(), port)
+
+	var conn net.Conn
+	if location.Scheme == "wss" {
+		config := &tls.Config{MinVersion: tls.VersionTLS12}
+		if target.options.TLSConfig != nil {
+			config = target.options.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = location.Hostname()
+		}
+		conn, err = dialTLSConn(ctx, dialer, timeout, address, config)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	// setting the deadline is the only way to interrupt the handshake
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	reader, err := webSocketHandshake(conn, location, target.options)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &webSocketConn{Conn: conn, reader: reader}, nil
+}
+
+// webSocketHandshake sends the upgrade request and checks the answer of the server, giving the reader
+// holding what the server sent after it
+func webSocketHandshake(conn net.Conn, location *url.URL, options WebSocketOptions) (*bufio.Reader, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	header := options.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Key", key)
+	header.Set("Sec-WebSocket-Version", "13")
+	if len(options.Subprotocols) > 0 {
+		header.Set("Sec-WebSocket-Protocol", strings.Join(options.Subprotocols, ", "))
+	}
+	request := &http.Request{Method: http.MethodGet, URL: location, Host: location.Host, Header: header}
+	if err := request.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %v", ErrWebSocketHandshake, response.Status)
+	}
+	if !strings.EqualFold(response.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(response.Header.Get("Connection")), "upgrade") {
+		return nil, fmt.Errorf("%w: the server did not upgrade to websocket", ErrWebSocketHandshake)
+	}
+	digest := sha1.Sum([]byte(key + webSocketGUID))
+	if response.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(digest[:]) {
+		return nil, fmt.Errorf("%w: Sec-WebSocket-Accept does not match the key", ErrWebSocketHandshake)
+	}
+	if len(options.Subprotocols) > 0 {
+		chosen := response.Header.Get("Sec-WebSocket-Protocol")
+		offered := false
+		for _, subprotocol := range options.Subprotocols {
+			offered = offered || subprotocol == chosen
+		}
+		if !offered {
+			return nil, fmt.Errorf("%w: the server did not pick one of the subprotocols, got %q", ErrWebSocketHandshake, chosen)
+		}
+	}
+	return reader, nil
+}
+
+// webSocketConn reads the data of the messages received as a stream and sends every write as a binary message
+type webSocketConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+	// remaining is the count of data bytes of the frame being read which were not read yet
+	remaining  uint64
+	masked     bool
+	mask       [4]byte
+	maskOffset int
+}
+
+// Read reads the data of the text, binary and continuation frames, handling the control frames in between
+func (conn *webSocketConn) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	for conn.remaining == 0 {
+		if err := conn.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	count, err := conn.reader.Read(data[:min(uint64(len(data)), conn.remaining)])
+	conn.unmask(data[:count])
+	conn.remaining -= uint64(count)
+	return count, err
+}
+
+// nextFrame reads the header of the next frame, answering a ping and giving io.EOF for a close frame
+func (conn *webSocketConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(conn.reader, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0F
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	// a server should not mask its frames, those which are get unmasked anyway
+	conn.masked, conn.maskOffset = header[1]&0x80 != 0, 0
+	if conn.masked {
+		if _, err := io.ReadFull(conn.reader, conn.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		conn.remaining = length
+		return nil
+	case opClose, opPing, opPong:
+		if length > 125 {
+			return fmt.Errorf("%w: control frame of %v bytes", errWebSocketProtocol, length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(conn.reader, payload); err != nil {
+			return err
+		}
+		conn.unmask(payload)
+		switch opcode {
+		case opPing:
+			return conn.writeFrame(opPong, payload)
+		case opClose:
+			// echo the status code, the connection is closed by the read go routine from then on
+			_ = conn.writeFrame(opClose, payload[:min(len(payload), 2)])
+			return io.EOF
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown opcode %v", errWebSocketProtocol, opcode)
+	}
+}
+
+// unmask unmasks the data read from a masked frame in place
+func (conn *webSocketConn) unmask(data []byte) {
+	if !conn.masked {
+		return
+	}
+	for index := range data {
+		data[index] ^= conn.mask[conn.maskOffset%4]
+		conn.maskOffset++
+	}
+}
+
+// Write sends the data as a single binary message
+func (conn *webSocketConn) Write(data []byte) (int, error) {
+	if err := conn.writeFrame(opBinary, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// writeFrame sends a single frame, masked as the frames of a client have to be, safe for concurrent use
+func (conn *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for index, bt := range payload {
+		frame = append(frame, bt^mask[index%4])
+	}
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	_, err := conn.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame, without waiting for the answer of the server, and closes the connection
+func (conn *webSocketConn) Close() error {
+	_ = conn.Conn.SetWriteDeadline(time.Now().Add(webSocketCloseTimeout))
+	_ = conn.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, 1000))
+	return conn.Conn.Close()
+}
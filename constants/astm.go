@@ -12,3 +12,14 @@ const (
 	EOT byte = '\x04'
 	NUL byte = '\x00'
 )
+
+const (
+	XON  byte = '\x11'
+	XOFF byte = '\x13'
+)
+
+// The bytes of an MLLP envelope
+const (
+	VT byte = '\x0B'
+	FS byte = '\x1C'
+)
@@ -12,4 +12,15 @@ const (
 const (
 	MaxFrameSize         = 240
 	MaxConnectionRetires = 5
+	MaxSendAttempts      = 6
+)
+
+// Role is the side of the link a connection plays, it decides who backs off when both sides send ENQ at once
+type Role int
+
+const (
+	// Instrument has priority on contention, it sends ENQ again after a second
+	Instrument Role = iota
+	// ComputerSystem yields on contention, it receives the message of the instrument and waits before sending ENQ again
+	ComputerSystem
 )
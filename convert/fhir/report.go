@@ -0,0 +1,334 @@
+// Package fhir converts parsed ASTM result messages into FHIR R4 resources, for the results forwarded
+// straight to the API of an EHR. ConvertResults maps them to a transaction Bundle of Patient,
+// DiagnosticReport and Observation resources, which marshals to the JSON of FHIR.
+package fhir
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// ErrNoPatients is returned for a message without patient records, the resources report the results of patients
+var ErrNoPatients = errors.New("message has no patient records")
+
+// The coding systems the resources use
+const (
+	// LOINC is the system of the LOINC codes, the ones EHRs expect the observations to be coded with
+	LOINC = "http://loinc.org"
+	// localTestCodeSystem is the system of the test codes of the instrument, used unless Options.LocalSystem is set
+	localTestCodeSystem = "urn:lis1a2:test-code"
+	// observationCategorySystem is the system of the category of an Observation
+	observationCategorySystem = "http://terminology.hl7.org/CodeSystem/observation-category"
+	// diagnosticServiceSystem is the system of the category of a DiagnosticReport
+	diagnosticServiceSystem = "http://terminology.hl7.org/CodeSystem/v2-0074"
+	// interpretationSystem is the system of the interpretation of an Observation, whose codes are the abnormal flags of ASTM
+	interpretationSystem = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+)
+
+// Options tunes the conversion, a field left at its zero value keeps its default
+type Options struct {
+	// MapCode gives the coding of the test code of an instrument, component 4 of its Universal Test ID like
+	// GLU in ^^^GLU, typically its LOINC code. It is called with the whole Universal Test ID as well, and the
+	// test code is only kept as a coding of LocalSystem when it returns false. See MapCodes.
+	MapCode func(testCode string, universalTestID astm.Field) (Coding, bool)
+	// LocalSystem is the system of the test codes of the instrument, defaults to urn:lis1a2:test-code
+	LocalSystem string
+	// PatientSystem is the system of the patient identifiers, left out by default
+	PatientSystem string
+	// SpecimenSystem is the system of the specimen identifiers of the reports, left out by default
+	SpecimenSystem string
+	// Location is the time zone of the timestamps without an offset, defaults to UTC
+	Location *time.Location
+	// NewID gives the ID of every resource, referenced within the bundle as urn:uuid:ID, a random UUID by default
+	NewID func() string
+}
+
+// optionsWithDefaults takes the first options given, filling in the defaults of the fields left unset
+func optionsWithDefaults(options []Options) Options {
+	merged := Options{}
+	if len(options) > 0 {
+		merged = options[0]
+	}
+	if merged.LocalSystem == "" {
+		merged.LocalSystem = localTestCodeSystem
+	}
+	if merged.Location == nil {
+		merged.Location = time.UTC
+	}
+	if merged.NewID == nil {
+		merged.NewID = newUUID
+	}
+	return merged
+}
+
+// MapCodes gives a MapCode hook looking the test codes up in codes, like {"GLU": {System: LOINC, Code: "2345-7"}}
+func MapCodes(codes map[string]Coding) func(testCode string, universalTestID astm.Field) (Coding, bool) {
+	return func(testCode string, _ astm.Field) (Coding, bool) {
+		coding, ok := codes[testCode]
+		return coding, ok
+	}
+}
+
+// ConvertResults converts the patients of a parsed ASTM message, with their orders and results, into a
+// FHIR R4 transaction Bundle: a Patient resource for every patient, a DiagnosticReport for every order and
+// an Observation for every result of it, the report referencing its observations and all of them the
+// patient. The comments of a result become notes of its observation, the ones of an order the conclusion
+// of its report. The test codes are coded as Options.MapCode tells.
+// It is optionally tuned by options.
+func ConvertResults(message *astm.Message, options ...Options) (*Bundle, error) {
+	if len(message.Patients) == 0 {
+		return nil, ErrNoPatients
+	}
+	converter := &converter{options: optionsWithDefaults(options)}
+	bundle := &Bundle{ResourceType: "Bundle", Type: "transaction"}
+	for _, patient := range message.Patients {
+		patientURL := converter.add(bundle, "Patient", converter.patient(patient))
+		for _, order := range patient.Orders {
+			var results []Reference
+			var statuses []string
+			for _, result := range order.Results {
+				observation := converter.observation(result, patientURL)
+				results = append(results, Reference{Reference: converter.add(bundle, "Observation", observation)})
+				statuses = append(statuses, observation.Status)
+			}
+			converter.add(bundle, "DiagnosticReport", converter.report(order, patientURL, results, statuses))
+		}
+	}
+	return bundle, nil
+}
+
+// converter maps the records of a message to resources
+type converter struct {
+	options Options
+}
+
+// add adds a resource to the bundle with the request creating it and gives the URL referencing it
+func (converter *converter) add(bundle *Bundle, resourceType string, resource any) string {
+	fullURL := "urn:uuid:" + converter.options.NewID()
+	bundle.Entry = append(bundle.Entry, BundleEntry{
+		FullURL:  fullURL,
+		Resource: resource,
+		Request:  &BundleRequest{Method: "POST", URL: resourceType},
+	})
+	return fullURL
+}
+
+// patient maps a patient record to a Patient: its practice or laboratory assigned ID, its name, whose
+// components are the last, first, middle, suffix and title, its birthdate and its sex
+func (converter *converter) patient(patient *astm.Patient) *Patient {
+	resource := &Patient{ResourceType: "Patient"}
+	for _, id := range []string{patient.PracticePatientID.Component(1), patient.LaboratoryPatientID.Component(1)} {
+		if id != "" {
+			resource.Identifier = append(resource.Identifier, Identifier{System: converter.options.PatientSystem, Value: id})
+		}
+	}
+	name := HumanName{Family: patient.Name.Component(1)}
+	for _, given := range []string{patient.Name.Component(2), patient.Name.Component(3)} {
+		if given != "" {
+			name.Given = append(name.Given, given)
+		}
+	}
+	if suffix := patient.Name.Component(4); suffix != "" {
+		name.Suffix = []string{suffix}
+	}
+	if prefix := patient.Name.Component(5); prefix != "" {
+		name.Prefix = []string{prefix}
+	}
+	if name.Family != "" || len(name.Given) > 0 {
+		resource.Name = []HumanName{name}
+	}
+	if birthdate, err := patient.Birthdate.Time(); err == nil && !birthdate.IsZero() {
+		resource.BirthDate = birthdate.Format("2006-01-02")
+	}
+	switch strings.ToUpper(patient.Sex.Component(1)) {
+	case "M":
+		resource.Gender = "male"
+	case "F":
+		resource.Gender = "female"
+	case "U":
+		resource.Gender = "unknown"
+	case "":
+	default:
+		resource.Gender = "other"
+	}
+	return resource
+}
+
+// report maps an order record to the DiagnosticReport of its results, given by the references to their
+// observations and their status
+func (converter *converter) report(order *astm.Order, patientURL string, results []Reference, statuses []string) *DiagnosticReport {
+	report := &DiagnosticReport{
+		ResourceType: "DiagnosticReport",
+		Status:       reportStatus(statuses),
+		Category: []CodeableConcept{{Coding: []Coding{
+			{System: diagnosticServiceSystem, Code: "LAB", Display: "Laboratory"},
+		}}},
+		Subject: &Reference{Reference: patientURL},
+		Result:  results,
+	}
+	if specimenID := order.SpecimenID.Component(1); specimenID != "" {
+		report.Identifier = []Identifier{{System: converter.options.SpecimenSystem, Value: specimenID}}
+	}
+	if order.UniversalTestID.Repeats() == 1 {
+		report.Code = converter.code(order.UniversalTestID)
+	} else {
+		report.Code = CodeableConcept{Text: "Laboratory report"}
+	}
+	report.EffectiveDateTime = converter.dateTime(order.CollectedAt)
+	report.Issued = converter.dateTime(order.ReportedAt)
+	report.Conclusion = strings.Join(commentTexts(order.Comments), "\n")
+	return report
+}
+
+// observation maps a result record to an Observation, a numeric value becoming a quantity
+func (converter *converter) observation(result *astm.Result, patientURL string) *Observation {
+	observation := &Observation{
+		ResourceType: "Observation",
+		Status:       observationStatus(result.Status.Component(1)),
+		Category: []CodeableConcept{{Coding: []Coding{
+			{System: observationCategorySystem, Code: "laboratory", Display: "Laboratory"},
+		}}},
+		Code:    converter.code(result.UniversalTestID),
+		Subject: &Reference{Reference: patientURL},
+	}
+	value, units := strings.TrimSpace(result.Value.Component(1)), result.Units.Component(1)
+	if quantity := newQuantity(value, units); quantity != nil {
+		observation.ValueQuantity = quantity
+	} else {
+		observation.ValueString = value
+	}
+	if flag := result.AbnormalFlags.Component(1); flag != "" {
+		observation.Interpretation = []CodeableConcept{{Coding: []Coding{{System: interpretationSystem, Code: flag}}}}
+	}
+	lower, upper := result.ReferenceRange.Component(1), result.ReferenceRange.Component(2)
+	if lower != "" || upper != "" {
+		referenceRange := ReferenceRange{Low: newQuantity(lower, units), High: newQuantity(upper, units)}
+		if referenceRange.Low == nil && referenceRange.High == nil {
+			referenceRange.Text = strings.Trim(lower+" - "+upper, " -")
+		}
+		observation.ReferenceRange = []ReferenceRange{referenceRange}
+	}
+	observation.EffectiveDateTime = converter.dateTime(result.CompletedAt)
+	if operator := result.OperatorID.Component(1); operator != "" {
+		observation.Performer = []Reference{{Display: operator}}
+	}
+	if instrument := result.InstrumentID.Component(1); instrument != "" {
+		observation.Device = &Reference{Display: instrument}
+	}
+	for _, text := range commentTexts(result.Comments) {
+		observation.Note = append(observation.Note, Annotation{Text: text})
+	}
+	return observation
+}
+
+// code gives the concept of a Universal Test ID: the coding MapCode gives, then the test code of the
+// instrument, along with the name of the test, component 5, as its text
+func (converter *converter) code(universalTestID astm.Field) CodeableConcept {
+	code := testCode(universalTestID)
+	concept := CodeableConcept{Text: universalTestID.Component(5)}
+	if code == "" {
+		return concept
+	}
+	if converter.options.MapCode != nil {
+		if coding, ok := converter.options.MapCode(code, universalTestID); ok {
+			concept.Coding = append(concept.Coding, coding)
+		}
+	}
+	concept.Coding = append(concept.Coding, Coding{System: converter.options.LocalSystem, Code: code})
+	return concept
+}
+
+// dateTime formats the timestamp of a field as a dateTime of FHIR, with its time zone offset, or only the
+// date when it holds no time. A timestamp which cannot be parsed is left out.
+func (converter *converter) dateTime(field astm.Field) string {
+	value := strings.TrimSpace(field.Component(1))
+	timestamp, err := astm.ParseTimestampInLocation(value, converter.options.Location)
+	if err != nil || timestamp.IsZero() {
+		return ""
+	}
+	if len(value) <= 8 {
+		return timestamp.Format("2006-01-02")
+	}
+	return timestamp.Format(time.RFC3339)
+}
+
+// testCode gives the test code of a Universal Test ID, its component 4, or its first one when it is the only one set
+func testCode(universalTestID astm.Field) string {
+	if code := universalTestID.Component(4); code != "" {
+		return code
+	}
+	return universalTestID.Component(1)
+}
+
+// newQuantity gives the quantity of a numeric value, nil for a value which is not a number, like a text or
+// a bound like <0.5
+func newQuantity(value string, units string) *Quantity {
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return nil
+	}
+	return &Quantity{Value: json.Number(value), Unit: units}
+}
+
+// observationStatus maps the result status of ASTM to the status of an Observation
+func observationStatus(status string) string {
+	switch status {
+	case "C":
+		return "corrected"
+	case "P", "S":
+		return "preliminary"
+	case "X":
+		return "cancelled"
+	case "I":
+		return "registered"
+	default:
+		return "final"
+	}
+}
+
+// reportStatus gives the status of a report from the ones of its observations: preliminary while one is,
+// corrected when one was, final otherwise
+func reportStatus(statuses []string) string {
+	status := "final"
+	for _, observationStatus := range statuses {
+		switch {
+		case observationStatus == "preliminary" || observationStatus == "registered":
+			return "preliminary"
+		case observationStatus == "corrected":
+			status = "corrected"
+		}
+	}
+	return status
+}
+
+// commentTexts gives the texts of the comments, their repeats joined by spaces
+func commentTexts(comments []*astm.CommentRecord) []string {
+	var texts []string
+	for _, comment := range comments {
+		var parts []string
+		for repeat := 1; repeat <= comment.Text.Repeats(); repeat++ {
+			if text := comment.Text.Repeat(repeat).Component(1); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) > 0 {
+			texts = append(texts, strings.Join(parts, " "))
+		}
+	}
+	return texts
+}
+
+// newUUID gives a random version 4 UUID
+func newUUID() string {
+	var uuid [16]byte
+	_, _ = rand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,115 @@
+package fhir
+
+import "encoding/json"
+
+// Bundle is a FHIR R4 Bundle, a transaction creating the resources it holds when posted to the base URL of a server
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Entry        []BundleEntry `json:"entry"`
+}
+
+// BundleEntry is a resource of a Bundle along with the request creating it, its FullURL is the urn:uuid
+// the other resources of the bundle reference it by
+type BundleEntry struct {
+	FullURL  string         `json:"fullUrl"`
+	Resource any            `json:"resource"`
+	Request  *BundleRequest `json:"request,omitempty"`
+}
+
+// BundleRequest is the request a transaction makes for an entry
+type BundleRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// Patient is a FHIR R4 Patient resource
+type Patient struct {
+	ResourceType string       `json:"resourceType"`
+	Identifier   []Identifier `json:"identifier,omitempty"`
+	Name         []HumanName  `json:"name,omitempty"`
+	Gender       string       `json:"gender,omitempty"`
+	BirthDate    string       `json:"birthDate,omitempty"`
+}
+
+// DiagnosticReport is a FHIR R4 DiagnosticReport resource, the report of an order grouping its observations
+type DiagnosticReport struct {
+	ResourceType      string            `json:"resourceType"`
+	Identifier        []Identifier      `json:"identifier,omitempty"`
+	Status            string            `json:"status"`
+	Category          []CodeableConcept `json:"category,omitempty"`
+	Code              CodeableConcept   `json:"code"`
+	Subject           *Reference        `json:"subject,omitempty"`
+	EffectiveDateTime string            `json:"effectiveDateTime,omitempty"`
+	Issued            string            `json:"issued,omitempty"`
+	Result            []Reference       `json:"result,omitempty"`
+	Conclusion        string            `json:"conclusion,omitempty"`
+}
+
+// Observation is a FHIR R4 Observation resource, the result of a single test
+type Observation struct {
+	ResourceType      string            `json:"resourceType"`
+	Status            string            `json:"status"`
+	Category          []CodeableConcept `json:"category,omitempty"`
+	Code              CodeableConcept   `json:"code"`
+	Subject           *Reference        `json:"subject,omitempty"`
+	EffectiveDateTime string            `json:"effectiveDateTime,omitempty"`
+	Performer         []Reference       `json:"performer,omitempty"`
+	ValueQuantity     *Quantity         `json:"valueQuantity,omitempty"`
+	ValueString       string            `json:"valueString,omitempty"`
+	Interpretation    []CodeableConcept `json:"interpretation,omitempty"`
+	Note              []Annotation      `json:"note,omitempty"`
+	Device            *Reference        `json:"device,omitempty"`
+	ReferenceRange    []ReferenceRange  `json:"referenceRange,omitempty"`
+}
+
+// ReferenceRange is the range of the values of an Observation considered normal
+type ReferenceRange struct {
+	Low  *Quantity `json:"low,omitempty"`
+	High *Quantity `json:"high,omitempty"`
+	Text string    `json:"text,omitempty"`
+}
+
+// CodeableConcept is a concept given by codings from one or more coding systems, or by text
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+// Coding is a code of a coding system, like the LOINC code 2345-7 of glucose
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Quantity is a measured amount, its value kept as written by the instrument so that its precision is too
+type Quantity struct {
+	Value json.Number `json:"value"`
+	Unit  string      `json:"unit,omitempty"`
+}
+
+// Reference references another resource, by the fullUrl of its entry within a bundle, or by its display
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+// Identifier is a business identifier of a resource, like the ID of a patient or of a specimen
+type Identifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value"`
+}
+
+// HumanName is the name of a person
+type HumanName struct {
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+	Suffix []string `json:"suffix,omitempty"`
+}
+
+// Annotation is a note added to a resource
+type Annotation struct {
+	Text string `json:"text"`
+}
@@ -0,0 +1,64 @@
+package hl7
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// escaper writes the encoding characters of a value as the escape sequences of HL7, with the field separator |,
+// the component separator ^, the repetition separator ~, the escape character \ and the subcomponent separator &
+var escaper = strings.NewReplacer(`\`, `\E\`, `|`, `\F\`, `^`, `\S\`, `~`, `\R\`, `&`, `\T\`, "\r", `\X0D\`, "\n", `\X0A\`)
+
+// escape escapes the encoding characters and line breaks of a value
+func escape(value string) string {
+	return escaper.Replace(value)
+}
+
+// unescape decodes the escape sequences of a value written with the encoding characters given, \F\, \S\, \R\,
+// \E\ and \T\ for the encoding characters and \Xhh\ for the bytes given as hexadecimal pairs. The sequences
+// it does not know, like the formatting ones, are kept as they were received.
+func unescape(value string, encoding encodingCharacters) string {
+	if strings.IndexByte(value, encoding.escape) < 0 {
+		return value
+	}
+	var unescaped strings.Builder
+	for index := 0; index < len(value); index++ {
+		if value[index] != encoding.escape {
+			unescaped.WriteByte(value[index])
+			continue
+		}
+		end := strings.IndexByte(value[index+1:], encoding.escape)
+		if end < 0 {
+			unescaped.WriteString(value[index:])
+			break
+		}
+		sequence := value[index+1 : index+1+end]
+		if decoded, ok := decodeEscape(sequence, encoding); ok {
+			unescaped.WriteString(decoded)
+		} else {
+			unescaped.WriteString(value[index : index+end+2])
+		}
+		index += end + 1
+	}
+	return unescaped.String()
+}
+
+// decodeEscape decodes the sequence between two escape characters, telling whether it knows it
+func decodeEscape(sequence string, encoding encodingCharacters) (string, bool) {
+	switch {
+	case sequence == "F":
+		return string(encoding.field), true
+	case sequence == "S":
+		return string(encoding.component), true
+	case sequence == "R":
+		return string(encoding.repeat), true
+	case sequence == "E":
+		return string(encoding.escape), true
+	case sequence == "T":
+		return string(encoding.subcomponent), true
+	case len(sequence) > 1 && sequence[0] == 'X':
+		decoded, err := hex.DecodeString(sequence[1:])
+		return string(decoded), err == nil
+	}
+	return "", false
+}
@@ -0,0 +1,237 @@
+package hl7
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// ErrUnsupportedMessage is returned by ConvertOrder for a message which is neither ORM^O01 nor OML^O21
+var ErrUnsupportedMessage = errors.New("unsupported HL7 message type")
+
+// OrderOptions tunes the conversion of an order, a field left at its zero value keeps its default
+type OrderOptions struct {
+	// Header is the header record of the ASTM message, defaults to one declaring the default delimiters
+	Header *astm.HeaderRecord
+	// TestCodes translates the codes of the tests ordered back to the ones of the instrument, matching the
+	// Code of its TestCode values, the ones missing are kept as they are
+	TestCodes TestCodes
+	// RejectUnmapped fails the conversion with ErrUnmappedTestCode for a code missing from TestCodes
+	RejectUnmapped bool
+}
+
+// orderInProgress is an order read from the segments of a message, its specimen known once the message is read
+type orderInProgress struct {
+	record   *astm.OrderRecord
+	comments []*astm.CommentRecord
+}
+
+// patientInProgress is a patient read from the segments of a message along with its orders
+type patientInProgress struct {
+	record   *astm.PatientRecord
+	comments []*astm.CommentRecord
+	orders   []*orderInProgress
+}
+
+// ConvertOrder converts an HL7 v2 ORM^O01 or OML^O21 order message into the ASTM P and O records
+// downloading it to an instrument, to be sent with SendParsedMessage. Every PID segment gives a patient
+// record, every OBR segment the test of an order record for the specimen of its SPM segment or otherwise
+// its placer order number, the tests ordered one after the other for the same specimen making a single
+// order record with a repeat for each. The NTE segments give the comments of the patient or order they follow.
+// The order control code CA, DC or OC of ORC-1 cancels the order, any other is a new order.
+// It is optionally tuned by options.
+func ConvertOrder(raw string, options ...OrderOptions) (*astm.Message, error) {
+	var orderOptions OrderOptions
+	if len(options) > 0 {
+		orderOptions = options[0]
+	}
+	segments, err := parseSegments(raw)
+	if err != nil {
+		return nil, err
+	}
+	messageType := segments[0].component(9, 1) + "^" + segments[0].component(9, 2)
+	if messageType != "ORM^O01" && messageType != "OML^O21" {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedMessage, messageType)
+	}
+
+	codes := reverseTestCodes(orderOptions.TestCodes)
+	var patients []*patientInProgress
+	var comments *[]*astm.CommentRecord
+	var order *orderInProgress
+	// the order control code and the timing of ORC and TQ1 apply to the OBR segments which follow them
+	actionCode, priority := "N", ""
+	for _, seg := range segments[1:] {
+		switch seg.name {
+		case "PID":
+			patient := &patientInProgress{record: patientRecord(seg)}
+			patients = append(patients, patient)
+			comments, order = &patient.comments, nil
+		case "PV1":
+			if len(patients) > 0 {
+				patients[len(patients)-1].record.Location = newField(seg.component(3, 1))
+			}
+		case "ORC":
+			actionCode, priority = "N", ""
+			if control := seg.component(1, 1); control == "CA" || control == "DC" || control == "OC" {
+				actionCode = "C"
+			}
+		case "OBR":
+			if len(patients) == 0 {
+				// an ORM^O01 message may leave out the patient, the ASTM orders need one
+				patients = append(patients, &patientInProgress{record: &astm.PatientRecord{}})
+			}
+			order, err = orderRecord(seg, codes, orderOptions.RejectUnmapped)
+			if err != nil {
+				return nil, err
+			}
+			order.record.ActionCode = newField(actionCode)
+			if order.record.Priority == nil && priority != "" {
+				order.record.Priority = newField(priority)
+			}
+			patient := patients[len(patients)-1]
+			patient.orders = append(patient.orders, order)
+			comments = &order.comments
+		case "TQ1":
+			priority = seg.component(9, 1)
+		case "SPM":
+			if order != nil {
+				if specimenID := seg.component(2, 1); specimenID != "" {
+					order.record.SpecimenID = newField(specimenID)
+				}
+				order.record.SpecimenDescriptor = newField(seg.component(4, 1))
+			}
+		case "NTE":
+			if comments != nil {
+				*comments = append(*comments, commentRecord(seg))
+			}
+		}
+	}
+	if len(patients) == 0 {
+		return nil, ErrNoPatients
+	}
+
+	builder := astm.NewOrderMessage()
+	if orderOptions.Header != nil {
+		builder.Header(orderOptions.Header)
+	}
+	for _, patient := range patients {
+		builder.Patient(patient.record)
+		for _, comment := range patient.comments {
+			builder.Comment(comment)
+		}
+		for _, merged := range mergeOrders(patient.orders) {
+			builder.Order(merged.record)
+			for _, comment := range merged.comments {
+				builder.Comment(comment)
+			}
+		}
+	}
+	return builder.Build()
+}
+
+// patientRecord maps a PID segment to a patient record: the first identifier of PID-3, the name of PID-5,
+// whose components family, given, middle, suffix and prefix are the last, first, middle, suffix and title
+// of ASTM, the date of birth of PID-7 and the sex of PID-8
+func patientRecord(seg segment) *astm.PatientRecord {
+	birthdate := seg.component(7, 1)
+	if len(birthdate) > 8 {
+		// the time of birth is not kept, the birthdate of ASTM is a date
+		birthdate = birthdate[:8]
+	}
+	return &astm.PatientRecord{
+		PracticePatientID: newField(seg.component(3, 1)),
+		Name: newField(seg.component(5, 1), seg.component(5, 2), seg.component(5, 3),
+			seg.component(5, 4), seg.component(5, 5)),
+		Birthdate: newField(birthdate),
+		Sex:       newField(seg.component(8, 1)),
+	}
+}
+
+// orderRecord maps an OBR segment to an order record: the placer order number of OBR-2 as the specimen,
+// the test of OBR-4, the time of collection of OBR-7, the ordering provider of OBR-16 and the priority
+// of OBR-27, which the TQ1-9 preceding it takes the place of in HL7 2.5
+func orderRecord(seg segment, codes map[string]string, rejectUnmapped bool) (*orderInProgress, error) {
+	universalTestID, err := astmTestID(seg.component(4, 1), seg.component(4, 2), codes, rejectUnmapped)
+	if err != nil {
+		return nil, err
+	}
+	record := &astm.OrderRecord{
+		SpecimenID:        newField(seg.component(2, 1)),
+		UniversalTestID:   universalTestID,
+		CollectedAt:       newField(seg.component(7, 1)),
+		OrderingPhysician: newField(seg.component(16, 1), seg.component(16, 2), seg.component(16, 3)),
+	}
+	if priority := seg.component(27, 6); priority != "" {
+		record.Priority = newField(priority)
+	}
+	return &orderInProgress{record: record}, nil
+}
+
+// commentRecord maps a NTE segment to a comment record, with the source of NTE-2, the laboratory by default,
+// and a repeat of the text for every repeat of NTE-3
+func commentRecord(seg segment) *astm.CommentRecord {
+	source := seg.component(2, 1)
+	if source == "" {
+		source = "L"
+	}
+	var text astm.Field
+	for _, repeat := range seg.repeats(3) {
+		text.AddRepeat(seg.componentOf(repeat, 1))
+	}
+	return &astm.CommentRecord{Source: newField(source), Text: text, Type: newField("G")}
+}
+
+// astmTestID gives the Universal Test ID of a test ordered, the code of the instrument as component 4 and
+// the name of the test as component 5 when the code is not mapped
+func astmTestID(code string, text string, codes map[string]string, rejectUnmapped bool) (astm.Field, error) {
+	if code == "" {
+		return nil, nil
+	}
+	if mapped, ok := codes[code]; ok {
+		return newField("", "", "", mapped), nil
+	}
+	if rejectUnmapped {
+		return nil, fmt.Errorf("%w: %q", ErrUnmappedTestCode, code)
+	}
+	return newField("", "", "", code, text), nil
+}
+
+// reverseTestCodes maps the Code of the TestCode values back to the test codes of the instrument
+func reverseTestCodes(codes TestCodes) map[string]string {
+	reversed := make(map[string]string, len(codes))
+	for instrumentCode, testCode := range codes {
+		reversed[testCode.Code] = instrumentCode
+	}
+	return reversed
+}
+
+// mergeOrders makes a single order of the orders following each other for the same specimen with the same
+// action code, their tests repeats of its Universal Test ID and their comments its comments
+func mergeOrders(orders []*orderInProgress) []*orderInProgress {
+	var merged []*orderInProgress
+	for _, order := range orders {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			specimenID := order.record.SpecimenID.Component(1)
+			if specimenID != "" && specimenID == last.record.SpecimenID.Component(1) &&
+				order.record.ActionCode.Component(1) == last.record.ActionCode.Component(1) {
+				last.record.UniversalTestID = append(last.record.UniversalTestID, order.record.UniversalTestID...)
+				last.comments = append(last.comments, order.comments...)
+				continue
+			}
+		}
+		merged = append(merged, order)
+	}
+	return merged
+}
+
+// newField makes a field of a single repeat out of the components given, leaving out the empty ones at its
+// end, nil when they are all empty
+func newField(components ...string) astm.Field {
+	components = trimTrailing(components)
+	if len(components) == 0 {
+		return nil
+	}
+	return astm.NewField(components...)
+}
@@ -0,0 +1,280 @@
+// Package hl7 converts parsed ASTM messages into HL7 v2 messages, the format the systems downstream of a
+// LIS usually take results in. ConvertORU maps the results an instrument sent to an ORU^R01 message.
+package hl7
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// ErrNoPatients is returned for a message without patient records, an ORU^R01 message reports the results of patients
+var ErrNoPatients = errors.New("message has no patient records")
+
+// ErrUnmappedTestCode is returned for a test code missing from Options.TestCodes when Options.RejectUnmapped is set
+var ErrUnmappedTestCode = errors.New("test code is not mapped")
+
+// defaultVersion is the HL7 version declared in MSH-12 by default
+const defaultVersion = "2.5.1"
+
+// hl7TimestampLayout is the date and time format of HL7, YYYYMMDDHHMMSS, the same as the one of ASTM
+const hl7TimestampLayout = "20060102150405"
+
+// TestCode identifies a test in HL7, as the identifier, text and name of coding system components of a CE or CWE
+type TestCode struct {
+	// Code is the identifier of the test, like the LOINC code 2345-7
+	Code string
+	// Text is the name of the test, like Glucose
+	Text string
+	// CodingSystem names the coding system of Code, like LN for LOINC
+	CodingSystem string
+}
+
+// TestCodes maps the test codes of an instrument, component 4 of the Universal Test ID like GLU in ^^^GLU,
+// to the codes the receiving system knows
+type TestCodes map[string]TestCode
+
+// Options tunes the conversion, a field left at its zero value keeps its default
+type Options struct {
+	// SendingApplication and SendingFacility go in MSH-3 and MSH-4
+	SendingApplication string
+	SendingFacility    string
+	// ReceivingApplication and ReceivingFacility go in MSH-5 and MSH-6
+	ReceivingApplication string
+	ReceivingFacility    string
+	// MessageControlID goes in MSH-10, defaults to the one of the header record or else to the time of the message
+	MessageControlID string
+	// ProcessingID goes in MSH-11, defaults to P for production
+	ProcessingID string
+	// Version goes in MSH-12, defaults to 2.5.1
+	Version string
+	// TestCodes translates the test codes of the orders and results, the ones missing are kept as they are
+	TestCodes TestCodes
+	// RejectUnmapped fails the conversion with ErrUnmappedTestCode for a test code missing from TestCodes
+	RejectUnmapped bool
+	// Now gives the time of the message when the header record has none, defaults to time.Now
+	Now func() time.Time
+}
+
+// optionsWithDefaults takes the first options given, filling in the defaults of the fields left unset
+func optionsWithDefaults(options []Options) Options {
+	merged := Options{}
+	if len(options) > 0 {
+		merged = options[0]
+	}
+	if merged.ProcessingID == "" {
+		merged.ProcessingID = "P"
+	}
+	if merged.Version == "" {
+		merged.Version = defaultVersion
+	}
+	if merged.Now == nil {
+		merged.Now = time.Now
+	}
+	return merged
+}
+
+// ConvertORU converts the patients of a parsed ASTM message, with their orders and results, into an HL7 v2
+// ORU^R01 message, its segments separated by CR. Every patient gives a PID segment, every order an OBR
+// segment and every result an OBX segment, each followed by NTE segments for the comments of the record.
+// The test codes are translated as Options.TestCodes tell.
+// It is optionally tuned by options.
+func ConvertORU(message *astm.Message, options ...Options) (string, error) {
+	if len(message.Patients) == 0 {
+		return "", ErrNoPatients
+	}
+	converter := &oruConverter{options: optionsWithDefaults(options)}
+	converter.header(message.Header)
+	for index, patient := range message.Patients {
+		if err := converter.patient(index+1, patient); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(converter.segments, "\r") + "\r", nil
+}
+
+// oruConverter collects the segments of an ORU^R01 message
+type oruConverter struct {
+	options  Options
+	segments []string
+	// orders counts the OBR segments over all the patients, as the set IDs of OBR run through the message
+	orders int
+}
+
+// header adds the MSH segment, taking the time and the control ID of the message from the header record
+func (converter *oruConverter) header(header *astm.HeaderRecord) {
+	timestamp, controlID := "", converter.options.MessageControlID
+	if header != nil {
+		timestamp = header.Timestamp.Component(1)
+		if controlID == "" {
+			controlID = header.MessageControlID.Component(1)
+		}
+	}
+	if timestamp == "" {
+		timestamp = converter.options.Now().Format(hl7TimestampLayout)
+	}
+	if controlID == "" {
+		controlID = timestamp
+	}
+	// MSH-1 is the field separator itself and MSH-2 the encoding characters, which are not escaped
+	fields := []string{
+		escape(converter.options.SendingApplication), escape(converter.options.SendingFacility),
+		escape(converter.options.ReceivingApplication), escape(converter.options.ReceivingFacility),
+		escape(timestamp), "", "ORU^R01^ORU_R01", escape(controlID),
+		escape(converter.options.ProcessingID), escape(converter.options.Version),
+	}
+	converter.segments = append(converter.segments, "MSH|^~\\&|"+strings.Join(trimTrailing(fields), "|"))
+}
+
+// patient adds the PID segment of a patient followed by its comments and orders
+func (converter *oruConverter) patient(setID int, patient *astm.Patient) error {
+	patientID := patient.PracticePatientID.Component(1)
+	if patientID == "" {
+		patientID = patient.LaboratoryPatientID.Component(1)
+	}
+	converter.add("PID",
+		strconv.Itoa(setID),
+		"",
+		escape(patientID),
+		"",
+		// the name components of ASTM are last, first, middle, suffix and title, the ones of HL7 family,
+		// given, middle, suffix and prefix
+		components(patient.Name.Component(1), patient.Name.Component(2), patient.Name.Component(3),
+			patient.Name.Component(4), patient.Name.Component(5)),
+		"",
+		escape(patient.Birthdate.Component(1)),
+		escape(patient.Sex.Component(1)),
+	)
+	converter.comments(patient.Comments)
+	for _, order := range patient.Orders {
+		if err := converter.order(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// order adds the OBR segment of an order followed by its comments and results
+func (converter *oruConverter) order(order *astm.Order) error {
+	converter.orders++
+	service, err := converter.testCode(order.UniversalTestID)
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 22)
+	fields[0] = strconv.Itoa(converter.orders)
+	fields[1] = escape(order.SpecimenID.Component(1))
+	fields[2] = escape(order.InstrumentSpecimenID.Component(1))
+	fields[3] = service
+	fields[6] = escape(order.CollectedAt.Component(1))
+	fields[21] = escape(order.ReportedAt.Component(1))
+	converter.add("OBR", fields...)
+	converter.comments(order.Comments)
+	for index, result := range order.Results {
+		if err := converter.result(index+1, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// result adds the OBX segment of a result followed by its comments
+func (converter *oruConverter) result(setID int, result *astm.Result) error {
+	observation, err := converter.testCode(result.UniversalTestID)
+	if err != nil {
+		return err
+	}
+	value := result.Value.Component(1)
+	valueType := "ST"
+	if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+		valueType = "NM"
+	}
+	status := result.Status.Component(1)
+	if status == "" {
+		status = "F"
+	}
+	fields := make([]string, 18)
+	fields[0] = strconv.Itoa(setID)
+	fields[1] = valueType
+	fields[2] = observation
+	fields[4] = escape(value)
+	fields[5] = escape(result.Units.Component(1))
+	fields[6] = escape(referenceRange(result.ReferenceRange))
+	fields[7] = escape(result.AbnormalFlags.Component(1))
+	fields[10] = escape(status)
+	fields[13] = escape(result.CompletedAt.Component(1))
+	fields[15] = escape(result.OperatorID.Component(1))
+	fields[17] = escape(result.InstrumentID.Component(1))
+	converter.add("OBX", fields...)
+	converter.comments(result.Comments)
+	return nil
+}
+
+// comments adds a NTE segment for every comment, numbered from 1 under the segment they follow
+func (converter *oruConverter) comments(comments []*astm.CommentRecord) {
+	for index, comment := range comments {
+		var text []string
+		for repeat := 1; repeat <= comment.Text.Repeats(); repeat++ {
+			if value := comment.Text.Repeat(repeat).Component(1); value != "" {
+				text = append(text, escape(value))
+			}
+		}
+		converter.add("NTE", strconv.Itoa(index+1), escape(comment.Source.Component(1)), strings.Join(text, "~"))
+	}
+}
+
+// testCode gives the coded element of the test code of a Universal Test ID, translated by Options.TestCodes.
+// A test code missing from them is kept along with its name, component 5, unless they have to be mapped.
+func (converter *oruConverter) testCode(universalTestID astm.Field) (string, error) {
+	code := universalTestID.Component(4)
+	if code == "" {
+		code = universalTestID.Component(1)
+	}
+	if code == "" {
+		return "", nil
+	}
+	mapped, ok := converter.options.TestCodes[code]
+	if !ok {
+		if converter.options.RejectUnmapped {
+			return "", fmt.Errorf("%w: %q", ErrUnmappedTestCode, code)
+		}
+		mapped = TestCode{Code: code, Text: universalTestID.Component(5)}
+	}
+	return components(mapped.Code, mapped.Text, mapped.CodingSystem), nil
+}
+
+// add adds a segment made of the fields given, which are already escaped, leaving out the empty fields at its end
+func (converter *oruConverter) add(name string, fields ...string) {
+	converter.segments = append(converter.segments, strings.Join(append([]string{name}, trimTrailing(fields)...), "|"))
+}
+
+// referenceRange writes the reference range of a result the HL7 way, like 3.9-6.1, as instruments often send
+// the lower and upper limit as components
+func referenceRange(field astm.Field) string {
+	lower, upper := field.Component(1), field.Component(2)
+	if upper == "" {
+		return lower
+	}
+	return lower + "-" + upper
+}
+
+// components escapes the values given and joins them into a composite, leaving out the empty ones at its end
+func components(values ...string) string {
+	escaped := make([]string, len(values))
+	for index, value := range values {
+		escaped[index] = escape(value)
+	}
+	return strings.Join(trimTrailing(escaped), "^")
+}
+
+// trimTrailing drops the empty values at the end
+func trimTrailing(values []string) []string {
+	for len(values) > 0 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
+	}
+	return values
+}
@@ -0,0 +1,93 @@
+package hl7
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMalformedMessage is returned for a message which does not start with a valid MSH segment
+var ErrMalformedMessage = errors.New("malformed HL7 message")
+
+// encodingCharacters are the separators and the escape character a message declares in MSH-1 and MSH-2
+type encodingCharacters struct {
+	field        byte
+	component    byte
+	repeat       byte
+	escape       byte
+	subcomponent byte
+}
+
+// segment is a segment of a message, its fields still escaped
+type segment struct {
+	name     string
+	fields   []string
+	encoding encodingCharacters
+}
+
+// parseSegments splits a message into its segments, separated by CR, LF or both, with the encoding
+// characters declared by its MSH segment
+func parseSegments(raw string) ([]segment, error) {
+	lines := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "MSH") || len(lines[0]) < 4 {
+		return nil, fmt.Errorf("%w: message does not start with a MSH segment", ErrMalformedMessage)
+	}
+	fieldSeparator := lines[0][3]
+	// the fifth encoding character of HL7 2.7, the truncation character, is not used
+	declared, _, _ := strings.Cut(lines[0][4:], string(fieldSeparator))
+	if len(declared) < 4 {
+		return nil, fmt.Errorf("%w: MSH-2 declares %q as encoding characters", ErrMalformedMessage, declared)
+	}
+	encoding := encodingCharacters{field: fieldSeparator, component: declared[0], repeat: declared[1], escape: declared[2], subcomponent: declared[3]}
+	segments := make([]segment, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Split(line, string(encoding.field))
+		if fields[0] == "MSH" {
+			// MSH-1 is the field separator itself, so the fields of MSH are numbered one further
+			fields = append([]string{"MSH", string(encoding.field)}, fields[1:]...)
+		}
+		segments = append(segments, segment{name: fields[0], fields: fields, encoding: encoding})
+	}
+	return segments, nil
+}
+
+// field gives the field with the given number, the whole of it with its repeats and components still escaped
+func (seg segment) field(number int) string {
+	if number < 1 || number >= len(seg.fields) {
+		return ""
+	}
+	return seg.fields[number]
+}
+
+// repeats gives the repeats of the field with the given number, still escaped
+func (seg segment) repeats(number int) []string {
+	value := seg.field(number)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, string(seg.encoding.repeat))
+}
+
+// component gives the component with the given number of the first repeat of a field, unescaped
+func (seg segment) component(number int, component int) string {
+	repeats := seg.repeats(number)
+	if len(repeats) == 0 {
+		return ""
+	}
+	return seg.componentOf(repeats[0], component)
+}
+
+// componentOf gives the component with the given number of a repeat, unescaped, without its subcomponents
+func (seg segment) componentOf(repeat string, component int) string {
+	components := strings.Split(repeat, string(seg.encoding.component))
+	if component < 1 || component > len(components) {
+		return ""
+	}
+	value := components[component-1]
+	if index := strings.IndexByte(value, seg.encoding.subcomponent); index >= 0 {
+		value = value[:index]
+	}
+	return unescape(value, seg.encoding)
+}
@@ -0,0 +1,137 @@
+package lis1a2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// defaultDuplicateWindow is how long the fingerprint of a message is remembered by default
+const defaultDuplicateWindow = time.Hour
+
+// DuplicatePolicy tells what happens to a message received again, see DuplicateOptions
+type DuplicatePolicy int
+
+const (
+	// SuppressDuplicates drops the duplicates, they are not handed over to OnMessage or ReadMessage
+	SuppressDuplicates DuplicatePolicy = iota
+	// FlagDuplicates hands the duplicates over with ReceivedMessage.Duplicate set
+	FlagDuplicates
+)
+
+// DuplicateOptions tunes the detection of the messages an analyzer sends again after a link failure,
+// a field left at its zero value keeps its default
+type DuplicateOptions struct {
+	// Window is how long a message is remembered, a message with the same fingerprint received within it is
+	// a duplicate, defaults to 1 hour
+	Window time.Duration
+	// Policy tells what happens to the duplicates, SuppressDuplicates by default
+	Policy DuplicatePolicy
+	// Fingerprint gives the fingerprint of a message, MessageFingerprint by default. A message with an empty
+	// fingerprint is never a duplicate.
+	Fingerprint func(message *astm.Message) string
+}
+
+// duplicateOptionsWithDefaults fills in the defaults of the fields left unset
+func duplicateOptionsWithDefaults(options DuplicateOptions) DuplicateOptions {
+	if options.Window <= 0 {
+		options.Window = defaultDuplicateWindow
+	}
+	if options.Fingerprint == nil {
+		options.Fingerprint = MessageFingerprint
+	}
+	return options
+}
+
+// duplicateDetector remembers the fingerprints of the messages received within the window
+type duplicateDetector struct {
+	options DuplicateOptions
+	mutex   sync.Mutex
+	// seen holds when every fingerprint remembered was first received
+	seen map[string]time.Time
+}
+
+// SetDuplicateOptions makes the connection fingerprint every message received, setting ReceivedMessage.Fingerprint,
+// and treat one received again within the window as the policy tells, calling the OnDuplicate hook.
+// The messages are not fingerprinted by default. It has to be called before Listen.
+func (astmConn *ASTMConnection) SetDuplicateOptions(options DuplicateOptions) {
+	astmConn.duplicates = &duplicateDetector{options: duplicateOptionsWithDefaults(options), seen: make(map[string]time.Time)}
+}
+
+// check gives the fingerprint of the message and tells whether it was received within the window already
+func (detector *duplicateDetector) check(message string) (string, bool) {
+	parsed, err := astm.ParseMessage(message, astm.ParseOptions{Strictness: astm.Off})
+	if err != nil {
+		return "", false
+	}
+	fingerprint := detector.options.Fingerprint(parsed)
+	if fingerprint == "" {
+		return "", false
+	}
+	now := time.Now()
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	for seen, receivedAt := range detector.seen {
+		if now.Sub(receivedAt) >= detector.options.Window {
+			delete(detector.seen, seen)
+		}
+	}
+	if _, ok := detector.seen[fingerprint]; ok {
+		return fingerprint, true
+	}
+	detector.seen[fingerprint] = now
+	return fingerprint, false
+}
+
+// isDuplicate fingerprints a message received, telling whether it is to be dropped as a duplicate
+func (astmConn *ASTMConnection) isDuplicate(received *ReceivedMessage) bool {
+	if astmConn.duplicates == nil {
+		return false
+	}
+	received.Fingerprint, received.Duplicate = astmConn.duplicates.check(received.Text)
+	if !received.Duplicate {
+		return false
+	}
+	slog.Warn("Received a message again.", "Fingerprint", received.Fingerprint)
+	if astmConn.hooks.onDuplicate != nil {
+		astmConn.hooks.onDuplicate(*received)
+	}
+	return astmConn.duplicates.options.Policy == SuppressDuplicates
+}
+
+// MessageFingerprint gives the SHA-256, in hex, of the instrument ID of the header and, for every result, the
+// specimen ID of its order, its test and when it was completed, so that a message sent again fingerprints
+// the same, whatever its header timestamp. A message without results has an empty fingerprint.
+func MessageFingerprint(message *astm.Message) string {
+	var instrument, specimenID string
+	var results []string
+	for _, record := range message.Records {
+		switch typed := record.(type) {
+		case *astm.HeaderRecord:
+			instrument = typed.SenderName.Component(1)
+		case *astm.OrderRecord:
+			specimenID = typed.SpecimenID.Component(1)
+		case *astm.ResultRecord:
+			results = append(results, strings.Join([]string{specimenID, fieldText(typed.UniversalTestID), fieldText(typed.CompletedAt)}, "|"))
+		}
+	}
+	if len(results) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(instrument + "\n" + strings.Join(results, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// fieldText joins the components and repeats of a field with the default delimiters
+func fieldText(field astm.Field) string {
+	repeats := make([]string, 0, len(field))
+	for _, repeat := range field {
+		repeats = append(repeats, strings.Join(repeat, "^"))
+	}
+	return strings.Join(repeats, "\\")
+}
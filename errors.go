@@ -0,0 +1,26 @@
+package lis1a2
+
+import (
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+)
+
+// The errors returned by this package and the ones below it are wrapped around sentinel errors, so that
+// callers tell them apart with errors.Is to retry or alert accordingly instead of matching their text:
+//
+//   - the transport: ErrNotConnected, connection.ErrConnectionClosed, connection.ErrPeerReset,
+//     connection.ErrReadIdleTimeout, connection.ErrWriteTimeout and connection.ErrShutdown
+//   - sending: ErrBusy, ErrEstablishmentTimeout, ErrReplyTimeout, ErrReceiverBusy, ErrTransmissionAborted
+//     and ErrInterrupted
+//   - receiving: ErrChecksumMismatch, ErrFrameSequence, ErrIncompleteRecord and ErrReceiveTimeout
+//   - parsing: ErrMalformedRecord, matched by every *astm.ParseError
+//
+// The ones of the other packages are available here as well.
+var (
+	// ErrNotConnected is returned when the connection is or gets disconnected, see connection.ErrNotConnected
+	ErrNotConnected = connection.ErrNotConnected
+	// ErrChecksumMismatch comes with a frame read whose checksum does not match, see connection.ErrChecksumMismatch
+	ErrChecksumMismatch = connection.ErrChecksumMismatch
+	// ErrMalformedRecord is matched by the errors of messages which could not be parsed, see astm.ErrMalformedRecord
+	ErrMalformedRecord = astm.ErrMalformedRecord
+)
@@ -0,0 +1,110 @@
+package lis1a2
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventBufferSize is the number of events the channel of Events holds before dropping them
+const eventBufferSize = 256
+
+// ErrFrameNAKed is the error of EventFrameRejected for a frame sent the receiver NAKed
+var ErrFrameNAKed = errors.New("frame NAKed by the receiver")
+
+// EventKind tells what happened on the link, see Event
+type EventKind int
+
+const (
+	// EventConnected is sent once Connect connected
+	EventConnected EventKind = iota
+	// EventDisconnected is sent once the connection is lost or disconnected, once per connection
+	EventDisconnected
+	// EventFrameReceived is sent with every frame received whose checksum matches
+	EventFrameReceived
+	// EventFrameRejected is sent with every frame received incomplete, malformed or with a checksum mismatch,
+	// and with every frame sent the receiver NAKed
+	EventFrameRejected
+	// EventMessageCompleted is sent with every message received in full or failed, and with every message sent
+	// once it was terminated
+	EventMessageCompleted
+	// EventTimerExpired is sent when the peer did not answer in time: the receive timeout while receiving,
+	// ErrReceiveTimeout, or the reply timeouts while sending, ErrReplyTimeout
+	EventTimerExpired
+)
+
+// String gives the name of the kind, like connected or frame_rejected
+func (kind EventKind) String() string {
+	switch kind {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventFrameReceived:
+		return "frame_received"
+	case EventFrameRejected:
+		return "frame_rejected"
+	case EventMessageCompleted:
+		return "message_completed"
+	case EventTimerExpired:
+		return "timer_expired"
+	}
+	return "unknown"
+}
+
+// Event is something which happened on the link of an ASTMConnection, see Events
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Direction tells whether the frame or the message of the event was sent or received
+	Direction ExchangeDirection
+	// Data is the frame of the frame events, as it went over the line, or the message of EventMessageCompleted,
+	// one record per line
+	Data string
+	// Err is the reason of EventDisconnected, nil after Disconnect, why the frame of EventFrameRejected was
+	// rejected, the error the message of EventMessageCompleted failed with, if it did, and the timeout of
+	// EventTimerExpired
+	Err error
+}
+
+// eventBus holds the channel of Events, created on the first call
+type eventBus struct {
+	mutex   sync.Mutex
+	channel chan Event
+	dropped atomic.Int64
+}
+
+// Events gives the channel the events of the link are sent on, the same one on every call, so that supervisory
+// applications observe every transport the same way. Nothing is sent before the first call. The protocol never
+// waits for the channel to be drained: an event which does not fit in its buffer of 256 events is dropped and
+// counted by EventsDropped. The channel is never closed, it carries the events of the connections made again.
+func (astmConn *ASTMConnection) Events() <-chan Event {
+	astmConn.events.mutex.Lock()
+	defer astmConn.events.mutex.Unlock()
+	if astmConn.events.channel == nil {
+		astmConn.events.channel = make(chan Event, eventBufferSize)
+	}
+	return astmConn.events.channel
+}
+
+// EventsDropped counts the events which did not fit in the channel of Events
+func (astmConn *ASTMConnection) EventsDropped() int64 {
+	return astmConn.events.dropped.Load()
+}
+
+// emit sends an event on the channel of Events, if it was asked for, without waiting
+func (astmConn *ASTMConnection) emit(event Event) {
+	astmConn.events.mutex.Lock()
+	channel := astmConn.events.channel
+	astmConn.events.mutex.Unlock()
+	if channel == nil {
+		return
+	}
+	event.Time = time.Now()
+	select {
+	case channel <- event:
+	default:
+		astmConn.events.dropped.Add(1)
+	}
+}
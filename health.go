@@ -0,0 +1,134 @@
+package lis1a2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+)
+
+// HealthOptions tunes HealthCheck, the idle watchdog and the keep-alive, a field left at its zero value keeps its default
+type HealthOptions struct {
+	// Probe makes HealthCheck send ENQ and EOT over an idle line, so that an instrument which stopped answering
+	// is noticed while the socket still looks open. Only the connection is checked by default.
+	Probe bool
+	// ProbeTimeout is how long the probe waits for the reply to ENQ, defaults to 15 seconds like establishing
+	ProbeTimeout time.Duration
+	// IdleAfter makes the watchdog flag the link with the OnIdle hook once nothing was sent or received over it
+	// for that long, zero disables the watchdog
+	IdleAfter time.Duration
+	// KeepAlive probes the line with ENQ and EOT, like HealthCheck does, once nothing was sent or received over
+	// it for that long, so that a site knows the analyzer is reachable during idle periods. A probe which fails
+	// runs the OnKeepAliveFailed hook. The probes count as traffic for IdleAfter. Zero sends no probe.
+	KeepAlive time.Duration
+}
+
+// healthOptionsWithDefaults fills in the defaults of the fields left unset
+func healthOptionsWithDefaults(options HealthOptions) HealthOptions {
+	if options.ProbeTimeout <= 0 {
+		options.ProbeTimeout = establishmentTimeout
+	}
+	return options
+}
+
+// SetHealthOptions tunes HealthCheck, the idle watchdog and the keep-alive. It has to be called before Listen.
+func (astmConn *ASTMConnection) SetHealthOptions(options HealthOptions) {
+	astmConn.health = healthOptionsWithDefaults(options)
+}
+
+// HealthCheck tells whether the link is alive. It returns connection.ErrNotConnected wrapped once the connection
+// was lost and, with HealthOptions.Probe, sends ENQ over an idle line and ends with EOT, returning
+// ErrEstablishmentTimeout wrapped when the instrument does not answer. An ACK, a NAK and an ENQ of the instrument
+// all prove it alive, and so does a message being sent or received, the probe is then skipped.
+// Once ctx is done the probe stops waiting and ctx.Err() is returned wrapped.
+func (astmConn *ASTMConnection) HealthCheck(ctx context.Context) error {
+	if !astmConn.IsConnected() || astmConn.internalCtx.Err() != nil {
+		return fmt.Errorf("health check failed: %w", connection.ErrNotConnected)
+	}
+	if !astmConn.health.Probe {
+		return nil
+	}
+	if err := astmConn.probe(ctx); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	return nil
+}
+
+// probe sends ENQ and EOT over the line unless it is in use, a message being sent or received
+func (astmConn *ASTMConnection) probe(ctx context.Context) error {
+	if astmConn.shuttingDown.Load() || !astmConn.sendMutex.TryLock() {
+		return nil
+	}
+	defer astmConn.sendMutex.Unlock()
+	if err := astmConn.sender.probe(ctx, astmConn.health.ProbeTimeout); err != nil && !errors.Is(err, ErrBusy) {
+		return err
+	}
+	return nil
+}
+
+// quietFor gives when something last went over the link, not before since, in Unix nanoseconds, and how long ago
+func (astmConn *ASTMConnection) quietFor(since int64) (int64, time.Duration) {
+	last := max(since, astmConn.metrics.lastActivity.Load())
+	return last, time.Since(time.Unix(0, last))
+}
+
+// watchIdle runs the OnIdle hook once nothing went over the link for IdleAfter, once per quiet period,
+// until ctx is done
+func (astmConn *ASTMConnection) watchIdle(ctx context.Context) {
+	idleAfter := astmConn.health.IdleAfter
+	listening := time.Now().UnixNano()
+	// flagged is the last activity of the quiet period flagged last
+	flagged := int64(0)
+	timer := time.NewTimer(idleAfter)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		last, idleFor := astmConn.quietFor(listening)
+		if idleFor < idleAfter {
+			timer.Reset(idleAfter - idleFor)
+			continue
+		}
+		if last != flagged {
+			flagged = last
+			slog.Warn("No traffic over the link.", "Idle for", idleFor)
+			if astmConn.hooks.onIdle != nil {
+				astmConn.hooks.onIdle(idleFor)
+			}
+		}
+		timer.Reset(idleAfter)
+	}
+}
+
+// keepAlive probes the line once nothing went over it for KeepAlive, until ctx is done
+func (astmConn *ASTMConnection) keepAlive(ctx context.Context) {
+	interval := astmConn.health.KeepAlive
+	listening := time.Now().UnixNano()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if _, idleFor := astmConn.quietFor(listening); idleFor < interval {
+			timer.Reset(interval - idleFor)
+			continue
+		}
+		slog.Debug("Probing the idle line.")
+		if err := astmConn.probe(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Keep-alive probe failed.", "Error", err)
+			if astmConn.hooks.onKeepAliveFailed != nil {
+				astmConn.hooks.onKeepAliveFailed(err)
+			}
+		}
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,218 @@
+package lis1a2
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// hooks are the observability callbacks registered on an ASTMConnection.
+// They run on the goroutine driving the protocol, so they should return quickly and not block,
+// a slow hook delays reading and the peer might time out.
+type hooks struct {
+	onFrameReceived   func(raw string)
+	onFrameSent       func(raw string)
+	onControlByte     func(b byte)
+	onStateChange     func(old constants.LIS1A2ConnectionStatus, new constants.LIS1A2ConnectionStatus)
+	onPeerBusy        func()
+	onFrameError      func(raw string, err error)
+	onConnected       func()
+	onDisconnected    func(err error)
+	onMessageReceived func(message string)
+	onSendComplete    func(err error)
+	onProtocolError   func(err error)
+	onFrameNAKed      func(raw string, attempt int)
+	onIdle            func(idleFor time.Duration)
+	onKeepAliveFailed func(err error)
+	onDuplicate       func(message ReceivedMessage)
+	// disconnectNotified makes onDisconnected run once per connection
+	disconnectNotified atomic.Bool
+}
+
+// OnFrameReceived registers a hook called with every frame received, before it is checked.
+// It has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnFrameReceived(hook func(raw string)) {
+	astmConn.hooks.onFrameReceived = hook
+}
+
+// OnFrameSent registers a hook called with every frame written, retransmissions included.
+// It has to be registered before sending and must not block.
+func (astmConn *ASTMConnection) OnFrameSent(hook func(raw string)) {
+	astmConn.hooks.onFrameSent = hook
+}
+
+// OnControlByte registers a hook called with every control byte received, like ENQ, ACK, NAK or EOT.
+// It has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnControlByte(hook func(b byte)) {
+	astmConn.hooks.onControlByte = hook
+}
+
+// OnStateChange registers a hook called whenever the connection changes state.
+// It has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnStateChange(hook func(old constants.LIS1A2ConnectionStatus, new constants.LIS1A2ConnectionStatus)) {
+	astmConn.hooks.onStateChange = hook
+}
+
+// OnPeerBusy registers a hook called whenever the receiver answers ENQ with NAK because it is busy,
+// the message is sent again after SenderOptions.BusyBackoff. It has to be registered before sending and must not block.
+func (astmConn *ASTMConnection) OnPeerBusy(hook func()) {
+	astmConn.hooks.onPeerBusy = hook
+}
+
+// OnFrameError registers a hook called with the bytes of every frame received incomplete, malformed or with
+// a checksum mismatch, along with connection.ErrIncompleteFrame, connection.ErrMalformedFrame or
+// connection.ErrChecksumMismatch. The frame is NAKed or left unanswered all the same, so that the sender sends it again.
+// It has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnFrameError(hook func(raw string, err error)) {
+	astmConn.hooks.onFrameError = hook
+}
+
+// OnConnected registers a hook called once Connect connected.
+// It has to be registered before Connect and must not block.
+func (astmConn *ASTMConnection) OnConnected(hook func()) {
+	astmConn.hooks.onConnected = hook
+}
+
+// OnDisconnected registers a hook called once the connection is lost, with the error which made Listen stop
+// reading, or with nil once Disconnect disconnected. It runs once per connection and must not block.
+func (astmConn *ASTMConnection) OnDisconnected(hook func(err error)) {
+	astmConn.hooks.onDisconnected = hook
+}
+
+// OnMessageReceived registers a hook called with every message received, one record per line, before it is
+// handed over to OnMessage, ReadMessage or OnQuery. It has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnMessageReceived(hook func(message string)) {
+	astmConn.hooks.onMessageReceived = hook
+}
+
+// OnSendComplete registers a hook called once a message sent with SendMessage was terminated,
+// with the error SendMessage returns. It has to be registered before sending and must not block.
+func (astmConn *ASTMConnection) OnSendComplete(hook func(err error)) {
+	astmConn.hooks.onSendComplete = hook
+}
+
+// OnProtocolError registers a hook called with the error which made a message received fail, like a frame
+// number out of sequence, ErrReceiveTimeout or ErrIncompleteRecord. It has to be registered before Listen
+// and must not block.
+func (astmConn *ASTMConnection) OnProtocolError(hook func(err error)) {
+	astmConn.hooks.onProtocolError = hook
+}
+
+// OnFrameNAKed registers a hook called with every frame sent the receiver answered with NAK, along with the
+// attempt it was, the frame is sent again until SenderOptions.MaxAttempts is reached.
+// It has to be registered before sending and must not block.
+func (astmConn *ASTMConnection) OnFrameNAKed(hook func(raw string, attempt int)) {
+	astmConn.hooks.onFrameNAKed = hook
+}
+
+// OnIdle registers a hook called once nothing was sent or received for HealthOptions.IdleAfter, with how long
+// the link has been quiet, and again after the next quiet period, so that monitoring pages before the lab
+// notices missing results. It runs on the go routine of the watchdog Listen starts, it has to be registered
+// before Listen and must not block.
+func (astmConn *ASTMConnection) OnIdle(hook func(idleFor time.Duration)) {
+	astmConn.hooks.onIdle = hook
+}
+
+// OnKeepAliveFailed registers a hook called with the error of every keep-alive probe which failed, like
+// ErrEstablishmentTimeout wrapped when the analyzer did not answer ENQ, see HealthOptions.KeepAlive.
+// It runs on the go routine sending the probes Listen starts, it has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnKeepAliveFailed(hook func(err error)) {
+	astmConn.hooks.onKeepAliveFailed = hook
+}
+
+// OnDuplicate registers a hook called with every message received again within the window of DuplicateOptions,
+// whether it is suppressed or flagged. It has to be registered before Listen and must not block.
+func (astmConn *ASTMConnection) OnDuplicate(hook func(message ReceivedMessage)) {
+	astmConn.hooks.onDuplicate = hook
+}
+
+// connectionMade runs the hook for a connection made
+func (astmConn *ASTMConnection) connectionMade() {
+	astmConn.hooks.disconnectNotified.Store(false)
+	astmConn.emit(Event{Kind: EventConnected})
+	if astmConn.hooks.onConnected != nil {
+		astmConn.hooks.onConnected()
+	}
+}
+
+// connectionLost runs the hook for a connection lost, once per connection
+func (astmConn *ASTMConnection) connectionLost(err error) {
+	if !astmConn.hooks.disconnectNotified.CompareAndSwap(false, true) {
+		return
+	}
+	astmConn.emit(Event{Kind: EventDisconnected, Err: err})
+	if astmConn.hooks.onDisconnected != nil {
+		astmConn.hooks.onDisconnected(err)
+	}
+}
+
+// frameNAKed runs the hook for a frame sent the receiver NAKed
+func (astmConn *ASTMConnection) frameNAKed(raw string, attempt int) {
+	astmConn.metrics.naksReceived.Add(1)
+	astmConn.emit(Event{Kind: EventFrameRejected, Direction: ExchangeSent, Data: raw, Err: ErrFrameNAKed})
+	if astmConn.hooks.onFrameNAKed != nil {
+		astmConn.hooks.onFrameNAKed(raw, attempt)
+	}
+}
+
+// frameError runs the hook for a frame which could not be read
+func (astmConn *ASTMConnection) frameError(raw string, err error) {
+	astmConn.emit(Event{Kind: EventFrameRejected, Direction: ExchangeReceived, Data: raw, Err: err})
+	if astmConn.hooks.onFrameError != nil {
+		astmConn.hooks.onFrameError(raw, err)
+	}
+}
+
+// dataReceived runs the hooks for data read from the connection
+func (astmConn *ASTMConnection) dataReceived(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if data[0] == constants.STX {
+		astmConn.journalAppend(ExchangeReceived, JournalFrame, string(data))
+		if astmConn.hooks.onFrameReceived != nil {
+			astmConn.hooks.onFrameReceived(string(data))
+		}
+	} else if len(data) == 1 && astmConn.hooks.onControlByte != nil {
+		astmConn.hooks.onControlByte(data[0])
+	}
+}
+
+// setStatus changes the state of the connection and runs the state change hook
+func (astmConn *ASTMConnection) setStatus(status constants.LIS1A2ConnectionStatus) {
+	astmConn.statusMutex.Lock()
+	old := astmConn.status
+	astmConn.status = status
+	if old != status || status == constants.Idle {
+		astmConn.progress = ProtocolState{Phase: phaseOf(status)}
+	}
+	astmConn.statusMutex.Unlock()
+	astmConn.statusChanged(old, status)
+}
+
+// compareAndSetStatus changes the state of the connection if it is still old and runs the state change hook
+func (astmConn *ASTMConnection) compareAndSetStatus(old constants.LIS1A2ConnectionStatus, status constants.LIS1A2ConnectionStatus) bool {
+	astmConn.statusMutex.Lock()
+	if astmConn.status != old {
+		astmConn.statusMutex.Unlock()
+		return false
+	}
+	astmConn.status = status
+	if old != status {
+		astmConn.progress = ProtocolState{Phase: phaseOf(status)}
+	}
+	astmConn.statusMutex.Unlock()
+	astmConn.statusChanged(old, status)
+	return true
+}
+
+// statusChanged times the establishment phase, traces the reception of messages and runs the state
+// change hook once the state changed
+func (astmConn *ASTMConnection) statusChanged(old constants.LIS1A2ConnectionStatus, status constants.LIS1A2ConnectionStatus) {
+	astmConn.metrics.statusChanged(old, status)
+	astmConn.traceReception(old, status)
+	if old != status && astmConn.hooks.onStateChange != nil {
+		astmConn.hooks.onStateChange(old, status)
+	}
+}
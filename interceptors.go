@@ -0,0 +1,79 @@
+package lis1a2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// errFrameIntercepted wraps the error of an interceptor dropping a frame
+var errFrameIntercepted = errors.New("frame dropped by an interceptor")
+
+// Frame is a whole frame going over the link, from STX to LF, as handed to a FrameInterceptor
+type Frame struct {
+	Raw []byte
+}
+
+// Decode decodes the frame, see protocol.DecodeFrame
+func (frame Frame) Decode() (protocol.Frame, error) {
+	return protocol.DecodeFrame(frame.Raw)
+}
+
+// FrameInterceptor observes or rewrites a frame, returning the frame to carry on with. An error drops the
+// frame: an inbound one is left unanswered, so that the sender sends it again, an outbound one fails the
+// transfer with the error.
+type FrameInterceptor func(frame Frame) (Frame, error)
+
+// UseInbound adds an interceptor running on every frame received, before the receiver checks it, corrupt
+// ones included, so that a quirk of the instrument can be fixed before its frames are NAKed. The interceptors
+// run in the order they were added, on the go routine running Listen, and the hooks and the journal see the
+// frames they give back. It has to be called before Listen.
+func (astmConn *ASTMConnection) UseInbound(interceptor FrameInterceptor) {
+	astmConn.inbound = append(astmConn.inbound, interceptor)
+}
+
+// UseOutbound adds an interceptor running on every frame about to be written, retransmissions included.
+// The interceptors run in the order they were added, after the hooks and the journal saw the frame the
+// way the sender built it. It has to be called before sending.
+func (astmConn *ASTMConnection) UseOutbound(interceptor FrameInterceptor) {
+	astmConn.outbound = append(astmConn.outbound, interceptor)
+}
+
+// intercept runs the interceptors on data if it is a frame, giving back what they made of it
+func intercept(interceptors []FrameInterceptor, data []byte) ([]byte, error) {
+	if len(interceptors) == 0 || len(data) == 0 || data[0] != constants.STX {
+		return data, nil
+	}
+	frame := Frame{Raw: data}
+	for _, interceptor := range interceptors {
+		var err error
+		if frame, err = interceptor(frame); err != nil {
+			return nil, err
+		}
+	}
+	return frame.Raw, nil
+}
+
+// interceptInbound runs the inbound interceptors on a frame read along with err, checking again a frame
+// they rewrote, like the connection checks the frames it reads
+func (astmConn *ASTMConnection) interceptInbound(data []byte, err error) ([]byte, error) {
+	if err != nil && !errors.Is(err, connection.ErrChecksumMismatch) {
+		return data, err
+	}
+	intercepted, interceptErr := intercept(astmConn.inbound, data)
+	if interceptErr != nil {
+		return data, fmt.Errorf("%w: %w", errFrameIntercepted, interceptErr)
+	}
+	if bytes.Equal(intercepted, data) {
+		return data, err
+	}
+	err = protocol.ValidateFrame(intercepted)
+	if err != nil && !errors.Is(err, connection.ErrChecksumMismatch) {
+		err = fmt.Errorf("%w: %w", connection.ErrChecksumMismatch, err)
+	}
+	return intercepted, err
+}
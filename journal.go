@@ -0,0 +1,204 @@
+package lis1a2
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalKind tells whether a JournalEntry is a frame or a message
+type JournalKind int
+
+const (
+	// JournalFrame is a frame as written to or read from the line, from STX to the LF after its checksum
+	JournalFrame JournalKind = iota
+	// JournalMessage is a message, one record per line, received in full or taken by the receiver
+	JournalMessage
+)
+
+// JournalEntry is a frame or a message an ASTMConnection sent or received, see SetJournal
+type JournalEntry struct {
+	Time      time.Time
+	Direction ExchangeDirection
+	Kind      JournalKind
+	// Data is the frame as it went over the line, or the message, decoded from the charset of the connection
+	// when received and encoded into it when sent
+	Data []byte
+}
+
+// Journal stores the frames and messages of an ASTMConnection in the order they were sent and received,
+// for audit and to process them again, see Replay. An ASTMConnection appends from the go routine running
+// Listen and from the ones sending, so Append has to be safe for concurrent use.
+type Journal interface {
+	// Append stores an entry after the ones already stored
+	Append(entry JournalEntry) error
+	// Walk calls visit with every entry stored, in the order they were appended, stopping at the first
+	// error visit returns and returning it
+	Walk(visit func(entry JournalEntry) error) error
+}
+
+// SetJournal makes the connection append every frame it sends and receives, and every message it receives in
+// full or the receiver takes, to journal. An entry which fails to be appended is logged, the exchange goes on.
+// It has to be called before Listen.
+func (astmConn *ASTMConnection) SetJournal(journal Journal) {
+	astmConn.journal = journal
+}
+
+// journalAppend appends an entry to the journal of the connection, if any
+func (astmConn *ASTMConnection) journalAppend(direction ExchangeDirection, kind JournalKind, data string) {
+	if astmConn.journal == nil {
+		return
+	}
+	entry := JournalEntry{Time: time.Now(), Direction: direction, Kind: kind, Data: []byte(data)}
+	if err := astmConn.journal.Append(entry); err != nil {
+		slog.Error("Failed to append to the journal.", "Error", err)
+	}
+}
+
+// Replay calls handler with every entry of journal, in the order they were appended, stopping at the first
+// error handler returns and returning it. The results an application failed to store before crashing are
+// recovered by parsing the messages received again:
+//
+//	err := lis1a2.Replay(journal, func(entry lis1a2.JournalEntry) error {
+//		if entry.Kind != lis1a2.JournalMessage || entry.Direction != lis1a2.ExchangeReceived {
+//			return nil
+//		}
+//		return store(entry.Data)
+//	})
+func Replay(journal Journal, handler func(entry JournalEntry) error) error {
+	return journal.Walk(handler)
+}
+
+// FileJournal is a Journal appending to a file, one JSON object per line:
+//
+//	{"time":"2024-01-01T12:30:00.123456789Z","direction":"received","kind":"frame","data":"AjFIfFxeJg0DRTUNCg=="}
+//
+// The direction is sent or received, the kind frame or message and the data is base64 encoded, so that
+// any byte is kept. The file is only ever appended to, a line cut short by a crash is skipped by Walk.
+type FileJournal struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// journalLine is the JSON shape of an entry of a FileJournal
+type journalLine struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Kind      string    `json:"kind"`
+	Data      []byte    `json:"data"`
+}
+
+// OpenFileJournal opens the journal stored in the file at path, creating the file if it does not exist
+func OpenFileJournal(path string) (*FileJournal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &FileJournal{path: path, file: file}, nil
+}
+
+// Append writes an entry at the end of the file, safe for concurrent use
+func (journal *FileJournal) Append(entry JournalEntry) error {
+	line, err := json.Marshal(journalLine{
+		Time:      entry.Time,
+		Direction: directionName(entry.Direction),
+		Kind:      kindName(entry.Kind),
+		Data:      entry.Data,
+	})
+	if err != nil {
+		return err
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	if journal.file == nil {
+		return errors.New("journal is closed")
+	}
+	_, err = journal.file.Write(append(line, '\n'))
+	return err
+}
+
+// Walk reads the entries of the file from its start, skipping a last line cut short by a crash
+func (journal *FileJournal) Walk(visit func(entry JournalEntry) error) error {
+	file, err := os.Open(journal.path)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var decoded journalLine
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			return fmt.Errorf("line %v of the journal is invalid: %w", number, err)
+		}
+		entry := JournalEntry{Time: decoded.Time, Data: decoded.Data}
+		if entry.Direction, err = parseDirection(decoded.Direction); err != nil {
+			return fmt.Errorf("line %v of the journal is invalid: %w", number, err)
+		}
+		if entry.Kind, err = parseKind(decoded.Kind); err != nil {
+			return fmt.Errorf("line %v of the journal is invalid: %w", number, err)
+		}
+		if err := visit(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the file, the entries appended afterwards fail
+func (journal *FileJournal) Close() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	if journal.file == nil {
+		return nil
+	}
+	err := journal.file.Close()
+	journal.file = nil
+	return err
+}
+
+func directionName(direction ExchangeDirection) string {
+	if direction == ExchangeReceived {
+		return "received"
+	}
+	return "sent"
+}
+
+func parseDirection(name string) (ExchangeDirection, error) {
+	switch strings.ToLower(name) {
+	case "sent":
+		return ExchangeSent, nil
+	case "received":
+		return ExchangeReceived, nil
+	}
+	return 0, fmt.Errorf("unknown direction %q", name)
+}
+
+func kindName(kind JournalKind) string {
+	if kind == JournalMessage {
+		return "message"
+	}
+	return "frame"
+}
+
+func parseKind(name string) (JournalKind, error) {
+	switch strings.ToLower(name) {
+	case "frame":
+		return JournalFrame, nil
+	case "message":
+		return JournalMessage, nil
+	}
+	return 0, fmt.Errorf("unknown kind %q", name)
+}
@@ -0,0 +1,230 @@
+package lis1a2
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// Metrics is a snapshot of the health of the link of an ASTMConnection, see ASTMConnection.Metrics.
+// The counters add up from the creation of the connection, over reconnects.
+type Metrics struct {
+	// FramesSent counts the frames written, retransmissions included
+	FramesSent int64
+	// FramesReceived counts the frames read, corrupt ones included
+	FramesReceived int64
+	// NAKsSent counts the NAKs written, to ENQs while busy and to corrupt or out of sequence frames
+	NAKsSent int64
+	// NAKsReceived counts the ENQs and frames the receiver answered with NAK
+	NAKsReceived int64
+	// Retransmissions counts the frames written again after the receiver NAKed them or did not answer
+	Retransmissions int64
+	// ChecksumFailures counts the frames read whose checksum did not match
+	ChecksumFailures int64
+	// MessagesSent counts the messages the receiver took, MessagesReceived the ones received in full
+	MessagesSent     int64
+	MessagesReceived int64
+	// BytesIn and BytesOut count the bytes read from and written to the connection
+	BytesIn  int64
+	BytesOut int64
+	// ReadsDropped counts the frames and control bytes the connection dropped or NAKed because its read channel
+	// was full, zero when it does not keep count of them, see connection.OverflowPolicy
+	ReadsDropped int64
+	// Connected tells whether the connection is connected, State the state of the link
+	Connected bool
+	State     constants.LIS1A2ConnectionStatus
+	// Establishments counts the ENQs the receiver ACKed, EstablishmentLatency adds up how long they took
+	// from the first ENQ, busy retries included, and LastEstablishmentLatency is how long the last one took
+	Establishments           int64
+	EstablishmentLatency     time.Duration
+	LastEstablishmentLatency time.Duration
+}
+
+// linkMetrics are the counters behind Metrics, updated from the go routines sending and running Listen
+type linkMetrics struct {
+	framesSent               atomic.Int64
+	framesReceived           atomic.Int64
+	naksSent                 atomic.Int64
+	naksReceived             atomic.Int64
+	retransmissions          atomic.Int64
+	checksumFailures         atomic.Int64
+	messagesSent             atomic.Int64
+	messagesReceived         atomic.Int64
+	bytesIn                  atomic.Int64
+	bytesOut                 atomic.Int64
+	enqsSent                 atomic.Int64
+	establishments           atomic.Int64
+	establishmentLatency     atomic.Int64
+	lastEstablishmentLatency atomic.Int64
+	// lastActivity is when data was last read or written, in Unix nanoseconds, zero before any traffic
+	lastActivity atomic.Int64
+	// establishingSince is when the state last changed to Establishing, in Unix nanoseconds
+	establishingSince atomic.Int64
+	// lastFrameSent is only used by the go routine sending, which holds the send mutex
+	lastFrameSent string
+}
+
+// Metrics gives a snapshot of the counters of the link, safe to call from any go routine
+func (astmConn *ASTMConnection) Metrics() Metrics {
+	metrics := &astmConn.metrics
+	snapshot := Metrics{
+		FramesSent:               metrics.framesSent.Load(),
+		FramesReceived:           metrics.framesReceived.Load(),
+		NAKsSent:                 metrics.naksSent.Load(),
+		NAKsReceived:             metrics.naksReceived.Load(),
+		Retransmissions:          metrics.retransmissions.Load(),
+		ChecksumFailures:         metrics.checksumFailures.Load(),
+		MessagesSent:             metrics.messagesSent.Load(),
+		MessagesReceived:         metrics.messagesReceived.Load(),
+		BytesIn:                  metrics.bytesIn.Load(),
+		BytesOut:                 metrics.bytesOut.Load(),
+		Connected:                astmConn.IsConnected(),
+		State:                    astmConn.currentStatus(),
+		Establishments:           metrics.establishments.Load(),
+		EstablishmentLatency:     time.Duration(metrics.establishmentLatency.Load()),
+		LastEstablishmentLatency: time.Duration(metrics.lastEstablishmentLatency.Load()),
+	}
+	if counting, ok := astmConn.connection.(interface{ ReadsDropped() int64 }); ok {
+		snapshot.ReadsDropped = counting.ReadsDropped()
+	}
+	return snapshot
+}
+
+// written counts data written to the connection
+func (metrics *linkMetrics) written(data []byte) {
+	metrics.bytesOut.Add(int64(len(data)))
+	if len(data) == 0 {
+		return
+	}
+	metrics.lastActivity.Store(time.Now().UnixNano())
+	if data[0] == constants.STX {
+		metrics.framesSent.Add(1)
+	} else if len(data) == 1 && data[0] == constants.NAK {
+		metrics.naksSent.Add(1)
+	} else if len(data) == 1 && data[0] == constants.ENQ {
+		metrics.enqsSent.Add(1)
+	}
+}
+
+// read counts data read from the connection
+func (metrics *linkMetrics) read(data []byte) {
+	metrics.bytesIn.Add(int64(len(data)))
+	if len(data) == 0 {
+		return
+	}
+	metrics.lastActivity.Store(time.Now().UnixNano())
+	if data[0] == constants.STX {
+		metrics.framesReceived.Add(1)
+	}
+}
+
+// frameSent counts a frame about to be sent as a retransmission when it is the frame sent last again,
+// the next frame carries another frame number
+func (metrics *linkMetrics) frameSent(raw string) {
+	if raw == metrics.lastFrameSent {
+		metrics.retransmissions.Add(1)
+	}
+	metrics.lastFrameSent = raw
+}
+
+// statusChanged times the establishment phase
+func (metrics *linkMetrics) statusChanged(old constants.LIS1A2ConnectionStatus, status constants.LIS1A2ConnectionStatus) {
+	if status == constants.Establishing {
+		if old != constants.Establishing {
+			metrics.establishingSince.Store(time.Now().UnixNano())
+		}
+		return
+	}
+	if old == constants.Establishing && status == constants.Sending {
+		latency := time.Now().UnixNano() - metrics.establishingSince.Load()
+		metrics.establishments.Add(1)
+		metrics.establishmentLatency.Add(latency)
+		metrics.lastEstablishmentLatency.Store(latency)
+	}
+}
+
+// stateNames are the values of the state label of the Prometheus state metric
+var stateNames = map[constants.LIS1A2ConnectionStatus]string{
+	constants.Idle:         "idle",
+	constants.Establishing: "establishing",
+	constants.Sending:      "sending",
+	constants.Receiving:    "receiving",
+}
+
+// labelEscaper escapes the value of a Prometheus label
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the metrics of the links given, by the name of the link, in the Prometheus text
+// exposition format, the name becoming the link label of every metric
+func WritePrometheus(w io.Writer, links map[string]Metrics) error {
+	names := make([]string, 0, len(links))
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	family := func(name string, kind string, help string, value func(metrics Metrics) float64) {
+		fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, link := range names {
+			fmt.Fprintf(&builder, "%s{link=\"%s\"} %g\n", name, labelEscaper.Replace(link), value(links[link]))
+		}
+	}
+	counter := func(name string, help string, value func(metrics Metrics) int64) {
+		family(name, "counter", help, func(metrics Metrics) float64 { return float64(value(metrics)) })
+	}
+	counter("lis1a2_frames_sent_total", "Frames sent, retransmissions included.", func(m Metrics) int64 { return m.FramesSent })
+	counter("lis1a2_frames_received_total", "Frames received, corrupt ones included.", func(m Metrics) int64 { return m.FramesReceived })
+	counter("lis1a2_naks_sent_total", "NAKs sent.", func(m Metrics) int64 { return m.NAKsSent })
+	counter("lis1a2_naks_received_total", "ENQs and frames answered with NAK.", func(m Metrics) int64 { return m.NAKsReceived })
+	counter("lis1a2_retransmissions_total", "Frames sent again.", func(m Metrics) int64 { return m.Retransmissions })
+	counter("lis1a2_checksum_failures_total", "Frames received with a checksum mismatch.", func(m Metrics) int64 { return m.ChecksumFailures })
+	counter("lis1a2_messages_sent_total", "Messages sent.", func(m Metrics) int64 { return m.MessagesSent })
+	counter("lis1a2_messages_received_total", "Messages received.", func(m Metrics) int64 { return m.MessagesReceived })
+	counter("lis1a2_bytes_in_total", "Bytes read from the connection.", func(m Metrics) int64 { return m.BytesIn })
+	counter("lis1a2_bytes_out_total", "Bytes written to the connection.", func(m Metrics) int64 { return m.BytesOut })
+	counter("lis1a2_reads_dropped_total", "Frames and control bytes dropped or NAKed, the read channel being full.", func(m Metrics) int64 { return m.ReadsDropped })
+	family("lis1a2_connected", "gauge", "Whether the connection is connected.", func(m Metrics) float64 {
+		if m.Connected {
+			return 1
+		}
+		return 0
+	})
+
+	fmt.Fprintf(&builder, "# HELP lis1a2_state State of the link, 1 for the current one.\n# TYPE lis1a2_state gauge\n")
+	for _, link := range names {
+		for _, state := range []constants.LIS1A2ConnectionStatus{constants.Idle, constants.Establishing, constants.Sending, constants.Receiving} {
+			value := 0
+			if links[link].State == state {
+				value = 1
+			}
+			fmt.Fprintf(&builder, "lis1a2_state{link=\"%s\",state=\"%s\"} %d\n", labelEscaper.Replace(link), stateNames[state], value)
+		}
+	}
+
+	fmt.Fprintf(&builder, "# HELP lis1a2_establishment_seconds Time from the first ENQ to the ACK of the receiver.\n# TYPE lis1a2_establishment_seconds summary\n")
+	for _, link := range names {
+		fmt.Fprintf(&builder, "lis1a2_establishment_seconds_sum{link=\"%s\"} %g\n", labelEscaper.Replace(link), links[link].EstablishmentLatency.Seconds())
+		fmt.Fprintf(&builder, "lis1a2_establishment_seconds_count{link=\"%s\"} %d\n", labelEscaper.Replace(link), links[link].Establishments)
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// MetricsHandler serves the metrics of the connections given, by the name of their link, for Prometheus to scrape
+func MetricsHandler(links map[string]*ASTMConnection) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshots := make(map[string]Metrics, len(links))
+		for name, astmConn := range links {
+			snapshots[name] = astmConn.Metrics()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WritePrometheus(w, snapshots)
+	})
+}
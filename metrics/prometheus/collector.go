@@ -0,0 +1,102 @@
+// Package prometheus exposes the metrics of ASTM connections as a prometheus.Collector, so that they are
+// registered on the registry of the application next to its own metrics. It is a module of its own, the
+// lis1a2 module does not depend on the Prometheus client, lis1a2.MetricsHandler serves the same metrics
+// without it:
+//
+//	prometheus.MustRegister(lis1a2prometheus.NewCollector(map[string]*lis1a2.ASTMConnection{"cobas": astmConn}))
+package prometheus
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// states are the values of the state label of the state metric, in the order they are collected
+var states = []struct {
+	status constants.LIS1A2ConnectionStatus
+	name   string
+}{
+	{constants.Idle, "idle"},
+	{constants.Establishing, "establishing"},
+	{constants.Sending, "sending"},
+	{constants.Receiving, "receiving"},
+}
+
+// counter is a counter of lis1a2.Metrics along with its description
+type counter struct {
+	desc  *prom.Desc
+	value func(metrics lis1a2.Metrics) int64
+}
+
+// Collector collects the metrics of the connections it was created with, labelled by the name of their link,
+// under the names lis1a2.WritePrometheus writes them with
+type Collector struct {
+	links         map[string]*lis1a2.ASTMConnection
+	counters      []counter
+	connected     *prom.Desc
+	state         *prom.Desc
+	establishment *prom.Desc
+}
+
+// NewCollector creates a Collector for the connections given, by the name of their link
+func NewCollector(links map[string]*lis1a2.ASTMConnection) *Collector {
+	link := []string{"link"}
+	newCounter := func(name string, help string, value func(metrics lis1a2.Metrics) int64) counter {
+		return counter{desc: prom.NewDesc(name, help, link, nil), value: value}
+	}
+	return &Collector{
+		links: links,
+		counters: []counter{
+			newCounter("lis1a2_frames_sent_total", "Frames sent, retransmissions included.", func(m lis1a2.Metrics) int64 { return m.FramesSent }),
+			newCounter("lis1a2_frames_received_total", "Frames received, corrupt ones included.", func(m lis1a2.Metrics) int64 { return m.FramesReceived }),
+			newCounter("lis1a2_naks_sent_total", "NAKs sent.", func(m lis1a2.Metrics) int64 { return m.NAKsSent }),
+			newCounter("lis1a2_naks_received_total", "ENQs and frames answered with NAK.", func(m lis1a2.Metrics) int64 { return m.NAKsReceived }),
+			newCounter("lis1a2_retransmissions_total", "Frames sent again.", func(m lis1a2.Metrics) int64 { return m.Retransmissions }),
+			newCounter("lis1a2_checksum_failures_total", "Frames received with a checksum mismatch.", func(m lis1a2.Metrics) int64 { return m.ChecksumFailures }),
+			newCounter("lis1a2_messages_sent_total", "Messages sent.", func(m lis1a2.Metrics) int64 { return m.MessagesSent }),
+			newCounter("lis1a2_messages_received_total", "Messages received.", func(m lis1a2.Metrics) int64 { return m.MessagesReceived }),
+			newCounter("lis1a2_bytes_in_total", "Bytes read from the connection.", func(m lis1a2.Metrics) int64 { return m.BytesIn }),
+			newCounter("lis1a2_bytes_out_total", "Bytes written to the connection.", func(m lis1a2.Metrics) int64 { return m.BytesOut }),
+			newCounter("lis1a2_reads_dropped_total", "Frames and control bytes dropped or NAKed, the read channel being full.", func(m lis1a2.Metrics) int64 { return m.ReadsDropped }),
+		},
+		connected:     prom.NewDesc("lis1a2_connected", "Whether the connection is connected.", link, nil),
+		state:         prom.NewDesc("lis1a2_state", "State of the link, 1 for the current one.", []string{"link", "state"}, nil),
+		establishment: prom.NewDesc("lis1a2_establishment_seconds", "Time from the first ENQ to the ACK of the receiver.", link, nil),
+	}
+}
+
+// Describe sends the descriptions of the metrics collected
+func (collector *Collector) Describe(descs chan<- *prom.Desc) {
+	for _, counter := range collector.counters {
+		descs <- counter.desc
+	}
+	descs <- collector.connected
+	descs <- collector.state
+	descs <- collector.establishment
+}
+
+// Collect sends a snapshot of the metrics of every connection, safe for concurrent use
+func (collector *Collector) Collect(metrics chan<- prom.Metric) {
+	for name, astmConn := range collector.links {
+		snapshot := astmConn.Metrics()
+		for _, counter := range collector.counters {
+			metrics <- prom.MustNewConstMetric(counter.desc, prom.CounterValue, float64(counter.value(snapshot)), name)
+		}
+		connected := 0.0
+		if snapshot.Connected {
+			connected = 1
+		}
+		metrics <- prom.MustNewConstMetric(collector.connected, prom.GaugeValue, connected, name)
+		for _, state := range states {
+			value := 0.0
+			if snapshot.State == state.status {
+				value = 1
+			}
+			metrics <- prom.MustNewConstMetric(collector.state, prom.GaugeValue, value, name, state.name)
+		}
+		metrics <- prom.MustNewConstSummary(collector.establishment, uint64(snapshot.Establishments),
+			snapshot.EstablishmentLatency.Seconds(), nil, name)
+	}
+}
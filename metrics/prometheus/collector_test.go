@@ -0,0 +1,94 @@
+package prometheus_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	lis1a2prometheus "github.com/therealriteshkudalkar/lis1a2/metrics/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	astmConn := lis1a2.NewASTMConnection(&mockConn, false)
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+	defer func() { _ = astmConn.Disconnect() }()
+	// the first frame is NAKed once
+	naked := false
+	mockConn.OnWrite(func(data []byte) {
+		switch {
+		case data[0] == constants.STX && !naked:
+			naked = true
+			_ = mockConn.Inject([]byte{constants.NAK})
+		case data[0] != constants.EOT:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send the message: %v", err)
+	}
+
+	collector := lis1a2prometheus.NewCollector(map[string]*lis1a2.ASTMConnection{"cobas": astmConn})
+	expected := `
+# HELP lis1a2_frames_sent_total Frames sent, retransmissions included.
+# TYPE lis1a2_frames_sent_total counter
+lis1a2_frames_sent_total{link="cobas"} 3
+# HELP lis1a2_naks_received_total ENQs and frames answered with NAK.
+# TYPE lis1a2_naks_received_total counter
+lis1a2_naks_received_total{link="cobas"} 1
+# HELP lis1a2_retransmissions_total Frames sent again.
+# TYPE lis1a2_retransmissions_total counter
+lis1a2_retransmissions_total{link="cobas"} 1
+# HELP lis1a2_messages_sent_total Messages sent.
+# TYPE lis1a2_messages_sent_total counter
+lis1a2_messages_sent_total{link="cobas"} 1
+# HELP lis1a2_connected Whether the connection is connected.
+# TYPE lis1a2_connected gauge
+lis1a2_connected{link="cobas"} 1
+# HELP lis1a2_state State of the link, 1 for the current one.
+# TYPE lis1a2_state gauge
+lis1a2_state{link="cobas",state="establishing"} 0
+lis1a2_state{link="cobas",state="idle"} 1
+lis1a2_state{link="cobas",state="receiving"} 0
+lis1a2_state{link="cobas",state="sending"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "lis1a2_frames_sent_total",
+		"lis1a2_naks_received_total", "lis1a2_retransmissions_total", "lis1a2_messages_sent_total",
+		"lis1a2_connected", "lis1a2_state"); err != nil {
+		t.Fatalf("Unexpected metrics: %v", err)
+	}
+
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("Failed to register the collector: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather: %v", err)
+	}
+	snapshot := astmConn.Metrics()
+	for _, family := range families {
+		if family.GetName() != "lis1a2_establishment_seconds" {
+			continue
+		}
+		summary := family.GetMetric()[0].GetSummary()
+		if summary.GetSampleCount() != uint64(snapshot.Establishments) || summary.GetSampleSum() != snapshot.EstablishmentLatency.Seconds() {
+			t.Fatalf("Expected %v establishments taking %v, got %v taking %vs", snapshot.Establishments,
+				snapshot.EstablishmentLatency, summary.GetSampleCount(), summary.GetSampleSum())
+		}
+		if snapshot.Establishments != 1 {
+			t.Fatalf("Expected a single establishment, got %v", snapshot.Establishments)
+		}
+		return
+	}
+	t.Fatal("Expected the establishment summary to be gathered")
+}
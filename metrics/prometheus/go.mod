@@ -0,0 +1,23 @@
+module github.com/therealriteshkudalkar/lis1a2/metrics/prometheus
+
+go 1.21.6
+
+require (
+	github.com/prometheus/client_golang v1.19.0
+	github.com/therealriteshkudalkar/lis1a2 v0.0.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/creack/goselect v0.1.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	go.bug.st/serial v1.6.2 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
+
+replace github.com/therealriteshkudalkar/lis1a2 => ../..
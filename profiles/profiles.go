@@ -0,0 +1,152 @@
+// Package profiles bundles the quirks of analyzer models: their frame size, timers, checksum leniency,
+// charset, delimiters, parsing strictness and vendor specific record types, so that an integration starts
+// from the settings known to work with the analyzer instead of rediscovering them:
+//
+//	astmConn := lis1a2.NewASTMConnection(&tcpConn, false)
+//	if err := profiles.SysmexXN.Apply(astmConn); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// The profiles bundled carry the settings integrators commonly use with these analyzers, the host interface
+// manual of the software version installed has the last word. Register adds profiles of your own, which
+// config.Instrument then names like the bundled ones.
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// ErrUnexpectedDelimiters is returned by Profile.Parse for a message whose header declares other delimiters
+// than the analyzer of the profile uses, which usually means the connection goes to another analyzer
+var ErrUnexpectedDelimiters = errors.New("message declares unexpected delimiters")
+
+// Profile is the protocol settings of an analyzer model, a field left at its zero value keeps the default
+// of the library
+type Profile struct {
+	// Name identifies the profile, like cobas-e411, see Lookup
+	Name string
+	// Sender and Receiver tune the LIS1-A2 protocol of the connection to the analyzer
+	Sender   lis1a2.SenderOptions
+	Receiver lis1a2.ReceiverOptions
+	// Charset is the one the analyzer writes its text in
+	Charset astm.Charset
+	// Delimiters are the ones the analyzer declares in its header, the messages built for it declare them as well
+	Delimiters astm.Delimiters
+	// ParseOptions tell how strictly the messages of the analyzer are parsed, the charset is the one of the connection
+	ParseOptions astm.ParseOptions
+	// Records are the vendor specific record types the analyzer sends, by record type, registered by Apply
+	Records map[byte]astm.RecordFactory
+}
+
+// CobasE411 is the Roche cobas e 411 immunoassay analyzer, usually connected over RS-232 through its host
+// interface. Its text is read as Latin-1 and its messages are parsed leniently.
+var CobasE411 = Profile{
+	Name:         "cobas-e411",
+	Sender:       lis1a2.SenderOptions{FrameReplyTimeout: 15 * time.Second, MaxFrameSize: 240},
+	Receiver:     lis1a2.ReceiverOptions{ReceiveTimeout: 30 * time.Second},
+	Charset:      astm.CharsetLatin1,
+	Delimiters:   astm.DefaultDelimiters,
+	ParseOptions: astm.ParseOptions{Strictness: astm.Lenient},
+}
+
+// SysmexXN is the Sysmex XN series of hematology analyzers, usually connected over TCP. Its frames are
+// received tolerantly and its messages, a complete blood count carrying many results, parsed leniently.
+var SysmexXN = Profile{
+	Name:         "sysmex-xn",
+	Sender:       lis1a2.SenderOptions{FrameReplyTimeout: 15 * time.Second, MaxFrameSize: 240},
+	Receiver:     lis1a2.ReceiverOptions{ReceiveTimeout: 30 * time.Second, Compatibility: lis1a2.Tolerant},
+	Charset:      astm.CharsetASCII,
+	Delimiters:   astm.DefaultDelimiters,
+	ParseOptions: astm.ParseOptions{Strictness: astm.Lenient},
+}
+
+var (
+	registryMutex sync.RWMutex
+	registered    = map[string]Profile{
+		CobasE411.Name: CobasE411,
+		SysmexXN.Name:  SysmexXN,
+	}
+)
+
+// Register makes the profile known to Lookup by its name, replacing the profile of the same name if any.
+// It is safe to call from any go routine.
+func Register(profile Profile) error {
+	if profile.Name == "" {
+		return errors.New("profile without a name")
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registered[profile.Name] = profile
+	return nil
+}
+
+// Lookup gives the profile registered under name, the bundled ones included
+func Lookup(name string) (Profile, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	profile, ok := registered[name]
+	return profile, ok
+}
+
+// Names gives the names of the profiles registered, sorted
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply sets the sender and receiver options and the charset of the profile on the connection, and registers
+// its record types with astm.RegisterRecordType, which applies to every message parsed. It has to be called
+// before Listen.
+func (profile Profile) Apply(astmConn *lis1a2.ASTMConnection) error {
+	for recordType, factory := range profile.Records {
+		if err := astm.RegisterRecordType(recordType, factory); err != nil {
+			return fmt.Errorf("profile %v: %w", profile.Name, err)
+		}
+	}
+	astmConn.SetSenderOptions(profile.Sender)
+	astmConn.SetReceiverOptions(profile.Receiver)
+	astmConn.SetCharset(profile.Charset)
+	return nil
+}
+
+// Parse parses a message received from the analyzer with the parse options of the profile, see
+// ReceivedMessage.ParseWith, failing with ErrUnexpectedDelimiters when its header does not declare the
+// delimiters of the profile
+func (profile Profile) Parse(message lis1a2.ReceivedMessage) (*astm.Message, error) {
+	parsed, err := message.ParseWith(profile.ParseOptions)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Delimiters != (astm.Delimiters{}) && parsed.Delimiters != profile.Delimiters {
+		return nil, fmt.Errorf("%w: %q, profile %v expects %q", ErrUnexpectedDelimiters, declared(parsed.Delimiters),
+			profile.Name, declared(profile.Delimiters))
+	}
+	return parsed, nil
+}
+
+// NewOrderMessage starts an order message to download to the analyzer, declaring the delimiters of the profile
+func (profile Profile) NewOrderMessage() *astm.OrderMessageBuilder {
+	builder := astm.NewOrderMessage()
+	if profile.Delimiters != (astm.Delimiters{}) {
+		builder.Delimiters(profile.Delimiters)
+	}
+	return builder
+}
+
+// declared gives the delimiters the way a header declares them, like |\^&
+func declared(delimiters astm.Delimiters) string {
+	return string([]byte{delimiters.Field, delimiters.Repeat, delimiters.Component, delimiters.Escape})
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// ErrMalformedFrame is returned by ValidateFrame for data which is not structured like a frame
+var ErrMalformedFrame = errors.New("malformed frame")
+
+// ErrChecksumMismatch is returned by ValidateFrame for a frame whose checksum does not match its content
+var ErrChecksumMismatch = errors.New("frame checksum mismatch")
+
+// ComputeChecksum gives the checksum of a frame as two uppercase hex characters: the modulo 256 sum
+// of the frame number through the ETX or ETB inclusive. The frame can be given whole, from STX to LF,
+// or as just the characters the checksum covers.
+func ComputeChecksum(frame []byte) [2]byte {
+	const hexDigits = "0123456789ABCDEF"
+	if len(frame) > 0 && frame[0] == constants.STX {
+		frame = frame[1:]
+	}
+	var sum byte
+	for _, bt := range frame {
+		sum += bt
+		if bt == constants.ETX || bt == constants.ETB {
+			break
+		}
+	}
+	return [2]byte{hexDigits[sum>>4], hexDigits[sum&0x0F]}
+}
+
+// ValidateFrame checks the structure of a whole frame, STX, frame number, text, CR ETX for an end
+// frame or ETB for an intermediate one, two checksum characters, CR and LF, and its checksum.
+// It returns an error wrapping ErrMalformedFrame or ErrChecksumMismatch if the frame is not valid.
+func ValidateFrame(frame []byte) error {
+	decoded, err := DecodeFrame(frame)
+	if err != nil {
+		return err
+	}
+	if !decoded.ChecksumValid {
+		calculated := ComputeChecksum(frame)
+		return fmt.Errorf("%w: received %s, calculated %s", ErrChecksumMismatch, decoded.Checksum[:], calculated[:])
+	}
+	return nil
+}
+
+// upper turns a lowercase hex digit into an uppercase one, some instruments send them lowercase
+func upper(bt byte) byte {
+	if bt >= 'a' && bt <= 'f' {
+		return bt - 'a' + 'A'
+	}
+	return bt
+}
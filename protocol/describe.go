@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// controlNames are the symbolic names of the control characters of the standard
+var controlNames = map[byte]string{
+	constants.NUL:  "NUL",
+	constants.STX:  "STX",
+	constants.ETX:  "ETX",
+	constants.EOT:  "EOT",
+	constants.ENQ:  "ENQ",
+	constants.ACK:  "ACK",
+	constants.LF:   "LF",
+	constants.CR:   "CR",
+	constants.XON:  "XON",
+	constants.XOFF: "XOFF",
+	constants.NAK:  "NAK",
+	constants.ETB:  "ETB",
+}
+
+// Describe writes data the way service engineers read the line, the control characters by their name,
+// like <STX>1H|\^&<CR><ETX>E5<CR><LF>, other non printable bytes as their hex value, like <1B>
+func Describe(data []byte) string {
+	var builder strings.Builder
+	for _, bt := range data {
+		if name, ok := controlNames[bt]; ok {
+			builder.WriteString("<" + name + ">")
+		} else if bt < 0x20 || bt >= 0x7F {
+			fmt.Fprintf(&builder, "<%02X>", bt)
+		} else {
+			builder.WriteByte(bt)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"fmt"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// Frame is a single decoded frame
+type Frame struct {
+	// FrameNumber is the number of the frame, from 0 to 7
+	FrameNumber int
+	// Text is the part of the record the frame carries, without its terminator
+	Text []byte
+	// Terminator is ETX for an end frame, the last frame of a record, and ETB for an intermediate frame
+	Terminator byte
+	// Checksum is the checksum the frame carries, as two hex characters
+	Checksum [2]byte
+	// ChecksumValid tells whether Checksum matches the content of the frame
+	ChecksumValid bool
+}
+
+// IsLast tells whether the frame is an end frame, the last frame of a record
+func (frame Frame) IsLast() bool {
+	return frame.Terminator == constants.ETX
+}
+
+// EncodeFrame builds a frame numbered frameNumber modulo 8 carrying text: STX, frame number, text,
+// CR ETX when last is set or ETB otherwise, two hex checksum characters, CR and LF.
+// The text is not split, see FrameBuilder for records longer than a frame.
+func EncodeFrame(frameNumber int, text []byte, last bool) []byte {
+	frame := make([]byte, 0, len(text)+8)
+	frame = append(frame, constants.STX, byte('0'+frameNumber%8))
+	frame = append(frame, text...)
+	if last {
+		frame = append(frame, constants.CR, constants.ETX)
+	} else {
+		frame = append(frame, constants.ETB)
+	}
+	checksum := ComputeChecksum(frame)
+	frame = append(frame, checksum[:]...)
+	return append(frame, constants.CR, constants.LF)
+}
+
+// DecodeFrame decodes a whole frame, from STX to LF. A checksum which does not match is reported by
+// ChecksumValid, an error wrapping ErrMalformedFrame is returned for data not structured like a frame.
+func DecodeFrame(data []byte) (Frame, error) {
+	dataLen := len(data)
+	if dataLen < 7 || data[0] != constants.STX {
+		return Frame{}, fmt.Errorf("%w: frame too short or not starting with STX", ErrMalformedFrame)
+	}
+	if data[dataLen-2] != constants.CR || data[dataLen-1] != constants.LF {
+		return Frame{}, fmt.Errorf("%w: frame not terminated with CR LF", ErrMalformedFrame)
+	}
+	if data[1] < '0' || data[1] > '7' {
+		return Frame{}, fmt.Errorf("%w: invalid frame number %q", ErrMalformedFrame, data[1])
+	}
+	frame := Frame{FrameNumber: int(data[1] - '0'), Terminator: data[dataLen-5]}
+	switch frame.Terminator {
+	case constants.ETB:
+		frame.Text = data[2 : dataLen-5]
+	case constants.ETX:
+		if dataLen < 8 || data[dataLen-6] != constants.CR {
+			return Frame{}, fmt.Errorf("%w: end frame without CR before ETX", ErrMalformedFrame)
+		}
+		frame.Text = data[2 : dataLen-6]
+	default:
+		return Frame{}, fmt.Errorf("%w: frame not terminated with ETX or ETB", ErrMalformedFrame)
+	}
+	frame.Checksum = [2]byte{data[dataLen-4], data[dataLen-3]}
+	frame.ChecksumValid = ComputeChecksum(data[1:dataLen-4]) == [2]byte{upper(frame.Checksum[0]), upper(frame.Checksum[1])}
+	return frame, nil
+}
+
+// FrameQuirk is a departure from the standard of a frame which DecodeTolerantFrame accepts, the quirks
+// found in a frame are or-ed together
+type FrameQuirk int
+
+const (
+	// QuirkMissingChecksum is a frame without the two checksum characters after its ETX or ETB
+	QuirkMissingChecksum FrameQuirk = 1 << iota
+	// QuirkLFOnly is a frame terminated with LF alone instead of CR LF
+	QuirkLFOnly
+)
+
+// DecodeTolerantFrame decodes a whole frame like DecodeFrame, also accepting the frames of older analyzers
+// which leave the checksum out or terminate the frame with LF only, and returns the quirks it found.
+// A frame without checksum has ChecksumValid set, there is nothing to check, a checksum sent has to match.
+func DecodeTolerantFrame(data []byte) (Frame, FrameQuirk, error) {
+	dataLen := len(data)
+	if dataLen < 4 || data[0] != constants.STX {
+		return Frame{}, 0, fmt.Errorf("%w: frame too short or not starting with STX", ErrMalformedFrame)
+	}
+	if data[dataLen-1] != constants.LF {
+		return Frame{}, 0, fmt.Errorf("%w: frame not terminated with LF", ErrMalformedFrame)
+	}
+	var quirks FrameQuirk
+	body := data[:dataLen-1]
+	if data[dataLen-2] == constants.CR {
+		body = data[:dataLen-2]
+	} else {
+		quirks |= QuirkLFOnly
+	}
+	if body[1] < '0' || body[1] > '7' {
+		return Frame{}, 0, fmt.Errorf("%w: invalid frame number %q", ErrMalformedFrame, body[1])
+	}
+	bodyLen := len(body)
+	terminatorAt := bodyLen - 3
+	if last := body[bodyLen-1]; last == constants.ETX || last == constants.ETB {
+		terminatorAt = bodyLen - 1
+		quirks |= QuirkMissingChecksum
+	}
+	if terminatorAt < 2 {
+		return Frame{}, 0, fmt.Errorf("%w: frame not terminated with ETX or ETB", ErrMalformedFrame)
+	}
+	frame := Frame{FrameNumber: int(body[1] - '0'), Terminator: body[terminatorAt], ChecksumValid: true}
+	switch frame.Terminator {
+	case constants.ETB:
+		frame.Text = body[2:terminatorAt]
+	case constants.ETX:
+		if terminatorAt < 3 || body[terminatorAt-1] != constants.CR {
+			return Frame{}, 0, fmt.Errorf("%w: end frame without CR before ETX", ErrMalformedFrame)
+		}
+		frame.Text = body[2 : terminatorAt-1]
+	default:
+		return Frame{}, 0, fmt.Errorf("%w: frame not terminated with ETX or ETB", ErrMalformedFrame)
+	}
+	if quirks&QuirkMissingChecksum == 0 {
+		frame.Checksum = [2]byte{body[bodyLen-2], body[bodyLen-1]}
+		frame.ChecksumValid = ComputeChecksum(body[1:terminatorAt+1]) == [2]byte{upper(frame.Checksum[0]), upper(frame.Checksum[1])}
+	}
+	return frame, quirks, nil
+}
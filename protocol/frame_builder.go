@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// FrameBuilder frames outgoing records, keeping track of the frame number across records.
+// The first frame is numbered 1, the numbers then go up to 7 and wrap around to 0.
+type FrameBuilder struct {
+	frameNumber  int
+	maxFrameSize int
+}
+
+// NewFrameBuilder creates a frame builder for a new transfer, carrying at most MaxFrameSize characters of text per frame
+func NewFrameBuilder() *FrameBuilder {
+	return NewFrameBuilderWithMaxFrameSize(constants.MaxFrameSize)
+}
+
+// NewFrameBuilderWithMaxFrameSize creates a frame builder for a new transfer which carries at most
+// maxFrameSize characters of text per frame, for analyzers which do not take the standard 240.
+// A size which is not positive falls back to MaxFrameSize.
+func NewFrameBuilderWithMaxFrameSize(maxFrameSize int) *FrameBuilder {
+	if maxFrameSize <= 0 {
+		maxFrameSize = constants.MaxFrameSize
+	}
+	return &FrameBuilder{frameNumber: 1, maxFrameSize: maxFrameSize}
+}
+
+// FrameNumber gives the number the next frame will carry
+func (builder *FrameBuilder) FrameNumber() int {
+	return builder.frameNumber
+}
+
+// Frames splits a record in frames of at most the maximum frame size characters of text, every frame but
+// the last is an intermediate frame terminated by ETB, the last one is an end frame terminated by CR ETX.
+// Each frame is STX, frame number, text, terminator, two hex checksum characters, CR and LF.
+func (builder *FrameBuilder) Frames(record string) [][]byte {
+	var frames [][]byte
+	byteRecord := []byte(record)
+	for len(byteRecord) > builder.maxFrameSize {
+		frames = append(frames, builder.frame(byteRecord[:builder.maxFrameSize], false))
+		byteRecord = byteRecord[builder.maxFrameSize:]
+	}
+	return append(frames, builder.frame(byteRecord, true))
+}
+
+// frame builds a single frame and moves on to the next frame number
+func (builder *FrameBuilder) frame(text []byte, last bool) []byte {
+	frame := EncodeFrame(builder.frameNumber, text, last)
+	builder.frameNumber = (builder.frameNumber + 1) % 8
+	return frame
+}
+
+// EncodeMessage frames the records of a message, numbering the frames as one transfer
+func EncodeMessage(records []string) [][]byte {
+	builder := NewFrameBuilder()
+	var frames [][]byte
+	for _, record := range records {
+		frames = append(frames, builder.Frames(record)...)
+	}
+	return frames
+}
+
+// ENQ gives the control byte which starts the establishment phase of a session
+func ENQ() []byte {
+	return []byte{constants.ENQ}
+}
+
+// EOT gives the control byte which ends the transfer phase of a session
+func EOT() []byte {
+	return []byte{constants.EOT}
+}
@@ -0,0 +1,114 @@
+package lis1a2
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// defaultQueryResponseTimeout is how long answering a query may take by default
+const defaultQueryResponseTimeout = 30 * time.Second
+
+// OrderProvider gives the patients, along with their orders, the instrument asks for in a request record,
+// like the orders for the specimen ID in its StartingRangeID. No patients tell the instrument there are no
+// orders, an error tells it the query could not be processed. ctx is done once the answer is late.
+type OrderProvider func(ctx context.Context, request *astm.RequestRecord) ([]*astm.Patient, error)
+
+// QueryOptions tunes how the queries of an instrument are answered, a field left at its zero value keeps its default
+type QueryOptions struct {
+	// ResponseTimeout is how long looking up the orders and sending them may take, defaults to 30 seconds
+	ResponseTimeout time.Duration
+	// Header is the header record the answers start with, its Timestamp is set when left empty.
+	// Defaults to a header declaring the default delimiters.
+	Header *astm.HeaderRecord
+}
+
+// queryOptionsWithDefaults takes the first options given, filling in the defaults of the fields left unset
+func queryOptionsWithDefaults(options []QueryOptions) QueryOptions {
+	merged := QueryOptions{}
+	if len(options) > 0 {
+		merged = options[0]
+	}
+	if merged.ResponseTimeout <= 0 {
+		merged.ResponseTimeout = defaultQueryResponseTimeout
+	}
+	if merged.Header == nil {
+		merged.Header = &astm.HeaderRecord{Delimiters: astm.DefaultDelimiters}
+	}
+	return merged
+}
+
+// queryHandler answers the queries of the instrument with the orders of its provider
+type queryHandler struct {
+	provider OrderProvider
+	options  QueryOptions
+}
+
+// OnQuery answers the queries of the instrument in host query mode: a message received with request records
+// is handed to the provider, request by request, and the patients and orders it gives are sent back in a
+// single message, terminated with astm.TerminationQueryProcessed, or astm.TerminationNoInformation when there
+// are none and astm.TerminationQueryError when the provider failed. The answer is sent from a go routine of
+// its own once the line is idle again, the query messages are not delivered to OnMessage or ReadMessage.
+// It is optionally tuned by options. It has to be registered before Listen.
+func (astmConn *ASTMConnection) OnQuery(provider OrderProvider, options ...QueryOptions) {
+	astmConn.queryHandler = &queryHandler{provider: provider, options: queryOptionsWithDefaults(options)}
+}
+
+// handleQuery answers the message if it is a query and a query handler is registered, telling whether it did
+func (astmConn *ASTMConnection) handleQuery(text string) bool {
+	if astmConn.queryHandler == nil {
+		return false
+	}
+	query, err := astm.ParseMessage(text, astm.ParseOptions{Strictness: astm.Lenient})
+	if err != nil || len(query.Requests) == 0 {
+		return false
+	}
+	go astmConn.answerQuery(astmConn.connectionContext(), query.Requests)
+	return true
+}
+
+// answerQuery looks the requests up with the provider and sends the answer
+func (astmConn *ASTMConnection) answerQuery(ctx context.Context, requests []*astm.RequestRecord) {
+	handler := astmConn.queryHandler
+	ctx, cancel := context.WithTimeout(ctx, handler.options.ResponseTimeout)
+	defer cancel()
+
+	var patients []*astm.Patient
+	terminationCode := astm.TerminationQueryProcessed
+	for _, request := range requests {
+		found, err := handler.provider(ctx, request)
+		if err != nil {
+			slog.Error("Failed to look up the orders of a query.", "Request", request.StartingRangeID, "Error", err)
+			patients, terminationCode = nil, astm.TerminationQueryError
+			break
+		}
+		patients = append(patients, found...)
+	}
+	if terminationCode == astm.TerminationQueryProcessed && len(patients) == 0 {
+		terminationCode = astm.TerminationNoInformation
+	}
+
+	header := *handler.options.Header
+	if header.Timestamp == nil {
+		header.Timestamp = astm.NewTimestampField(time.Now())
+	}
+	answer := astm.NewMessage(&header, patients, terminationCode)
+	err := astmConn.SendParsedMessage(ctx, answer)
+	// the line is still taken by the query until the receiver returned to idle, or by a message the
+	// instrument sends meanwhile
+	for errors.Is(err, ErrBusy) && ctx.Err() == nil {
+		select {
+		case <-time.After(contentionPollInterval):
+			err = astmConn.SendParsedMessage(ctx, answer)
+		case <-ctx.Done():
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to send the answer to a query.", "Error", err)
+		return
+	}
+	slog.Info("Answered a query.", "Patients", len(patients), "Termination code", terminationCode)
+}
@@ -0,0 +1,214 @@
+package lis1a2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+)
+
+// linkLostRetryInterval is how long the send queue waits before sending a message again after the link was lost
+const linkLostRetryInterval = time.Second
+
+// defaultQueueRetryBackoff is how long the send queue waits by default before sending again a message the
+// receiver did not take
+const defaultQueueRetryBackoff = 10 * time.Second
+
+// defaultQueueMaxRetryBackoff caps the wait between the attempts to send a queued message by default
+const defaultQueueMaxRetryBackoff = 5 * time.Minute
+
+// QueueOptions tunes how the send queue retries the messages the receiver did not take, because it did not
+// answer ENQ or a frame in time, ErrReplyTimeout, or NAKed them every attempt, ErrTransmissionAborted.
+// A field left at its zero value keeps its default.
+type QueueOptions struct {
+	// RetryBackoff is the wait before sending such a message again, it doubles after every failed attempt,
+	// defaults to 10 seconds
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the wait between the attempts, defaults to 5 minutes
+	MaxRetryBackoff time.Duration
+	// MaxAttempts is the number of times such a message is sent before it is given up, removed from the
+	// QueueStore and its done callback called with the error, zero retries until it is sent
+	MaxAttempts int
+}
+
+// queueOptionsWithDefaults fills in the defaults of the fields left unset
+func queueOptionsWithDefaults(options QueueOptions) QueueOptions {
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultQueueRetryBackoff
+	}
+	if options.MaxRetryBackoff <= 0 {
+		options.MaxRetryBackoff = defaultQueueMaxRetryBackoff
+	}
+	if options.MaxRetryBackoff < options.RetryBackoff {
+		options.MaxRetryBackoff = options.RetryBackoff
+	}
+	return options
+}
+
+// queuedMessage is a message waiting in the send queue, along with the callback told how sending it went
+type queuedMessage struct {
+	records []string
+	done    func(err error)
+	// id is the ID of the message in the QueueStore when stored is set
+	id     uint64
+	stored bool
+}
+
+// sendQueue holds the messages enqueued on an ASTMConnection until they are sent
+type sendQueue struct {
+	mutex    sync.Mutex
+	messages []queuedMessage
+	// store keeps the messages queued across restarts when set, see SetQueueStore
+	store QueueStore
+	// wakeUp tells the go routine sending the queued messages that one was enqueued
+	wakeUp  chan struct{}
+	options QueueOptions
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{wakeUp: make(chan struct{}, 1), options: queueOptionsWithDefaults(QueueOptions{})}
+}
+
+// SetQueueOptions tunes how the send queue retries the messages the receiver did not take. It has to be
+// called before Listen.
+func (astmConn *ASTMConnection) SetQueueOptions(options QueueOptions) {
+	queue := astmConn.queue
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.options = queueOptionsWithDefaults(options)
+}
+
+// Enqueue stores a message, one record per element, to be sent once the line is idle, in the order it was
+// enqueued in. The messages are sent by a go routine Listen starts, like SendMessage would, an instrument
+// sending meanwhile being received first, and done, when not nil, is called with the result of SendMessage.
+// A message the receiver did not take is sent again with backoff, see SetQueueOptions, done is called once it
+// was sent or given up. The messages still queued when the connection is lost are sent once it is connected
+// and listening again, and once the process restarted as well with a QueueStore, see SetQueueStore.
+// It is safe to call from any go routine, handlers included.
+func (astmConn *ASTMConnection) Enqueue(records []string, done func(err error)) {
+	queue := astmConn.queue
+	queue.mutex.Lock()
+	message := queuedMessage{records: records, done: done}
+	if queue.store != nil {
+		id, err := queue.store.Add(records, time.Now())
+		if err != nil {
+			queue.mutex.Unlock()
+			slog.Error("Failed to store a queued message. It is not queued.", "Error", err)
+			if done != nil {
+				done(fmt.Errorf("failed to store the queued message: %w", err))
+			}
+			return
+		}
+		message.id, message.stored = id, true
+	}
+	queue.messages = append(queue.messages, message)
+	queue.mutex.Unlock()
+	select {
+	case queue.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// QueueLength gives the number of messages enqueued not sent yet, the one being sent included
+func (astmConn *ASTMConnection) QueueLength() int {
+	queue := astmConn.queue
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.messages)
+}
+
+// sendQueued sends the messages enqueued one after the other until ctx is done, waiting for the line
+// to be idle when a message is being received
+func (astmConn *ASTMConnection) sendQueued(ctx context.Context) {
+	queue := astmConn.queue
+	// attempts counts the attempts to send the message at the head of the queue the receiver did not take
+	attempts := 0
+	for {
+		queue.mutex.Lock()
+		options := queue.options
+		if len(queue.messages) == 0 {
+			queue.mutex.Unlock()
+			select {
+			case <-queue.wakeUp:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		message := queue.messages[0]
+		queue.mutex.Unlock()
+
+		err := astmConn.SendMessage(ctx, message.records)
+		if errors.Is(err, ErrBusy) {
+			select {
+			case <-time.After(contentionPollInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			// disconnected, the message is sent again once connected
+			slog.Info("Disconnected while sending a queued message. It stays queued.")
+			return
+		}
+		if linkLost(err) {
+			// Listen stops the queue once it notices, a connection reconnecting by itself gets it sent again
+			slog.Info("Lost the link while sending a queued message. It stays queued.", "Error", err)
+			select {
+			case <-time.After(linkLostRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if notTaken(err) {
+			attempts++
+			if options.MaxAttempts == 0 || attempts < options.MaxAttempts {
+				// the analyzer may be offline, the message stays queued, and stored, until it takes it
+				backoff := options.RetryBackoff << min(attempts-1, 30)
+				if backoff <= 0 || backoff > options.MaxRetryBackoff {
+					backoff = options.MaxRetryBackoff
+				}
+				slog.Info("The receiver did not take a queued message. Sending it again later.", "Attempt", attempts, "Backoff", backoff, "Error", err)
+				select {
+				case <-time.After(backoff):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		attempts = 0
+		if err != nil {
+			slog.Error("Failed to send a queued message.", "Error", err)
+		}
+		queue.mutex.Lock()
+		queue.messages = queue.messages[1:]
+		if message.stored {
+			if err := queue.store.Remove(message.id); err != nil {
+				// it is sent again once the process restarted
+				slog.Error("Failed to remove a queued message from the store.", "Error", err)
+			}
+		}
+		queue.mutex.Unlock()
+		if message.done != nil {
+			message.done(err)
+		}
+	}
+}
+
+// linkLost tells whether sending failed because the transport is gone, the message is then kept queued
+func linkLost(err error) bool {
+	return errors.Is(err, connection.ErrNotConnected) || errors.Is(err, connection.ErrConnectionClosed) ||
+		errors.Is(err, connection.ErrPeerReset)
+}
+
+// notTaken tells whether sending failed because the receiver did not take the message, it is then sent again
+func notTaken(err error) bool {
+	return errors.Is(err, ErrReplyTimeout) || errors.Is(err, ErrTransmissionAborted)
+}
@@ -0,0 +1,242 @@
+package lis1a2
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StoredMessage is a message of the send queue kept by a QueueStore
+type StoredMessage struct {
+	// ID identifies the message in the store
+	ID uint64
+	// Records are the records of the message, one per element
+	Records []string
+	// EnqueuedAt is when the message was enqueued
+	EnqueuedAt time.Time
+}
+
+// QueueStore keeps the messages of the send queue, so that the messages enqueued for an analyzer which is
+// offline survive the restarts of the process. It can be implemented over bbolt, SQLite or any other store,
+// FileQueueStore keeps them in a file.
+type QueueStore interface {
+	// Load gives the messages stored, in the order they were enqueued
+	Load() ([]StoredMessage, error)
+	// Add stores a message enqueued, giving its ID
+	Add(records []string, enqueuedAt time.Time) (uint64, error)
+	// Remove removes a message once it was sent, or failed to be
+	Remove(id uint64) error
+}
+
+// SetQueueStore makes the send queue keep its messages in store: the messages stored are enqueued again,
+// first, and every message enqueued is stored until it was sent or failed to be. Enqueue calls done with the
+// error of the store, instead of enqueuing the message, when it fails to store it. The messages enqueued again
+// have no done callback. It has to be called before Listen and Enqueue.
+func (astmConn *ASTMConnection) SetQueueStore(store QueueStore) error {
+	stored, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load the queued messages: %w", err)
+	}
+	queue := astmConn.queue
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.store = store
+	restored := make([]queuedMessage, 0, len(stored)+len(queue.messages))
+	for _, message := range stored {
+		restored = append(restored, queuedMessage{records: message.Records, id: message.ID, stored: true})
+	}
+	queue.messages = append(restored, queue.messages...)
+	select {
+	case queue.wakeUp <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// FileQueueStore is a QueueStore appending to a file, one JSON object per line adding or removing a message:
+//
+//	{"op":"add","id":1,"time":"2024-01-01T12:30:00Z","records":["H|\\^&","O|1|SID001||^^^GLU","L|1|N"]}
+//	{"op":"remove","id":1}
+//
+// Every line is synced to the disk before Add or Remove return. The file is compacted when opened, keeping the
+// messages not removed, and a last line cut short by a crash is skipped.
+type FileQueueStore struct {
+	path    string
+	mutex   sync.Mutex
+	file    *os.File
+	lastID  uint64
+	pending map[uint64]StoredMessage
+}
+
+// queueStoreLine is the JSON shape of a line of a FileQueueStore
+type queueStoreLine struct {
+	Op      string     `json:"op"`
+	ID      uint64     `json:"id"`
+	Time    *time.Time `json:"time,omitempty"`
+	Records []string   `json:"records,omitempty"`
+}
+
+// OpenFileQueueStore opens the store kept in the file at path, creating the file if it does not exist
+func OpenFileQueueStore(path string) (*FileQueueStore, error) {
+	store := &FileQueueStore{path: path, pending: make(map[uint64]StoredMessage)}
+	if err := store.read(); err != nil {
+		return nil, fmt.Errorf("failed to open queue store: %w", err)
+	}
+	if err := store.compact(); err != nil {
+		return nil, fmt.Errorf("failed to open queue store: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue store: %w", err)
+	}
+	store.file = file
+	return store, nil
+}
+
+// read reads the messages not removed from the file, if it exists
+func (store *FileQueueStore) read() error {
+	file, err := os.Open(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without its LF was cut short by a crash
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var decoded queueStoreLine
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			return fmt.Errorf("line %v of the queue store is invalid: %w", number, err)
+		}
+		store.lastID = max(store.lastID, decoded.ID)
+		switch decoded.Op {
+		case "add":
+			message := StoredMessage{ID: decoded.ID, Records: decoded.Records}
+			if decoded.Time != nil {
+				message.EnqueuedAt = *decoded.Time
+			}
+			store.pending[decoded.ID] = message
+		case "remove":
+			delete(store.pending, decoded.ID)
+		default:
+			return fmt.Errorf("line %v of the queue store is invalid: unknown op %q", number, decoded.Op)
+		}
+	}
+}
+
+// compact rewrites the file with the messages not removed only, replacing it once written
+func (store *FileQueueStore) compact() error {
+	messages, _ := store.Load()
+	compacted, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(compacted.Name())
+	// CreateTemp leaves the file readable by its owner only, the file replaced keeps its mode
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(store.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := compacted.Chmod(mode); err != nil {
+		_ = compacted.Close()
+		return err
+	}
+	writer := bufio.NewWriter(compacted)
+	for _, message := range messages {
+		line, err := json.Marshal(queueStoreLine{Op: "add", ID: message.ID, Time: &message.EnqueuedAt, Records: message.Records})
+		if err != nil {
+			_ = compacted.Close()
+			return err
+		}
+		_, _ = writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		_ = compacted.Close()
+		return err
+	}
+	if err := compacted.Sync(); err != nil {
+		_ = compacted.Close()
+		return err
+	}
+	if err := compacted.Close(); err != nil {
+		return err
+	}
+	return os.Rename(compacted.Name(), store.path)
+}
+
+// Load gives the messages stored, in the order they were enqueued
+func (store *FileQueueStore) Load() ([]StoredMessage, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	messages := make([]StoredMessage, 0, len(store.pending))
+	for _, message := range store.pending {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// Add appends a message to the file, safe for concurrent use
+func (store *FileQueueStore) Add(records []string, enqueuedAt time.Time) (uint64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	id := store.lastID + 1
+	if err := store.append(queueStoreLine{Op: "add", ID: id, Time: &enqueuedAt, Records: records}); err != nil {
+		return 0, err
+	}
+	store.lastID = id
+	store.pending[id] = StoredMessage{ID: id, Records: records, EnqueuedAt: enqueuedAt}
+	return id, nil
+}
+
+// Remove appends the removal of a message to the file, safe for concurrent use
+func (store *FileQueueStore) Remove(id uint64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.append(queueStoreLine{Op: "remove", ID: id}); err != nil {
+		return err
+	}
+	delete(store.pending, id)
+	return nil
+}
+
+// append writes a line at the end of the file and syncs it to the disk
+func (store *FileQueueStore) append(entry queueStoreLine) error {
+	if store.file == nil {
+		return errors.New("queue store is closed")
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := store.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return store.file.Sync()
+}
+
+// Close closes the file, adding and removing fail afterwards
+func (store *FileQueueStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
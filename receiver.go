@@ -0,0 +1,411 @@
+package lis1a2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// defaultReceiveTimeout is how long the receiver waits for the next frame or EOT by default
+const defaultReceiveTimeout = 30 * time.Second
+
+// ErrReceiveTimeout is delivered instead of a message when the sender did not send the next frame
+// or EOT in time, the receiver then discarded what it received and returned to idle
+var ErrReceiveTimeout = errors.New("no frame from the sender")
+
+// ErrFrameSequence is delivered instead of a message in which a frame carried a frame number out of
+// sequence, the frame was NAKed
+var ErrFrameSequence = errors.New("frame number out of sequence")
+
+// ErrIncompleteRecord is delivered instead of a message which ended with EOT right after an intermediate
+// frame, the rest of the record split over the frames never came
+var ErrIncompleteRecord = errors.New("message ended in the middle of a record")
+
+// ErrMessageRejected is delivered instead of a message the confirmation handler rejected, see Receiver.OnConfirm
+var ErrMessageRejected = errors.New("message rejected by the application")
+
+// defaultBusyAfterRejection is how long the receiver NAKs ENQ after a message was rejected by default, the
+// interval after which the standard has a sender try again
+const defaultBusyAfterRejection = 10 * time.Second
+
+// Compatibility tells how closely the frames received have to follow the standard to be accepted
+type Compatibility int
+
+const (
+	// Strict NAKs every frame which does not follow the standard
+	Strict Compatibility = iota
+	// Tolerant also accepts the frames of older analyzers which leave the checksum out or terminate the
+	// frame with LF only, see protocol.DecodeTolerantFrame. A checksum sent still has to match.
+	Tolerant
+)
+
+// ReceiverOptions tunes a Receiver, a field left at its zero value keeps its default
+type ReceiverOptions struct {
+	// ReceiveTimeout is how long the sender has to send the next frame or EOT, defaults to 30 seconds
+	ReceiveTimeout time.Duration
+	// Compatibility tells which frames are accepted, defaults to Strict
+	Compatibility Compatibility
+	// WarnOnQuirks logs every frame accepted by Tolerant although it does not follow the standard as a
+	// warning instead of at the debug level
+	WarnOnQuirks bool
+	// BusyAfterRejection is how long ENQ is NAKed, the receiver being busy, after the confirmation handler
+	// rejected a message, defaults to 10 seconds
+	BusyAfterRejection time.Duration
+}
+
+// receiverOptionsWithDefaults takes the first options given, filling in the defaults of the fields left unset
+func receiverOptionsWithDefaults(options []ReceiverOptions) ReceiverOptions {
+	merged := ReceiverOptions{}
+	if len(options) > 0 {
+		merged = options[0]
+	}
+	if merged.ReceiveTimeout <= 0 {
+		merged.ReceiveTimeout = defaultReceiveTimeout
+	}
+	if merged.BusyAfterRejection <= 0 {
+		merged.BusyAfterRejection = defaultBusyAfterRejection
+	}
+	return merged
+}
+
+// receiverLink is what the Receiver needs from the connection it receives over
+type receiverLink interface {
+	write(data []byte) error
+	currentStatus() constants.LIS1A2ConnectionStatus
+	setStatus(status constants.LIS1A2ConnectionStatus)
+	// compareAndSetStatus changes the state only if it is still old, telling whether it did, so that the
+	// sending and the receiving side cannot both take the line
+	compareAndSetStatus(old constants.LIS1A2ConnectionStatus, status constants.LIS1A2ConnectionStatus) bool
+	// reportProgress tells the phase of the protocol reached, with the frame exchanged and its retries, see State
+	reportProgress(phase Phase, frameNumber int, retries int)
+}
+
+// Receiver runs the receiving side of the LIS1-A2 link layer: it answers ENQ with ACK, checks
+// every frame, ACKing it or NAKing a corrupt or out of sequence one, reassembles the records split
+// over intermediate frames, and delivers the message, one record per line, once EOT arrives.
+// A message ending in the middle of a record is failed with ErrIncompleteRecord.
+// A frame carrying the number of the frame accepted last is a retransmission, sent because our ACK
+// got lost, it is ACKed again and its text discarded. When the sender goes quiet for ReceiveTimeout
+// in the middle of a message, the message is failed with ErrReceiveTimeout.
+type Receiver struct {
+	link      receiverLink
+	options   ReceiverOptions
+	onMessage func(message string, err error)
+	// confirm accepts or rejects a message before the frame of its terminator record is ACKed, see OnConfirm
+	confirm func(message string) error
+	// confirmed tells whether confirm accepted the message being received
+	confirmed bool
+	// rejected is the error confirm rejected the message being received with
+	rejected error
+	// busyUntil is when the receiver takes ENQ again after a message was rejected
+	busyUntil time.Time
+	// receivedFrameNumber is the number of the frame accepted last, zero right after ENQ
+	receivedFrameNumber int
+	// frameAccepted tells whether a frame was accepted since ENQ, before that there is nothing to retransmit
+	frameAccepted bool
+	// framesNAKed counts the frames NAKed since the frame accepted last
+	framesNAKed int
+	// interruptRequested makes the next frame accepted be answered with EOT instead of ACK
+	interruptRequested atomic.Bool
+	receiveErr         error
+	buffer             []byte
+	recordBuffer       string
+	messageBuffer      string
+}
+
+// NewReceiver creates a Receiver over the connection, which has to be listening, calling onMessage
+// with every message received or the error which made it fail, like a frame number out of sequence.
+// onMessage runs on the go routine calling Listen. An ASTMConnection receives through its own
+// Receiver, see ReadMessage. It is optionally tuned by options.
+func NewReceiver(conn connection.Connection, onMessage func(message string, err error), options ...ReceiverOptions) *Receiver {
+	return &Receiver{
+		link:      &connectionLink{connectionReader: connectionReader{connection: conn}, status: constants.Idle},
+		options:   receiverOptionsWithDefaults(options),
+		onMessage: onMessage,
+		buffer:    make([]byte, 0),
+	}
+}
+
+// OnConfirm makes the receiver hand every message over to confirm before it accepts it, once the frame of its
+// terminator record arrives, ACKing that frame only when confirm returns nil, so that a message is never taken
+// from the instrument without the application storing it. When confirm fails the frame is NAKed, the sender
+// sends it again and confirm runs again, and once the sender gives up the message is failed with
+// ErrMessageRejected wrapping the error of confirm, ENQ being NAKed for ReceiverOptions.BusyAfterRejection so
+// that the instrument sends the message again later. A message without terminator record is confirmed at EOT,
+// the instrument took it as delivered then, a rejection only fails it and makes the receiver busy.
+// confirm runs on the go routine calling Listen and has to return well within the 15 seconds the sender waits
+// for the reply to a frame. It has to be called before Listen.
+func (receiver *Receiver) OnConfirm(confirm func(message string) error) {
+	receiver.confirm = confirm
+}
+
+// Listen reads from the connection and answers the sender until ctx is done, returning ctx.Err(),
+// or reading from the connection fails, returning the error
+func (receiver *Receiver) Listen(ctx context.Context) error {
+	// the Receivers of ASTMConnections are driven by their Listen, only NewReceiver ones get here
+	link := receiver.link.(*connectionLink)
+	for {
+		data, err := receiver.read(ctx, &link.connectionReader)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, connection.ErrChecksumMismatch) && receiver.tolerates(data) {
+			// a frame of an older analyzer which Tolerant accepts all the same
+			err = nil
+		}
+		if errors.Is(err, ErrReceiveTimeout) {
+			continue
+		} else if errors.Is(err, connection.ErrChecksumMismatch) {
+			// the corrupt frame is still handed over so that it gets NAKed
+			slog.Debug("Received frame with checksum mismatch.", "Error", err)
+		} else if errors.Is(err, connection.ErrIncompleteFrame) || errors.Is(err, connection.ErrReadOverflow) {
+			slog.Error("Dropped incomplete data.", "Error", err, "Data", data)
+			continue
+		} else if err != nil {
+			return err
+		}
+		receiver.receive(data)
+	}
+}
+
+// read reads from the connection until data arrives or ctx is done. In the middle of a message it
+// gives up after ReceiveTimeout, failing the message and returning to idle, and returns ErrReceiveTimeout.
+func (receiver *Receiver) read(ctx context.Context, reader *connectionReader) ([]byte, error) {
+	if receiver.link.currentStatus() != constants.Receiving {
+		return reader.read(ctx)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, receiver.options.ReceiveTimeout)
+	defer cancel()
+	data, err := reader.read(timeoutCtx)
+	if ctx.Err() == nil && timeoutCtx.Err() != nil {
+		slog.Error("No frame received in time. Going to Idle state.", "Timeout", receiver.options.ReceiveTimeout)
+		receiver.fail(fmt.Errorf("%w after %v", ErrReceiveTimeout, receiver.options.ReceiveTimeout))
+		return nil, ErrReceiveTimeout
+	}
+	return data, err
+}
+
+// RequestInterrupt asks the sender to stop, the next frame received is acknowledged with EOT instead of ACK.
+// The sender may carry on with the message regardless, it is still received then.
+// It is safe to call from any go routine.
+func (receiver *Receiver) RequestInterrupt() {
+	receiver.interruptRequested.Store(true)
+}
+
+// receive handles data read from the connection, any number of bytes of it
+func (receiver *Receiver) receive(data []byte) {
+	for _, singleByte := range data {
+		if receiver.handleByte(singleByte) {
+			return
+		}
+	}
+}
+
+// handleByte handles a single byte received in the Idle or Receiving state,
+// it returns true once the message ended and the rest of the data should be dropped
+func (receiver *Receiver) handleByte(singleByte byte) bool {
+	switch receiver.link.currentStatus() {
+	case constants.Idle:
+		if singleByte != constants.ENQ {
+			// the neutral state ignores everything but ENQ, like a late ACK or noise on the line
+			slog.Debug("Ignoring a byte received in Idle state.", "Byte", singleByte)
+		} else if time.Now().Before(receiver.busyUntil) {
+			slog.Info("Received ENQ while busy after a message was rejected. Sending NAK.", "Busy until", receiver.busyUntil)
+			receiver.writeControlByte(constants.NAK)
+		} else if !receiver.link.compareAndSetStatus(constants.Idle, constants.Receiving) {
+			slog.Debug("Received ENQ while the sending side took the line. Leaving it to the sender.")
+		} else {
+			slog.Info("Received ENQ in Idle state. Sending ACK.")
+			receiver.writeControlByte(constants.ACK)
+			receiver.receivedFrameNumber = 0
+			receiver.frameAccepted = false
+			receiver.framesNAKed = 0
+		}
+	case constants.Receiving:
+		if singleByte == constants.ENQ {
+			receiver.writeControlByte(constants.NAK)
+		} else if singleByte != constants.EOT {
+			if singleByte != constants.NUL {
+				receiver.buffer = append(receiver.buffer, singleByte)
+			}
+			if singleByte == constants.LF {
+				receivedFrame := string(receiver.buffer)
+				receiver.buffer = make([]byte, 0)
+				receiver.frameReceived(receivedFrame)
+			}
+		} else {
+			slog.Debug("Received EOT in Receiving state. Going to Idle state.")
+			receiver.endOfMessage()
+			return true
+		}
+	default:
+		slog.Error("In incorrect state.", "Skipping byte", singleByte)
+	}
+	return false
+}
+
+// frameReceived checks a complete frame and ACKs it, adding its text to the message, or NAKs it
+func (receiver *Receiver) frameReceived(receivedFrame string) {
+	frame, valid := receiver.decode(receivedFrame)
+	if !valid {
+		slog.Error("Checksum did not match. Sending NAK.")
+		receiver.writeControlByte(constants.NAK)
+		receiver.frameNAKed()
+	} else if frameNumber := receivedFrame[1]; receiver.isRetransmission(frameNumber) {
+		slog.Info("Received a retransmission of the last frame. Sending ACK and discarding it.", "Frame number", string(frameNumber))
+		receiver.writeControlByte(constants.ACK)
+	} else if frameNumber != receiver.expectedFrameNumber() {
+		slog.Error("Frame number out of sequence. Sending NAK.", "Received", string(frameNumber), "Expected", string(receiver.expectedFrameNumber()))
+		receiver.writeControlByte(constants.NAK)
+		receiver.receiveErr = fmt.Errorf("%w: received %c, expected %c", ErrFrameSequence, frameNumber, receiver.expectedFrameNumber())
+		receiver.frameNAKed()
+	} else if !receiver.confirmTerminator(frame) {
+		slog.Error("Message rejected by the application. Sending NAK.", "Error", receiver.rejected)
+		receiver.writeControlByte(constants.NAK)
+		receiver.frameNAKed()
+	} else {
+		if receiver.interruptRequested.CompareAndSwap(true, false) {
+			slog.Info("Checksum ok. Sending EOT to interrupt the sender.")
+			receiver.writeControlByte(constants.EOT)
+		} else {
+			slog.Debug("Checksum ok. Sending ACK.")
+			receiver.writeControlByte(constants.ACK)
+		}
+		receiver.receivedFrameNumber = (receiver.receivedFrameNumber + 1) % 8
+		receiver.frameAccepted = true
+		receiver.framesNAKed = 0
+		receiver.link.reportProgress(PhaseReceiving, receiver.receivedFrameNumber, 0)
+		receiver.recordBuffer += string(frame.Text)
+		if frame.IsLast() {
+			receiver.messageBuffer += receiver.recordBuffer + "\n"
+			receiver.recordBuffer = ""
+		}
+	}
+}
+
+// frameNAKed counts a frame NAKed and reports it along with the frame accepted last
+func (receiver *Receiver) frameNAKed() {
+	receiver.framesNAKed++
+	receiver.link.reportProgress(PhaseReceiving, receiver.receivedFrameNumber, receiver.framesNAKed)
+}
+
+// confirmTerminator runs confirm on the message once the frame given ends its terminator record, telling
+// whether the frame is to be accepted
+func (receiver *Receiver) confirmTerminator(frame protocol.Frame) bool {
+	record := receiver.recordBuffer + string(frame.Text)
+	if receiver.confirm == nil || !frame.IsLast() || len(record) == 0 || record[0] != 'L' {
+		return true
+	}
+	if err := receiver.confirm(receiver.messageBuffer + record + "\n"); err != nil {
+		receiver.rejected = err
+		receiver.busyUntil = time.Now().Add(receiver.options.BusyAfterRejection)
+		return false
+	}
+	receiver.rejected = nil
+	receiver.confirmed = true
+	return true
+}
+
+// decode decodes a complete frame, telling whether it is valid: it follows the standard and its checksum
+// matches, or Tolerant accepts it
+func (receiver *Receiver) decode(receivedFrame string) (protocol.Frame, bool) {
+	frame, err := protocol.DecodeFrame([]byte(receivedFrame))
+	if err == nil && frame.ChecksumValid {
+		return frame, true
+	}
+	if receiver.options.Compatibility == Tolerant {
+		frame, quirks, err := protocol.DecodeTolerantFrame([]byte(receivedFrame))
+		if err == nil && frame.ChecksumValid {
+			log := slog.Debug
+			if receiver.options.WarnOnQuirks {
+				log = slog.Warn
+			}
+			log("Accepted a frame which does not follow the standard.", "Missing checksum", quirks&protocol.QuirkMissingChecksum != 0,
+				"LF only", quirks&protocol.QuirkLFOnly != 0, "Frame", receivedFrame)
+			return frame, true
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("%w: received %s", protocol.ErrChecksumMismatch, frame.Checksum[:])
+	}
+	slog.Error("Checking checksum. Given frame is invalid.", "Error", err)
+	return protocol.Frame{}, false
+}
+
+// tolerates tells whether a frame the connection found malformed or corrupt is accepted by Tolerant
+func (receiver *Receiver) tolerates(data []byte) bool {
+	if receiver.options.Compatibility != Tolerant {
+		return false
+	}
+	frame, _, err := protocol.DecodeTolerantFrame(data)
+	return err == nil && frame.ChecksumValid
+}
+
+// endOfMessage delivers the message received, or the error which made it fail, and returns to idle
+func (receiver *Receiver) endOfMessage() {
+	if receiver.receiveErr == nil && len(receiver.recordBuffer) != 0 {
+		slog.Error("Message ended in the middle of a record.", "Partial record", receiver.recordBuffer)
+		receiver.receiveErr = ErrIncompleteRecord
+	}
+	if receiver.receiveErr == nil && receiver.rejected != nil {
+		receiver.receiveErr = fmt.Errorf("%w: %w", ErrMessageRejected, receiver.rejected)
+	}
+	if receiver.receiveErr == nil && receiver.messageBuffer != "" && receiver.confirm != nil && !receiver.confirmed {
+		// without terminator record the message is confirmed once the sender took it as delivered
+		if err := receiver.confirm(receiver.messageBuffer); err != nil {
+			slog.Error("Message rejected by the application.", "Error", err)
+			receiver.busyUntil = time.Now().Add(receiver.options.BusyAfterRejection)
+			receiver.receiveErr = fmt.Errorf("%w: %w", ErrMessageRejected, err)
+		}
+	}
+	if receiver.receiveErr != nil {
+		receiver.fail(receiver.receiveErr)
+		return
+	}
+	receiver.confirmed = false
+	if len(receiver.messageBuffer) != 0 {
+		receiver.onMessage(receiver.messageBuffer, nil)
+		receiver.messageBuffer = ""
+	}
+	receiver.link.setStatus(constants.Idle)
+	slog.Debug("State changed to Idle.")
+}
+
+// fail delivers the error which made the message fail, discards what was received of it and returns to idle
+func (receiver *Receiver) fail(err error) {
+	receiver.onMessage("", err)
+	receiver.receiveErr = nil
+	receiver.confirmed = false
+	receiver.rejected = nil
+	receiver.buffer = make([]byte, 0)
+	receiver.recordBuffer = ""
+	receiver.messageBuffer = ""
+	receiver.frameAccepted = false
+	receiver.link.setStatus(constants.Idle)
+	slog.Debug("State changed to Idle.")
+}
+
+// expectedFrameNumber gives the frame number the next received frame should carry, as a character
+func (receiver *Receiver) expectedFrameNumber() byte {
+	return byte('0' + (receiver.receivedFrameNumber+1)%8)
+}
+
+// isRetransmission tells whether a frame number, as a character, is the one of the frame accepted last
+func (receiver *Receiver) isRetransmission(frameNumber byte) bool {
+	return receiver.frameAccepted && frameNumber == byte('0'+receiver.receivedFrameNumber)
+}
+
+// writeControlByte writes a single control byte like ACK or NAK, a failure is only logged
+// as the peer times out waiting for it anyway
+func (receiver *Receiver) writeControlByte(controlByte byte) {
+	if err := receiver.link.write([]byte{controlByte}); err != nil {
+		slog.Error("Failed to write control byte.", "Byte", controlByte, "Error", err)
+	}
+}
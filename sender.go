@@ -0,0 +1,500 @@
+package lis1a2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// establishmentTimeout is how long the sender waits for the receiver to reply to an ENQ
+const establishmentTimeout = 15 * time.Second
+
+// defaultFrameReplyTimeout is how long the sender waits for the receiver to reply to a frame by default
+const defaultFrameReplyTimeout = 30 * time.Second
+
+// instrumentContentionWait is how long an instrument waits before sending ENQ again on contention
+const instrumentContentionWait = time.Second
+
+// defaultBusyBackoff is the least the sender has to wait before sending ENQ again to a busy receiver
+const defaultBusyBackoff = 10 * time.Second
+
+// defaultContentionBackoff is the least a computer system has to wait after yielding to the instrument
+const defaultContentionBackoff = 20 * time.Second
+
+// contentionPollInterval is how often a computer system which yielded checks the line is idle again
+const contentionPollInterval = 100 * time.Millisecond
+
+// ErrReplyTimeout is returned when the receiver did not reply to an ENQ or a frame in time,
+// the sender then sent EOT and gave the message up
+var ErrReplyTimeout = errors.New("no reply from the receiver")
+
+// ErrEstablishmentTimeout is returned when the receiver did not reply to ENQ in time, it wraps ErrReplyTimeout
+var ErrEstablishmentTimeout = fmt.Errorf("%w to ENQ", ErrReplyTimeout)
+
+// ErrReceiverBusy is returned along with ErrTransmissionAborted when the receiver answered the last ENQ
+// with NAK, busy for all the attempts
+var ErrReceiverBusy = errors.New("receiver busy")
+
+// ErrTransmissionAborted is returned when ENQ or a frame was not ACKed after MaxAttempts attempts,
+// the sender then sent EOT and gave the message up
+var ErrTransmissionAborted = errors.New("transmission aborted")
+
+// ErrInterrupted is returned when the receiver asked to interrupt the transfer, replying EOT to a frame,
+// and InterruptImmediately made the sender stop before the end of the message
+var ErrInterrupted = errors.New("transfer interrupted by the receiver")
+
+// ErrBusy is returned when a message is to be sent while one is being received, the line is taken
+// until the peer ends its message with EOT
+var ErrBusy = errors.New("line busy receiving a message")
+
+// InterruptPolicy decides what the sender does when the receiver replies EOT to a frame, which
+// acknowledges the frame and asks the sender to stop
+type InterruptPolicy int
+
+const (
+	// InterruptAfterMessage sends the rest of the message before terminating as usual
+	InterruptAfterMessage InterruptPolicy = iota
+	// InterruptImmediately terminates right after the frame, the rest of the message is not sent
+	InterruptImmediately
+)
+
+// SenderOptions tunes a Sender, a field left at its zero value keeps its default
+type SenderOptions struct {
+	// MaxAttempts is the number of times ENQ or a frame is sent, the first time included, before the
+	// transmission is aborted, defaults to MaxSendAttempts
+	MaxAttempts int
+	// BusyBackoff is how long the sender waits before sending ENQ again when the receiver answers it
+	// with NAK because it is busy, defaults to 10 seconds
+	BusyBackoff time.Duration
+	// FrameReplyTimeout is how long the receiver has to ACK or NAK a frame, defaults to 30 seconds
+	FrameReplyTimeout time.Duration
+	// MaxFrameSize is the most characters of text a frame carries, longer records are split over
+	// intermediate frames, defaults to the standard MaxFrameSize of 240
+	MaxFrameSize int
+	// InterruptPolicy applies when the receiver asks to interrupt the transfer, defaults to InterruptAfterMessage
+	InterruptPolicy InterruptPolicy
+	// Role decides who backs off when both sides send ENQ at once, defaults to Instrument
+	Role constants.Role
+	// ContentionBackoff is how long a ComputerSystem waits after yielding to the instrument before
+	// sending ENQ again, at least until the message of the instrument is received, defaults to 20 seconds
+	ContentionBackoff time.Duration
+}
+
+// senderOptionsWithDefaults takes the first options given, filling in the defaults of the fields left unset
+func senderOptionsWithDefaults(options []SenderOptions) SenderOptions {
+	merged := SenderOptions{}
+	if len(options) > 0 {
+		merged = options[0]
+	}
+	if merged.MaxAttempts <= 0 {
+		merged.MaxAttempts = constants.MaxSendAttempts
+	}
+	if merged.MaxFrameSize <= 0 {
+		merged.MaxFrameSize = constants.MaxFrameSize
+	}
+	if merged.BusyBackoff <= 0 {
+		merged.BusyBackoff = defaultBusyBackoff
+	}
+	if merged.FrameReplyTimeout <= 0 {
+		merged.FrameReplyTimeout = defaultFrameReplyTimeout
+	}
+	if merged.ContentionBackoff <= 0 {
+		merged.ContentionBackoff = defaultContentionBackoff
+	}
+	return merged
+}
+
+// senderLink is what the Sender needs from the connection it sends over: the way to write,
+// the replies of the receiver and the state shared with the receiving side
+type senderLink interface {
+	receiverLink
+	// awaitReply gives the next control byte received, waiting at most timeout
+	awaitReply(ctx context.Context, timeout time.Duration) (byte, error)
+	// discardReply drops a reply which arrived after awaitReply gave up on it
+	discardReply()
+	frameSent(raw string)
+	// peerBusy tells that the receiver answered ENQ with NAK
+	peerBusy()
+	// frameNAKed tells that the receiver answered a frame with NAK, on the attempt given
+	frameNAKed(raw string, attempt int)
+}
+
+// Sender runs the sending side of the LIS1-A2 link layer, one phase after the other:
+// the establishment phase sends ENQ until the receiver ACKs it, the transfer phase sends every
+// record as numbered frames, each ACKed before the next one, and the termination phase sends EOT.
+// A NAKed ENQ or frame is sent again, after MaxAttempts attempts the exchange is terminated and
+// ErrTransmissionAborted returned. The receiver has 15 seconds to reply to ENQ and FrameReplyTimeout
+// to reply to a frame, past that the exchange is terminated and ErrReplyTimeout returned.
+// A frame answered with EOT is acknowledged, the receiver asking to interrupt the transfer, see InterruptPolicy.
+type Sender struct {
+	link         senderLink
+	options      SenderOptions
+	frameBuilder *protocol.FrameBuilder
+	// interrupted tells whether the receiver asked to interrupt the transfer in progress
+	interrupted bool
+}
+
+// NewSender creates a Sender over the connection, which has to be listening. The Sender reads the
+// replies of the receiver from the connection itself, nothing else may read from it while sending,
+// an ASTMConnection sends through its own Sender with SendMessage instead.
+// It is optionally tuned by options.
+func NewSender(conn connection.Connection, options ...SenderOptions) *Sender {
+	return &Sender{
+		link:    &connectionLink{connectionReader: connectionReader{connection: conn}, status: constants.Idle},
+		options: senderOptionsWithDefaults(options),
+	}
+}
+
+// SendMessage sends an ASTM Message, one record per line, running the establishment, transfer and
+// termination phases. The returned error tells which phase failed, once ctx is done the exchange
+// is terminated with EOT and ctx.Err() is returned wrapped in it.
+func (sender *Sender) SendMessage(ctx context.Context, message []byte) error {
+	var records []string
+	for _, record := range strings.Split(string(message), "\n") {
+		records = append(records, strings.TrimSuffix(record, "\r"))
+	}
+	return sender.SendRecords(ctx, records)
+}
+
+// SendRecords sends the records of an ASTM Message like SendMessage, empty records are skipped
+func (sender *Sender) SendRecords(ctx context.Context, records []string) error {
+	if err := sender.establish(ctx); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if len(record) == 0 {
+			continue
+		}
+		if err := sender.sendRecord(ctx, record); err != nil {
+			return err
+		}
+	}
+	sender.terminate()
+	return nil
+}
+
+// establish sends ENQ until the receiver ACKs it, retrying BusyBackoff after a NAK, which means the receiver is busy.
+// After MaxAttempts failed attempts it gives up, sends EOT and returns to idle.
+// An ENQ received instead of a reply means both sides want to send, see resolveContention.
+func (sender *Sender) establish(ctx context.Context) error {
+	sender.frameBuilder = protocol.NewFrameBuilderWithMaxFrameSize(sender.options.MaxFrameSize)
+	sender.interrupted = false
+	if !sender.link.compareAndSetStatus(constants.Idle, constants.Establishing) {
+		if sender.link.currentStatus() == constants.Receiving {
+			slog.Info("Line busy receiving a message when trying to establish send mode.")
+			return fmt.Errorf("establishment phase failed: %w", ErrBusy)
+		}
+		slog.Error("Connection not in idle when trying to establish send mode.")
+		return errors.New("establishment phase failed: connection not in idle")
+	}
+	slog.Debug("Establishing send mode.")
+	busy := false
+	for attempt := 1; attempt <= sender.options.MaxAttempts; attempt++ {
+		sender.link.discardReply()
+		sender.link.reportProgress(PhaseEstablishing, 0, attempt-1)
+		if err := sender.link.write([]byte{constants.ENQ}); err != nil {
+			sender.link.setStatus(constants.Idle)
+			return fmt.Errorf("establishment phase failed: %w", err)
+		}
+		slog.Debug("Sent ENQ.", "Attempt", attempt)
+		reply, err := sender.link.awaitReply(ctx, establishmentTimeout)
+		if ctx.Err() != nil {
+			sender.terminate()
+			return fmt.Errorf("establishment phase failed: %w", ctx.Err())
+		}
+		if errors.Is(err, ErrReplyTimeout) {
+			slog.Error("No reply to ENQ.", "Timeout", establishmentTimeout)
+			sender.terminate()
+			return fmt.Errorf("establishment phase failed: %w after %v", ErrEstablishmentTimeout, establishmentTimeout)
+		}
+		if err == nil && reply == constants.ACK {
+			sender.link.setStatus(constants.Sending)
+			slog.Debug("Changing status to sending.")
+			return nil
+		}
+		busy = err == nil && reply == constants.NAK
+		if busy {
+			slog.Info("Receiver busy.", "Attempt", attempt, "Backoff", sender.options.BusyBackoff)
+			sender.link.peerBusy()
+			if attempt == sender.options.MaxAttempts {
+				break
+			}
+			if err := sender.wait(ctx, sender.options.BusyBackoff); err != nil {
+				sender.terminate()
+				return fmt.Errorf("establishment phase failed: %w", err)
+			}
+		}
+		if err == nil && reply == constants.ENQ {
+			if err := sender.resolveContention(ctx); err != nil {
+				return fmt.Errorf("establishment phase failed: %w", err)
+			}
+		}
+	}
+	slog.Error("Could not establish send mode.")
+	sender.terminate()
+	if busy {
+		return fmt.Errorf("%w: establishment phase failed after %v attempts: %w", ErrTransmissionAborted, sender.options.MaxAttempts, ErrReceiverBusy)
+	}
+	return fmt.Errorf("%w: establishment phase failed after %v attempts", ErrTransmissionAborted, sender.options.MaxAttempts)
+}
+
+// probe sends ENQ once, without a message to send, and ends with EOT, to tell whether the receiver answers within
+// timeout. An ENQ of the receiver, which wants to send, leaves the line to it without EOT.
+// It returns ErrBusy wrapped when the line is in use.
+func (sender *Sender) probe(ctx context.Context, timeout time.Duration) error {
+	if !sender.link.compareAndSetStatus(constants.Idle, constants.Establishing) {
+		return fmt.Errorf("probe failed: %w", ErrBusy)
+	}
+	sender.link.discardReply()
+	if err := sender.link.write([]byte{constants.ENQ}); err != nil {
+		sender.link.setStatus(constants.Idle)
+		return fmt.Errorf("probe failed: %w", err)
+	}
+	reply, err := sender.link.awaitReply(ctx, timeout)
+	switch {
+	case ctx.Err() != nil:
+		sender.terminate()
+		return fmt.Errorf("probe failed: %w", ctx.Err())
+	case errors.Is(err, ErrReplyTimeout):
+		slog.Error("No reply to the ENQ probing the line.", "Timeout", timeout)
+		sender.terminate()
+		return fmt.Errorf("probe failed: %w after %v", ErrEstablishmentTimeout, timeout)
+	case err != nil:
+		sender.link.setStatus(constants.Idle)
+		return fmt.Errorf("probe failed: %w", err)
+	case reply == constants.ENQ:
+		slog.Debug("Contention while probing the line. Leaving it to the peer.")
+		sender.link.setStatus(constants.Idle)
+		return nil
+	}
+	sender.terminate()
+	return nil
+}
+
+// resolveContention backs off after receiving ENQ while establishing, the instrument has priority:
+// an Instrument waits a second before sending ENQ again, a ComputerSystem returns to idle, so that
+// the ENQ the instrument sends again is ACKed and its message received, and waits ContentionBackoff,
+// at least until the line is idle again.
+func (sender *Sender) resolveContention(ctx context.Context) error {
+	if sender.options.Role == constants.Instrument {
+		slog.Debug("Contention detected. Sending ENQ again in a second.")
+		if err := sender.wait(ctx, instrumentContentionWait); err != nil {
+			sender.terminate()
+			return err
+		}
+		return nil
+	}
+	slog.Info("Contention detected. Yielding to the instrument.", "Backoff", sender.options.ContentionBackoff)
+	sender.link.setStatus(constants.Idle)
+	if err := sender.wait(ctx, sender.options.ContentionBackoff); err != nil {
+		return err
+	}
+	for !sender.link.compareAndSetStatus(constants.Idle, constants.Establishing) {
+		if err := sender.wait(ctx, contentionPollInterval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for duration, ignoring what the receiver sends meanwhile, and returns early with an error
+// once ctx is done or the link is lost
+func (sender *Sender) wait(ctx context.Context, duration time.Duration) error {
+	end := time.Now().Add(duration)
+	for remaining := duration; remaining > 0; remaining = time.Until(end) {
+		if _, err := sender.link.awaitReply(ctx, remaining); err != nil && !errors.Is(err, ErrReplyTimeout) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRecord sends a single ASTM Record as one or more frames
+func (sender *Sender) sendRecord(ctx context.Context, record string) error {
+	for _, frame := range sender.frameBuilder.Frames(record) {
+		if err := sender.sendFrame(ctx, frame); err != nil {
+			return err
+		}
+		if sender.interrupted && sender.options.InterruptPolicy == InterruptImmediately {
+			slog.Info("Receiver interrupted the transfer. Stopping.")
+			sender.terminate()
+			return fmt.Errorf("transfer phase failed on frame %c: %w", frame[1], ErrInterrupted)
+		}
+	}
+	return nil
+}
+
+// sendFrame writes a frame and waits for it to be ACKed, resending it on NAK until MaxAttempts is reached
+func (sender *Sender) sendFrame(ctx context.Context, frame []byte) error {
+	if sender.link.currentStatus() != constants.Sending {
+		slog.Error("Connection not in send mode when trying to send data.")
+		return errors.New("transfer phase failed: connection not in send mode")
+	}
+	frameNumber := frame[1]
+	for attempt := 1; attempt <= sender.options.MaxAttempts; attempt++ {
+		sender.link.discardReply()
+		sender.link.reportProgress(PhaseSendingFrames, int(frameNumber-'0'), attempt-1)
+		sender.link.frameSent(string(frame))
+		if err := sender.link.write(frame); err != nil {
+			sender.link.setStatus(constants.Idle)
+			return fmt.Errorf("transfer phase failed on frame %c: %w", frameNumber, err)
+		}
+		sender.link.reportProgress(PhaseAwaitingACK, int(frameNumber-'0'), attempt-1)
+		reply, err := sender.link.awaitReply(ctx, sender.options.FrameReplyTimeout)
+		if ctx.Err() != nil {
+			sender.terminate()
+			return fmt.Errorf("transfer phase failed on frame %c: %w", frameNumber, ctx.Err())
+		}
+		if errors.Is(err, ErrReplyTimeout) {
+			slog.Error("No reply to frame.", "Frame number", string(frameNumber), "Timeout", sender.options.FrameReplyTimeout)
+			sender.terminate()
+			return fmt.Errorf("transfer phase failed on frame %c: %w after %v", frameNumber, err, sender.options.FrameReplyTimeout)
+		}
+		if err == nil && reply == constants.ACK {
+			slog.Debug("Frame sent successfully.")
+			sender.link.reportProgress(PhaseSendingFrames, int(frameNumber-'0'), attempt-1)
+			return nil
+		}
+		if err == nil && reply == constants.EOT {
+			slog.Info("Frame sent successfully. Receiver asked to interrupt the transfer.", "Frame number", string(frameNumber))
+			sender.interrupted = true
+			sender.link.reportProgress(PhaseSendingFrames, int(frameNumber-'0'), attempt-1)
+			return nil
+		}
+		if err == nil && reply == constants.NAK {
+			sender.link.frameNAKed(string(frame), attempt)
+		}
+		slog.Debug("Frame not acknowledged.", "Frame number", string(frameNumber), "Attempt", attempt)
+	}
+	sender.terminate()
+	slog.Error("Max number of send retires reached.")
+	return fmt.Errorf("%w: transfer phase failed on frame %c after %v attempts", ErrTransmissionAborted, frameNumber, sender.options.MaxAttempts)
+}
+
+// terminate sends EOT and returns to idle
+func (sender *Sender) terminate() {
+	sender.link.reportProgress(PhaseTerminating, 0, 0)
+	if err := sender.link.write([]byte{constants.EOT}); err != nil {
+		slog.Error("Failed to write control byte.", "Byte", constants.EOT, "Error", err)
+	}
+	slog.Debug("Sending EOT.")
+	sender.link.setStatus(constants.Idle)
+	slog.Debug("Changed mode to Idle and stopped send mode.")
+}
+
+// connectionLink is the link of a Sender or Receiver reading from the connection itself
+type connectionLink struct {
+	connectionReader
+	status constants.LIS1A2ConnectionStatus
+}
+
+// connectionReader reads from a connection, giving up waiting on the data when a context is done
+type connectionReader struct {
+	connection connection.Connection
+	// pending is the read still in progress after the last read gave up waiting on it
+	pending chan readReply
+}
+
+// readReply is the outcome of reading from the connection
+type readReply struct {
+	data []byte
+	err  error
+}
+
+func (link *connectionLink) write(data []byte) error {
+	return link.connection.Write(data)
+}
+
+// read reads from the connection until data arrives or ctx is done, through ReadBytesFromConnection
+// when the connection is a connection.BytesConnection
+func (reader *connectionReader) read(ctx context.Context) ([]byte, error) {
+	if reader.pending == nil {
+		// the read cannot be interrupted, it is picked up by the next call when it outlives this one
+		pending := make(chan readReply, 1)
+		go func() {
+			pending <- reader.readFromConnection()
+		}()
+		reader.pending = pending
+	}
+	select {
+	case reply := <-reader.pending:
+		reader.pending = nil
+		return reply.data, reply.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readFromConnection makes a single blocking read from the connection
+func (reader *connectionReader) readFromConnection() readReply {
+	if bytesConn, ok := reader.connection.(connection.BytesConnection); ok {
+		data, err := bytesConn.ReadBytesFromConnection()
+		return readReply{data: data, err: err}
+	}
+	data, err := reader.connection.ReadStringFromConnection()
+	return readReply{data: []byte(data), err: err}
+}
+
+func (link *connectionLink) awaitReply(ctx context.Context, timeout time.Duration) (byte, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		data, err := link.read(timeoutCtx)
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if timeoutCtx.Err() != nil {
+			return 0, ErrReplyTimeout
+		}
+		if err != nil && !errors.Is(err, connection.ErrChecksumMismatch) &&
+			!errors.Is(err, connection.ErrIncompleteFrame) && !errors.Is(err, connection.ErrReadOverflow) {
+			return 0, err
+		}
+		if err == nil && len(data) == 1 {
+			return data[0], nil
+		}
+		slog.Debug("Ignored data received while waiting for a reply.", "Data", data)
+	}
+}
+
+func (link *connectionLink) discardReply() {
+	if link.pending == nil {
+		return
+	}
+	select {
+	case <-link.pending:
+		link.pending = nil
+	default:
+	}
+}
+
+func (link *connectionLink) currentStatus() constants.LIS1A2ConnectionStatus {
+	return link.status
+}
+
+func (link *connectionLink) setStatus(status constants.LIS1A2ConnectionStatus) {
+	link.status = status
+}
+
+func (link *connectionLink) compareAndSetStatus(old constants.LIS1A2ConnectionStatus, status constants.LIS1A2ConnectionStatus) bool {
+	if link.status != old {
+		return false
+	}
+	link.status = status
+	return true
+}
+
+func (link *connectionLink) frameSent(string) {}
+
+func (link *connectionLink) peerBusy() {}
+
+func (link *connectionLink) frameNAKed(string, int) {}
+
+func (link *connectionLink) reportProgress(Phase, int, int) {}
@@ -0,0 +1,206 @@
+// Package simulator simulates the peers of a LIS1-A2 link over TCP, so that instrument drivers and LIS
+// applications can be tested end to end without the real thing
+package simulator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// ErrClosed is returned by WaitForMessages once the simulator is closed
+var ErrClosed = errors.New("simulator closed")
+
+// HostSimulator is a LIS host listening on TCP for instruments to connect. It speaks the receiving side
+// of LIS1-A2 with a lis1a2.Receiver, ACKing the frames, NAKing the corrupt ones, and records every message
+// received. It can be scripted to go busy, NAKing ENQ, or to NAK specific frames, to test how an instrument
+// driver recovers.
+type HostSimulator struct {
+	listener *connection.TCPListener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	routines sync.WaitGroup
+
+	mutex       sync.Mutex
+	connections []*connection.TCPConnection
+	messages    []lis1a2.ReceivedMessage
+	// received is closed and replaced whenever a message is recorded
+	received       chan struct{}
+	framesReceived int
+	busyCount      int
+	framesToNAK    map[int]bool
+}
+
+// NewHostSimulator starts listening on the host and port provided, port "0" picks a free one which Addr tells,
+// and accepts instruments until Close
+func NewHostSimulator(host string, port string) (*HostSimulator, error) {
+	listener, err := connection.NewTCPListener(host, port)
+	if err != nil {
+		return nil, err
+	}
+	simulator := &HostSimulator{
+		listener:    listener,
+		received:    make(chan struct{}),
+		framesToNAK: make(map[int]bool),
+	}
+	simulator.ctx, simulator.cancel = context.WithCancel(context.Background())
+	simulator.routines.Add(1)
+	go simulator.accept()
+	return simulator, nil
+}
+
+// Addr returns the address the simulator listens on
+func (simulator *HostSimulator) Addr() net.Addr {
+	return simulator.listener.Addr()
+}
+
+// GoBusy makes the simulator answer the next count ENQs with NAK, the instrument has to try again later
+func (simulator *HostSimulator) GoBusy(count int) {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	simulator.busyCount = count
+}
+
+// NAKFrame makes the simulator NAK the frame received with the given number, counting from 1 all the
+// frames received since it started, the retransmissions included, so that the instrument sends it again
+func (simulator *HostSimulator) NAKFrame(index int) {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	simulator.framesToNAK[index] = true
+}
+
+// FramesReceived gives the number of frames received since the simulator started, the retransmissions included
+func (simulator *HostSimulator) FramesReceived() int {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	return simulator.framesReceived
+}
+
+// Messages returns the messages received so far, or the errors which made them fail
+func (simulator *HostSimulator) Messages() []lis1a2.ReceivedMessage {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	return append([]lis1a2.ReceivedMessage(nil), simulator.messages...)
+}
+
+// WaitForMessages waits until count messages were received and returns them, or returns ctx.Err() once ctx is
+// done and ErrClosed once the simulator is closed
+func (simulator *HostSimulator) WaitForMessages(ctx context.Context, count int) ([]lis1a2.ReceivedMessage, error) {
+	for {
+		simulator.mutex.Lock()
+		if len(simulator.messages) >= count {
+			messages := append([]lis1a2.ReceivedMessage(nil), simulator.messages...)
+			simulator.mutex.Unlock()
+			return messages, nil
+		}
+		received := simulator.received
+		simulator.mutex.Unlock()
+		select {
+		case <-received:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-simulator.ctx.Done():
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Close stops listening and disconnects the instruments connected
+func (simulator *HostSimulator) Close() error {
+	simulator.cancel()
+	err := simulator.listener.Close()
+	simulator.mutex.Lock()
+	for _, tcpConn := range simulator.connections {
+		_ = tcpConn.Disconnect()
+	}
+	simulator.mutex.Unlock()
+	simulator.routines.Wait()
+	return err
+}
+
+// accept accepts the instruments connecting and receives from every one of them on a go routine of its own
+func (simulator *HostSimulator) accept() {
+	defer simulator.routines.Done()
+	for {
+		tcpConn, err := simulator.listener.Accept()
+		if err != nil {
+			if simulator.ctx.Err() == nil {
+				slog.Error("Simulator stopped accepting.", "Error", err)
+			}
+			return
+		}
+		simulator.mutex.Lock()
+		if simulator.ctx.Err() != nil {
+			simulator.mutex.Unlock()
+			_ = tcpConn.Disconnect()
+			return
+		}
+		simulator.connections = append(simulator.connections, tcpConn)
+		simulator.mutex.Unlock()
+
+		tcpConn.Listen()
+		receiver := lis1a2.NewReceiver(&scriptedConnection{Connection: tcpConn, simulator: simulator}, simulator.messageReceived)
+		simulator.routines.Add(1)
+		go func() {
+			defer simulator.routines.Done()
+			if err := receiver.Listen(simulator.ctx); err != nil && simulator.ctx.Err() == nil {
+				slog.Info("Instrument disconnected from the simulator.", "Error", err)
+			}
+		}()
+	}
+}
+
+// messageReceived records a message received by one of the receivers
+func (simulator *HostSimulator) messageReceived(message string, err error) {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	simulator.messages = append(simulator.messages, lis1a2.ReceivedMessage{Text: message, Err: err})
+	close(simulator.received)
+	simulator.received = make(chan struct{})
+}
+
+// intercept tells whether the script answers the data read from the instrument itself, with NAK,
+// instead of handing it over to the receiver
+func (simulator *HostSimulator) intercept(data string) bool {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	switch data[0] {
+	case constants.ENQ:
+		if simulator.busyCount > 0 {
+			simulator.busyCount--
+			return true
+		}
+	case constants.STX:
+		simulator.framesReceived++
+		if simulator.framesToNAK[simulator.framesReceived] {
+			delete(simulator.framesToNAK, simulator.framesReceived)
+			return true
+		}
+	}
+	return false
+}
+
+// scriptedConnection hands the data of the instrument over to the receiver, but for the ENQs and frames the
+// script of the simulator answers with NAK itself, which the receiver then never sees
+type scriptedConnection struct {
+	connection.Connection
+	simulator *HostSimulator
+}
+
+func (conn *scriptedConnection) ReadStringFromConnection() (string, error) {
+	for {
+		data, err := conn.Connection.ReadStringFromConnection()
+		if len(data) == 0 || !conn.simulator.intercept(data) {
+			return data, err
+		}
+		if err := conn.Connection.Write([]byte{constants.NAK}); err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// ErrNoMessages is returned when sending from an InstrumentSimulator which has no canned messages
+var ErrNoMessages = errors.New("no messages to send")
+
+// InstrumentSimulator is an analyzer sending canned result messages to a LIS, one after the other and
+// starting over after the last, on trigger with Send or on a schedule with Run. It sends with a
+// lis1a2.Sender, records longer than the MaxFrameSize of the sender options being split over intermediate
+// frames, and can be scripted to corrupt the checksum of specific frames, to test how a LIS recovers.
+type InstrumentSimulator struct {
+	sender   *lis1a2.Sender
+	messages [][]string
+	// sendMutex makes the messages sent one at a time when Send and Run are called together
+	sendMutex sync.Mutex
+	next      int
+
+	mutex           sync.Mutex
+	framesSent      int
+	framesToCorrupt map[int]bool
+}
+
+// NewInstrumentSimulator creates an InstrumentSimulator sending the messages, one record per element like
+// the lines given by astm.Message.Lines, over the connection. The connection has to be connected, dialing
+// the LIS or accepting it, and listening, nothing else may read from it. The sender is optionally tuned by options.
+func NewInstrumentSimulator(conn connection.Connection, messages [][]string, options ...lis1a2.SenderOptions) *InstrumentSimulator {
+	instrument := &InstrumentSimulator{
+		messages:        messages,
+		framesToCorrupt: make(map[int]bool),
+	}
+	instrument.sender = lis1a2.NewSender(&corruptingConnection{Connection: conn, instrument: instrument}, options...)
+	return instrument
+}
+
+// CorruptFrame makes the simulator send the frame with the given number with a wrong checksum, counting from 1
+// all the frames sent since it was created, the retransmissions included, so that the LIS has to NAK it
+func (instrument *InstrumentSimulator) CorruptFrame(index int) {
+	instrument.mutex.Lock()
+	defer instrument.mutex.Unlock()
+	instrument.framesToCorrupt[index] = true
+}
+
+// FramesSent gives the number of frames sent since the simulator was created, the retransmissions included
+func (instrument *InstrumentSimulator) FramesSent() int {
+	instrument.mutex.Lock()
+	defer instrument.mutex.Unlock()
+	return instrument.framesSent
+}
+
+// Send sends the next canned message, returning the error of the sender when the LIS did not take it.
+// A message failing is not sent again, the next call sends the one after it.
+func (instrument *InstrumentSimulator) Send(ctx context.Context) error {
+	if len(instrument.messages) == 0 {
+		return ErrNoMessages
+	}
+	instrument.sendMutex.Lock()
+	defer instrument.sendMutex.Unlock()
+	message := instrument.messages[instrument.next]
+	instrument.next = (instrument.next + 1) % len(instrument.messages)
+	return instrument.sender.SendRecords(ctx, message)
+}
+
+// Run sends the next canned message every interval until ctx is done, returning ctx.Err(),
+// or a message fails, returning the error
+func (instrument *InstrumentSimulator) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if err := instrument.Send(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("simulated instrument failed to send: %w", err)
+		}
+	}
+}
+
+// corrupt tells whether the script wants the frame about to be written sent with a wrong checksum
+func (instrument *InstrumentSimulator) corrupt() bool {
+	instrument.mutex.Lock()
+	defer instrument.mutex.Unlock()
+	instrument.framesSent++
+	if instrument.framesToCorrupt[instrument.framesSent] {
+		delete(instrument.framesToCorrupt, instrument.framesSent)
+		return true
+	}
+	return false
+}
+
+// corruptingConnection writes the data of the sender, but for the frames the script of the simulator
+// wants corrupted, which are written with their checksum off by one
+type corruptingConnection struct {
+	connection.Connection
+	instrument *InstrumentSimulator
+}
+
+func (conn *corruptingConnection) Write(data []byte) error {
+	if len(data) > 6 && data[0] == constants.STX && conn.instrument.corrupt() {
+		checksumAt := len(data) - 4
+		var checksum byte
+		if _, err := fmt.Sscanf(string(data[checksumAt:checksumAt+2]), "%02X", &checksum); err != nil {
+			return err
+		}
+		corrupted := append([]byte(nil), data...)
+		copy(corrupted[checksumAt:], fmt.Sprintf("%02X", checksum+1))
+		slog.Debug("Simulated instrument corrupting the checksum of a frame.", "Frame number", string(data[1]))
+		data = corrupted
+	}
+	return conn.Connection.Write(data)
+}
@@ -0,0 +1,99 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+)
+
+// ErrDeadLettered is wrapped by the error of a message which could not be published and was handed over
+// to the DeadLetter instead
+var ErrDeadLettered = errors.New("message dead-lettered")
+
+// DeadLetter keeps the messages which could not be published, for them to be inspected and published again
+type DeadLetter interface {
+	Store(message lis1a2.ReceivedMessage, cause error) error
+}
+
+// deadLettering hands the messages the sink it wraps failed to publish over to a DeadLetter
+type deadLettering struct {
+	sink       MessageSink
+	deadLetter DeadLetter
+}
+
+// WithDeadLetter wraps a sink to hand the messages it fails to publish over to deadLetter, Retry runs inside
+// of it for the messages to be tried again first. The error returned wraps ErrDeadLettered and the error of the
+// sink, or the error of the DeadLetter as well when it failed to store the message. A message which failed,
+// its Err set, is not stored.
+func WithDeadLetter(sink MessageSink, deadLetter DeadLetter) MessageSink {
+	return &deadLettering{sink: sink, deadLetter: deadLetter}
+}
+
+// Publish publishes the message, storing it in the DeadLetter when this fails
+func (deadLettering *deadLettering) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	err := deadLettering.sink.Publish(ctx, message)
+	if err == nil || message.Err != nil {
+		return err
+	}
+	if storeErr := deadLettering.deadLetter.Store(message, err); storeErr != nil {
+		return fmt.Errorf("failed to dead-letter the message: %w", errors.Join(storeErr, err))
+	}
+	return fmt.Errorf("%w: %w", ErrDeadLettered, err)
+}
+
+// FileDeadLetter is a DeadLetter appending to a file, one JSON object per line:
+//
+//	{"time":"2024-01-01T12:30:00.123456789Z","error":"failed to post: 503 Service Unavailable","text":"H|\\^&\nL|1|N\n"}
+type FileDeadLetter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// deadLetterLine is the JSON shape of a message stored by a FileDeadLetter
+type deadLetterLine struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+	Text  string    `json:"text"`
+}
+
+// OpenFileDeadLetter opens the dead letter file at path, creating it if it does not exist
+func OpenFileDeadLetter(path string) (*FileDeadLetter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	return &FileDeadLetter{file: file}, nil
+}
+
+// Store writes the message at the end of the file with the error it failed with, safe for concurrent use
+func (deadLetter *FileDeadLetter) Store(message lis1a2.ReceivedMessage, cause error) error {
+	line, err := json.Marshal(deadLetterLine{Time: time.Now(), Error: cause.Error(), Text: message.Text})
+	if err != nil {
+		return err
+	}
+	deadLetter.mutex.Lock()
+	defer deadLetter.mutex.Unlock()
+	if deadLetter.file == nil {
+		return errors.New("dead letter file is closed")
+	}
+	_, err = deadLetter.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file, storing fails afterwards
+func (deadLetter *FileDeadLetter) Close() error {
+	deadLetter.mutex.Lock()
+	defer deadLetter.mutex.Unlock()
+	if deadLetter.file == nil {
+		return nil
+	}
+	err := deadLetter.file.Close()
+	deadLetter.file = nil
+	return err
+}
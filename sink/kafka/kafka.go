@@ -0,0 +1,106 @@
+// Package kafka publishes the messages received from instruments to a Kafka topic, keyed by the instrument ID
+// of their header, so that they feed streaming pipelines. The package does not depend on a Kafka client, the
+// Producer is implemented over the client of the application, like the Writer of segmentio/kafka-go:
+//
+//	type writer struct{ *kafkago.Writer }
+//
+//	func (w writer) Produce(ctx context.Context, record kafka.Record) error {
+//		headers := make([]kafkago.Header, 0, len(record.Headers))
+//		for key, value := range record.Headers {
+//			headers = append(headers, kafkago.Header{Key: key, Value: []byte(value)})
+//		}
+//		return w.WriteMessages(ctx, kafkago.Message{Topic: record.Topic, Key: record.Key, Value: record.Value, Headers: headers})
+//	}
+//
+//	publisher := kafka.NewPublisher(writer{&kafkago.Writer{Addr: kafkago.TCP("broker:9092")}}, kafka.Options{Topic: "lab.results"})
+//	astmConn.OnMessage(publisher.Handler(ctx))
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+)
+
+// HeaderFormat is the header of a record telling its format, raw or json, see sink.Format
+const HeaderFormat = "format"
+
+// Record is a record to produce to Kafka
+type Record struct {
+	Topic string
+	// Key is the instrument ID of the message, nil when its header names no sender
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// Producer produces records to Kafka, returning once the broker acknowledged them
+type Producer interface {
+	Produce(ctx context.Context, record Record) error
+}
+
+// Options tunes a Publisher, a field left at its zero value keeps its default
+type Options struct {
+	// Topic is the topic the messages are published to
+	Topic string
+	// Format tells how the messages are published, sink.FormatRaw by default. With both formats every message
+	// is published twice, the raw record first.
+	Format sink.Format
+	// ParseOptions tell how the messages published as JSON are parsed, see ReceivedMessage.ParseWith
+	ParseOptions astm.ParseOptions
+	// Key gives the key of a message, sink.InstrumentID by default
+	Key func(message lis1a2.ReceivedMessage) string
+}
+
+// optionsWithDefaults fills in the defaults of the fields left unset
+func optionsWithDefaults(options Options) Options {
+	if options.Key == nil {
+		options.Key = sink.InstrumentID
+	}
+	return options
+}
+
+// Publisher publishes the messages received to a Kafka topic, it is a sink.MessageSink
+type Publisher struct {
+	producer Producer
+	options  Options
+}
+
+// NewPublisher creates a Publisher producing with producer
+func NewPublisher(producer Producer, options Options) *Publisher {
+	return &Publisher{producer: producer, options: optionsWithDefaults(options)}
+}
+
+// Publish publishes the message in the formats of the options. A message which failed is not published,
+// its error is returned, and neither is a message which cannot be parsed to JSON.
+func (publisher *Publisher) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	payloads, err := sink.Encode(message, publisher.options.Format, publisher.options.ParseOptions)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	if instrument := publisher.options.Key(message); instrument != "" {
+		key = []byte(instrument)
+	}
+	for _, payload := range payloads {
+		record := Record{
+			Topic:   publisher.options.Topic,
+			Key:     key,
+			Value:   payload.Data,
+			Headers: map[string]string{HeaderFormat: payload.Format.String()},
+		}
+		if err := publisher.producer.Produce(ctx, record); err != nil {
+			return fmt.Errorf("failed to publish to %v: %w", publisher.options.Topic, err)
+		}
+	}
+	return nil
+}
+
+// Handler gives a handler for ASTMConnection.OnMessage publishing every message received until ctx is done,
+// see sink.Handler
+func (publisher *Publisher) Handler(ctx context.Context) func(message lis1a2.ReceivedMessage) {
+	return sink.Handler(ctx, publisher)
+}
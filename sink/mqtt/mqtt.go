@@ -0,0 +1,118 @@
+// Package mqtt publishes the messages received from instruments to an MQTT broker, on a topic naming the
+// instrument ID of their header and the format, like lab/results/cobas/raw. The package does not depend on an
+// MQTT client, the Client is implemented over the client of the application, like eclipse/paho.mqtt.golang:
+//
+//	type client struct{ paho.Client }
+//
+//	func (c client) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+//		token := c.Client.Publish(topic, qos, retained, payload)
+//		select {
+//		case <-token.Done():
+//			return token.Error()
+//		case <-ctx.Done():
+//			return ctx.Err()
+//		}
+//	}
+//
+//	astmConn.OnMessage(sink.Handler(ctx, sink.Retry(mqtt.NewSink(client{pahoClient}, mqtt.Options{Topic: "lab/results"}), sink.RetryOptions{})))
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+)
+
+// The quality of service levels of MQTT
+const (
+	AtMostOnce  byte = 0
+	AtLeastOnce byte = 1
+	ExactlyOnce byte = 2
+)
+
+// unknownInstrument is the level of the topic of a message whose header names no sender
+const unknownInstrument = "unknown"
+
+// Client publishes a payload to an MQTT broker, returning once the broker acknowledged it as the quality of
+// service tells, a PUBACK for AtLeastOnce
+type Client interface {
+	Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error
+}
+
+// Options tunes a Sink, a field left at its zero value keeps its default
+type Options struct {
+	// Topic is the topic the messages are published under, followed by the instrument ID and the format
+	Topic string
+	// QoS is the quality of service the messages are published with, AtLeastOnce by default, AtMostOnce
+	// is not available as it could lose results
+	QoS byte
+	// Retained makes the broker keep the last message of every instrument for the subscribers to come
+	Retained bool
+	// Format tells how the messages are published, sink.FormatRaw by default. With both formats every message
+	// is published twice, the raw message first.
+	Format sink.Format
+	// ParseOptions tell how the messages published as JSON are parsed, see ReceivedMessage.ParseWith
+	ParseOptions astm.ParseOptions
+	// Instrument gives the instrument ID of a message, sink.InstrumentID by default
+	Instrument func(message lis1a2.ReceivedMessage) string
+}
+
+// optionsWithDefaults fills in the defaults of the fields left unset
+func optionsWithDefaults(options Options) Options {
+	if options.QoS != ExactlyOnce {
+		options.QoS = AtLeastOnce
+	}
+	if options.Instrument == nil {
+		options.Instrument = sink.InstrumentID
+	}
+	return options
+}
+
+// Sink publishes the messages received to an MQTT broker, it is a sink.MessageSink
+type Sink struct {
+	client  Client
+	options Options
+}
+
+// NewSink creates a Sink publishing with client
+func NewSink(client Client, options Options) *Sink {
+	return &Sink{client: client, options: optionsWithDefaults(options)}
+}
+
+// Publish publishes the message in the formats of the options, see sink.MessageSink
+func (mqttSink *Sink) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	payloads, err := sink.Encode(message, mqttSink.options.Format, mqttSink.options.ParseOptions)
+	if err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		topic := mqttSink.Topic(message, payload.Format)
+		if err := mqttSink.client.Publish(ctx, topic, mqttSink.options.QoS, mqttSink.options.Retained, payload.Data); err != nil {
+			return fmt.Errorf("failed to publish to %v: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// Topic gives the topic the message is published under in the format given, the instrument ID replacing
+// the characters a level of a topic cannot hold with _
+func (mqttSink *Sink) Topic(message lis1a2.ReceivedMessage, format sink.Format) string {
+	instrument := strings.Map(func(r rune) rune {
+		if r == '/' || r == '+' || r == '#' || r == 0 {
+			return '_'
+		}
+		return r
+	}, mqttSink.options.Instrument(message))
+	if instrument == "" {
+		instrument = unknownInstrument
+	}
+	levels := []string{instrument, format.String()}
+	if mqttSink.options.Topic != "" {
+		levels = append([]string{mqttSink.options.Topic}, levels...)
+	}
+	return strings.Join(levels, "/")
+}
@@ -0,0 +1,106 @@
+// Package nats publishes the messages received from instruments to NATS, on a subject ending with the instrument
+// ID of their header, like lab.results.cobas. The package does not depend on a NATS client, the Publisher is
+// implemented over the client of the application, JetStream confirming that the stream stored the message:
+//
+//	type publisher struct{ js jetstream.JetStream }
+//
+//	func (p publisher) Publish(ctx context.Context, subject string, data []byte, headers map[string]string) error {
+//		message := natsgo.NewMsg(subject)
+//		message.Data = data
+//		for key, value := range headers {
+//			message.Header.Set(key, value)
+//		}
+//		_, err := p.js.PublishMsg(ctx, message)
+//		return err
+//	}
+//
+//	astmConn.OnMessage(sink.Handler(ctx, sink.Retry(nats.NewSink(publisher{js}, nats.Options{Subject: "lab.results"}), sink.RetryOptions{})))
+package nats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+)
+
+// HeaderFormat is the header of a message telling its format, raw or json, see sink.Format
+const HeaderFormat = "Lis1a2-Format"
+
+// unknownInstrument is the last token of the subject of a message whose header names no sender
+const unknownInstrument = "unknown"
+
+// Publisher publishes a message with its headers to NATS, returning once the server confirmed it, like
+// JetStream does, for the messages to be delivered at least once
+type Publisher interface {
+	Publish(ctx context.Context, subject string, data []byte, headers map[string]string) error
+}
+
+// Options tunes a Sink, a field left at its zero value keeps its default
+type Options struct {
+	// Subject is the subject the messages are published under, the instrument ID is appended to it as a token
+	Subject string
+	// Format tells how the messages are published, sink.FormatRaw by default. With both formats every message
+	// is published twice, the raw message first.
+	Format sink.Format
+	// ParseOptions tell how the messages published as JSON are parsed, see ReceivedMessage.ParseWith
+	ParseOptions astm.ParseOptions
+	// Instrument gives the instrument ID of a message, sink.InstrumentID by default
+	Instrument func(message lis1a2.ReceivedMessage) string
+}
+
+// optionsWithDefaults fills in the defaults of the fields left unset
+func optionsWithDefaults(options Options) Options {
+	if options.Instrument == nil {
+		options.Instrument = sink.InstrumentID
+	}
+	return options
+}
+
+// Sink publishes the messages received to NATS, it is a sink.MessageSink
+type Sink struct {
+	publisher Publisher
+	options   Options
+}
+
+// NewSink creates a Sink publishing with publisher
+func NewSink(publisher Publisher, options Options) *Sink {
+	return &Sink{publisher: publisher, options: optionsWithDefaults(options)}
+}
+
+// Publish publishes the message in the formats of the options, see sink.MessageSink
+func (natsSink *Sink) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	payloads, err := sink.Encode(message, natsSink.options.Format, natsSink.options.ParseOptions)
+	if err != nil {
+		return err
+	}
+	subject := natsSink.Subject(message)
+	for _, payload := range payloads {
+		headers := map[string]string{HeaderFormat: payload.Format.String()}
+		if err := natsSink.publisher.Publish(ctx, subject, payload.Data, headers); err != nil {
+			return fmt.Errorf("failed to publish to %v: %w", subject, err)
+		}
+	}
+	return nil
+}
+
+// Subject gives the subject the message is published under, the instrument ID replacing the characters
+// a token of a subject cannot hold with _
+func (natsSink *Sink) Subject(message lis1a2.ReceivedMessage) string {
+	instrument := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '.' || r == '*' || r == '>' {
+			return '_'
+		}
+		return r
+	}, natsSink.options.Instrument(message))
+	if instrument == "" {
+		instrument = unknownInstrument
+	}
+	if natsSink.options.Subject == "" {
+		return instrument
+	}
+	return natsSink.options.Subject + "." + instrument
+}
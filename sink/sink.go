@@ -0,0 +1,167 @@
+// Package sink forwards the messages received from instruments to a message broker. A MessageSink publishes
+// a message, returning once the broker took it, and Retry publishes it again until it does, so that every
+// message reaches the broker at least once, and WithDeadLetter keeps the messages which could not be published.
+// The sinks of the sink/kafka, sink/nats and sink/mqtt packages run over the client of the application, the
+// packages do not depend on one, sink/webhook posts the messages to an HTTPS endpoint:
+//
+//	forwarder := sink.Retry(nats.NewSink(client, nats.Options{Subject: "lab.results"}), sink.RetryOptions{})
+//	astmConn.OnMessage(sink.Handler(ctx, forwarder))
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// ErrUnpublishable is wrapped by the error of a message which cannot be published however often it is
+// tried again, like a message which cannot be parsed to JSON, Retry does not retry it
+var ErrUnpublishable = errors.New("message cannot be published")
+
+// MessageSink publishes the messages received to a broker, returning once the broker took the message.
+// A message which failed, its Err set, is not published and its error is returned.
+type MessageSink interface {
+	Publish(ctx context.Context, message lis1a2.ReceivedMessage) error
+}
+
+// Format tells how the messages are published, FormatRaw and FormatJSON can be combined
+type Format int
+
+const (
+	// FormatRaw publishes the text of the message, one record per line
+	FormatRaw Format = 1 << iota
+	// FormatJSON publishes the message parsed, as astm.Message marshals to JSON
+	FormatJSON
+)
+
+// String gives the name of a single format, raw or json
+func (format Format) String() string {
+	if format == FormatJSON {
+		return "json"
+	}
+	return "raw"
+}
+
+// Payload is a message encoded in a single format
+type Payload struct {
+	Format Format
+	Data   []byte
+}
+
+// Encode encodes the message in the formats given, FormatRaw when none is, the raw payload first. The messages
+// encoded to JSON are parsed with options, an error wrapping ErrUnpublishable is returned when this fails.
+func Encode(message lis1a2.ReceivedMessage, format Format, options astm.ParseOptions) ([]Payload, error) {
+	if message.Err != nil {
+		return nil, message.Err
+	}
+	if format == 0 {
+		format = FormatRaw
+	}
+	var payloads []Payload
+	if format&FormatRaw != 0 {
+		payloads = append(payloads, Payload{Format: FormatRaw, Data: []byte(message.Text)})
+	}
+	if format&FormatJSON != 0 {
+		parsed, err := message.ParseWith(options)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnpublishable, err)
+		}
+		encoded, err := json.Marshal(parsed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnpublishable, err)
+		}
+		payloads = append(payloads, Payload{Format: FormatJSON, Data: encoded})
+	}
+	return payloads, nil
+}
+
+// InstrumentID reads the first component of the sender name of the header of a message, empty when it has none
+func InstrumentID(message lis1a2.ReceivedMessage) string {
+	parsed, err := astm.ParseMessage(message.Text, astm.ParseOptions{Strictness: astm.Off})
+	if err != nil || parsed.Header == nil {
+		return ""
+	}
+	return parsed.Header.SenderName.Component(1)
+}
+
+// RetryOptions tunes Retry, a field left at its zero value keeps its default
+type RetryOptions struct {
+	// MaxAttempts is the number of attempts to publish a message before giving up, zero retries until ctx is done
+	MaxAttempts int
+	// InitialBackoff is the wait before the second attempt, it doubles after every failed attempt, defaults to 1 second
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts, defaults to 1 minute
+	MaxBackoff time.Duration
+}
+
+// retryOptionsWithDefaults fills in the defaults of the fields left unset
+func retryOptionsWithDefaults(options RetryOptions) RetryOptions {
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = time.Minute
+	}
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = options.InitialBackoff
+	}
+	return options
+}
+
+// retrying publishes the messages again until the sink it wraps took them
+type retrying struct {
+	sink    MessageSink
+	options RetryOptions
+}
+
+// Retry wraps a sink to publish a message again with exponential backoff and jitter until the broker took
+// it, the attempts ran out or ctx is done, returning the last error then. A message which failed or an error
+// wrapping ErrUnpublishable is not retried. A sink which publishes a message and fails before the broker
+// confirmed it makes it published again, the consumers have to tell the duplicates apart.
+func Retry(sink MessageSink, options RetryOptions) MessageSink {
+	return &retrying{sink: sink, options: retryOptionsWithDefaults(options)}
+}
+
+// Publish publishes the message until the sink wrapped took it
+func (retrying *retrying) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	backoff := retrying.options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := retrying.sink.Publish(ctx, message)
+		if err == nil || message.Err != nil || errors.Is(err, ErrUnpublishable) {
+			return err
+		}
+		if retrying.options.MaxAttempts > 0 && attempt >= retrying.options.MaxAttempts {
+			return fmt.Errorf("gave up after %v attempts: %w", attempt, err)
+		}
+		slog.Debug("Failed to publish the message, retrying.", "Attempt", attempt, "Error", err)
+		// wait between half and the whole backoff, so that the sinks do not retry in lockstep
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %v attempts: %w", attempt, errors.Join(ctx.Err(), err))
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, retrying.options.MaxBackoff)
+	}
+}
+
+// Handler gives a handler for ASTMConnection.OnMessage publishing every message received to the sink until
+// ctx is done, logging the ones which could not be published. The sink runs on the go routine calling Listen,
+// which reads nothing more until the message is published.
+func Handler(ctx context.Context, sink MessageSink) func(message lis1a2.ReceivedMessage) {
+	return func(message lis1a2.ReceivedMessage) {
+		if message.Err != nil {
+			return
+		}
+		if err := sink.Publish(ctx, message); err != nil {
+			slog.Error("Failed to publish the message received.", "Error", err)
+		}
+	}
+}
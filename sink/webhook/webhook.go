@@ -0,0 +1,161 @@
+// Package webhook posts the messages received from instruments to an HTTPS endpoint as JSON, the simplest
+// way to hand results over to a LIS running in the cloud. The body is signed with HMAC-SHA256 when a secret
+// is set, NewForwarder retries the posts which fail and dead-letters the messages it could not post:
+//
+//	deadLetter, err := sink.OpenFileDeadLetter("/var/lib/lis/undelivered.jsonl")
+//	...
+//	forwarder := webhook.NewForwarder(webhook.Options{URL: "https://lis.example.com/results", Secret: secret},
+//		sink.RetryOptions{MaxAttempts: 5}, deadLetter)
+//	astmConn.OnMessage(sink.Handler(ctx, forwarder))
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+)
+
+// The headers of a post
+const (
+	// HeaderTimestamp carries when the body was signed, in Unix seconds
+	HeaderTimestamp = "X-Lis1a2-Timestamp"
+	// HeaderSignature carries sha256= and the hex HMAC-SHA256 of the timestamp, a dot and the body, see Verify
+	HeaderSignature = "X-Lis1a2-Signature"
+)
+
+// ErrUnexpectedStatus is wrapped by the error of a post answered with a status other than 2xx
+var ErrUnexpectedStatus = errors.New("unexpected status")
+
+// defaultTimeout is how long a post takes at most with the client of the options left unset
+const defaultTimeout = 30 * time.Second
+
+// Payload is the JSON body of a post
+type Payload struct {
+	// Instrument is the instrument ID of the message, see sink.InstrumentID
+	Instrument string `json:"instrument"`
+	// Text is the message, one record per line
+	Text string `json:"text"`
+	// Message is the message parsed
+	Message *astm.Message `json:"message"`
+}
+
+// Options tunes a Sink, a field left at its zero value keeps its default
+type Options struct {
+	// URL is the endpoint the messages are posted to
+	URL string
+	// Secret signs the body with HMAC-SHA256 when set, see HeaderSignature
+	Secret []byte
+	// Headers are added to every post, like an Authorization header
+	Headers map[string]string
+	// Client posts the messages, a client timing out after 30 seconds by default
+	Client *http.Client
+	// ParseOptions tell how the messages are parsed, see ReceivedMessage.ParseWith
+	ParseOptions astm.ParseOptions
+}
+
+// optionsWithDefaults fills in the defaults of the fields left unset
+func optionsWithDefaults(options Options) Options {
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: defaultTimeout}
+	}
+	return options
+}
+
+// Sink posts every message to the endpoint once, it is a sink.MessageSink
+type Sink struct {
+	options Options
+}
+
+// NewSink creates a Sink posting to the endpoint of the options
+func NewSink(options Options) *Sink {
+	return &Sink{options: optionsWithDefaults(options)}
+}
+
+// NewForwarder creates a Sink posting to the endpoint of the options, posting a message again as retry tells
+// and handing it over to deadLetter once it gave up, see sink.Retry and sink.WithDeadLetter.
+// A nil deadLetter only retries.
+func NewForwarder(options Options, retry sink.RetryOptions, deadLetter sink.DeadLetter) sink.MessageSink {
+	forwarder := sink.Retry(NewSink(options), retry)
+	if deadLetter != nil {
+		forwarder = sink.WithDeadLetter(forwarder, deadLetter)
+	}
+	return forwarder
+}
+
+// Publish posts the message as a Payload, see sink.MessageSink. A status of 2xx means the endpoint took it,
+// the other ones fail with ErrUnexpectedStatus wrapped, and with sink.ErrUnpublishable as well for the
+// 4xx statuses but 408 and 429, as posting the message again would not help.
+func (webhookSink *Sink) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	if message.Err != nil {
+		return message.Err
+	}
+	parsed, err := message.ParseWith(webhookSink.options.ParseOptions)
+	if err != nil {
+		return fmt.Errorf("%w: %w", sink.ErrUnpublishable, err)
+	}
+	body, err := json.Marshal(Payload{Instrument: sink.InstrumentID(message), Text: message.Text, Message: parsed})
+	if err != nil {
+		return fmt.Errorf("%w: %w", sink.ErrUnpublishable, err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookSink.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", sink.ErrUnpublishable, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range webhookSink.options.Headers {
+		request.Header.Set(key, value)
+	}
+	if len(webhookSink.options.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(HeaderTimestamp, timestamp)
+		request.Header.Set(HeaderSignature, Sign(webhookSink.options.Secret, timestamp, body))
+	}
+
+	response, err := webhookSink.options.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to post: %w", err)
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("failed to post: %w %v", ErrUnexpectedStatus, response.Status)
+	if response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %w", sink.ErrUnpublishable, err)
+	}
+	return err
+}
+
+// Sign gives the value of HeaderSignature for the body signed at timestamp, in Unix seconds
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tells whether the signature of a post, the value of HeaderSignature, matches the body signed at
+// timestamp, the value of HeaderTimestamp, for the endpoints receiving the posts
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,168 @@
+package lis1a2
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// ExchangeDirection tells whether an Exchange sent or received a message
+type ExchangeDirection int
+
+const (
+	// ExchangeSent is a message sent with SendMessage
+	ExchangeSent ExchangeDirection = iota
+	// ExchangeReceived is a message received, from the ENQ of the peer to its EOT
+	ExchangeReceived
+)
+
+// Exchange describes a message sent or received once it is over, for the span tracing it
+type Exchange struct {
+	Direction ExchangeDirection
+	// InstrumentID is the first component of the sender name of the header record, empty without one
+	InstrumentID string
+	// Frames counts the frames sent or received, the retransmissions included
+	Frames int
+	// Retries counts the ENQs and frames sent again, for a message sent, or NAKed, for a message received
+	Retries  int
+	Duration time.Duration
+	// Err is the error which made the exchange fail, nil when it succeeded
+	Err error
+}
+
+// Attributes gives the attributes of the exchange by the names spans carry them under, so that a tracer
+// can copy them, like lis1a2.instrument_id
+func (exchange Exchange) Attributes() map[string]any {
+	direction := "sent"
+	if exchange.Direction == ExchangeReceived {
+		direction = "received"
+	}
+	return map[string]any{
+		"lis1a2.direction":     direction,
+		"lis1a2.instrument_id": exchange.InstrumentID,
+		"lis1a2.frame_count":   exchange.Frames,
+		"lis1a2.retries":       exchange.Retries,
+	}
+}
+
+// ExchangeTracer creates a span for every message an ASTMConnection sends or receives, see SetExchangeTracer.
+// An OpenTelemetry tracer starts a span in StartExchange and ends it in End, setting the attributes
+// of the exchange, which keeps this package free of a dependency on OpenTelemetry.
+type ExchangeTracer interface {
+	// StartExchange starts the span of an exchange. ctx is the one given to SendMessage for a message sent,
+	// a background one for a message received. The context returned, carrying the span, is the one of
+	// the ReceivedMessage for a message received.
+	StartExchange(ctx context.Context, direction ExchangeDirection) (context.Context, ExchangeSpan)
+}
+
+// ExchangeSpan is the span of an exchange in progress
+type ExchangeSpan interface {
+	// End ends the span once the exchange is over
+	End(exchange Exchange)
+}
+
+// exchangeInProgress is the span of a message being sent or received, along with the counters it started at
+type exchangeInProgress struct {
+	span      ExchangeSpan
+	ctx       context.Context
+	startedAt time.Time
+	frames    int64
+	retries   int64
+	enqs      int64
+	message   string
+	err       error
+}
+
+// SetExchangeTracer makes the connection trace every message sent and received as a span.
+// It has to be set before Listen.
+func (astmConn *ASTMConnection) SetExchangeTracer(tracer ExchangeTracer) {
+	astmConn.exchangeTracer = tracer
+}
+
+// startSendExchange starts the span of a message about to be sent, nil without a tracer.
+// It is called holding the send mutex, so the counters only move for this message.
+func (astmConn *ASTMConnection) startSendExchange(ctx context.Context) (context.Context, *exchangeInProgress) {
+	if astmConn.exchangeTracer == nil {
+		return ctx, nil
+	}
+	spanCtx, span := astmConn.exchangeTracer.StartExchange(ctx, ExchangeSent)
+	return spanCtx, &exchangeInProgress{
+		span:      span,
+		startedAt: time.Now(),
+		frames:    astmConn.metrics.framesSent.Load(),
+		retries:   astmConn.metrics.retransmissions.Load(),
+		enqs:      astmConn.metrics.enqsSent.Load(),
+	}
+}
+
+// endSendExchange ends the span of a message sent. Its retries are the frames sent again and the ENQs
+// after the first one, sent again to a busy receiver or after a contention.
+func (astmConn *ASTMConnection) endSendExchange(exchange *exchangeInProgress, records []string, err error) {
+	if exchange == nil {
+		return
+	}
+	retries := astmConn.metrics.retransmissions.Load() - exchange.retries
+	if enqs := astmConn.metrics.enqsSent.Load() - exchange.enqs; enqs > 1 {
+		retries += enqs - 1
+	}
+	exchange.span.End(Exchange{
+		Direction:    ExchangeSent,
+		InstrumentID: instrumentID(strings.Join(records, "\n")),
+		Frames:       int(astmConn.metrics.framesSent.Load() - exchange.frames),
+		Retries:      int(retries),
+		Duration:     time.Since(exchange.startedAt),
+		Err:          err,
+	})
+}
+
+// traceReception starts the span of a message received once the ENQ of the peer is ACKed, and ends it on
+// the return to idle. It runs on the go routine running Listen, the only one changing the state from and to Receiving.
+func (astmConn *ASTMConnection) traceReception(old constants.LIS1A2ConnectionStatus, status constants.LIS1A2ConnectionStatus) {
+	if astmConn.exchangeTracer == nil {
+		return
+	}
+	if status == constants.Receiving && old != constants.Receiving {
+		ctx, span := astmConn.exchangeTracer.StartExchange(context.Background(), ExchangeReceived)
+		astmConn.reception = &exchangeInProgress{
+			span:      span,
+			ctx:       ctx,
+			startedAt: time.Now(),
+			frames:    astmConn.metrics.framesReceived.Load(),
+			retries:   astmConn.metrics.naksSent.Load(),
+		}
+	} else if old == constants.Receiving && status != constants.Receiving && astmConn.reception != nil {
+		reception := astmConn.reception
+		astmConn.reception = nil
+		reception.span.End(Exchange{
+			Direction:    ExchangeReceived,
+			InstrumentID: instrumentID(reception.message),
+			Frames:       int(astmConn.metrics.framesReceived.Load() - reception.frames),
+			Retries:      int(astmConn.metrics.naksSent.Load() - reception.retries),
+			Duration:     time.Since(reception.startedAt),
+			Err:          reception.err,
+		})
+	}
+}
+
+// receptionEnded keeps the outcome of the message received for its span and gives the context of the
+// span, nil without a tracer
+func (astmConn *ASTMConnection) receptionEnded(message string, err error) context.Context {
+	if astmConn.reception == nil {
+		return nil
+	}
+	astmConn.reception.message = message
+	astmConn.reception.err = err
+	return astmConn.reception.ctx
+}
+
+// instrumentID reads the first component of the sender name of the header of a message
+func instrumentID(message string) string {
+	parsed, err := astm.ParseMessage(message, astm.ParseOptions{Strictness: astm.Off})
+	if err != nil || parsed.Header == nil {
+		return ""
+	}
+	return parsed.Header.SenderName.Component(1)
+}
@@ -0,0 +1,79 @@
+package lis1a2
+
+import "github.com/therealriteshkudalkar/lis1a2/constants"
+
+// Phase is the step of the protocol an ASTMConnection is at, see State
+type Phase int
+
+const (
+	// PhaseIdle is the neutral state, no message is being sent or received
+	PhaseIdle Phase = iota
+	// PhaseEstablishing is while ENQ is sent, until the receiver ACKs it
+	PhaseEstablishing
+	// PhaseSendingFrames is while the frames of a message are sent, between the frames ACKed
+	PhaseSendingFrames
+	// PhaseAwaitingACK is while a frame sent waits for the reply of the receiver
+	PhaseAwaitingACK
+	// PhaseReceiving is while a message is received, from the ENQ ACKed to the EOT
+	PhaseReceiving
+	// PhaseTerminating is while EOT is sent, ending the message sent
+	PhaseTerminating
+)
+
+// String gives the name of the phase, like idle or awaiting_ack
+func (phase Phase) String() string {
+	switch phase {
+	case PhaseIdle:
+		return "idle"
+	case PhaseEstablishing:
+		return "establishing"
+	case PhaseSendingFrames:
+		return "sending_frames"
+	case PhaseAwaitingACK:
+		return "awaiting_ack"
+	case PhaseReceiving:
+		return "receiving"
+	case PhaseTerminating:
+		return "terminating"
+	}
+	return "unknown"
+}
+
+// ProtocolState is a snapshot of the progress of the protocol, see ASTMConnection.State
+type ProtocolState struct {
+	Phase Phase
+	// FrameNumber is the number of the frame being sent, or of the frame accepted last while receiving,
+	// zero before the first frame
+	FrameNumber int
+	// Retries counts the times the ENQ or the frame being sent was sent again, or the frames NAKed since the
+	// frame accepted last while receiving
+	Retries int
+}
+
+// State gives the phase of the protocol, with the frame being exchanged and its retries, for dashboards and
+// for the tests asserting on the progress of a message. It is safe to call from any go routine.
+func (astmConn *ASTMConnection) State() ProtocolState {
+	astmConn.statusMutex.Lock()
+	defer astmConn.statusMutex.Unlock()
+	return astmConn.progress
+}
+
+// reportProgress records the phase the sender or the receiver reached, with the frame and its retries
+func (astmConn *ASTMConnection) reportProgress(phase Phase, frameNumber int, retries int) {
+	astmConn.statusMutex.Lock()
+	defer astmConn.statusMutex.Unlock()
+	astmConn.progress = ProtocolState{Phase: phase, FrameNumber: frameNumber, Retries: retries}
+}
+
+// phaseOf gives the phase a state of the connection starts in
+func phaseOf(status constants.LIS1A2ConnectionStatus) Phase {
+	switch status {
+	case constants.Establishing:
+		return PhaseEstablishing
+	case constants.Sending:
+		return PhaseSendingFrames
+	case constants.Receiving:
+		return PhaseReceiving
+	}
+	return PhaseIdle
+}
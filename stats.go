@@ -0,0 +1,48 @@
+package lis1a2
+
+import (
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// Stats is a snapshot of the session of an ASTMConnection for health dashboards and watchdogs,
+// see ASTMConnection.Stats. The counters add up from the creation of the connection, over reconnects.
+type Stats struct {
+	BytesRead        int64
+	BytesWritten     int64
+	FramesSent       int64
+	FramesReceived   int64
+	MessagesSent     int64
+	MessagesReceived int64
+	// LastActivity is when data was last read or written, the zero time before that
+	LastActivity time.Time
+	// Reconnects counts the times the connection re-established itself, zero when it does not keep count of
+	// them, see connection.TCPConnection.Stats
+	Reconnects int64
+	Connected  bool
+	State      constants.LIS1A2ConnectionStatus
+}
+
+// Stats gives a snapshot of the session, safe to call from any go routine
+func (astmConn *ASTMConnection) Stats() Stats {
+	metrics := &astmConn.metrics
+	stats := Stats{
+		BytesRead:        metrics.bytesIn.Load(),
+		BytesWritten:     metrics.bytesOut.Load(),
+		FramesSent:       metrics.framesSent.Load(),
+		FramesReceived:   metrics.framesReceived.Load(),
+		MessagesSent:     metrics.messagesSent.Load(),
+		MessagesReceived: metrics.messagesReceived.Load(),
+		Connected:        astmConn.IsConnected(),
+		State:            astmConn.currentStatus(),
+	}
+	if lastActivity := metrics.lastActivity.Load(); lastActivity != 0 {
+		stats.LastActivity = time.Unix(0, lastActivity)
+	}
+	if counting, ok := astmConn.connection.(interface{ Stats() connection.Stats }); ok {
+		stats.Reconnects = counting.Stats().Reconnects
+	}
+	return stats
+}
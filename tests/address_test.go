@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"net"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+)
+
+// listenOn starts a TCPListener on host accepting a single connection, giving its port
+func listenOn(t *testing.T, host string, options ...connection.Options) string {
+	t.Helper()
+	listener, err := connection.NewTCPListener(host, "0", options...)
+	if err != nil {
+		t.Skipf("Cannot listen on %v: %v", host, err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		if accepted, err := listener.Accept(); err == nil {
+			t.Cleanup(func() { _ = accepted.Disconnect() })
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestTCPConnectionToIPv6Literals(t *testing.T) {
+	for _, host := range []string{"::1", "[::1]"} {
+		port := listenOn(t, host)
+		tcpConn := connection.NewTCPConnection(host, port)
+		if err := tcpConn.Connect(); err != nil {
+			t.Fatalf("Failed to connect to %v: %v", host, err)
+		}
+		_ = tcpConn.Disconnect()
+	}
+}
+
+func TestTCPConnectionAddressFamily(t *testing.T) {
+	port := listenOn(t, "127.0.0.1")
+	ipv6Only := connection.NewTCPConnection("127.0.0.1", port, connection.Options{AddressFamily: connection.IPv6Only})
+	if err := ipv6Only.Connect(); err == nil {
+		_ = ipv6Only.Disconnect()
+		t.Fatalf("Expected an IPv6 only connection to fail connecting to an IPv4 address")
+	}
+
+	// localhost resolves to 127.0.0.1, and maybe to ::1 which nothing listens on, IPv4 is dialed after it
+	port = listenOn(t, "127.0.0.1")
+	preferIPv6 := connection.NewTCPConnection("localhost", port, connection.Options{AddressFamily: connection.PreferIPv6})
+	if err := preferIPv6.Connect(); err != nil {
+		t.Fatalf("Expected the IPv4 address to be dialed when no IPv6 one answers, got %v", err)
+	}
+	_ = preferIPv6.Disconnect()
+
+	if _, err := connection.NewTCPListener("::1", "0", connection.Options{AddressFamily: connection.IPv4Only}); err == nil {
+		t.Fatalf("Expected an IPv4 only listener to fail listening on an IPv6 address")
+	}
+}
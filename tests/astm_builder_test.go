@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+func TestOrderMessageBuilder(t *testing.T) {
+	message, err := astm.NewOrderMessage().
+		Header(&astm.HeaderRecord{SenderName: astm.NewField("LIS"), ProcessingID: astm.NewField("P")}).
+		Patient(&astm.PatientRecord{LaboratoryPatientID: astm.NewField("PID001"), Name: astm.NewField("Doe", "John")}).
+		Order(&astm.OrderRecord{SpecimenID: astm.NewField("SID001"), UniversalTestID: astm.NewField("", "", "", "GLU"), Priority: astm.NewField("R")}).
+		Comment(&astm.CommentRecord{Source: astm.NewField("L"), Text: astm.NewField("fasting")}).
+		Order(&astm.OrderRecord{SpecimenID: astm.NewField("SID001"), UniversalTestID: astm.NewField("", "", "", "NA")}).
+		Patient(&astm.PatientRecord{LaboratoryPatientID: astm.NewField("PID002")}).
+		Order(&astm.OrderRecord{SpecimenID: astm.NewField("SID002"), UniversalTestID: astm.NewField("", "", "", "K")}).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build: %v", err)
+	}
+	expected := []string{
+		"H|\\^&|||LIS|||||||P",
+		"P|1||PID001||Doe^John",
+		"O|1|SID001||^^^GLU|R",
+		"C|1|L|fasting",
+		"O|2|SID001||^^^NA",
+		"P|2||PID002",
+		"O|1|SID002||^^^K",
+		"L|1|N",
+	}
+	if lines := message.Lines(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+	if _, err := astm.ParseMessage(strings.Join(message.Lines(), "\n")); err != nil {
+		t.Fatalf("Expected the message to pass the strict checks, got %v", err)
+	}
+}
+
+func TestOrderMessageBuilderDelimiters(t *testing.T) {
+	message, err := astm.NewOrderMessage().
+		Delimiters(astm.Delimiters{Field: '!', Repeat: '~', Component: '#', Escape: '$'}).
+		Patient(&astm.PatientRecord{Name: astm.NewField("Doe", "John")}).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build: %v", err)
+	}
+	if lines := strings.Join(message.Lines(), "\n"); lines != "H!~#$\nP!1!!!!Doe#John\nL!1!N" {
+		t.Fatalf("Unexpected message %q", lines)
+	}
+}
+
+func TestOrderMessageBuilderErrors(t *testing.T) {
+	if _, err := astm.NewOrderMessage().Order(&astm.OrderRecord{}).Patient(&astm.PatientRecord{}).Build(); err == nil {
+		t.Error("Expected an error for an order added before any patient")
+	}
+	if _, err := astm.NewOrderMessage().Build(); err == nil {
+		t.Error("Expected an error for a message without patients")
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestCharsetDecode(t *testing.T) {
+	tests := []struct {
+		charset  astm.Charset
+		text     string
+		expected string
+	}{
+		{astm.CharsetPassThrough, "M\xfcller", "M\xfcller"},
+		{astm.CharsetUTF8, "Müller", "Müller"},
+		{astm.CharsetUTF8, "M\xfcller", "M�ller"},
+		{astm.CharsetLatin1, "M\xfcller \x80", "Müller \u0080"},
+		{astm.CharsetWindows1252, "M\xfcller \x80 \x93quoted\x94 \x81", "Müller € “quoted” \u0081"},
+		{astm.CharsetASCII, "M\xfcller", "M�ller"},
+		{astm.CharsetLatin1, "Doe^John", "Doe^John"},
+	}
+	for _, test := range tests {
+		if decoded := test.charset.Decode(test.text); decoded != test.expected {
+			t.Errorf("Expected %q decoded with charset %v to be %q, got %q", test.text, test.charset, test.expected, decoded)
+		}
+	}
+}
+
+func TestCharsetEncode(t *testing.T) {
+	tests := []struct {
+		charset  astm.Charset
+		text     string
+		expected string
+	}{
+		{astm.CharsetPassThrough, "Müller", "Müller"},
+		{astm.CharsetUTF8, "Müller", "Müller"},
+		{astm.CharsetLatin1, "Müller €", "M\xfcller ?"},
+		{astm.CharsetWindows1252, "Müller € “quoted”", "M\xfcller \x80 \x93quoted\x94"},
+		{astm.CharsetWindows1252, "\u0080", "?"},
+		{astm.CharsetASCII, "Müller", "M?ller"},
+	}
+	for _, test := range tests {
+		if encoded := test.charset.Encode(test.text); encoded != test.expected {
+			t.Errorf("Expected %q encoded with charset %v to be %q, got %q", test.text, test.charset, test.expected, encoded)
+		}
+	}
+}
+
+func TestParseMessageCharset(t *testing.T) {
+	raw := "H|\\^&\nP|1||PID001||M\xfcller^J\xfcrgen\nC|1|I|caf\xe9 &XE9&|G\nL|1|N\n"
+	message, err := astm.ParseMessage(raw, astm.ParseOptions{Strictness: astm.Lenient, Charset: astm.CharsetLatin1})
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if name := message.Patients[0].Name; name.Component(1) != "Müller" || name.Component(2) != "Jürgen" {
+		t.Fatalf("Unexpected patient name %q", name)
+	}
+	// the bytes of an escape sequence are decoded like the others
+	comment := message.Records[2].(*astm.CommentRecord)
+	if text := comment.Text.Component(1); text != "café é" {
+		t.Fatalf("Unexpected comment text %q", text)
+	}
+
+	message, err = astm.ParseMessage(raw, astm.ParseOptions{Strictness: astm.Lenient})
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if name := message.Patients[0].Name; name.Component(1) != "M\xfcller" {
+		t.Fatalf("Expected the patient name to be passed through, got %q", name)
+	}
+}
+
+func TestASTMConnectionCharset(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	astmConn.SetCharset(astm.CharsetWindows1252)
+	inbound := string([]byte{constants.ENQ}) + frame(1, "P|1||PID001||M\xfcller\x80", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if message != "P|1||PID001||Müller€\n" {
+		t.Fatalf("Unexpected message %q", message)
+	}
+
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	before := len(mockConn.Written())
+	if err := astmConn.SendMessage(context.Background(), []string{"P|1||PID001||Müller€"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expected := string([]byte{constants.ENQ}) + frame(1, "P|1||PID001||M\xfcller\x80", true) + string([]byte{constants.EOT})
+	if written := mockConn.Written()[before:]; !bytes.Equal(written, []byte(expected)) {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
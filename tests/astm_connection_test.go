@@ -1,5 +1,220 @@
 package tests
 
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
 func testASTMConnectionConnectDisconnect() {
 
 }
+
+// startReceiver starts a local TCP server acting as an ASTM receiver, it answers ENQ with enqReply
+// and every frame with frameReply, and posts the frames it received on the returned channel on EOT
+func startReceiver(t *testing.T, enqReply byte, frameReply byte) (string, string, chan []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var frames []string
+		var frame []byte
+		for {
+			bt, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			switch bt {
+			case constants.ENQ:
+				_, _ = conn.Write([]byte{enqReply})
+			case constants.EOT:
+				received <- frames
+				frames = nil
+			case constants.LF:
+				frames = append(frames, string(append(frame, bt)))
+				frame = nil
+				_, _ = conn.Write([]byte{frameReply})
+			default:
+				frame = append(frame, bt)
+			}
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, received
+}
+
+func connectASTM(t *testing.T, host string, port string) *lis1a2.ASTMConnection {
+	t.Helper()
+	var tcpConn = connection.NewTCPConnection(host, port)
+	astmConn := lis1a2.NewASTMConnection(&tcpConn, false)
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+	return astmConn
+}
+
+func TestSendMessage(t *testing.T) {
+	host, port, received := startReceiver(t, constants.ACK, constants.ACK)
+	astmConn := connectASTM(t, host, port)
+	longRecord := "R|1|" + strings.Repeat("A", constants.MaxFrameSize)
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", longRecord, "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	frames := <-received
+	if len(frames) != 4 {
+		t.Fatalf("Expected 4 frames, got %v: %q", len(frames), frames)
+	}
+	for i, frame := range frames {
+		expectedFrameNumber := byte('0' + (i+1)%8)
+		if frame[1] != expectedFrameNumber {
+			t.Fatalf("Expected frame number %c, got %c", expectedFrameNumber, frame[1])
+		}
+	}
+	if frames[1][len(frames[1])-5] != constants.ETB || frames[2][len(frames[2])-5] != constants.ETX {
+		t.Fatalf("Expected the long record to be split in an ETB and an ETX frame: %q", frames[1:3])
+	}
+}
+
+func TestSendMessageEstablishmentFails(t *testing.T) {
+	host, port, _ := startReceiver(t, constants.NAK, constants.ACK)
+	astmConn := connectASTM(t, host, port)
+	astmConn.SetSenderOptions(lis1a2.SenderOptions{BusyBackoff: 10 * time.Millisecond})
+	err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"})
+	if err == nil || !strings.Contains(err.Error(), "establishment") {
+		t.Fatalf("Expected an establishment phase error, got %v", err)
+	}
+}
+
+func TestSendMessageTransferFails(t *testing.T) {
+	host, port, _ := startReceiver(t, constants.ACK, constants.NAK)
+	astmConn := connectASTM(t, host, port)
+	err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"})
+	if err == nil || !strings.Contains(err.Error(), "transfer") {
+		t.Fatalf("Expected a transfer phase error, got %v", err)
+	}
+}
+
+// frame builds a frame with its checksum, terminated by ETX when last is set and by ETB otherwise
+func frame(frameNumber int, text string, last bool) string {
+	body := fmt.Sprintf("%d%v", frameNumber%8, text)
+	if last {
+		body += string([]byte{constants.CR, constants.ETX})
+	} else {
+		body += string([]byte{constants.ETB})
+	}
+	sum := 0
+	for _, bt := range []byte(body) {
+		sum = (sum + int(bt)) % 256
+	}
+	return fmt.Sprintf("\x02%v%02X\r\n", body, sum)
+}
+
+// startSender starts a local TCP server acting as an ASTM sender, it sends ENQ, the frames
+// and EOT, waiting for a reply after every one of them except EOT
+func startSender(t *testing.T, frames []string) (string, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reply := make([]byte, 1)
+		for _, data := range append([]string{string([]byte{constants.ENQ})}, frames...) {
+			if _, err := conn.Write([]byte(data)); err != nil {
+				return
+			}
+			if _, err := conn.Read(reply); err != nil {
+				return
+			}
+		}
+		_, _ = conn.Write([]byte{constants.EOT})
+		_, _ = conn.Read(reply)
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+func TestReadMessageReassemblesFrames(t *testing.T) {
+	host, port := startSender(t, []string{
+		frame(1, "H|\\^&", true),
+		frame(2, "R|1|^^^GLU|", false),
+		frame(3, "5.4|mmol/L", true),
+		frame(4, "L|1", true),
+	})
+	astmConn := connectASTM(t, host, port)
+	message, err := astmConn.ReadMessage(time.Second * 5)
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if expected := "H|\\^&\nR|1|^^^GLU|5.4|mmol/L\nL|1\n"; message != expected {
+		t.Fatalf("Expected %q, got %q", expected, message)
+	}
+}
+
+func TestReadMessageFrameNumberGap(t *testing.T) {
+	host, port := startSender(t, []string{
+		frame(1, "H|\\^&", true),
+		frame(3, "L|1", true),
+	})
+	astmConn := connectASTM(t, host, port)
+	if _, err := astmConn.ReadMessage(time.Second * 5); err == nil {
+		t.Fatalf("Expected an error for a frame number gap")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	host, port, received := startReceiver(t, constants.ACK, constants.ACK)
+	var tcpConn = connection.NewTCPConnection(host, port)
+	astmConn := lis1a2.NewASTMConnection(&tcpConn, false)
+	var framesSent, controlBytes int
+	var states []constants.LIS1A2ConnectionStatus
+	astmConn.OnFrameSent(func(raw string) { framesSent++ })
+	astmConn.OnControlByte(func(b byte) { controlBytes++ })
+	astmConn.OnStateChange(func(old constants.LIS1A2ConnectionStatus, new constants.LIS1A2ConnectionStatus) {
+		states = append(states, new)
+	})
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	<-received
+	if framesSent != 2 {
+		t.Fatalf("Expected 2 frames sent, got %v", framesSent)
+	}
+	expectedStates := []constants.LIS1A2ConnectionStatus{constants.Establishing, constants.Sending, constants.Idle}
+	if fmt.Sprint(states) != fmt.Sprint(expectedStates) {
+		t.Fatalf("Expected states %v, got %v", expectedStates, states)
+	}
+	if controlBytes != 3 {
+		t.Fatalf("Expected 3 ACKs received, got %v", controlBytes)
+	}
+}
@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+func TestFieldAccess(t *testing.T) {
+	message, err := astm.ParseMessage("H|\\^&\nP|1\nO|1|SID001||^^^GLU^1\\^^^NA|R\nL|1|N\n")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	testID := message.Patients[0].Orders[0].UniversalTestID
+	if testID.Repeats() != 2 {
+		t.Fatalf("Expected 2 repeats, got %v", testID.Repeats())
+	}
+	if testID.Component(4) != "GLU" || testID.Component(5) != "1" {
+		t.Fatalf("Unexpected components of the first repeat %q", testID)
+	}
+	if testID.Repeat(2).Component(4) != "NA" {
+		t.Fatalf("Unexpected test code of the second repeat %q", testID.Repeat(2).Component(4))
+	}
+	if testID.Repeat(3) != nil || testID.Component(6) != "" || testID.Repeat(3).Component(1) != "" {
+		t.Fatal("Expected the missing repeats and components to be empty")
+	}
+}
+
+func TestFieldSetters(t *testing.T) {
+	var testID astm.Field
+	testID.SetComponent(4, "GLU")
+	testID.SetRepeatComponent(2, 4, "NA")
+	testID.AddRepeat("", "", "", "K")
+	record := &astm.OrderRecord{SequenceNumber: 1, UniversalTestID: testID, Priority: astm.NewField("R")}
+	if line := astm.SerializeRecord(record, astm.DefaultDelimiters); line != "O|1|||^^^GLU\\^^^NA\\^^^K|R" {
+		t.Fatalf("Unexpected record line %q", line)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+func TestMessageMarshalJSON(t *testing.T) {
+	message, err := astm.ParseMessage("H|\\^&|||Analyzer^1.0\nP|1||PID001||Doe^John\nO|1|SID001||^^^GLU\\^^^NA|R\nR|1|^^^GLU|5&F&4\nL|1|N\n")
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	expected := `{"delimiters":{"field":"|","repeat":"\\","component":"^","escape":"\u0026"},"records":[` +
+		`{"type":"H","fields":[[["H"]],[["\\^\u0026"]],[],[],[["Analyzer","1.0"]]]},` +
+		`{"type":"P","fields":[[["P"]],[["1"]],[],[["PID001"]],[],[["Doe","John"]]]},` +
+		`{"type":"O","fields":[[["O"]],[["1"]],[["SID001"]],[],[["","","","GLU"],["","","","NA"]],[["R"]]]},` +
+		`{"type":"R","fields":[[["R"]],[["1"]],[["","","","GLU"]],[["5|4"]]]},` +
+		`{"type":"L","fields":[[["L"]],[["1"]],[["N"]]]}]}`
+	if string(encoded) != expected {
+		t.Fatalf("Expected\n%v\ngot\n%s", expected, encoded)
+	}
+
+	var decoded astm.Message
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if strings.Join(decoded.Lines(), "\n") != strings.Join(message.Lines(), "\n") {
+		t.Fatalf("Expected %q after a round trip, got %q", message.Lines(), decoded.Lines())
+	}
+	if len(decoded.Patients) != 1 || len(decoded.Patients[0].Orders[0].Results) != 1 {
+		t.Fatalf("Expected the hierarchy to be rebuilt, got %v patients", len(decoded.Patients))
+	}
+	if value := decoded.Patients[0].Orders[0].Results[0].Value.Component(1); value != "5|4" {
+		t.Fatalf("Expected the value to be unescaped, got %q", value)
+	}
+}
+
+func TestMessageMarshalJSONKeepsDelimiters(t *testing.T) {
+	message, err := astm.ParseMessage("H!@~%\nP!1!!PID001!!Doe~John\nL!1!N\n")
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	var decoded astm.Message
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if decoded.Delimiters != message.Delimiters {
+		t.Fatalf("Expected the delimiters %v, got %v", message.Delimiters, decoded.Delimiters)
+	}
+	if lines := decoded.Lines(); lines[1] != "P!1!!PID001!!Doe~John" {
+		t.Fatalf("Unexpected patient record %q", lines[1])
+	}
+}
+
+func TestMessageUnmarshalJSONFailures(t *testing.T) {
+	tests := map[string]string{
+		"invalid delimiters": `{"delimiters":{"field":"||","repeat":"\\","component":"^","escape":"&"},"records":[]}`,
+		"invalid type":       `{"delimiters":{"field":"|","repeat":"\\","component":"^","escape":"&"},"records":[{"type":"HH"}]}`,
+		"missing header":     `{"delimiters":{"field":"|","repeat":"\\","component":"^","escape":"&"},"records":[{"type":"L","fields":[[["L"]],[["1"]]]}]}`,
+	}
+	for name, data := range tests {
+		var message astm.Message
+		if err := json.Unmarshal([]byte(data), &message); err == nil {
+			t.Errorf("Expected unmarshalling a message with %v to fail", name)
+		}
+	}
+}
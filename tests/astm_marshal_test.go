@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// vendorResult models a result record with a proprietary field 15 carrying a dilution factor
+type vendorResult struct {
+	RecordType     string     `astm:"1"`
+	SequenceNumber int        `astm:"2"`
+	TestCode       string     `astm:"3.1.4"`
+	Value          float64    `astm:"4"`
+	Units          string     `astm:"5"`
+	ReferenceRange []string   `astm:"6"`
+	Flags          astm.Field `astm:"7"`
+	CompletedAt    time.Time  `astm:"13"`
+	Dilution       int        `astm:"15,omitempty"`
+	Ignored        string     `astm:"-"`
+}
+
+func TestUnmarshalRecord(t *testing.T) {
+	var result vendorResult
+	err := astm.Unmarshal("R|1|^^^GLU|5.4|mmol/L|3.9^6.1|N\\H||F||||20240101123000||10", &result)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if result.RecordType != "R" || result.SequenceNumber != 1 || result.TestCode != "GLU" || result.Value != 5.4 || result.Units != "mmol/L" {
+		t.Fatalf("Unexpected values %+v", result)
+	}
+	if len(result.ReferenceRange) != 2 || result.ReferenceRange[1] != "6.1" {
+		t.Fatalf("Unexpected reference range %q", result.ReferenceRange)
+	}
+	if result.Flags.Repeats() != 2 || result.Flags.Repeat(2).Component(1) != "H" {
+		t.Fatalf("Unexpected flags %q", result.Flags)
+	}
+	if !result.CompletedAt.Equal(time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)) || result.Dilution != 10 {
+		t.Fatalf("Unexpected completion time or dilution %v %v", result.CompletedAt, result.Dilution)
+	}
+}
+
+func TestMarshalRecord(t *testing.T) {
+	result := vendorResult{
+		RecordType:     "R",
+		SequenceNumber: 2,
+		TestCode:       "NA",
+		Value:          150,
+		Units:          "mmol/L",
+		ReferenceRange: []string{"135", "145"},
+		Flags:          astm.NewField("H"),
+		Ignored:        "not written",
+	}
+	line, err := astm.Marshal(&result)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if line != "R|2|^^^NA|150|mmol/L|135^145|H" {
+		t.Fatalf("Unexpected record line %q", line)
+	}
+
+	var parsed vendorResult
+	if err := astm.Unmarshal(line, &parsed); err != nil || parsed.TestCode != "NA" || parsed.Value != 150 {
+		t.Fatalf("Expected the record line to unmarshal back, got %+v, %v", parsed, err)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	var result vendorResult
+	if err := astm.Unmarshal("R|1|^^^GLU|high", &result); err == nil {
+		t.Error("Expected an error for a value which is not a number")
+	}
+	if err := astm.Unmarshal("R|1", result); err == nil {
+		t.Error("Expected an error for a target which is not a pointer")
+	}
+	if _, err := astm.Marshal(struct {
+		Value string `astm:"3"`
+	}{"x"}); err == nil {
+		t.Error("Expected an error for a struct without a record type")
+	}
+	if _, err := astm.Marshal(struct {
+		RecordType string `astm:"1.x"`
+	}{"R"}); err == nil {
+		t.Error("Expected an error for an invalid tag")
+	}
+}
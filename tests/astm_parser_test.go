@@ -0,0 +1,314 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+const resultMessage = "H|\\^&|||Analyzer^1.0|||||||P|1|20240101120000\n" +
+	"P|1||PID001||Doe^John\n" +
+	"O|1|SID001||^^^GLU|R\n" +
+	"R|1|^^^GLU|5.4|mmol/L|3.9^6.1|N||F\n" +
+	"C|1|I|Fasting sample|G\n" +
+	"R|2|^^^NA|150|mmol/L|135^145|H||F\n" +
+	"L|1|N\n"
+
+func TestParseMessage(t *testing.T) {
+	message, err := astm.ParseMessage(resultMessage)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if len(message.Records) != 7 {
+		t.Fatalf("Expected 7 records, got %v", len(message.Records))
+	}
+	header := message.Records[0].(*astm.HeaderRecord)
+	if header.SenderName[0][0] != "Analyzer" || header.SenderName[0][1] != "1.0" {
+		t.Fatalf("Unexpected sender name %q", header.SenderName)
+	}
+	result := message.Records[5].(*astm.ResultRecord)
+	if result.SequenceNumber != 2 || result.UniversalTestID[0][3] != "NA" || result.Units[0][0] != "mmol/L" {
+		t.Fatalf("Unexpected result record %+v", result)
+	}
+	if result.ReferenceRange[0][0] != "135" || result.ReferenceRange[0][1] != "145" || result.AbnormalFlags[0][0] != "H" {
+		t.Fatalf("Unexpected reference range or flags %q %q", result.ReferenceRange, result.AbnormalFlags)
+	}
+}
+
+func TestParseMessageWithDeclaredDelimiters(t *testing.T) {
+	raw := strings.NewReplacer("|", "!", "^", "~", "\\", "@").Replace(resultMessage)
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	result := message.Records[3].(*astm.ResultRecord)
+	if result.ReferenceRange[0][1] != "6.1" {
+		t.Fatalf("Unexpected reference range %q", result.ReferenceRange)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	for name, raw := range map[string]string{
+		"unknown record type": "H|\\^&\nX|1\nL|1\n",
+		"sequence gap":        "H|\\^&\nP|1\nP|3\nL|1\n",
+		"missing header":      "P|1\nL|1\n",
+	} {
+		if _, err := astm.ParseMessage(raw); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestParseRequestManufacturerAndScientificRecords(t *testing.T) {
+	raw := "H|\\^&\n" +
+		"Q|1|^SID001||^^^ALL||20240101000000|20240102000000|||||O\n" +
+		"M|1|VENDOR^X|42\n" +
+		"S|1|Enzymatic|Analyzer^1.0|Lot42|mmol/L|QC1|Serum||Tube|SID001|GLU|5.4|mmol/L\n" +
+		"L|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	request, ok := message.Records[1].(*astm.RequestRecord)
+	if !ok || request.StartingRangeID[0][1] != "SID001" || request.UniversalTestID[0][3] != "ALL" || request.StatusCodes[0][0] != "O" {
+		t.Fatalf("Unexpected request record %+v", message.Records[1])
+	}
+	manufacturer, ok := message.Records[2].(*astm.ManufacturerRecord)
+	if !ok || manufacturer.SequenceNumber != 1 || manufacturer.Fields[2][0][1] != "X" {
+		t.Fatalf("Unexpected manufacturer record %+v", message.Records[2])
+	}
+	scientific, ok := message.Records[3].(*astm.ScientificRecord)
+	if !ok || scientific.Analyte[0][0] != "GLU" || scientific.Result[0][0] != "5.4" || scientific.ResultUnits[0][0] != "mmol/L" {
+		t.Fatalf("Unexpected scientific record %+v", message.Records[3])
+	}
+	if lines := message.Lines(); strings.Join(lines, "\n")+"\n" != raw {
+		t.Fatalf("Expected the records to be written back as they were parsed, got %q", lines)
+	}
+}
+
+func TestParseMessageHierarchy(t *testing.T) {
+	raw := "H|\\^&\nC|1|L|Batch 7\n" +
+		"P|1||PID001\nO|1|SID001||^^^GLU\nR|1|^^^GLU|5.4\nC|1|I|Fasting\nR|2|^^^NA|150\nO|2|SID002||^^^K\nR|1|^^^K|4.1\n" +
+		"P|2||PID002\nC|1|L|Allergic\nO|1|SID003||^^^GLU\n" +
+		"L|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if message.Header == nil || message.Terminator == nil || len(message.Comments) != 1 || len(message.Patients) != 2 {
+		t.Fatalf("Unexpected message %+v", message)
+	}
+	first := message.Patients[0]
+	if first.SequenceNumber != 1 || first.LaboratoryPatientID[0][0] != "PID001" || len(first.Orders) != 2 {
+		t.Fatalf("Unexpected first patient %+v", first)
+	}
+	if results := first.Orders[0].Results; len(results) != 2 || results[0].Value[0][0] != "5.4" ||
+		len(results[0].Comments) != 1 || results[0].Comments[0].Text[0][0] != "Fasting" || len(results[1].Comments) != 0 {
+		t.Fatalf("Unexpected results of the first order %+v", results)
+	}
+	if results := first.Orders[1].Results; len(results) != 1 || results[0].SequenceNumber != 1 {
+		t.Fatalf("Unexpected results of the second order %+v", results)
+	}
+	second := message.Patients[1]
+	if len(second.Comments) != 1 || len(second.Orders) != 1 || len(second.Orders[0].Results) != 0 {
+		t.Fatalf("Unexpected second patient %+v", second)
+	}
+}
+
+func TestParseMessageErrorPositions(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		line  int
+		field int
+	}{
+		{"sequence gap", "H|\\^&\nP|1\nP|3\nL|1\n", 3, 2},
+		{"unknown record type", "H|\\^&\nX|1\nL|1\n", 2, 1},
+		{"order without patient", "H|\\^&\nO|1\nL|1\n", 2, 0},
+		{"result without order", "H|\\^&\nP|1\nR|1\nL|1\n", 3, 0},
+		{"record after terminator", "H|\\^&\nL|1\nP|1\n", 3, 0},
+		{"second header", "H|\\^&\nP|1\nH|\\^&\n", 3, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := astm.ParseMessage(test.raw)
+			var parseErr *astm.ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected a *ParseError, got %v", err)
+			}
+			if !errors.Is(err, astm.ErrMalformedRecord) {
+				t.Fatalf("Expected the error to match ErrMalformedRecord, got %v", err)
+			}
+			if parseErr.Line != test.line || parseErr.Field != test.field {
+				t.Fatalf("Expected the error at line %v, field %v, got %v", test.line, test.field, err)
+			}
+		})
+	}
+}
+
+func TestSerializeRecordsBuiltFromScratch(t *testing.T) {
+	message := &astm.Message{Records: []astm.Record{
+		&astm.HeaderRecord{Delimiters: astm.Delimiters{Field: '!', Repeat: '~', Component: '#', Escape: '$'}, SenderName: astm.Field{{"LIS", "2.0"}}},
+		&astm.PatientRecord{SequenceNumber: 1, LaboratoryPatientID: astm.Field{{"PID001"}}, Name: astm.Field{{"Doe", "John"}}},
+		&astm.OrderRecord{SequenceNumber: 1, SpecimenID: astm.Field{{"SID001"}}, UniversalTestID: astm.Field{{"", "", "", "GLU"}, {"", "", "", "NA"}}},
+		&astm.TerminatorRecord{SequenceNumber: 1, TerminationCode: astm.Field{{"N"}}},
+	}}
+	expected := []string{
+		"H!~#$!!!LIS#2.0",
+		"P!1!!PID001!!Doe#John",
+		"O!1!SID001!!###GLU~###NA",
+		"L!1!N",
+	}
+	lines := message.Lines()
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+	parsed, err := astm.ParseMessage(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse the serialized message: %v", err)
+	}
+	if name := parsed.Patients[0].Name; len(name) != 1 || len(name[0]) != 2 || name[0][1] != "John" {
+		t.Fatalf("Expected the patient name to survive, got %v", name)
+	}
+}
+
+func TestSerializeChangedRecord(t *testing.T) {
+	message, err := astm.ParseMessage(resultMessage)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	result := message.Patients[0].Orders[0].Results[0]
+	result.Value = astm.Field{{"5.6"}}
+	line := astm.SerializeRecord(result.ResultRecord, astm.DefaultDelimiters)
+	if line != "R|1|^^^GLU|5.6|mmol/L|3.9^6.1|N||F" {
+		t.Fatalf("Expected the changed value with the other fields kept, got %q", line)
+	}
+}
+
+func TestDetectDelimiters(t *testing.T) {
+	delimiters, err := astm.DetectDelimiters("H!~#$!!!Analyzer")
+	if err != nil {
+		t.Fatalf("Failed to detect the delimiters: %v", err)
+	}
+	if delimiters != (astm.Delimiters{Field: '!', Repeat: '~', Component: '#', Escape: '$'}) {
+		t.Fatalf("Unexpected delimiters %+v", delimiters)
+	}
+	for _, header := range []string{"H|\\^", "P|\\^&", "H||^&", "H|\\A&", "H| ^&"} {
+		if _, err := astm.DetectDelimiters(header); err == nil {
+			t.Errorf("Expected an error for the header %q", header)
+		}
+	}
+}
+
+func TestParseMessageEscapeSequences(t *testing.T) {
+	raw := "H|\\^&\nP|1||PID&F&001||Doe&S&Smith^J&R&K&E&Co\nC|1|I|line one&X0D0A&line two|G\nL|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	patient := message.Patients[0]
+	if patient.LaboratoryPatientID[0][0] != "PID|001" {
+		t.Fatalf("Expected the field delimiter to be unescaped, got %q", patient.LaboratoryPatientID)
+	}
+	if name := patient.Name[0]; len(name) != 2 || name[0] != "Doe^Smith" || name[1] != "J\\K&Co" {
+		t.Fatalf("Expected the component, repeat and escape delimiters to be unescaped, got %q", patient.Name)
+	}
+	if text := patient.Comments[0].Text[0][0]; text != "line one\r\nline two" {
+		t.Fatalf("Expected the hexadecimal bytes to be unescaped, got %q", text)
+	}
+	if lines := message.Lines(); strings.Join(lines, "\n")+"\n" != strings.Replace(raw, "&X0D0A&", "&X0D&&X0A&", 1) {
+		t.Fatalf("Expected the values to be escaped again, got %q", lines)
+	}
+}
+
+func TestParseMessageUnknownEscapes(t *testing.T) {
+	raw := "H|\\^&\nP|1||&H&bold&N&||Doe\nL|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if id := message.Patients[0].LaboratoryPatientID[0][0]; id != "&H&bold&N&" {
+		t.Fatalf("Expected the unknown escapes to be passed through, got %q", id)
+	}
+
+	_, err = astm.ParseMessage(raw, astm.ParseOptions{UnknownEscapes: astm.EscapeReject})
+	var parseErr *astm.ParseError
+	if !errors.As(err, &parseErr) || !errors.Is(err, astm.ErrUnknownEscape) {
+		t.Fatalf("Expected a *ParseError wrapping ErrUnknownEscape, got %v", err)
+	}
+	if parseErr.Line != 2 || parseErr.Field != 4 {
+		t.Fatalf("Expected the error at line 2, field 4, got %v", err)
+	}
+}
+
+func TestParseMessageStrictness(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		lenient bool
+		off     bool
+	}{
+		{"sequence gap", "H|\\^&\nP|1\nP|3\nL|1\n", true, true},
+		{"invalid sequence number", "H|\\^&\nP|x\nL|1\n", true, true},
+		{"missing terminator", "H|\\^&\nP|1\n", true, true},
+		{"result without order", "H|\\^&\nP|1\nR|1\nC|1|I|orphan\nL|1\n", true, true},
+		{"record after terminator", "H|\\^&\nL|1\nP|1\n", false, true},
+		{"second header", "H|\\^&\nP|1\nH|\\^&\nL|1\n", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := astm.ParseMessage(test.raw); err == nil {
+				t.Error("Expected the strict parsing to fail")
+			}
+			_, err := astm.ParseMessage(test.raw, astm.ParseOptions{Strictness: astm.Lenient})
+			if (err == nil) != test.lenient {
+				t.Errorf("Unexpected result of the lenient parsing: %v", err)
+			}
+			_, err = astm.ParseMessage(test.raw, astm.ParseOptions{Strictness: astm.Off})
+			if (err == nil) != test.off {
+				t.Errorf("Unexpected result of the parsing without checks: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseMessageLenientHierarchy(t *testing.T) {
+	raw := "H|\\^&\nP|1\nR|1|^^^GLU|5.4\nC|1|I|orphan\nO|1|SID001\nR|2|^^^NA|150\n"
+	message, err := astm.ParseMessage(raw, astm.ParseOptions{Strictness: astm.Lenient})
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if len(message.Records) != 6 {
+		t.Fatalf("Expected all the records to be kept, got %v", len(message.Records))
+	}
+	patient := message.Patients[0]
+	if len(patient.Comments) != 0 || len(patient.Orders) != 1 || len(patient.Orders[0].Results) != 1 {
+		t.Fatalf("Expected the orphan result and its comment to be left out of the hierarchy, got %+v", patient)
+	}
+	if sequenceNumber := patient.Orders[0].Results[0].SequenceNumber; sequenceNumber != 2 {
+		t.Fatalf("Expected the sequence number as received, got %v", sequenceNumber)
+	}
+}
+
+func TestNewMessageNumbersRecords(t *testing.T) {
+	comment := &astm.CommentRecord{Text: astm.NewField("fasting")}
+	patients := []*astm.Patient{
+		{PatientRecord: &astm.PatientRecord{SequenceNumber: 9}, Orders: []*astm.Order{
+			{OrderRecord: &astm.OrderRecord{SpecimenID: astm.NewField("SID001")}, Comments: []*astm.CommentRecord{comment, comment}},
+		}},
+		{PatientRecord: &astm.PatientRecord{}},
+	}
+	message := astm.NewMessage(nil, patients, astm.TerminationNormal)
+	expected := "H|\\^&\nP|1\nO|1|SID001\nC|1||fasting\nC|2||fasting\nP|2\nL|1|N"
+	if lines := strings.Join(message.Lines(), "\n"); lines != expected {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+	if patients[0].SequenceNumber != 9 || comment.SequenceNumber != 0 {
+		t.Fatal("Expected the records given to be left as they are")
+	}
+	if _, err := astm.ParseMessage(strings.Join(message.Lines(), "\n")); err != nil {
+		t.Fatalf("Expected the message to parse, got %v", err)
+	}
+}
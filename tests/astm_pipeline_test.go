@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+const pipelineMessage = "H|\\^&\nP|1||||Doe^John||||||||||||\nO|1| 000123 ||^^^GLU\nR|1|^^^GLU|5.4|mmol/L\nL|1|N\n"
+
+func TestPipeline(t *testing.T) {
+	var visited []byte
+	pipeline := astm.NewPipeline(
+		astm.NormalizeSpecimenIDs(func(specimenID string) string {
+			return strings.TrimLeft(strings.TrimSpace(specimenID), "0")
+		}),
+	).Then(
+		astm.RedactPatientNames("***"),
+		func(record astm.Record) error {
+			visited = append(visited, record.RecordType())
+			return nil
+		},
+	)
+	message, err := astm.ParseMessage(pipelineMessage, astm.ParseOptions{Pipeline: pipeline})
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if specimenID := message.Patients[0].Orders[0].SpecimenID.Component(1); specimenID != "123" {
+		t.Fatalf("Expected the specimen ID normalized, got %q", specimenID)
+	}
+	if name := message.Patients[0].Name; name.Component(1) != "***" || name.Component(2) != "***" {
+		t.Fatalf("Expected the name redacted, got %q", name)
+	}
+	if string(visited) != "HPORL" {
+		t.Fatalf("Expected every record in order, got %q", visited)
+	}
+}
+
+func TestPipelineRejects(t *testing.T) {
+	missingUnits := errors.New("result without units")
+	pipeline := astm.NewPipeline(func(record astm.Record) error {
+		if result, ok := record.(*astm.ResultRecord); ok && result.Units.Component(1) != "mg/dL" {
+			return missingUnits
+		}
+		return nil
+	})
+	_, err := astm.ParseMessage(pipelineMessage, astm.ParseOptions{Pipeline: pipeline})
+	var parseErr *astm.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 4 {
+		t.Fatalf("Expected a ParseError at line 4, got %v", err)
+	}
+	if !errors.Is(err, astm.ErrRecordRejected) || !errors.Is(err, missingUnits) {
+		t.Fatalf("Expected the error of the middleware wrapped, got %v", err)
+	}
+}
+
+func TestASTMConnectionRecordMiddleware(t *testing.T) {
+	messages := make(chan lis1a2.ReceivedMessage, 1)
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.UseRecordMiddleware(astm.NormalizeSpecimenIDs(strings.TrimSpace))
+		astmConn.OnMessage(func(message lis1a2.ReceivedMessage) { messages <- message })
+	})
+
+	inbound := string([]byte{constants.ENQ})
+	for index, record := range strings.Split(strings.TrimSuffix(pipelineMessage, "\n"), "\n") {
+		inbound += frame(index+1, record, true)
+	}
+	if err := mockConn.Inject([]byte(inbound + string([]byte{constants.EOT}))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	var received lis1a2.ReceivedMessage
+	select {
+	case received = <-messages:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the message to be received")
+	}
+	if !strings.Contains(received.Text, " 000123 ") {
+		t.Fatalf("Expected the text received as it was sent, got %q", received.Text)
+	}
+	message, err := received.ParseWith(astm.ParseOptions{Pipeline: astm.NewPipeline(astm.RedactPatientNames("-"))})
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if specimenID := message.Patients[0].Orders[0].SpecimenID.Component(1); specimenID != "000123" {
+		t.Fatalf("Expected the specimen ID normalized by the connection, got %q", specimenID)
+	}
+	if name := message.Patients[0].Name.Component(1); name != "-" {
+		t.Fatalf("Expected the pipeline of the options to run as well, got %q", name)
+	}
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+// calibrationRecord is a vendor record Z reporting the calibration of an assay
+type calibrationRecord struct {
+	Assay  string  `astm:"3"`
+	Factor float64 `astm:"4"`
+}
+
+func (record *calibrationRecord) RecordType() byte { return 'Z' }
+
+func registerRecordType(t *testing.T, recordType byte, factory astm.RecordFactory) {
+	if err := astm.RegisterRecordType(recordType, factory); err != nil {
+		t.Fatalf("Failed to register record type %c: %v", recordType, err)
+	}
+	t.Cleanup(func() {
+		_ = astm.RegisterRecordType(recordType, nil)
+	})
+}
+
+func TestParseMessageWithCustomRecords(t *testing.T) {
+	registerRecordType(t, 'Z', func(fields []astm.Field) (astm.Record, error) {
+		var record calibrationRecord
+		return &record, astm.UnmarshalFields(fields, &record)
+	})
+	registerRecordType(t, 'M', astm.NewGenericRecord)
+
+	raw := "H|\\^&\nP|1\nZ|1|GLU|1.02\nM|7|vendor^data\nL|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	calibration, ok := message.Records[2].(*calibrationRecord)
+	if !ok || calibration.Assay != "GLU" || calibration.Factor != 1.02 {
+		t.Fatalf("Expected the Z record to be built by its factory, got %#v", message.Records[2])
+	}
+	generic, ok := message.Records[3].(*astm.GenericRecord)
+	if !ok || generic.Type != 'M' {
+		t.Fatalf("Expected the M record to be kept as a GenericRecord, got %#v", message.Records[3])
+	}
+	if line := astm.SerializeRecord(generic, astm.DefaultDelimiters); line != "M|7|vendor^data" {
+		t.Fatalf("Expected the generic record to be written back, got %q", line)
+	}
+}
+
+func TestParseMessageCustomRecordFailure(t *testing.T) {
+	registerRecordType(t, 'Z', func(fields []astm.Field) (astm.Record, error) {
+		var record calibrationRecord
+		return &record, astm.UnmarshalFields(fields, &record)
+	})
+	_, err := astm.ParseMessage("H|\\^&\nZ|1|GLU|high\nL|1|N\n")
+	var parseErr *astm.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 2 {
+		t.Fatalf("Expected a *ParseError at line 2, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "high") {
+		t.Fatalf("Expected the error of the factory, got %v", err)
+	}
+}
+
+func TestRegisterRecordTypeRejectsHierarchyRecords(t *testing.T) {
+	for _, recordType := range []byte("HPORCQL1") {
+		if err := astm.RegisterRecordType(recordType, astm.NewGenericRecord); err == nil {
+			t.Errorf("Expected an error registering record type %c", recordType)
+		}
+	}
+}
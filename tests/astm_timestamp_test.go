@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"20240101123045", time.Date(2024, 1, 1, 12, 30, 45, 0, time.UTC)},
+		{"202401011230", time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{"20240101", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"20240101123045+0130", time.Date(2024, 1, 1, 12, 30, 45, 0, time.FixedZone("", 90*60))},
+		{"20240101-0500", time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("", -5*60*60))},
+		{"", time.Time{}},
+	}
+	for _, test := range tests {
+		parsed, err := astm.ParseTimestamp(test.value)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.value, err)
+		} else if !parsed.Equal(test.expected) {
+			t.Errorf("Expected %q to be %v, got %v", test.value, test.expected, parsed)
+		}
+	}
+	for _, value := range []string{"202", "2024010", "20241301", "20240101123045+01", "2024010112304512", "today"} {
+		if _, err := astm.ParseTimestamp(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseTimestampInLocation(t *testing.T) {
+	location := time.FixedZone("analyzer", 2*60*60)
+	parsed, err := astm.ParseTimestampInLocation("20240101120000", location)
+	if err != nil || !parsed.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the timestamp in the location given, got %v, %v", parsed, err)
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	timestamp := time.Date(2024, 3, 9, 8, 5, 1, 0, time.FixedZone("", -3*60*60))
+	if formatted := astm.FormatTimestamp(timestamp); formatted != "20240309080501" {
+		t.Errorf("Unexpected timestamp %q", formatted)
+	}
+	if formatted := astm.FormatTimestampWithZone(timestamp); formatted != "20240309080501-0300" {
+		t.Errorf("Unexpected timestamp with zone %q", formatted)
+	}
+	if formatted := astm.FormatDate(timestamp); formatted != "20240309" {
+		t.Errorf("Unexpected date %q", formatted)
+	}
+	if astm.FormatTimestamp(time.Time{}) != "" {
+		t.Error("Expected a zero time to be left empty")
+	}
+}
+
+func TestRecordTimestamps(t *testing.T) {
+	message, err := astm.ParseMessage(resultMessage)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	sentAt, err := message.Header.Timestamp.Time()
+	if err != nil || !sentAt.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected header timestamp %v, %v", sentAt, err)
+	}
+	patient := &astm.PatientRecord{SequenceNumber: 1, Birthdate: astm.NewDateField(time.Date(1980, 5, 17, 0, 0, 0, 0, time.UTC))}
+	if line := astm.SerializeRecord(patient, astm.DefaultDelimiters); line != "P|1||||||19800517" {
+		t.Fatalf("Unexpected patient record %q", line)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestCaptureConnectionCapturesBothDirections(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	var capture bytes.Buffer
+	captureConn := connection.NewCaptureConnection(&mockConn, &capture)
+	if err := captureConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer captureConn.Disconnect()
+	captureConn.Listen()
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	before := time.Now()
+	if err := lis1a2.NewSender(captureConn).SendRecords(ctx, []string{"H|\\^&"}); err != nil {
+		t.Fatalf("Expected the message to be sent, got %v", err)
+	}
+
+	if !strings.HasPrefix(capture.String(), "LIS1A2CP\x00\x01") {
+		t.Fatalf("Expected the capture to start with its header, got %q", capture.String())
+	}
+	reader, err := connection.NewCaptureReader(&capture)
+	if err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+	expected := []connection.CaptureChunk{
+		{Direction: connection.CaptureSent, Data: []byte{constants.ENQ}},
+		{Direction: connection.CaptureReceived, Data: []byte{constants.ACK}},
+		{Direction: connection.CaptureSent, Data: []byte(frame(1, "H|\\^&", true))},
+		{Direction: connection.CaptureReceived, Data: []byte{constants.ACK}},
+		{Direction: connection.CaptureSent, Data: []byte{constants.EOT}},
+	}
+	for index, want := range expected {
+		chunk, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Failed to read chunk %d: %v", index+1, err)
+		}
+		if chunk.Direction != want.Direction || !bytes.Equal(chunk.Data, want.Data) || chunk.Time.Before(before) {
+			t.Fatalf("Expected chunk %d to be %v %q, got %v %q at %v", index+1, want.Direction, want.Data, chunk.Direction, chunk.Data, chunk.Time)
+		}
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the capture to end, got %v", err)
+	}
+}
+
+func TestCaptureReaderFailures(t *testing.T) {
+	if _, err := connection.NewCaptureReader(strings.NewReader("\x05\x02")); !errors.Is(err, connection.ErrNotACapture) {
+		t.Fatalf("Expected ErrNotACapture for a raw capture, got %v", err)
+	}
+
+	var capture bytes.Buffer
+	writer := connection.NewCaptureWriter(&capture)
+	if err := writer.WriteChunk(connection.CaptureChunk{Time: time.Unix(0, 42), Direction: connection.CaptureReceived, Data: []byte("\x02abc")}); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	truncated := capture.Bytes()[:capture.Len()-1]
+	reader, err := connection.NewCaptureReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected io.ErrUnexpectedEOF for a chunk cut short, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/config"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+const instrumentsConfig = `{
+  "instruments": [
+    {
+      "name": "cobas",
+      "transport": "tcp",
+      "address": "127.0.0.1:4000",
+      "reconnect": {"initial_backoff": "1s", "max_backoff": "1m"},
+      "connection": {"dial_timeout": "5s", "read_buffer_size": 32, "overflow": "error"},
+      "sender": {"max_attempts": 3, "frame_reply_timeout": "15s", "max_frame_size": 64, "role": "computer_system"},
+      "receiver": {"receive_timeout": "45s", "compatibility": "tolerant"},
+      "charset": "windows-1252"
+    },
+    {
+      "name": "sysmex",
+      "transport": "serial",
+      "serial": {"port": "/dev/ttyUSB0", "baud_rate": 19200, "parity": "even", "stop_bits": 2, "flow_control": "software"}
+    }
+  ]
+}`
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lis.json")
+	if err := os.WriteFile(path, []byte(instrumentsConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(loaded.Instruments) != 2 {
+		t.Fatalf("Expected 2 instruments, got %+v", loaded.Instruments)
+	}
+	cobas := loaded.Instruments[0]
+	expected := lis1a2.SenderOptions{MaxAttempts: 3, FrameReplyTimeout: 15 * time.Second, MaxFrameSize: 64, Role: constants.ComputerSystem}
+	if options := cobas.SenderOptions(); options != expected {
+		t.Fatalf("Expected the sender options %+v, got %+v", expected, options)
+	}
+	if options := cobas.ReceiverOptions(); options.ReceiveTimeout != 45*time.Second || options.Compatibility != lis1a2.Tolerant {
+		t.Fatalf("Unexpected receiver options %+v", options)
+	}
+	if time.Duration(cobas.Connection.DialTimeout) != 5*time.Second || time.Duration(cobas.Reconnect.MaxBackoff) != time.Minute {
+		t.Fatalf("Unexpected durations %+v and %+v", cobas.Connection, cobas.Reconnect)
+	}
+
+	conn, err := cobas.NewConnection()
+	if err != nil {
+		t.Fatalf("Failed to build connection: %v", err)
+	}
+	if _, ok := conn.(*connection.TCPConnection); !ok || conn.IsConnected() {
+		t.Fatalf("Expected a TCP connection not connected yet, got %T", conn)
+	}
+	if _, err := loaded.Instruments[1].NewConnection(); err != nil {
+		t.Fatalf("Failed to build serial connection: %v", err)
+	}
+	astmConn, err := cobas.NewASTMConnection()
+	if err != nil || astmConn.IsConnected() {
+		t.Fatalf("Expected an ASTM connection not connected yet, got %v", err)
+	}
+}
+
+func TestParseConfigWithUnmarshal(t *testing.T) {
+	var decoded []byte
+	unmarshal := func(data []byte, value any) error {
+		decoded = data
+		return json.Unmarshal([]byte(`{"instruments":[{"name":"a","transport":"tcp","address":"host:1"}]}`), value)
+	}
+	parsed, err := config.Parse([]byte("instruments: ..."), unmarshal)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if string(decoded) != "instruments: ..." || parsed.Instruments[0].Address != "host:1" {
+		t.Fatalf("Expected the unmarshal given to decode the config, got %+v", parsed)
+	}
+}
+
+func TestParseConfigFailures(t *testing.T) {
+	tests := map[string]string{
+		"no name":           `{"instruments":[{"transport":"tcp","address":"host:1"}]}`,
+		"duplicate name":    `{"instruments":[{"name":"a","transport":"tcp","address":"host:1"},{"name":"a","transport":"tcp","address":"host:2"}]}`,
+		"unknown transport": `{"instruments":[{"name":"a","transport":"udp","address":"host:1"}]}`,
+		"missing port":      `{"instruments":[{"name":"a","transport":"tcp","address":"host"}]}`,
+		"missing serial":    `{"instruments":[{"name":"a","transport":"serial"}]}`,
+		"unknown parity":    `{"instruments":[{"name":"a","transport":"serial","serial":{"port":"COM3","parity":"high"}}]}`,
+		"unknown charset":   `{"instruments":[{"name":"a","transport":"tcp","address":"host:1","charset":"ebcdic"}]}`,
+		"invalid duration":  `{"instruments":[{"name":"a","transport":"tcp","address":"host:1","sender":{"busy_backoff":"soon"}}]}`,
+		"tls reconnect":     `{"instruments":[{"name":"a","transport":"tls","address":"host:1","reconnect":{}}]}`,
+	}
+	for name, data := range tests {
+		_, err := config.Parse([]byte(data))
+		if !errors.Is(err, config.ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for a config with %v, got %v", name, err)
+		}
+	}
+	if _, err := config.Parse([]byte(`{"instruments":[{"name":"cobas","transport":"tcp"}]}`)); err == nil || !strings.Contains(err.Error(), "cobas") {
+		t.Errorf("Expected the error to name the instrument, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// connectConfirming connects an ASTMConnection listening over a MockConnection which confirms the messages
+// with confirm, delivering them on the returned channel
+func connectConfirming(t *testing.T, confirm func(message lis1a2.ReceivedMessage) error) (*connection.MockConnection, chan lis1a2.ReceivedMessage) {
+	t.Helper()
+	messages := make(chan lis1a2.ReceivedMessage, 1)
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetReceiverOptions(lis1a2.ReceiverOptions{BusyAfterRejection: 100 * time.Millisecond})
+		astmConn.OnConfirm(confirm)
+		astmConn.OnMessage(func(message lis1a2.ReceivedMessage) { messages <- message })
+	})
+	return mockConn, messages
+}
+
+// awaitMessage waits for a message to be delivered
+func awaitMessage(t *testing.T, messages chan lis1a2.ReceivedMessage) lis1a2.ReceivedMessage {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+	return lis1a2.ReceivedMessage{}
+}
+
+func TestConfirmRetransmission(t *testing.T) {
+	attempts := 0
+	mockConn, messages := connectConfirming(t, func(message lis1a2.ReceivedMessage) error {
+		if message.Text != "H|\\^&\nL|1\n" {
+			t.Errorf("Unexpected message confirmed %q", message.Text)
+		}
+		if attempts++; attempts == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + frame(2, "L|1", true) +
+		string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if message := awaitMessage(t, messages); message.Err != nil || message.Text != "H|\\^&\nL|1\n" {
+		t.Fatalf("Expected the message delivered once confirmed, got %q, error %v", message.Text, message.Err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK, constants.NAK, constants.ACK}) {
+		t.Fatalf("Expected the terminator NAKed until confirmed, got %q", written)
+	}
+}
+
+func TestConfirmRejected(t *testing.T) {
+	full := errors.New("disk full")
+	mockConn, messages := connectConfirming(t, func(message lis1a2.ReceivedMessage) error { return full })
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if message := awaitMessage(t, messages); !errors.Is(message.Err, lis1a2.ErrMessageRejected) || !errors.Is(message.Err, full) {
+		t.Fatalf("Expected the message rejected, got %q, error %v", message.Text, message.Err)
+	}
+
+	// busy for a while, ENQ is NAKed so that the instrument sends the message again later
+	if err := mockConn.Inject([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	expected := string([]byte{constants.ACK, constants.ACK, constants.NAK, constants.NAK})
+	deadline := time.Now().Add(time.Second)
+	for string(mockConn.Written()) != expected && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if written := mockConn.Written(); string(written) != expected {
+		t.Fatalf("Expected ENQ NAKed while busy, got %q", written)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := mockConn.Inject([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for len(mockConn.Written()) != len(expected)+1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if written := mockConn.Written(); written[len(written)-1] != constants.ACK {
+		t.Fatalf("Expected ENQ ACKed once no longer busy, got %q", written)
+	}
+}
+
+func TestConfirmWithoutTerminator(t *testing.T) {
+	confirmed := make(chan string, 1)
+	mockConn, messages := connectConfirming(t, func(message lis1a2.ReceivedMessage) error {
+		confirmed <- message.Text
+		return nil
+	})
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "P|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if message := awaitMessage(t, messages); message.Err != nil || <-confirmed != message.Text {
+		t.Fatalf("Expected the message confirmed at EOT, got %q, error %v", message.Text, message.Err)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestInstrumentSendsENQAgainOnContention(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	var enqs atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			if enqs.Add(1) == 1 {
+				_ = mockConn.Inject([]byte{constants.ENQ})
+			} else {
+				_ = mockConn.Inject([]byte{constants.ACK})
+			}
+		case constants.STX:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	start := time.Now()
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Expected the instrument to wait a second before sending ENQ again, waited %v", elapsed)
+	}
+	expected := string([]byte{constants.ENQ, constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
+
+func TestComputerSystemYieldsOnContention(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	astmConn.SetSenderOptions(lis1a2.SenderOptions{Role: constants.ComputerSystem, ContentionBackoff: 200 * time.Millisecond})
+	var enqs atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			if enqs.Add(1) == 1 {
+				_ = mockConn.Inject([]byte{constants.ENQ})
+			} else {
+				_ = mockConn.Inject([]byte{constants.ACK})
+			}
+		case constants.STX:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	astmConn.OnStateChange(func(old constants.LIS1A2ConnectionStatus, new constants.LIS1A2ConnectionStatus) {
+		if old == constants.Establishing && new == constants.Idle {
+			// the instrument sends ENQ again once the computer system yielded
+			inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "R|1|^^^GLU|5.4", true) + string([]byte{constants.EOT})
+			go func() { _ = mockConn.Inject([]byte(inbound)) }()
+		}
+	})
+	sent := make(chan error, 1)
+	go func() { sent <- astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}) }()
+
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil || message != "H|\\^&\nR|1|^^^GLU|5.4\n" {
+		t.Fatalf("Expected the message of the instrument to be received, got %q, %v", message, err)
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the message to be sent after the backoff")
+	}
+	expected := string([]byte{constants.ENQ, constants.ACK, constants.ACK, constants.ACK, constants.ENQ}) +
+		frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// resentMessage gives the frames of a result message sent at timestamp, from ENQ to EOT
+func resentMessage(timestamp string, value string) string {
+	return string([]byte{constants.ENQ}) + frame(1, "H|\\^&|||cobas|||||||P||"+timestamp, true) + frame(2, "P|1", true) +
+		frame(3, "O|1|SID001||^^^GLU", true) + frame(4, "R|1|^^^GLU|"+value+"|mmol/L||||F||||20240101120000", true) +
+		frame(5, "L|1|N", true) + string([]byte{constants.EOT})
+}
+
+// connectDeduplicating connects an ASTMConnection listening over a MockConnection detecting duplicates
+func connectDeduplicating(t *testing.T, options lis1a2.DuplicateOptions) (*connection.MockConnection, chan lis1a2.ReceivedMessage, chan lis1a2.ReceivedMessage) {
+	t.Helper()
+	messages := make(chan lis1a2.ReceivedMessage, 4)
+	duplicates := make(chan lis1a2.ReceivedMessage, 4)
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetDuplicateOptions(options)
+		astmConn.OnMessage(func(message lis1a2.ReceivedMessage) { messages <- message })
+		astmConn.OnDuplicate(func(message lis1a2.ReceivedMessage) { duplicates <- message })
+	})
+	return mockConn, messages, duplicates
+}
+
+func TestSuppressDuplicates(t *testing.T) {
+	mockConn, messages, duplicates := connectDeduplicating(t, lis1a2.DuplicateOptions{})
+	for _, timestamp := range []string{"20240101120500", "20240101121000"} {
+		if err := mockConn.Inject([]byte(resentMessage(timestamp, "5.4"))); err != nil {
+			t.Fatalf("Failed to inject: %v", err)
+		}
+	}
+	first := awaitMessage(t, messages)
+	if first.Duplicate || len(first.Fingerprint) != 64 {
+		t.Fatalf("Expected the first message fingerprinted, got %+v", first)
+	}
+	select {
+	case duplicate := <-duplicates:
+		if !duplicate.Duplicate || duplicate.Fingerprint != first.Fingerprint {
+			t.Fatalf("Expected the message sent again to fingerprint the same, got %+v", duplicate)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the duplicate to be reported")
+	}
+	select {
+	case message := <-messages:
+		t.Fatalf("Expected the duplicate suppressed, got %+v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFlagDuplicates(t *testing.T) {
+	mockConn, messages, _ := connectDeduplicating(t, lis1a2.DuplicateOptions{Policy: lis1a2.FlagDuplicates, Window: 100 * time.Millisecond})
+	if err := mockConn.Inject([]byte(resentMessage("20240101120500", "5.4") + resentMessage("20240101120600", "5.4"))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if first, second := awaitMessage(t, messages), awaitMessage(t, messages); first.Duplicate || !second.Duplicate {
+		t.Fatalf("Expected the second message flagged, got %v and %v", first.Duplicate, second.Duplicate)
+	}
+
+	// past the window the message is new again
+	time.Sleep(150 * time.Millisecond)
+	if err := mockConn.Inject([]byte(resentMessage("20240101130000", "5.4"))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if message := awaitMessage(t, messages); message.Duplicate {
+		t.Fatalf("Expected the message received past the window not to be a duplicate")
+	}
+}
+
+func TestMessageFingerprint(t *testing.T) {
+	parse := func(text string) *astm.Message {
+		message, err := astm.ParseMessage(text)
+		if err != nil {
+			t.Fatalf("Failed to parse: %v", err)
+		}
+		return message
+	}
+	result := "H|\\^&|||cobas\nP|1\nO|1|SID001||^^^GLU\nR|1|^^^GLU|5.4||||||||20240101120000\nL|1|N\n"
+	other := "H|\\^&|||cobas\nP|1\nO|1|SID002||^^^GLU\nR|1|^^^GLU|5.4||||||||20240101120000\nL|1|N\n"
+	if lis1a2.MessageFingerprint(parse(result)) == lis1a2.MessageFingerprint(parse(other)) {
+		t.Fatalf("Expected the results of other specimens to fingerprint differently")
+	}
+	if fingerprint := lis1a2.MessageFingerprint(parse("H|\\^&\nL|1|N\n")); fingerprint != "" {
+		t.Fatalf("Expected a message without results to have no fingerprint, got %q", fingerprint)
+	}
+}
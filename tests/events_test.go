@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// connectWatchingEvents connects an ASTMConnection over a mock connection, asking for its events first
+func connectWatchingEvents(t *testing.T) (*connection.MockConnection, *lis1a2.ASTMConnection, <-chan lis1a2.Event) {
+	t.Helper()
+	var events <-chan lis1a2.Event
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) { events = astmConn.Events() })
+	return mockConn, astmConn, events
+}
+
+// awaitEvents reads the next events, failing unless they are of the kinds given, in that order
+func awaitEvents(t *testing.T, events <-chan lis1a2.Event, kinds ...lis1a2.EventKind) []lis1a2.Event {
+	t.Helper()
+	var received []lis1a2.Event
+	for _, kind := range kinds {
+		select {
+		case event := <-events:
+			if event.Kind != kind {
+				t.Fatalf("Expected a %v event, got %v: %+v", kind, event.Kind, event)
+			}
+			received = append(received, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a %v event", kind)
+		}
+	}
+	return received
+}
+
+func TestEventsOfAMessageReceived(t *testing.T) {
+	mockConn, astmConn, events := connectWatchingEvents(t)
+	awaitEvents(t, events, lis1a2.EventConnected)
+
+	header, corrupt, terminator := frame(1, "H|\\^&", true), []byte(frame(2, "L|1", true)), frame(2, "L|1", true)
+	corrupt[len(corrupt)-3] ^= 0x01
+	inbound := string([]byte{constants.ENQ}) + header + string(corrupt) + terminator + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject the message: %v", err)
+	}
+
+	received := awaitEvents(t, events, lis1a2.EventFrameReceived, lis1a2.EventFrameRejected,
+		lis1a2.EventFrameReceived, lis1a2.EventMessageCompleted)
+	if received[0].Data != header || received[0].Direction != lis1a2.ExchangeReceived {
+		t.Fatalf("Expected the header frame received, got %+v", received[0])
+	}
+	if received[1].Data != string(corrupt) || !errors.Is(received[1].Err, connection.ErrChecksumMismatch) {
+		t.Fatalf("Expected the corrupt frame rejected with ErrChecksumMismatch, got %+v", received[1])
+	}
+	if message := received[3]; message.Data != "H|\\^&\nL|1\n" || message.Err != nil || message.Direction != lis1a2.ExchangeReceived {
+		t.Fatalf("Expected the message received, got %+v", message)
+	}
+
+	if err := astmConn.Disconnect(); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if disconnected := awaitEvents(t, events, lis1a2.EventDisconnected); disconnected[0].Err != nil {
+		t.Fatalf("Expected no reason after Disconnect, got %v", disconnected[0].Err)
+	}
+}
+
+func TestEventsOfMessagesSent(t *testing.T) {
+	mockConn, astmConn, events := connectWatchingEvents(t)
+	awaitEvents(t, events, lis1a2.EventConnected)
+	astmConn.SetSenderOptions(lis1a2.SenderOptions{FrameReplyTimeout: 50 * time.Millisecond})
+
+	// the first frame is NAKed once
+	naked := false
+	mockConn.OnWrite(func(data []byte) {
+		switch {
+		case data[0] == constants.STX && !naked:
+			naked = true
+			_ = mockConn.Inject([]byte{constants.NAK})
+		case data[0] != constants.EOT:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send the message: %v", err)
+	}
+	sent := awaitEvents(t, events, lis1a2.EventFrameRejected, lis1a2.EventMessageCompleted)
+	if rejected := sent[0]; rejected.Direction != lis1a2.ExchangeSent || rejected.Data != frame(1, "H|\\^&", true) ||
+		!errors.Is(rejected.Err, lis1a2.ErrFrameNAKed) {
+		t.Fatalf("Expected the first frame rejected with ErrFrameNAKed, got %+v", rejected)
+	}
+	if completed := sent[1]; completed.Data != "H|\\^&\nL|1\n" || completed.Err != nil {
+		t.Fatalf("Expected the message sent, got %+v", completed)
+	}
+
+	// the frames are left unanswered
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"})
+	if !errors.Is(err, lis1a2.ErrReplyTimeout) {
+		t.Fatalf("Expected ErrReplyTimeout, got %v", err)
+	}
+	expired := awaitEvents(t, events, lis1a2.EventTimerExpired, lis1a2.EventMessageCompleted)
+	if !errors.Is(expired[0].Err, lis1a2.ErrReplyTimeout) || !errors.Is(expired[1].Err, lis1a2.ErrReplyTimeout) {
+		t.Fatalf("Expected the timer to expire and the message to fail, got %+v", expired)
+	}
+	if dropped := astmConn.EventsDropped(); dropped != 0 {
+		t.Fatalf("Expected no event dropped, got %v", dropped)
+	}
+}
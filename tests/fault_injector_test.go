@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// connectFaulty connects an ASTMConnection over a MockConnection wrapped by a FaultInjector injecting no faults yet
+func connectFaulty(t *testing.T) (*connection.MockConnection, *connection.FaultInjector, *lis1a2.ASTMConnection) {
+	t.Helper()
+	var mockConn = connection.NewMockConnection()
+	faultInjector := connection.NewFaultInjector(&mockConn, connection.FaultOptions{})
+	astmConn := connectListening(t, faultInjector, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetSenderOptions(lis1a2.SenderOptions{MaxAttempts: 3, FrameReplyTimeout: 100 * time.Millisecond})
+	})
+	return &mockConn, faultInjector, astmConn
+}
+
+func TestFaultInjectorCorruptsChecksums(t *testing.T) {
+	mockConn, faultInjector, astmConn := connectFaulty(t)
+	var corrupted atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		switch {
+		case data[0] == constants.ENQ:
+			faultInjector.SetFaults(connection.FaultOptions{CorruptChecksum: 1, Direction: connection.FaultOutbound})
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case data[0] == constants.STX && errors.Is(protocol.ValidateFrame(data), protocol.ErrChecksumMismatch):
+			corrupted.Add(1)
+			faultInjector.SetFaults(connection.FaultOptions{})
+			_ = mockConn.Inject([]byte{constants.NAK})
+		case data[0] == constants.STX:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Expected the frame corrupted to be sent again, got %v", err)
+	}
+	if corrupted.Load() != 1 || astmConn.Metrics().Retransmissions != 1 {
+		t.Fatalf("Expected a single frame corrupted and sent again, got %d and %+v", corrupted.Load(), astmConn.Metrics())
+	}
+}
+
+func TestFaultInjectorDropsACKs(t *testing.T) {
+	mockConn, faultInjector, astmConn := connectFaulty(t)
+	var frames atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.STX && frames.Add(1) == 1 {
+			faultInjector.SetFaults(connection.FaultOptions{DropACK: 1, Direction: connection.FaultInbound})
+		}
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	err := astmConn.SendMessage(context.Background(), []string{"H|\\^&"})
+	if !errors.Is(err, lis1a2.ErrReplyTimeout) {
+		t.Fatalf("Expected the frame whose ACK was dropped to time out, got %v", err)
+	}
+	if frames.Load() != 1 {
+		t.Fatalf("Expected the transfer to be aborted after the frame, got %d frames", frames.Load())
+	}
+}
+
+func TestFaultInjectorDelaysReplies(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	faultInjector := connection.NewFaultInjector(&mockConn, connection.FaultOptions{Delay: 1, DelayBy: 50 * time.Millisecond})
+	if err := faultInjector.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer faultInjector.Disconnect()
+	if err := mockConn.Inject([]byte{constants.ACK}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	start := time.Now()
+	data, err := faultInjector.ReadBytesFromConnection()
+	if err != nil || !bytes.Equal(data, []byte{constants.ACK}) {
+		t.Fatalf("Expected the ACK, got %q and %v", data, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected the ACK to be held back for 50ms, got it after %v", elapsed)
+	}
+}
+
+func TestFaultInjectorSeversTheLink(t *testing.T) {
+	mockConn, faultInjector, astmConn := connectFaulty(t)
+	faultInjector.SetFaults(connection.FaultOptions{Sever: 1, Direction: connection.FaultOutbound})
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	err := astmConn.SendMessage(context.Background(), []string{"H|\\^&"})
+	if !errors.Is(err, connection.ErrNotConnected) {
+		t.Fatalf("Expected ErrNotConnected once severed, got %v", err)
+	}
+	header := frame(1, "H|\\^&", true)
+	if written := string(mockConn.Written()); written != string([]byte{constants.ENQ})+header[:len(header)/2] || mockConn.IsConnected() {
+		t.Fatalf("Expected half the frame written and the link disconnected, got %q", written)
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/convert/fhir"
+)
+
+// sequentialIDs gives the IDs 1, 2, 3 and so on, so that the bundles converted are reproducible
+func sequentialIDs() func() string {
+	next := 0
+	return func() string {
+		next++
+		return strconv.Itoa(next)
+	}
+}
+
+func TestConvertResults(t *testing.T) {
+	message, err := astm.ParseMessage(resultMessage)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	bundle, err := fhir.ConvertResults(message, fhir.Options{
+		MapCode: fhir.MapCodes(map[string]fhir.Coding{"GLU": {System: fhir.LOINC, Code: "2345-7", Display: "Glucose"}}),
+		NewID:   sequentialIDs(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert message: %v", err)
+	}
+	if bundle.Type != "transaction" || len(bundle.Entry) != 4 {
+		t.Fatalf("Expected a transaction of a patient, two observations and a report, got %+v", bundle)
+	}
+	patient := bundle.Entry[0].Resource.(*fhir.Patient)
+	if len(patient.Identifier) != 1 || patient.Identifier[0].Value != "PID001" || patient.Name[0].Family != "Doe" || patient.Name[0].Given[0] != "John" {
+		t.Fatalf("Unexpected patient %+v", patient)
+	}
+
+	encoded, err := json.Marshal(bundle.Entry[1])
+	if err != nil {
+		t.Fatalf("Failed to marshal observation: %v", err)
+	}
+	expected := `{"fullUrl":"urn:uuid:2","resource":{"resourceType":"Observation","status":"final",` +
+		`"category":[{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/observation-category","code":"laboratory","display":"Laboratory"}]}],` +
+		`"code":{"coding":[{"system":"http://loinc.org","code":"2345-7","display":"Glucose"},{"system":"urn:lis1a2:test-code","code":"GLU"}]},` +
+		`"subject":{"reference":"urn:uuid:1"},"valueQuantity":{"value":5.4,"unit":"mmol/L"},` +
+		`"interpretation":[{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation","code":"N"}]}],` +
+		`"note":[{"text":"Fasting sample"}],` +
+		`"referenceRange":[{"low":{"value":3.9,"unit":"mmol/L"},"high":{"value":6.1,"unit":"mmol/L"}}]},` +
+		`"request":{"method":"POST","url":"Observation"}}`
+	if string(encoded) != expected {
+		t.Fatalf("Expected\n%v\ngot\n%s", expected, encoded)
+	}
+
+	sodium := bundle.Entry[2].Resource.(*fhir.Observation)
+	if len(sodium.Code.Coding) != 1 || sodium.Code.Coding[0].Code != "NA" || sodium.Interpretation[0].Coding[0].Code != "H" {
+		t.Fatalf("Expected the unmapped test code to be kept as a local code, got %+v", sodium)
+	}
+	report := bundle.Entry[3].Resource.(*fhir.DiagnosticReport)
+	if report.Status != "final" || report.Identifier[0].Value != "SID001" || len(report.Result) != 2 ||
+		report.Result[0].Reference != "urn:uuid:2" || report.Result[1].Reference != "urn:uuid:3" {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+}
+
+func TestConvertResultsValues(t *testing.T) {
+	raw := "H|\\^&\n" +
+		"P|1||LAB9||Roe^Ann^M||19750615|F\n" +
+		"O|1|SID9||^^^HIV^HIV screen|||20240101103000\n" +
+		"R|1|^^^HIV^HIV screen|Reactive|||||P||OP1||20240101123000|ANALYZER1\n" +
+		"R|2|^^^CRP|<0.5|mg/L|0.0^5.0|||C\n" +
+		"L|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	bundle, err := fhir.ConvertResults(message, fhir.Options{NewID: sequentialIDs()})
+	if err != nil {
+		t.Fatalf("Failed to convert message: %v", err)
+	}
+	patient := bundle.Entry[0].Resource.(*fhir.Patient)
+	if patient.BirthDate != "1975-06-15" || patient.Gender != "female" || len(patient.Name[0].Given) != 2 {
+		t.Fatalf("Unexpected patient %+v", patient)
+	}
+	hiv := bundle.Entry[1].Resource.(*fhir.Observation)
+	if hiv.ValueString != "Reactive" || hiv.ValueQuantity != nil || hiv.Status != "preliminary" ||
+		hiv.EffectiveDateTime != "2024-01-01T12:30:00Z" || hiv.Device.Display != "ANALYZER1" || hiv.Code.Text != "HIV screen" {
+		t.Fatalf("Unexpected observation %+v", hiv)
+	}
+	crp := bundle.Entry[2].Resource.(*fhir.Observation)
+	if crp.ValueString != "<0.5" || crp.Status != "corrected" || crp.ReferenceRange[0].High.Value != "5.0" {
+		t.Fatalf("Unexpected observation %+v", crp)
+	}
+	report := bundle.Entry[3].Resource.(*fhir.DiagnosticReport)
+	if report.Status != "preliminary" || report.EffectiveDateTime != "2024-01-01T10:30:00Z" || report.Code.Text != "HIV screen" {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+}
+
+func TestConvertResultsWithoutPatients(t *testing.T) {
+	message, err := astm.ParseMessage("H|\\^&\nL|1|N\n")
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if _, err := fhir.ConvertResults(message); !errors.Is(err, fhir.ErrNoPatients) {
+		t.Fatalf("Expected ErrNoPatients, got %v", err)
+	}
+}
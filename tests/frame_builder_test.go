@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+func TestFrameBuilderSplitsLongRecords(t *testing.T) {
+	record := strings.Repeat("A", constants.MaxFrameSize) + "B"
+	frames := protocol.NewFrameBuilder().Frames(record)
+	expected := []string{frame(1, strings.Repeat("A", constants.MaxFrameSize), false), frame(2, "B", true)}
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %v frames, got %v", len(expected), len(frames))
+	}
+	for i, builtFrame := range frames {
+		if string(builtFrame) != expected[i] {
+			t.Fatalf("Expected frame %v to be %q, got %q", i, expected[i], builtFrame)
+		}
+		if err := protocol.ValidateFrame(builtFrame); err != nil {
+			t.Fatalf("Expected frame %v to be valid: %v", i, err)
+		}
+	}
+}
+
+func TestFrameBuilderWithMaxFrameSize(t *testing.T) {
+	builder := protocol.NewFrameBuilderWithMaxFrameSize(10)
+	frames := builder.Frames("R|1|^^^GLU|5.4|mg/dL")
+	expected := []string{frame(1, "R|1|^^^GLU", false), frame(2, "|5.4|mg/dL", true)}
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %v frames, got %q", len(expected), frames)
+	}
+	for i, builtFrame := range frames {
+		if string(builtFrame) != expected[i] {
+			t.Fatalf("Expected frame %v to be %q, got %q", i, expected[i], builtFrame)
+		}
+	}
+	// the numbering carries on over the records of the transfer
+	if next := builder.Frames("L|1"); string(next[0]) != frame(3, "L|1", true) {
+		t.Fatalf("Expected the next record to be framed as frame 3, got %q", next[0])
+	}
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// connectProbing connects an ASTMConnection listening over a MockConnection with the health options given
+func connectProbing(t *testing.T, options lis1a2.HealthOptions) (*connection.MockConnection, *lis1a2.ASTMConnection) {
+	t.Helper()
+	return connectMock(t, func(astmConn *lis1a2.ASTMConnection) { astmConn.SetHealthOptions(options) })
+}
+
+func TestHealthCheckProbe(t *testing.T) {
+	mockConn, astmConn := connectProbing(t, lis1a2.HealthOptions{Probe: true})
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("Expected the link to be healthy, got %v", err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ENQ, constants.EOT}) {
+		t.Fatalf("Expected ENQ and EOT, got %q", written)
+	}
+	if stats := astmConn.Stats(); stats.State != constants.Idle || stats.MessagesSent != 0 {
+		t.Fatalf("Expected the line idle again without a message sent, got %+v", stats)
+	}
+}
+
+func TestHealthCheckProbeTimeout(t *testing.T) {
+	mockConn, astmConn := connectProbing(t, lis1a2.HealthOptions{Probe: true, ProbeTimeout: 50 * time.Millisecond})
+	err := astmConn.HealthCheck(context.Background())
+	if !errors.Is(err, lis1a2.ErrEstablishmentTimeout) {
+		t.Fatalf("Expected ErrEstablishmentTimeout, got %v", err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ENQ, constants.EOT}) {
+		t.Fatalf("Expected the probe to end with EOT, got %q", written)
+	}
+}
+
+func TestHealthCheckDisconnected(t *testing.T) {
+	mockConn, astmConn := connectProbing(t, lis1a2.HealthOptions{Probe: true})
+	if err := astmConn.Disconnect(); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if err := astmConn.HealthCheck(context.Background()); !errors.Is(err, connection.ErrNotConnected) {
+		t.Fatalf("Expected ErrNotConnected, got %v", err)
+	}
+	if written := mockConn.Written(); len(written) != 0 {
+		t.Fatalf("Expected nothing written, got %q", written)
+	}
+}
+
+func TestHealthCheckSkipsProbeWhileReceiving(t *testing.T) {
+	mockConn, astmConn := connectProbing(t, lis1a2.HealthOptions{Probe: true})
+	if err := mockConn.Inject([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for astmConn.Stats().State != constants.Receiving && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := astmConn.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("Expected the link receiving to be healthy, got %v", err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK}) {
+		t.Fatalf("Expected only the ACK of the ENQ received, got %q", written)
+	}
+}
+
+func TestIdleWatchdog(t *testing.T) {
+	idle := make(chan time.Duration, 4)
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetHealthOptions(lis1a2.HealthOptions{IdleAfter: 50 * time.Millisecond})
+		astmConn.OnIdle(func(idleFor time.Duration) { idle <- idleFor })
+	})
+
+	select {
+	case idleFor := <-idle:
+		if idleFor < 50*time.Millisecond {
+			t.Fatalf("Expected the link flagged after 50ms, got %v", idleFor)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the idle link to be flagged")
+	}
+	select {
+	case <-idle:
+		t.Fatalf("Expected the link to be flagged once per quiet period")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	if err := mockConn.Inject([]byte{constants.EOT}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the link to be flagged again after the traffic stopped")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	probes := make(chan struct{}, 16)
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ, constants.STX:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.EOT:
+			select {
+			case probes <- struct{}{}:
+			default:
+			}
+		}
+	})
+	astmConn := connectListening(t, &mockConn, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetHealthOptions(lis1a2.HealthOptions{KeepAlive: 30 * time.Millisecond})
+		astmConn.OnKeepAliveFailed(func(err error) { t.Errorf("Expected the probes to be answered, got %v", err) })
+	})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-probes:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the idle line to be probed")
+		}
+	}
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Expected the message to be sent between the probes, got %v", err)
+	}
+	if metrics := astmConn.Metrics(); metrics.MessagesSent != 1 {
+		t.Fatalf("Expected the message sent, got %+v", metrics)
+	}
+}
+
+func TestKeepAliveFailed(t *testing.T) {
+	failed := make(chan error, 16)
+	connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetHealthOptions(lis1a2.HealthOptions{KeepAlive: 30 * time.Millisecond, ProbeTimeout: 20 * time.Millisecond})
+		astmConn.OnKeepAliveFailed(func(err error) { failed <- err })
+	})
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, lis1a2.ErrEstablishmentTimeout) {
+			t.Fatalf("Expected ErrEstablishmentTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the probe unanswered to fail")
+	}
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/convert/hl7"
+)
+
+func TestConvertORU(t *testing.T) {
+	message, err := astm.ParseMessage(resultMessage)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	oru, err := hl7.ConvertORU(message, hl7.Options{
+		SendingApplication: "LIS",
+		ReceivingFacility:  "HOSPITAL",
+		TestCodes:          hl7.TestCodes{"GLU": {Code: "2345-7", Text: "Glucose", CodingSystem: "LN"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert message: %v", err)
+	}
+	expected := "MSH|^~\\&|LIS|||HOSPITAL|20240101120000||ORU^R01^ORU_R01|20240101120000|P|2.5.1\r" +
+		"PID|1||PID001||Doe^John\r" +
+		"OBR|1|SID001||2345-7^Glucose^LN\r" +
+		"OBX|1|NM|2345-7^Glucose^LN||5.4|mmol/L|3.9-6.1|N|||F\r" +
+		"NTE|1|I|Fasting sample\r" +
+		"OBX|2|NM|NA||150|mmol/L|135-145|H|||F\r"
+	if oru != expected {
+		t.Fatalf("Expected\n%q\ngot\n%q", expected, oru)
+	}
+}
+
+func TestConvertORUEscapesValues(t *testing.T) {
+	raw := "H|\\^&|MSG42\n" +
+		"P|1|PAT7|||Doe^Jane||19800101|F\n" +
+		"O|1|SID7||^^^HIV^HIV screen\n" +
+		"R|1|^^^HIV^HIV screen|Reactive &F& repeat|||A||P||OP1||20240101123000|ANALYZER1\n" +
+		"L|1|N\n"
+	message, err := astm.ParseMessage(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	now := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	oru, err := hl7.ConvertORU(message, hl7.Options{Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("Failed to convert message: %v", err)
+	}
+	segments := strings.Split(strings.TrimSuffix(oru, "\r"), "\r")
+	expected := []string{
+		"MSH|^~\\&|||||20240203040506||ORU^R01^ORU_R01|MSG42|P|2.5.1",
+		"PID|1||PAT7||Doe^Jane||19800101|F",
+		"OBR|1|SID7||HIV^HIV screen",
+		"OBX|1|ST|HIV^HIV screen||Reactive \\F\\ repeat|||A|||P|||20240101123000||OP1||ANALYZER1",
+	}
+	if len(segments) != len(expected) {
+		t.Fatalf("Expected %v segments, got %q", len(expected), segments)
+	}
+	for index := range expected {
+		if segments[index] != expected[index] {
+			t.Errorf("Expected segment %v to be %q, got %q", index+1, expected[index], segments[index])
+		}
+	}
+}
+
+func TestConvertORURejectsUnmappedTestCodes(t *testing.T) {
+	message, err := astm.ParseMessage(resultMessage)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	_, err = hl7.ConvertORU(message, hl7.Options{
+		TestCodes:      hl7.TestCodes{"GLU": {Code: "2345-7"}},
+		RejectUnmapped: true,
+	})
+	if !errors.Is(err, hl7.ErrUnmappedTestCode) || !strings.Contains(err.Error(), `"NA"`) {
+		t.Fatalf("Expected ErrUnmappedTestCode for NA, got %v", err)
+	}
+}
+
+func TestConvertORUWithoutPatients(t *testing.T) {
+	message, err := astm.ParseMessage("H|\\^&\nL|1|N\n")
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if _, err := hl7.ConvertORU(message); !errors.Is(err, hl7.ErrNoPatients) {
+		t.Fatalf("Expected ErrNoPatients, got %v", err)
+	}
+}
+
+const omlMessage = "MSH|^~\\&|HIS||LIS||20240101120000||OML^O21^OML_O21|42|P|2.5.1\r" +
+	"PID|1||PAT1^^^HIS||Doe^Jane||19800101083000|F\r" +
+	"PV1|1|O|WARD3\r" +
+	"NTE|1||Fasting \\T\\ rested\r" +
+	"ORC|NW|ORD1\r" +
+	"TQ1|1||||||||S\r" +
+	"OBR|1|ORD1||2345-7^Glucose^LN|||20240101110000\r" +
+	"SPM|1|SID1||SER\r" +
+	"OBR|2|ORD1||2951-2^Sodium^LN\r" +
+	"SPM|1|SID1||SER\r" +
+	"ORC|CA|ORD2\r" +
+	"OBR|1|ORD2||HIV^HIV screen\r" +
+	"NTE|1|P|Sample haemolysed\r"
+
+func TestConvertOrder(t *testing.T) {
+	message, err := hl7.ConvertOrder(omlMessage, hl7.OrderOptions{
+		TestCodes: hl7.TestCodes{"GLU": {Code: "2345-7"}, "NA": {Code: "2951-2"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert order: %v", err)
+	}
+	expected := []string{
+		"H|\\^&",
+		"P|1|PAT1|||Doe^Jane||19800101|F|||||||||||||||||WARD3",
+		"C|1|L|Fasting &E& rested|G",
+		"O|1|SID1||^^^GLU\\^^^NA|S||20240101110000||||N||||SER",
+		"O|2|ORD2||^^^HIV^HIV screen|||||||C",
+		"C|1|P|Sample haemolysed|G",
+		"L|1|N",
+	}
+	lines := message.Lines()
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%v\ngot\n%v", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+	// the order message is valid ASTM, it parses back strictly
+	if _, err := astm.ParseMessage(strings.Join(lines, "\n")); err != nil {
+		t.Fatalf("Failed to parse the order message: %v", err)
+	}
+}
+
+func TestConvertOrderRejectsUnsupportedMessages(t *testing.T) {
+	_, err := hl7.ConvertOrder("MSH|^~\\&|HIS||LIS||20240101120000||ADT^A01|42|P|2.5.1\rPID|1||PAT1\r")
+	if !errors.Is(err, hl7.ErrUnsupportedMessage) {
+		t.Fatalf("Expected ErrUnsupportedMessage, got %v", err)
+	}
+	if _, err := hl7.ConvertOrder("PID|1||PAT1\r"); !errors.Is(err, hl7.ErrMalformedMessage) {
+		t.Fatalf("Expected ErrMalformedMessage, got %v", err)
+	}
+	_, err = hl7.ConvertOrder(omlMessage, hl7.OrderOptions{TestCodes: hl7.TestCodes{"GLU": {Code: "2345-7"}}, RejectUnmapped: true})
+	if !errors.Is(err, hl7.ErrUnmappedTestCode) {
+		t.Fatalf("Expected ErrUnmappedTestCode, got %v", err)
+	}
+}
+
+func TestConvertOrderWithoutPatient(t *testing.T) {
+	orm := "MSH|^~\\&|HIS||LIS||20240101120000||ORM^O01|43|P|2.3\rORC|NW|ORD3\rOBR|1|ORD3||GLU|||||||||||||||||||||||^^^^^R\r"
+	message, err := hl7.ConvertOrder(orm)
+	if err != nil {
+		t.Fatalf("Failed to convert order: %v", err)
+	}
+	if len(message.Patients) != 1 || len(message.Patients[0].Orders) != 1 {
+		t.Fatalf("Expected a patient holding the order, got %q", message.Lines())
+	}
+	order := message.Patients[0].Orders[0]
+	if order.UniversalTestID.Component(4) != "GLU" || order.Priority.Component(1) != "R" || order.SpecimenID.Component(1) != "ORD3" {
+		t.Fatalf("Unexpected order %q", message.Lines())
+	}
+}
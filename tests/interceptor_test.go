@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// stripEscape rewrites a frame without the ESC bytes of its text
+func stripEscape(frame lis1a2.Frame) (lis1a2.Frame, error) {
+	decoded, err := frame.Decode()
+	if err != nil || !bytes.Contains(decoded.Text, []byte{0x1B}) {
+		return frame, nil
+	}
+	text := bytes.ReplaceAll(decoded.Text, []byte{0x1B}, nil)
+	return lis1a2.Frame{Raw: protocol.EncodeFrame(decoded.FrameNumber, text, decoded.IsLast())}, nil
+}
+
+func TestInboundInterceptor(t *testing.T) {
+	var received []string
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.UseInbound(stripEscape)
+		astmConn.OnFrameReceived(func(raw string) { received = append(received, raw) })
+	})
+
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&\x1B", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil || message != "H|\\^&\nL|1\n" {
+		t.Fatalf("Unexpected message %q, error %v", message, err)
+	}
+	if strings.Contains(strings.Join(received, ""), "\x1B") {
+		t.Fatalf("Expected the hooks to see the frames rewritten, got %q", received)
+	}
+}
+
+func TestInboundInterceptorDrops(t *testing.T) {
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.UseInbound(func(frame lis1a2.Frame) (lis1a2.Frame, error) {
+			return frame, errors.New("quarantined")
+		})
+	})
+
+	if err := mockConn.Inject([]byte(string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK}) {
+		t.Fatalf("Expected only the ENQ to be answered, got %q", written)
+	}
+}
+
+func TestOutboundInterceptor(t *testing.T) {
+	var sent []string
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.UseOutbound(func(frame lis1a2.Frame) (lis1a2.Frame, error) {
+			decoded, err := frame.Decode()
+			if err != nil {
+				return frame, err
+			}
+			text := bytes.ReplaceAll(decoded.Text, []byte("Doe"), []byte("XXX"))
+			return lis1a2.Frame{Raw: protocol.EncodeFrame(decoded.FrameNumber, text, decoded.IsLast())}, nil
+		})
+		astmConn.OnFrameSent(func(raw string) { sent = append(sent, raw) })
+	})
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ || data[0] == constants.STX {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "P|1||||Doe^John", "L|1"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	written := string(mockConn.Written())
+	if !strings.Contains(written, frame(2, "P|1||||XXX^John", true)) || strings.Contains(written, "Doe") {
+		t.Fatalf("Expected the frame rewritten, got %q", written)
+	}
+	if !strings.Contains(strings.Join(sent, ""), "Doe") {
+		t.Fatalf("Expected the hooks to see the frames the way the sender built them, got %q", sent)
+	}
+}
+
+func TestOutboundInterceptorFails(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	refused := errors.New("refused")
+	astmConn.UseOutbound(func(frame lis1a2.Frame) (lis1a2.Frame, error) { return frame, refused })
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); !errors.Is(err, refused) {
+		t.Fatalf("Expected the error of the interceptor, got %v", err)
+	}
+	if written := mockConn.Written(); bytes.IndexByte(written, constants.STX) >= 0 {
+		t.Fatalf("Expected no frame written, got %q", written)
+	}
+}
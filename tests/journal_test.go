@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestASTMConnectionJournal(t *testing.T) {
+	journal, err := lis1a2.OpenFileJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.Close()
+	mockConn, astmConn := connectMock(t)
+	astmConn.SetJournal(journal)
+
+	header := frame(1, "H|\\^&", true)
+	inbound := string([]byte{constants.ENQ}) + header + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if _, err := astmConn.ReadMessage(time.Second); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1|N"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	var entries []lis1a2.JournalEntry
+	err = lis1a2.Replay(journal, func(entry lis1a2.JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to replay journal: %v", err)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("Expected 2 frames and a message each way, got %d entries", len(entries))
+	}
+	if entries[0].Direction != lis1a2.ExchangeReceived || entries[0].Kind != lis1a2.JournalFrame || string(entries[0].Data) != header {
+		t.Fatalf("Expected the first frame received first, got %+v", entries[0])
+	}
+	received := entries[2]
+	if received.Direction != lis1a2.ExchangeReceived || received.Kind != lis1a2.JournalMessage || string(received.Data) != "H|\\^&\nL|1\n" {
+		t.Fatalf("Expected the message received, got %+v", received)
+	}
+	sent := entries[5]
+	if sent.Direction != lis1a2.ExchangeSent || sent.Kind != lis1a2.JournalMessage || string(sent.Data) != "H|\\^&\nL|1|N\n" {
+		t.Fatalf("Expected the message sent last, got %+v", sent)
+	}
+	if entries[3].Kind != lis1a2.JournalFrame || entries[3].Time.IsZero() {
+		t.Fatalf("Expected a timed frame sent, got %+v", entries[3])
+	}
+}
+
+func TestFileJournalSkipsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := lis1a2.OpenFileJournal(path)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	entry := lis1a2.JournalEntry{Time: time.Unix(1700000000, 0).UTC(), Direction: lis1a2.ExchangeReceived, Data: []byte{constants.STX, 0xff}}
+	if err := journal.Append(entry); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if err := journal.Append(entry); err == nil {
+		t.Fatalf("Expected appending to a closed journal to fail")
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	_, _ = file.WriteString(`{"time":"2024-01-01T12:30:00Z","direc`)
+	_ = file.Close()
+
+	var entries []lis1a2.JournalEntry
+	if err := journal.Walk(func(entry lis1a2.JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to walk journal: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].Time.Equal(entry.Time) || string(entries[0].Data) != string(entry.Data) {
+		t.Fatalf("Expected the entry appended back with its bytes, got %+v", entries)
+	}
+
+	stop := errors.New("stop")
+	if err := lis1a2.Replay(journal, func(lis1a2.JournalEntry) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("Expected the error of the handler, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+	"github.com/therealriteshkudalkar/lis1a2/sink/kafka"
+)
+
+// recordingProducer keeps the records produced, failing with err when set
+type recordingProducer struct {
+	records []kafka.Record
+	err     error
+}
+
+func (producer *recordingProducer) Produce(ctx context.Context, record kafka.Record) error {
+	if producer.err != nil {
+		return producer.err
+	}
+	producer.records = append(producer.records, record)
+	return nil
+}
+
+const kafkaMessage = "H|\\^&|||cobas^1.0\nP|1\nO|1|S1||^^^GLU\nR|1|^^^GLU|5.4\nL|1|N\n"
+
+func TestKafkaPublish(t *testing.T) {
+	producer := &recordingProducer{}
+	publisher := kafka.NewPublisher(producer, kafka.Options{Topic: "lab.results", Format: sink.FormatRaw | sink.FormatJSON})
+	if err := publisher.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if len(producer.records) != 2 {
+		t.Fatalf("Expected the raw and the JSON records, got %v", producer.records)
+	}
+	raw, encoded := producer.records[0], producer.records[1]
+	if raw.Topic != "lab.results" || string(raw.Key) != "cobas" || string(raw.Value) != kafkaMessage || raw.Headers[kafka.HeaderFormat] != "raw" {
+		t.Fatalf("Unexpected raw record %+v", raw)
+	}
+	if string(encoded.Key) != "cobas" || encoded.Headers[kafka.HeaderFormat] != "json" {
+		t.Fatalf("Unexpected JSON record %+v", encoded)
+	}
+	var decoded astm.Message
+	if err := json.Unmarshal(encoded.Value, &decoded); err != nil || len(decoded.Records) != 5 {
+		t.Fatalf("Expected the message as JSON, got %v", err)
+	}
+}
+
+func TestKafkaPublishFailures(t *testing.T) {
+	unavailable := errors.New("broker unavailable")
+	producer := &recordingProducer{err: unavailable}
+	publisher := kafka.NewPublisher(producer, kafka.Options{Topic: "lab.results"})
+	if err := publisher.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); !errors.Is(err, unavailable) {
+		t.Fatalf("Expected the error of the producer, got %v", err)
+	}
+
+	producer.err = nil
+	if err := publisher.Publish(context.Background(), lis1a2.ReceivedMessage{Err: lis1a2.ErrReceiveTimeout}); !errors.Is(err, lis1a2.ErrReceiveTimeout) {
+		t.Fatalf("Expected the error of the message, got %v", err)
+	}
+	publisher = kafka.NewPublisher(producer, kafka.Options{Topic: "lab.results", Format: sink.FormatJSON})
+	if err := publisher.Publish(context.Background(), lis1a2.ReceivedMessage{Text: "P|1\n"}); err == nil {
+		t.Fatalf("Expected a message without header to fail as JSON")
+	}
+	if len(producer.records) != 0 {
+		t.Fatalf("Expected nothing published, got %v", producer.records)
+	}
+}
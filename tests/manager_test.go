@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/config"
+)
+
+// startInstrument listens like an instrument the LIS dials, handing over every connection accepted
+func startInstrument(t *testing.T) (string, chan net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return listener.Addr().String(), accepted
+}
+
+func awaitAccepted(t *testing.T, accepted chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-accepted:
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the instrument to be connected")
+		return nil
+	}
+}
+
+// awaitClosed waits for the LIS to close the connection of the instrument
+func awaitClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestManagerApply(t *testing.T) {
+	first, firstAccepted := startInstrument(t)
+	second, secondAccepted := startInstrument(t)
+	moved, movedAccepted := startInstrument(t)
+	var setUp []string
+	manager := config.NewManager(func(instrument config.Instrument, astmConn *lis1a2.ASTMConnection) {
+		setUp = append(setUp, instrument.Name)
+	})
+	ctx := context.Background()
+	defer manager.Close(ctx)
+
+	a := config.Instrument{Name: "a", Transport: "tcp", Address: first}
+	b := config.Instrument{Name: "b", Transport: "tcp", Address: second}
+	if err := manager.Apply(ctx, &config.Config{Instruments: []config.Instrument{a}}); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+	connA := awaitAccepted(t, firstAccepted)
+	running := manager.Connection("a")
+
+	if err := manager.Apply(ctx, &config.Config{Instruments: []config.Instrument{a, b}}); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+	awaitAccepted(t, secondAccepted)
+	if manager.Connection("a") != running {
+		t.Fatalf("Expected the instrument left unchanged to keep its connection")
+	}
+	if names := manager.Instruments(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("Expected instruments a and b, got %v", names)
+	}
+
+	a.Address = moved
+	if err := manager.Apply(ctx, &config.Config{Instruments: []config.Instrument{a}}); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+	awaitClosed(t, connA)
+	awaitAccepted(t, movedAccepted)
+	if manager.Connection("b") != nil || manager.Connection("a") == running {
+		t.Fatalf("Expected b to be removed and a to be connected again")
+	}
+	if !reflect.DeepEqual(setUp, []string{"a", "b", "a"}) {
+		t.Fatalf("Expected every connection built to be set up, got %v", setUp)
+	}
+}
+
+func TestManagerApplyInvalidConfig(t *testing.T) {
+	address, accepted := startInstrument(t)
+	manager := config.NewManager(nil)
+	ctx := context.Background()
+	defer manager.Close(ctx)
+	valid := config.Instrument{Name: "a", Transport: "tcp", Address: address}
+	if err := manager.Apply(ctx, &config.Config{Instruments: []config.Instrument{valid}}); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+	conn := awaitAccepted(t, accepted)
+
+	invalid := config.Instrument{Name: "b", Transport: "carrier pigeon"}
+	if err := manager.Apply(ctx, &config.Config{Instruments: []config.Instrument{invalid}}); err == nil {
+		t.Fatalf("Expected an invalid config to fail")
+	}
+	if manager.Connection("a") == nil {
+		t.Fatalf("Expected nothing to change after an invalid config")
+	}
+	if err := manager.Close(ctx); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	awaitClosed(t, conn)
+	if names := manager.Instruments(); len(names) != 0 {
+		t.Fatalf("Expected no instrument once closed, got %v", names)
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestASTMConnectionMetricsCountSending(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	var frames atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.STX && frames.Add(1) == 1 {
+			_ = mockConn.Inject([]byte{constants.NAK})
+		} else if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	metrics := astmConn.Metrics()
+	if metrics.FramesSent != 3 || metrics.Retransmissions != 1 || metrics.NAKsReceived != 1 || metrics.MessagesSent != 1 {
+		t.Fatalf("Expected 3 frames sent, 1 retransmission, 1 NAK received and 1 message sent, got %+v", metrics)
+	}
+	if metrics.BytesOut != int64(len(mockConn.Written())) || metrics.BytesIn != 4 {
+		t.Fatalf("Expected %d bytes out and 4 bytes in, got %d and %d", len(mockConn.Written()), metrics.BytesOut, metrics.BytesIn)
+	}
+	if metrics.Establishments != 1 || metrics.LastEstablishmentLatency <= 0 || metrics.State != constants.Idle || !metrics.Connected {
+		t.Fatalf("Expected a single timed establishment, back to idle, got %+v", metrics)
+	}
+}
+
+func TestASTMConnectionMetricsCountReceiving(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	header := frame(1, "H|\\^&", true)
+	corrupt := []byte(header)
+	corrupt[3] = 'X'
+	inbound := string([]byte{constants.ENQ}) + string(corrupt) + header + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if _, err := astmConn.ReadMessage(time.Second); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+
+	metrics := astmConn.Metrics()
+	if metrics.FramesReceived != 3 || metrics.ChecksumFailures != 1 || metrics.NAKsSent != 1 || metrics.MessagesReceived != 1 {
+		t.Fatalf("Expected 3 frames received, 1 checksum failure, 1 NAK sent and 1 message received, got %+v", metrics)
+	}
+	if metrics.BytesIn != int64(len(inbound)) || metrics.BytesOut != 4 {
+		t.Fatalf("Expected %d bytes in and 4 bytes out, got %d and %d", len(inbound), metrics.BytesIn, metrics.BytesOut)
+	}
+}
+
+func TestASTMConnectionStats(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	if stats := astmConn.Stats(); !stats.LastActivity.IsZero() || !stats.Connected || stats.State != constants.Idle {
+		t.Fatalf("Expected a connected idle session without activity, got %+v", stats)
+	}
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	before := time.Now()
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	mockConn.OnWrite(nil)
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if _, err := astmConn.ReadMessage(time.Second); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+
+	stats := astmConn.Stats()
+	if stats.FramesSent != 2 || stats.FramesReceived != 2 || stats.MessagesSent != 1 || stats.MessagesReceived != 1 {
+		t.Fatalf("Expected 2 frames and 1 message each way, got %+v", stats)
+	}
+	if stats.BytesWritten != int64(len(mockConn.Written())) || stats.BytesRead != int64(3+len(inbound)) {
+		t.Fatalf("Unexpected byte counts %+v", stats)
+	}
+	if stats.LastActivity.Before(before) || stats.Reconnects != 0 {
+		t.Fatalf("Expected recent activity and no reconnect, got %+v", stats)
+	}
+}
+
+func TestMetricsHandlerServesPrometheusText(t *testing.T) {
+	_, astmConn := connectMock(t)
+	recorder := httptest.NewRecorder()
+	lis1a2.MetricsHandler(map[string]*lis1a2.ASTMConnection{"analyzer \"1\"": astmConn}).
+		ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		"# TYPE lis1a2_frames_sent_total counter\n",
+		"lis1a2_frames_sent_total{link=\"analyzer \\\"1\\\"\"} 0\n",
+		"lis1a2_connected{link=\"analyzer \\\"1\\\"\"} 1\n",
+		"lis1a2_state{link=\"analyzer \\\"1\\\"\",state=\"idle\"} 1\n",
+		"lis1a2_state{link=\"analyzer \\\"1\\\"\",state=\"sending\"} 0\n",
+		"lis1a2_establishment_seconds_count{link=\"analyzer \\\"1\\\"\"} 0\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected %q in the metrics, got\n%s", expected, body)
+		}
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("Unexpected content type %q", contentType)
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// envelope wraps data in an MLLP envelope
+func envelope(data string) string {
+	return "\x0b" + data + "\x1c\r"
+}
+
+func TestMLLPConnectionUnwrapsEnvelopes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	// the frame ends with CR ETX, its CRs are data and not the end of the envelope
+	frame := "\x021H|\\^&\r\x03E5\r\n"
+	written := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// an envelope split over writes is reassembled
+		_, _ = conn.Write([]byte(envelope("\x05") + "\x0b"))
+		_, _ = conn.Write([]byte(frame[:5]))
+		_, _ = conn.Write([]byte(frame[5:] + "\x1c\r" + envelope("\x04")))
+		block := make([]byte, 4)
+		_, _ = io.ReadFull(conn, block)
+		written <- block
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	mllpConn := connection.NewMLLPConnection(host, port)
+	if err := mllpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the TCP server: %v", err)
+	}
+	defer mllpConn.Disconnect()
+	mllpConn.Listen()
+
+	for _, expected := range []string{"\x05", frame, "\x04"} {
+		str, err := mllpConn.ReadStringFromConnection()
+		if err != nil {
+			t.Fatalf("Unexpected error reading %q: %v", expected, err)
+		}
+		if str != expected {
+			t.Fatalf("Expected %q, got %q", expected, str)
+		}
+	}
+	if err := mllpConn.Write([]byte{constants.ACK}); err != nil {
+		t.Fatalf("Failed to write ACK: %v", err)
+	}
+	if block := string(<-written); block != envelope("\x06") {
+		t.Fatalf("Expected ACK in an envelope, got %q", block)
+	}
+}
+
+func TestMLLPListenerWrapsWrites(t *testing.T) {
+	listener, err := connection.NewMLLPListener("127.0.0.1", "0")
+	if err != nil {
+		t.Fatalf("Failed to start the MLLP listener: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial the MLLP listener: %v", err)
+	}
+	defer client.Close()
+	mllpConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer mllpConn.Disconnect()
+	mllpConn.Listen()
+
+	frame := "\x021H|\\^&\r\x03E5\r\n"
+	if err := mllpConn.Write([]byte(frame)); err != nil {
+		t.Fatalf("Failed to write the frame: %v", err)
+	}
+	block, err := bufio.NewReader(client).ReadString('\x1c')
+	if err != nil {
+		t.Fatalf("Failed to read the envelope: %v", err)
+	}
+	if block != "\x0b"+frame+"\x1c" {
+		t.Fatalf("Expected the frame in an envelope, got %q", block)
+	}
+	if _, err := client.Write([]byte(envelope("\x15"))); err != nil {
+		t.Fatalf("Failed to write NAK: %v", err)
+	}
+	if str, err := mllpConn.ReadStringFromConnection(); err != nil || str != "\x15" {
+		t.Fatalf("Expected NAK, got %q, %v", str, err)
+	}
+}
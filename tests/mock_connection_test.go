@@ -0,0 +1,603 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// connectListening wraps the connection in an ASTM connection which is listening, set up by setup, when not
+// nil, before it connects
+func connectListening(t *testing.T, conn connection.Connection, setup func(astmConn *lis1a2.ASTMConnection)) *lis1a2.ASTMConnection {
+	t.Helper()
+	astmConn := lis1a2.NewASTMConnection(conn, false)
+	if setup != nil {
+		setup(astmConn)
+	}
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+	t.Cleanup(func() { _ = conn.Disconnect() })
+	return astmConn
+}
+
+// connectMock wraps a mock connection in an ASTM connection which is listening, see connectListening
+func connectMock(t *testing.T, setup ...func(astmConn *lis1a2.ASTMConnection)) (*connection.MockConnection, *lis1a2.ASTMConnection) {
+	t.Helper()
+	var mockConn = connection.NewMockConnection()
+	var configure func(astmConn *lis1a2.ASTMConnection)
+	if len(setup) > 0 {
+		configure = setup[0]
+	}
+	return &mockConn, connectListening(t, &mockConn, configure)
+}
+
+func TestMockConnectionSendMessage(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expected := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if written := mockConn.Written(); !bytes.Equal(written, []byte(expected)) {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
+
+func TestMockConnectionReadMessage(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if message != "H|\\^&\nL|1\n" {
+		t.Fatalf("Unexpected message %q", message)
+	}
+	if written := mockConn.Written(); !bytes.Equal(written, []byte{constants.ACK, constants.ACK, constants.ACK}) {
+		t.Fatalf("Expected the ENQ and both frames to be ACKed, got %q", written)
+	}
+}
+
+func TestMockConnectionReadBytes(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = mockConn.Disconnect() }()
+	// a Latin-1 é and bytes which are not valid UTF-8
+	sent := frame(1, "M|1|\xe9\x80\xff", true)
+	if err := mockConn.Inject([]byte(sent)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	data, err := mockConn.ReadBytesFromConnection()
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(data, []byte(sent)) {
+		t.Fatalf("Expected %q to be read, got %q", sent, data)
+	}
+}
+
+func TestASTMConnectionReadMessageKeepsBytes(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	record := "M|1|\xe9\x00\x80\xff|caf\xe9"
+	inbound := string([]byte{constants.ENQ}) + frame(1, record, true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	// NUL bytes are dropped on the line, every other byte reaches the message as it was sent
+	if expected := "M|1|\xe9\x80\xff|caf\xe9\n"; message != expected {
+		t.Fatalf("Expected %q, got %q", expected, message)
+	}
+}
+
+func TestASTMConnectionDisconnectIsIdempotent(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := astmConn.ReadMessage(time.Minute)
+		readErr <- err
+	}()
+	// the sender delivers a message nobody reads while the application disconnects
+	go func() {
+		inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + string([]byte{constants.EOT})
+		_ = mockConn.Inject([]byte(inbound + inbound + inbound))
+	}()
+
+	var done = make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- astmConn.Disconnect() }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Expected Disconnect to succeed, got %v", err)
+		}
+	}
+	if err := astmConn.Disconnect(); err != nil {
+		t.Fatalf("Expected a further Disconnect to succeed, got %v", err)
+	}
+	select {
+	case <-readErr:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected ReadMessage to return once disconnected")
+	}
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); !errors.Is(err, lis1a2.ErrNotConnected) {
+		t.Fatalf("Expected sending after disconnecting to fail with ErrNotConnected, got %v", err)
+	}
+}
+
+func TestASTMConnectionShutdownWaitsForMessage(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	enqWritten := make(chan struct{})
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			close(enqWritten)
+		}
+		if data[0] != constants.EOT {
+			// a slow receiver, the message is still being sent when shutting down
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				_ = mockConn.Inject([]byte{constants.ACK})
+			}()
+		}
+	})
+	sent := make(chan error, 1)
+	go func() {
+		sent <- astmConn.SendMessage(context.Background(), []string{"H|\\^&", "P|1", "L|1"})
+	}()
+	<-enqWritten
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := astmConn.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("Expected the message in progress to be sent, got %v", err)
+	}
+	if written := mockConn.Written(); written[len(written)-1] != constants.EOT {
+		t.Fatalf("Expected the message to be terminated with EOT before shutting down, got %q", written)
+	}
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); !errors.Is(err, connection.ErrShutdown) {
+		t.Fatalf("Expected ErrShutdown sending after Shutdown, got %v", err)
+	}
+}
+
+func TestASTMConnectionWaitsForBusyReceiver(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	astmConn.SetSenderOptions(lis1a2.SenderOptions{BusyBackoff: 200 * time.Millisecond})
+	busy := make(chan struct{}, 1)
+	astmConn.OnPeerBusy(func() { busy <- struct{}{} })
+	enqs := 0
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			enqs++
+			if enqs == 1 {
+				_ = mockConn.Inject([]byte{constants.NAK})
+			} else {
+				_ = mockConn.Inject([]byte{constants.ACK})
+			}
+		case constants.STX:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	start := time.Now()
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Expected to wait for the busy receiver before sending ENQ again, waited %v", elapsed)
+	}
+	select {
+	case <-busy:
+	default:
+		t.Fatalf("Expected the peer busy hook to be called")
+	}
+	expected := string([]byte{constants.ENQ, constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
+
+func TestASTMConnectionReportsFrameErrors(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	astmConn := lis1a2.NewASTMConnection(&mockConn, false)
+	type frameError struct {
+		raw string
+		err error
+	}
+	frameErrors := make(chan frameError, 2)
+	astmConn.OnFrameError(func(raw string, err error) { frameErrors <- frameError{raw: raw, err: err} })
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+	t.Cleanup(func() { _ = mockConn.Disconnect() })
+
+	malformed := "\x021H|\\^&\r\n"
+	abandoned := "\x021R|1|"
+	inbound := string([]byte{constants.ENQ}) + malformed + abandoned + frame(1, "H|\\^&", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil || message != "H|\\^&\n" {
+		t.Fatalf("Unexpected message %q, error %v", message, err)
+	}
+	if first := <-frameErrors; first.raw != malformed || !errors.Is(first.err, connection.ErrMalformedFrame) {
+		t.Fatalf("Expected the malformed frame to be reported with ErrMalformedFrame, got %q, %v", first.raw, first.err)
+	}
+	if second := <-frameErrors; second.raw != abandoned || !errors.Is(second.err, connection.ErrIncompleteFrame) {
+		t.Fatalf("Expected the abandoned frame to be reported with ErrIncompleteFrame, got %q, %v", second.raw, second.err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.NAK, constants.ACK}) {
+		t.Fatalf("Expected the malformed frame to be NAKed, got %q", written)
+	}
+}
+
+func TestASTMConnectionDiscardsRetransmittedRecord(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	header := frame(1, "H|\\^&", true)
+	corrupt := []byte(header)
+	corrupt[3] = 'X'
+	// the corrupt frame is NAKed and sent again, then sent a third time as if the ACK had been lost
+	inbound := string([]byte{constants.ENQ}) + string(corrupt) + header + header + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil || message != "H|\\^&\nL|1\n" {
+		t.Fatalf("Expected every record once, got %q, %v", message, err)
+	}
+	expected := []byte{constants.ACK, constants.NAK, constants.ACK, constants.ACK, constants.ACK}
+	if written := mockConn.Written(); !bytes.Equal(written, expected) {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
+
+func TestASTMConnectionSendParsedMessage(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	message, err := astm.ParseMessage("H#~$%###LIS\nP#1##PID~123\nL#1#N")
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if err := astmConn.SendParsedMessage(context.Background(), message); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expected := string([]byte{constants.ENQ}) + frame(1, "H#~$%###LIS", true) + frame(2, "P#1##PID~123", true) +
+		frame(3, "L#1#N", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
+
+func TestASTMConnectionOnMessage(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	astmConn := lis1a2.NewASTMConnection(&mockConn, false)
+	messages := make(chan lis1a2.ReceivedMessage, 2)
+	astmConn.OnMessage(func(message lis1a2.ReceivedMessage) { messages <- message })
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+	t.Cleanup(func() { _ = mockConn.Disconnect() })
+
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "P|1", true) + frame(3, "O|1|SID001", true) +
+		frame(4, "R|1|^^^GLU|5.4", true) + frame(5, "L|1", true) + string([]byte{constants.EOT}) +
+		string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(3, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	received := <-messages
+	if received.Err != nil || received.Text != "H|\\^&\nP|1\nO|1|SID001\nR|1|^^^GLU|5.4\nL|1\n" {
+		t.Fatalf("Unexpected message %+v", received)
+	}
+	parsed, err := received.Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if result := parsed.Patients[0].Orders[0].Results[0]; result.Value[0][0] != "5.4" {
+		t.Fatalf("Expected a result record with value 5.4, got %+v", result)
+	}
+	if failed := <-messages; failed.Err == nil {
+		t.Fatalf("Expected the message with a frame number gap to fail, got %q", failed.Text)
+	}
+	if _, err := astmConn.ReadMessage(50 * time.Millisecond); err == nil {
+		t.Fatalf("Expected ReadMessage to get nothing once OnMessage is registered")
+	}
+}
+
+func TestASTMConnectionEventHandlers(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	astmConn := lis1a2.NewASTMConnection(&mockConn, false)
+	events := make(chan string, 16)
+	astmConn.OnConnected(func() { events <- "connected" })
+	astmConn.OnDisconnected(func(err error) { events <- "disconnected" })
+	astmConn.OnMessageReceived(func(message string) { events <- "message " + message })
+	astmConn.OnProtocolError(func(err error) { events <- "protocol error" })
+	astmConn.OnFrameNAKed(func(raw string, attempt int) { events <- "frame NAKed " + strconv.Itoa(attempt) })
+	astmConn.OnSendComplete(func(err error) { events <- "send complete " + strconv.FormatBool(err == nil) })
+	astmConn.OnMessage(func(message lis1a2.ReceivedMessage) {})
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go astmConn.Listen()
+	expectEvent := func(expected string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event != expected {
+				t.Fatalf("Expected the event %q, got %q", expected, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the event %q", expected)
+		}
+	}
+	expectEvent("connected")
+
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT}) +
+		string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(3, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	expectEvent("message H|\\^&\nL|1\n")
+	expectEvent("protocol error")
+
+	naked := false
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.STX && !naked {
+			naked = true
+			_ = mockConn.Inject([]byte{constants.NAK})
+		} else if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expectEvent("frame NAKed 1")
+	expectEvent("send complete true")
+
+	_ = astmConn.Disconnect()
+	_ = astmConn.Disconnect()
+	expectEvent("disconnected")
+	select {
+	case event := <-events:
+		t.Fatalf("Unexpected event %q", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestASTMConnectionContextCancellation(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := astmConn.ReadMessageWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ReadMessageWithContext to give up with the deadline, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if astmConn.EstablishSendModeWithContext(ctx) {
+		t.Fatal("Expected send mode not to be established without an ACK")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("Expected the establishment to give up with the deadline, it took %v", elapsed)
+	}
+	if written := mockConn.Written(); !bytes.Equal(written, []byte{constants.ENQ, constants.EOT}) {
+		t.Fatalf("Expected ENQ and the EOT giving up, got %q", written)
+	}
+}
+
+func TestASTMConnectionConnectWithContext(t *testing.T) {
+	var tcpConn = connection.NewTCPConnection("127.0.0.1", "1")
+	astmConn := lis1a2.NewASTMConnection(&tcpConn, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := astmConn.ConnectWithContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the connection to give up with ctx, got %v", err)
+	}
+}
+
+func TestASTMConnectionBusyWhileReceiving(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	acked := make(chan struct{}, 1)
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ACK {
+			select {
+			case acked <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err := mockConn.Inject([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	<-acked
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); !errors.Is(err, lis1a2.ErrBusy) {
+		t.Fatalf("Expected ErrBusy while receiving, got %v", err)
+	}
+	if written := mockConn.Written(); !bytes.Equal(written, []byte{constants.ACK}) {
+		t.Fatalf("Expected nothing but the ACK to be written, got %q", written)
+	}
+
+	inbound := frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if _, err := astmConn.ReadMessage(time.Second); err != nil {
+		t.Fatalf("Failed to read the message: %v", err)
+	}
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Expected the message to be sent once the line is idle, got %v", err)
+	}
+}
+
+func TestASTMConnectionEnqueue(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	var writtenMutex sync.Mutex
+	var frames []string
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.STX:
+			writtenMutex.Lock()
+			frames = append(frames, string(data))
+			writtenMutex.Unlock()
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	// the instrument takes the line first, the queued messages wait for it to be idle
+	if err := mockConn.Inject([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	sent := make(chan error, 2)
+	astmConn.Enqueue([]string{"H|\\^&", "L|1"}, func(err error) { sent <- err })
+	astmConn.Enqueue([]string{"H|\\^&", "P|1", "L|1"}, func(err error) { sent <- err })
+	time.Sleep(150 * time.Millisecond)
+	if length := astmConn.QueueLength(); length != 2 {
+		t.Fatalf("Expected both messages to be queued while receiving, got %v", length)
+	}
+
+	inbound := frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	for index := 0; index < 2; index++ {
+		select {
+		case err := <-sent:
+			if err != nil {
+				t.Fatalf("Failed to send queued message %v: %v", index+1, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Queued message %v was not sent", index+1)
+		}
+	}
+	if length := astmConn.QueueLength(); length != 0 {
+		t.Fatalf("Expected the queue to be empty, got %v", length)
+	}
+	writtenMutex.Lock()
+	defer writtenMutex.Unlock()
+	expected := []string{frame(1, "H|\\^&", true), frame(2, "L|1", true), frame(1, "H|\\^&", true), frame(2, "P|1", true), frame(3, "L|1", true)}
+	if strings.Join(frames, "") != strings.Join(expected, "") {
+		t.Fatalf("Expected the messages to be sent in order, got %q", frames)
+	}
+}
+
+func TestASTMConnectionReconnectSendsQueueOnce(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	time.Sleep(50 * time.Millisecond)
+	// the link is lost, connecting again stops the go routines of the previous Listen
+	_ = mockConn.Disconnect()
+	time.Sleep(50 * time.Millisecond)
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect again: %v", err)
+	}
+	go astmConn.Listen()
+	var writtenMutex sync.Mutex
+	var frames []string
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.STX:
+			writtenMutex.Lock()
+			frames = append(frames, string(data))
+			writtenMutex.Unlock()
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	sent := make(chan error, 2)
+	astmConn.Enqueue([]string{"H|\\^&", "L|1"}, func(err error) { sent <- err })
+	astmConn.Enqueue([]string{"H|\\^&", "P|1", "L|1"}, func(err error) { sent <- err })
+	for index := 0; index < 2; index++ {
+		select {
+		case err := <-sent:
+			if err != nil {
+				t.Fatalf("Failed to send queued message %v: %v", index+1, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Queued message %v was not sent", index+1)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	writtenMutex.Lock()
+	defer writtenMutex.Unlock()
+	expected := []string{frame(1, "H|\\^&", true), frame(2, "L|1", true), frame(1, "H|\\^&", true), frame(2, "P|1", true), frame(3, "L|1", true)}
+	if strings.Join(frames, "") != strings.Join(expected, "") {
+		t.Fatalf("Expected every message to be sent once, in order, got %q", frames)
+	}
+}
+
+func TestASTMConnectionKeepsQueueWhenLinkLost(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	time.Sleep(50 * time.Millisecond)
+	_ = mockConn.Disconnect()
+	time.Sleep(50 * time.Millisecond)
+
+	sent := make(chan error, 1)
+	astmConn.Enqueue([]string{"H|\\^&", "L|1"}, func(err error) { sent <- err })
+	select {
+	case err := <-sent:
+		t.Fatalf("Expected the message to stay queued while the link is lost, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if length := astmConn.QueueLength(); length != 1 {
+		t.Fatalf("Expected the message to stay queued, got %v", length)
+	}
+
+	if err := astmConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect again: %v", err)
+	}
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ || data[0] == constants.STX {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	go astmConn.Listen()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Failed to send the queued message once connected again: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The queued message was not sent once connected again")
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestReadOverflowDropCounts(t *testing.T) {
+	var mockConn = connection.NewMockConnection(connection.Options{ReadBufferSize: 1, OverflowPolicy: connection.OverflowDrop})
+	astmConn := lis1a2.NewASTMConnection(&mockConn, false)
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer mockConn.Disconnect()
+	if err := mockConn.Inject([]byte{constants.ENQ, constants.ENQ, constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if dropped := mockConn.ReadsDropped(); dropped != 2 {
+		t.Fatalf("Expected 2 reads dropped, got %d", dropped)
+	}
+	if metrics := astmConn.Metrics(); metrics.ReadsDropped != 2 {
+		t.Fatalf("Expected the metrics to count 2 reads dropped, got %+v", metrics)
+	}
+	var exposition strings.Builder
+	if err := lis1a2.WritePrometheus(&exposition, map[string]lis1a2.Metrics{"cobas": astmConn.Metrics()}); err != nil {
+		t.Fatalf("Failed to write the metrics: %v", err)
+	}
+	if !strings.Contains(exposition.String(), "lis1a2_reads_dropped_total{link=\"cobas\"} 2\n") {
+		t.Fatalf("Expected the reads dropped to be exposed, got %q", exposition.String())
+	}
+}
+
+func TestReadOverflowNAK(t *testing.T) {
+	var mockConn = connection.NewMockConnection(connection.Options{ReadBufferSize: 1, OverflowPolicy: connection.OverflowNAK})
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer mockConn.Disconnect()
+	naked := make(chan []byte, 1)
+	mockConn.OnWrite(func(data []byte) { naked <- data })
+	if err := mockConn.Inject([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if err := mockConn.Inject([]byte(frame(1, "H|\\^&", true))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case data := <-naked:
+		if string(data) != string([]byte{constants.NAK}) {
+			t.Fatalf("Expected the frame which did not fit to be NAKed, got %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the frame which did not fit to be NAKed")
+	}
+	if dropped := mockConn.ReadsDropped(); dropped != 1 {
+		t.Fatalf("Expected 1 read dropped, got %d", dropped)
+	}
+
+	// an EOT cannot be sent again, it waits for the consumer instead
+	injected := make(chan error, 1)
+	go func() { injected <- mockConn.Inject([]byte{constants.EOT}) }()
+	for _, expected := range []byte{constants.ENQ, constants.EOT} {
+		data, err := mockConn.ReadBytesFromConnection()
+		if err != nil || string(data) != string([]byte{expected}) {
+			t.Fatalf("Expected %q, got %q and %v", []byte{expected}, data, err)
+		}
+	}
+	if err := <-injected; err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if dropped := mockConn.ReadsDropped(); dropped != 1 {
+		t.Fatalf("Expected the EOT not to be dropped, got %d reads dropped", dropped)
+	}
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/config"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/profiles"
+)
+
+func TestProfileLookup(t *testing.T) {
+	for _, bundled := range []profiles.Profile{profiles.CobasE411, profiles.SysmexXN} {
+		if profile, ok := profiles.Lookup(bundled.Name); !ok || profile.Name != bundled.Name {
+			t.Fatalf("Expected the bundled profile %v to be registered, got %+v", bundled.Name, profile)
+		}
+	}
+	if _, ok := profiles.Lookup("abacus"); ok {
+		t.Fatalf("Expected no profile for an unknown analyzer")
+	}
+}
+
+func TestProfileApply(t *testing.T) {
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		if err := profiles.SysmexXN.Apply(astmConn); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+	})
+
+	// the frames without checksum are accepted like the profile tells
+	inbound := string([]byte{constants.ENQ}) + "\x021H|\\^&\r\x03\r\n" + "\x022L|1\r\x03\r\n" + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil || message != "H|\\^&\nL|1\n" {
+		t.Fatalf("Unexpected message %q, error %v", message, err)
+	}
+}
+
+func TestProfileParse(t *testing.T) {
+	profile := profiles.SysmexXN
+	// Lenient takes a result without its order
+	parsed, err := profile.Parse(lis1a2.ReceivedMessage{Text: "H|\\^&\nP|1\nR|1|^^^WBC|6.2\nL|1|N\n"})
+	if err != nil || len(parsed.Records) != 4 {
+		t.Fatalf("Expected the message to be parsed leniently, got %v", err)
+	}
+	if _, err := profile.Parse(lis1a2.ReceivedMessage{Text: "H!~^&\nL!1!N\n"}); !errors.Is(err, profiles.ErrUnexpectedDelimiters) {
+		t.Fatalf("Expected ErrUnexpectedDelimiters, got %v", err)
+	}
+
+	profile.Delimiters = astm.Delimiters{Field: '!', Repeat: '~', Component: '^', Escape: '&'}
+	order, err := profile.NewOrderMessage().Patient(&astm.PatientRecord{}).Build()
+	if err != nil {
+		t.Fatalf("Failed to build: %v", err)
+	}
+	if lines := order.Lines(); len(lines) == 0 || lines[0][:5] != "H!~^&" {
+		t.Fatalf("Expected the order to declare the delimiters of the profile, got %q", lines)
+	}
+}
+
+func TestProfileRegister(t *testing.T) {
+	custom := profiles.Profile{
+		Name:    "bench-analyzer",
+		Sender:  lis1a2.SenderOptions{MaxFrameSize: 64, FrameReplyTimeout: 5 * time.Second},
+		Charset: astm.CharsetWindows1252,
+		Records: map[byte]astm.RecordFactory{'Z': astm.NewGenericRecord},
+	}
+	if err := profiles.Register(custom); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	t.Cleanup(func() { _ = astm.RegisterRecordType('Z', nil) })
+
+	instrument := config.Instrument{Name: "bench", Transport: "tcp", Address: "127.0.0.1:4000", Profile: "bench-analyzer",
+		Sender: config.Sender{MaxFrameSize: 128}}
+	if options := instrument.SenderOptions(); options.MaxFrameSize != 128 || options.FrameReplyTimeout != 5*time.Second {
+		t.Fatalf("Expected the profile under the configuration, got %+v", options)
+	}
+	if _, err := instrument.NewASTMConnection(); err != nil {
+		t.Fatalf("Failed to build the connection: %v", err)
+	}
+	message, err := astm.ParseMessage("H|\\^&\nZ|1|cal\nL|1|N\n", astm.ParseOptions{Strictness: astm.Lenient})
+	if err != nil {
+		t.Fatalf("Expected the record type of the profile to be registered, got %v", err)
+	}
+	if generic, ok := message.Records[1].(*astm.GenericRecord); !ok || generic.Type != 'Z' {
+		t.Fatalf("Expected a Z record, got %#v", message.Records[1])
+	}
+
+	instrument.Profile = "abacus"
+	if err := instrument.Validate(); !errors.Is(err, config.ErrInvalidConfig) {
+		t.Fatalf("Expected an unknown profile to be invalid, got %v", err)
+	}
+}
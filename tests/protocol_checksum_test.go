@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+func TestComputeChecksum(t *testing.T) {
+	whole := frame(1, "H|\\^&", false)
+	if checksum := protocol.ComputeChecksum([]byte(whole)); string(checksum[:]) != whole[len(whole)-4:len(whole)-2] {
+		t.Fatalf("Unexpected checksum %q of %q", checksum, whole)
+	}
+	if checksum := protocol.ComputeChecksum([]byte("1H|\\^&\x17")); string(checksum[:]) != "EC" {
+		t.Fatalf("Expected checksum EC, got %q", checksum)
+	}
+}
+
+func TestValidateFrame(t *testing.T) {
+	good := frame(3, "R|1|^^^GLU|5.4", true)
+	corrupt := []byte(good)
+	corrupt[4] = 'X'
+	lowercase := []byte(frame(2, "L|1", true))
+	for index := len(lowercase) - 4; index < len(lowercase)-2; index++ {
+		if lowercase[index] >= 'A' && lowercase[index] <= 'F' {
+			lowercase[index] += 'a' - 'A'
+		}
+	}
+	tests := []struct {
+		name     string
+		frame    []byte
+		expected error
+	}{
+		{"end frame", []byte(good), nil},
+		{"intermediate frame", []byte(frame(7, "R|1|", false)), nil},
+		{"lowercase checksum", lowercase, nil},
+		{"checksum mismatch", corrupt, protocol.ErrChecksumMismatch},
+		{"no STX", []byte(good[1:]), protocol.ErrMalformedFrame},
+		{"no LF", []byte(good[:len(good)-1]), protocol.ErrMalformedFrame},
+		{"frame number out of range", []byte("\x028L|1\r\x0300\r\n"), protocol.ErrMalformedFrame},
+		{"no ETX", []byte("\x021L|1\r\x0000\r\n"), protocol.ErrMalformedFrame},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := protocol.ValidateFrame(test.frame)
+			if test.expected == nil && err != nil {
+				t.Fatalf("Expected %q to be valid, got %v", test.frame, err)
+			}
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Expected %v for %q, got %v", test.expected, test.frame, err)
+			}
+		})
+	}
+}
+
+func TestReceiverNAKsChecksumMismatch(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer mockConn.Disconnect()
+
+	messages := make(chan string, 1)
+	receiver := lis1a2.NewReceiver(&mockConn, func(message string, err error) {
+		if err != nil {
+			t.Errorf("Unexpected receive error: %v", err)
+		}
+		messages <- message
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = receiver.Listen(ctx) }()
+
+	corrupt := []byte(frame(1, "H|\\^&", true))
+	corrupt[3] = 'X'
+	inbound := string([]byte{constants.ENQ}) + string(corrupt) + frame(1, "H|\\^&", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case message := <-messages:
+		if message != "H|\\^&\n" {
+			t.Fatalf("Unexpected message %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.NAK, constants.ACK}) {
+		t.Fatalf("Expected the corrupt frame to be NAKed and its retransmission ACKed, got %q", written)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+func TestEncodeFrame(t *testing.T) {
+	if encoded := protocol.EncodeFrame(1, []byte("H|\\^&"), true); string(encoded) != frame(1, "H|\\^&", true) {
+		t.Fatalf("Unexpected end frame %q", encoded)
+	}
+	if encoded := protocol.EncodeFrame(9, []byte("R|1|"), false); string(encoded) != frame(1, "R|1|", false) {
+		t.Fatalf("Expected the frame number to wrap around, got %q", encoded)
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	decoded, err := protocol.DecodeFrame(protocol.EncodeFrame(3, []byte("R|1|^^^GLU"), false))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded.FrameNumber != 3 || string(decoded.Text) != "R|1|^^^GLU" || decoded.Terminator != constants.ETB || decoded.IsLast() || !decoded.ChecksumValid {
+		t.Fatalf("Unexpected intermediate frame %+v", decoded)
+	}
+
+	corrupt := protocol.EncodeFrame(0, []byte("L|1"), true)
+	corrupt[3] = 'X'
+	decoded, err = protocol.DecodeFrame(corrupt)
+	if err != nil {
+		t.Fatalf("Expected a checksum mismatch not to be an error, got %v", err)
+	}
+	if decoded.FrameNumber != 0 || string(decoded.Text) != "LX1" || !decoded.IsLast() || decoded.ChecksumValid {
+		t.Fatalf("Unexpected corrupt end frame %+v", decoded)
+	}
+
+	if _, err := protocol.DecodeFrame([]byte("\x021L|1\r\n")); !errors.Is(err, protocol.ErrMalformedFrame) {
+		t.Fatalf("Expected ErrMalformedFrame, got %v", err)
+	}
+}
+
+func TestDecodeTolerantFrame(t *testing.T) {
+	valid := frame(2, "R|1|^^^GLU", false)
+	tests := []struct {
+		data   string
+		quirks protocol.FrameQuirk
+		last   bool
+	}{
+		{frame(1, "H|\\^&", true), 0, true},
+		{"\x021H|\\^&\r\x03\r\n", protocol.QuirkMissingChecksum, true},
+		{strings.TrimSuffix(valid, "\r\n") + "\n", protocol.QuirkLFOnly, false},
+		{"\x022R|1|^^^GLU\x17\n", protocol.QuirkMissingChecksum | protocol.QuirkLFOnly, false},
+	}
+	for _, test := range tests {
+		decoded, quirks, err := protocol.DecodeTolerantFrame([]byte(test.data))
+		if err != nil || quirks != test.quirks || decoded.IsLast() != test.last || !decoded.ChecksumValid {
+			t.Fatalf("Unexpected decoding of %q: %+v, quirks %v, error %v", test.data, decoded, quirks, err)
+		}
+		if text := string(decoded.Text); text != "H|\\^&" && text != "R|1|^^^GLU" {
+			t.Fatalf("Unexpected text %q of %q", text, test.data)
+		}
+	}
+
+	corrupt := []byte(strings.TrimSuffix(valid, "\r\n") + "\n")
+	corrupt[3] = 'X'
+	if decoded, _, err := protocol.DecodeTolerantFrame(corrupt); err != nil || decoded.ChecksumValid {
+		t.Fatalf("Expected a checksum sent to be checked, got %+v and %v", decoded, err)
+	}
+	if _, _, err := protocol.DecodeTolerantFrame([]byte("\x021L|1\n")); !errors.Is(err, protocol.ErrMalformedFrame) {
+		t.Fatalf("Expected ErrMalformedFrame, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// startAnalyzer starts a TCP server answering ENQ with ACK, standing in for an analyzer behind a proxy
+func startAnalyzer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the analyzer: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request := make([]byte, 1)
+		if _, err := io.ReadFull(conn, request); err == nil && request[0] == constants.ENQ {
+			_, _ = conn.Write([]byte{constants.ACK})
+		}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	return listener.Addr().String()
+}
+
+// startProxy starts a proxy serving a single client with serve, which gives the address the client asked for,
+// relaying the traffic to it afterwards
+func startProxy(t *testing.T, serve func(conn net.Conn, reader *bufio.Reader) (string, error)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the proxy: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		reader := bufio.NewReader(client)
+		address, err := serve(client, reader)
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", address)
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		go func() { _, _ = io.Copy(server, reader) }()
+		_, _ = io.Copy(client, server)
+	}()
+	return listener.Addr().String()
+}
+
+// serveSOCKS5 plays a SOCKS5 proxy accepting only the username and password given
+func serveSOCKS5(username string, password string) func(conn net.Conn, reader *bufio.Reader) (string, error) {
+	return func(conn net.Conn, reader *bufio.Reader) (string, error) {
+		greeting := make([]byte, 2)
+		_, _ = io.ReadFull(reader, greeting)
+		methods := make([]byte, greeting[1])
+		_, _ = io.ReadFull(reader, methods)
+		_, _ = conn.Write([]byte{0x05, 0x02})
+		version, _ := reader.ReadByte()
+		usernameLength, _ := reader.ReadByte()
+		gotUsername := make([]byte, usernameLength)
+		_, _ = io.ReadFull(reader, gotUsername)
+		passwordLength, _ := reader.ReadByte()
+		gotPassword := make([]byte, passwordLength)
+		_, _ = io.ReadFull(reader, gotPassword)
+		if version != 0x01 || string(gotUsername) != username || string(gotPassword) != password {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return "", errors.New("authentication failed")
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+
+		request := make([]byte, 4)
+		_, _ = io.ReadFull(reader, request)
+		var host string
+		switch request[3] {
+		case 0x01:
+			ip := make([]byte, 4)
+			_, _ = io.ReadFull(reader, ip)
+			host = net.IP(ip).String()
+		case 0x03:
+			length, _ := reader.ReadByte()
+			name := make([]byte, length)
+			_, _ = io.ReadFull(reader, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		_, _ = io.ReadFull(reader, port)
+		if host == "analyzer.invalid" {
+			host = "127.0.0.1"
+		}
+		_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+	}
+}
+
+// connectThroughProxy connects to address through the proxy and checks ENQ is answered with ACK
+func connectThroughProxy(t *testing.T, host string, port string, proxy *connection.Proxy) error {
+	t.Helper()
+	tcpConn := connection.NewTCPConnection(host, port, connection.Options{Proxy: proxy})
+	if err := tcpConn.Connect(); err != nil {
+		return err
+	}
+	defer tcpConn.Disconnect()
+	tcpConn.Listen()
+	if err := tcpConn.Write([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to write ENQ: %v", err)
+	}
+	if str, err := tcpConn.ReadStringFromConnection(); err != nil || str != "\x06" {
+		t.Fatalf("Expected ACK through the proxy, got %q, %v", str, err)
+	}
+	return nil
+}
+
+func TestTCPConnectionThroughSOCKS5(t *testing.T) {
+	_, port, _ := net.SplitHostPort(startAnalyzer(t))
+	proxy, err := connection.ParseProxyURL("socks5://lab:secret@" + startProxy(t, serveSOCKS5("lab", "secret")))
+	if err != nil {
+		t.Fatalf("Failed to parse the proxy URL: %v", err)
+	}
+	// the name is resolved by the proxy, it does not resolve here
+	if err := connectThroughProxy(t, "analyzer.invalid", port, proxy); err != nil {
+		t.Fatalf("Failed to connect through the SOCKS5 proxy: %v", err)
+	}
+
+	proxy = &connection.Proxy{Kind: connection.ProxySOCKS5, Address: startProxy(t, serveSOCKS5("lab", "secret")),
+		Username: "lab", Password: "wrong"}
+	if err := connectThroughProxy(t, "127.0.0.1", port, proxy); !errors.Is(err, connection.ErrProxyRefused) {
+		t.Fatalf("Expected ErrProxyRefused with the wrong password, got %v", err)
+	}
+}
+
+func TestTCPConnectionThroughHTTPConnect(t *testing.T) {
+	host, port, _ := net.SplitHostPort(startAnalyzer(t))
+	authorizations := make(chan string, 1)
+	address := startProxy(t, func(conn net.Conn, reader *bufio.Reader) (string, error) {
+		request, err := http.ReadRequest(reader)
+		if err != nil || request.Method != http.MethodConnect {
+			return "", errors.New("not a CONNECT request")
+		}
+		authorizations <- request.Header.Get("Proxy-Authorization")
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return request.Host, nil
+	})
+	proxy := &connection.Proxy{Kind: connection.ProxyHTTPConnect, Address: address, Username: "lab", Password: "secret"}
+	if err := connectThroughProxy(t, host, port, proxy); err != nil {
+		t.Fatalf("Failed to connect through the HTTP proxy: %v", err)
+	}
+	if authorization := <-authorizations; authorization != "Basic bGFiOnNlY3JldA==" {
+		t.Fatalf("Expected Basic credentials, got %q", authorization)
+	}
+
+	address = startProxy(t, func(conn net.Conn, reader *bufio.Reader) (string, error) {
+		_, _ = http.ReadRequest(reader)
+		_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+		return "", errors.New("authentication required")
+	})
+	proxy = &connection.Proxy{Kind: connection.ProxyHTTPConnect, Address: address}
+	if err := connectThroughProxy(t, host, port, proxy); !errors.Is(err, connection.ErrProxyRefused) {
+		t.Fatalf("Expected ErrProxyRefused when the proxy asks for credentials, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/astm"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// answerQuery sends a query for the specimen from the instrument and returns the records answered by the host
+func answerQuery(t *testing.T, specimenID string, provider lis1a2.OrderProvider) []string {
+	t.Helper()
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.OnQuery(provider, lis1a2.QueryOptions{Header: &astm.HeaderRecord{SenderName: astm.NewField("LIS")}})
+	})
+
+	var recordsMutex sync.Mutex
+	var records []string
+	answered := make(chan struct{})
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.STX:
+			recordsMutex.Lock()
+			records = append(records, string(data[2:len(data)-6]))
+			recordsMutex.Unlock()
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.EOT:
+			close(answered)
+		}
+	})
+	query := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "Q|1|^"+specimenID+"||ALL", true) +
+		frame(3, "L|1|N", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(query)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case <-answered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The query was not answered")
+	}
+	recordsMutex.Lock()
+	defer recordsMutex.Unlock()
+	return records
+}
+
+func TestQueryAnsweredWithOrders(t *testing.T) {
+	records := answerQuery(t, "SID001", func(ctx context.Context, request *astm.RequestRecord) ([]*astm.Patient, error) {
+		specimenID := request.StartingRangeID.Component(2)
+		return []*astm.Patient{{
+			PatientRecord: &astm.PatientRecord{LaboratoryPatientID: astm.NewField("PID001")},
+			Orders: []*astm.Order{
+				{OrderRecord: &astm.OrderRecord{SpecimenID: astm.NewField(specimenID), UniversalTestID: astm.NewField("", "", "", "GLU")}},
+				{OrderRecord: &astm.OrderRecord{SpecimenID: astm.NewField(specimenID), UniversalTestID: astm.NewField("", "", "", "NA")}},
+			},
+		}}, nil
+	})
+	if len(records) != 5 || !strings.HasPrefix(records[0], "H|\\^&|||LIS") {
+		t.Fatalf("Unexpected answer %q", records)
+	}
+	expected := []string{"P|1||PID001", "O|1|SID001||^^^GLU", "O|2|SID001||^^^NA", "L|1|F"}
+	if strings.Join(records[1:], "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected %q, got %q", expected, records[1:])
+	}
+}
+
+func TestQueryAnsweredWithoutOrders(t *testing.T) {
+	records := answerQuery(t, "SID002", func(ctx context.Context, request *astm.RequestRecord) ([]*astm.Patient, error) {
+		return nil, nil
+	})
+	if len(records) != 2 || records[1] != "L|1|I" {
+		t.Fatalf("Expected a header and a terminator telling there is no information, got %q", records)
+	}
+}
+
+func TestQueryProviderFailure(t *testing.T) {
+	records := answerQuery(t, "SID003", func(ctx context.Context, request *astm.RequestRecord) ([]*astm.Patient, error) {
+		return nil, errors.New("database unavailable")
+	})
+	if len(records) != 2 || records[1] != "L|1|Q" {
+		t.Fatalf("Expected a header and a terminator telling the query failed, got %q", records)
+	}
+}
+
+func TestQueryAnsweredOnceTheLineIsIdle(t *testing.T) {
+	mockConn, _ := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.OnQuery(func(ctx context.Context, request *astm.RequestRecord) ([]*astm.Patient, error) {
+			return nil, nil
+		}, lis1a2.QueryOptions{Header: &astm.HeaderRecord{SenderName: astm.NewField("LIS")}})
+	})
+
+	answered := make(chan struct{})
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.STX:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.EOT:
+			close(answered)
+		}
+	})
+	// the instrument takes the line again right after its query, the answer waits for the line to be idle
+	query := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "Q|1|^SID004||ALL", true) +
+		frame(3, "L|1|N", true) + string([]byte{constants.EOT, constants.ENQ})
+	if err := mockConn.Inject([]byte(query)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case <-answered:
+		t.Fatal("The query was answered while the instrument was sending")
+	default:
+	}
+	if err := mockConn.Inject([]byte(frame(1, "H|\\^&", true) + frame(2, "L|1|N", true) + string([]byte{constants.EOT}))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case <-answered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The query was not answered once the line was idle")
+	}
+}
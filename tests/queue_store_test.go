@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestFileQueueStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	store, err := lis1a2.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	first, _ := store.Add([]string{"H|\\^&", "O|1|SID001", "L|1"}, time.Now())
+	second, err := store.Add([]string{"H|\\^&", "O|1|SID002", "L|1"}, time.Now())
+	if err != nil || second <= first {
+		t.Fatalf("Expected increasing IDs, got %v and %v, error %v", first, second, err)
+	}
+	if err := store.Remove(first); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	_ = store.Close()
+
+	// a line cut short by a crash is skipped
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = file.WriteString(`{"op":"add","id":9,"rec`)
+	_ = file.Close()
+
+	store, err = lis1a2.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer store.Close()
+	messages, _ := store.Load()
+	if len(messages) != 1 || messages[0].ID != second || messages[0].Records[1] != "O|1|SID002" {
+		t.Fatalf("Expected the second message only, got %+v", messages)
+	}
+	if content, _ := os.ReadFile(path); strings.Count(string(content), "\n") != 1 {
+		t.Fatalf("Expected the file compacted, got %q", content)
+	}
+	if third, _ := store.Add([]string{"H|\\^&", "L|1"}, time.Now()); third <= second {
+		t.Fatalf("Expected the IDs to carry on after %v, got %v", second, third)
+	}
+}
+
+func TestFileQueueStoreKeepsTheFileMode(t *testing.T) {
+	created := filepath.Join(t.TempDir(), "queue.jsonl")
+	store, err := lis1a2.OpenFileQueueStore(created)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	_ = store.Close()
+	if info, _ := os.Stat(created); info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected a new file created with the mode 0644, got %v", info.Mode().Perm())
+	}
+
+	existing := filepath.Join(t.TempDir(), "queue.jsonl")
+	if err := os.WriteFile(existing, nil, 0600); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if err := os.Chmod(existing, 0640); err != nil {
+		t.Fatalf("Failed to change the mode: %v", err)
+	}
+	store, err = lis1a2.OpenFileQueueStore(existing)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	_ = store.Close()
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0640 {
+		t.Fatalf("Expected the file compacted to keep the mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestASTMConnectionQueueStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	store, err := lis1a2.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	// queued by the process before it restarted, the analyzer being offline
+	if _, err := store.Add([]string{"H|\\^&", "O|1|SID001", "L|1"}, time.Now()); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+
+	var mockConn = connection.NewMockConnection()
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ || data[0] == constants.STX {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	sent := make(chan error, 1)
+	connectListening(t, &mockConn, func(astmConn *lis1a2.ASTMConnection) {
+		if err := astmConn.SetQueueStore(store); err != nil {
+			t.Fatalf("Failed to set the store: %v", err)
+		}
+		astmConn.Enqueue([]string{"H|\\^&", "O|1|SID002", "L|1"}, func(err error) { sent <- err })
+		if messages, _ := store.Load(); len(messages) != 2 || astmConn.QueueLength() != 2 {
+			t.Fatalf("Expected both messages stored and queued, got %+v", messages)
+		}
+	})
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the queued messages to be sent")
+	}
+	written := string(mockConn.Written())
+	if first, second := strings.Index(written, "SID001"), strings.Index(written, "SID002"); first < 0 || second < first {
+		t.Fatalf("Expected the stored message sent first, got %q", written)
+	}
+	if messages, _ := store.Load(); len(messages) != 0 {
+		t.Fatalf("Expected the messages sent removed from the store, got %+v", messages)
+	}
+}
+
+func TestASTMConnectionQueueStoreKeepsMessagesNotTaken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	store, err := lis1a2.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	// the analyzer never ACKs ENQ
+	var enqs atomic.Int32
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetSenderOptions(lis1a2.SenderOptions{MaxAttempts: 1})
+		astmConn.SetQueueOptions(lis1a2.QueueOptions{RetryBackoff: 20 * time.Millisecond})
+		if err := astmConn.SetQueueStore(store); err != nil {
+			t.Fatalf("Failed to set the store: %v", err)
+		}
+	})
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			enqs.Add(1)
+			_ = mockConn.Inject([]byte{constants.NAK})
+		}
+	})
+	sent := make(chan error, 1)
+	astmConn.Enqueue([]string{"H|\\^&", "O|1|SID001", "L|1"}, func(err error) { sent <- err })
+	deadline := time.Now().Add(2 * time.Second)
+	for enqs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if attempts := enqs.Load(); attempts < 3 {
+		t.Fatalf("Expected the message to be sent again, got %v attempts", attempts)
+	}
+	select {
+	case err := <-sent:
+		t.Fatalf("Expected the message to stay queued, got %v", err)
+	default:
+	}
+
+	// the process restarts
+	_ = astmConn.Disconnect()
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	reopened, err := lis1a2.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+	if messages, _ := reopened.Load(); len(messages) != 1 || messages[0].Records[1] != "O|1|SID001" {
+		t.Fatalf("Expected the message not taken to survive the restart, got %+v", messages)
+	}
+}
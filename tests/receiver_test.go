@@ -0,0 +1,346 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestReceiverDeliversMessages(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer mockConn.Disconnect()
+	mockConn.Listen()
+
+	messages := make(chan string, 1)
+	receiver := lis1a2.NewReceiver(&mockConn, func(message string, err error) {
+		if err != nil {
+			t.Errorf("Unexpected receive error: %v", err)
+		}
+		messages <- message
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	listened := make(chan error, 1)
+	go func() { listened <- receiver.Listen(ctx) }()
+
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "R|1|", false) +
+		frame(3, "^^^GLU|5.4", true) + frame(4, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case message := <-messages:
+		if message != "H|\\^&\nR|1|^^^GLU|5.4\nL|1\n" {
+			t.Fatalf("Unexpected message %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK, constants.ACK, constants.ACK, constants.ACK}) {
+		t.Fatalf("Expected the ENQ and every frame to be ACKed, got %q", written)
+	}
+
+	cancel()
+	if err := <-listened; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Listen to return context.Canceled, got %v", err)
+	}
+}
+
+// receivedMessage is what a Receiver delivered to its onMessage callback
+type receivedMessage struct {
+	message string
+	err     error
+}
+
+// listenReceiver starts a Receiver listening over a mock connection, delivering on the returned channel
+func listenReceiver(t *testing.T, options ...lis1a2.ReceiverOptions) (*connection.MockConnection, chan receivedMessage) {
+	t.Helper()
+	var mockConn = connection.NewMockConnection()
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	messages := make(chan receivedMessage, 1)
+	receiver := lis1a2.NewReceiver(&mockConn, func(message string, err error) {
+		messages <- receivedMessage{message: message, err: err}
+	}, options...)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _ = receiver.Listen(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		_ = mockConn.Disconnect()
+	})
+	return &mockConn, messages
+}
+
+func TestReceiverFrameNumbersWrapAround(t *testing.T) {
+	mockConn, messages := listenReceiver(t)
+	inbound := string([]byte{constants.ENQ})
+	expected := ""
+	for frameNumber := 1; frameNumber <= 10; frameNumber++ {
+		inbound += frame(frameNumber, fmt.Sprintf("C|%v", frameNumber), true)
+		expected += fmt.Sprintf("C|%v\n", frameNumber)
+	}
+	if err := mockConn.Inject([]byte(inbound + string([]byte{constants.EOT}))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if received.err != nil || received.message != expected {
+			t.Fatalf("Unexpected message %q, error %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+}
+
+func TestReceiverDiscardsRetransmittedFrame(t *testing.T) {
+	mockConn, messages := listenReceiver(t)
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(1, "H|\\^&", true) +
+		frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if received.err != nil || received.message != "H|\\^&\nL|1\n" {
+			t.Fatalf("Unexpected message %q, error %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK, constants.ACK, constants.ACK}) {
+		t.Fatalf("Expected the retransmission to be ACKed, got %q", written)
+	}
+}
+
+func TestReceiverIgnoresBytesWhileIdle(t *testing.T) {
+	mockConn, messages := listenReceiver(t)
+	// a late ACK, a stray EOT and noise get no reply, only ENQ leaves the neutral state
+	inbound := string([]byte{constants.ACK, constants.EOT, 'x', constants.ENQ}) + frame(1, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if received.err != nil || received.message != "L|1\n" {
+			t.Fatalf("Unexpected message %q, error %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK}) {
+		t.Fatalf("Expected only the ENQ and the frame to be answered, got %q", written)
+	}
+}
+
+func TestReceiverNAKsFrameOutOfSequence(t *testing.T) {
+	mockConn, messages := listenReceiver(t)
+	// a frame numbered 0 right after ENQ is not a retransmission, nothing was accepted yet
+	inbound := string([]byte{constants.ENQ}) + frame(0, "H|\\^&", true) + frame(1, "H|\\^&", true) +
+		frame(3, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if !errors.Is(received.err, lis1a2.ErrFrameSequence) {
+			t.Fatalf("Expected the message to fail with ErrFrameSequence, got %q, %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the failure to be delivered")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.NAK, constants.ACK, constants.NAK}) {
+		t.Fatalf("Expected the frames out of sequence to be NAKed, got %q", written)
+	}
+}
+
+func TestReceiverNAKsFrameOutOfSequenceInLaterMessage(t *testing.T) {
+	mockConn, messages := listenReceiver(t)
+	first := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	// the frame accepted in the first message is not retransmitted by a frame numbered 0 in the second one
+	second := string([]byte{constants.ENQ}) + frame(0, "H|\\^&", true) + string([]byte{constants.EOT})
+	for index, inbound := range []string{first, second} {
+		if err := mockConn.Inject([]byte(inbound)); err != nil {
+			t.Fatalf("Failed to inject: %v", err)
+		}
+		select {
+		case received := <-messages:
+			if index == 0 && received.err != nil {
+				t.Fatalf("Unexpected error receiving the first message: %v", received.err)
+			}
+			if index == 1 && !errors.Is(received.err, lis1a2.ErrFrameSequence) {
+				t.Fatalf("Expected the second message to fail with ErrFrameSequence, got %q, %v", received.message, received.err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected message %v to be delivered", index+1)
+		}
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK, constants.ACK, constants.ACK, constants.NAK}) {
+		t.Fatalf("Expected the frame numbered 0 to be NAKed, got %q", written)
+	}
+}
+
+func TestReceiverTimesOut(t *testing.T) {
+	mockConn, messages := listenReceiver(t, lis1a2.ReceiverOptions{ReceiveTimeout: 100 * time.Millisecond})
+	if err := mockConn.Inject([]byte(string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if !errors.Is(received.err, lis1a2.ErrReceiveTimeout) {
+			t.Fatalf("Expected ErrReceiveTimeout, got %q, %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the receiver to time out")
+	}
+	// back in idle, the next ENQ starts a new message
+	if err := mockConn.Inject([]byte(string([]byte{constants.ENQ}) + frame(1, "L|1", true) + string([]byte{constants.EOT}))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if received.err != nil || received.message != "L|1\n" {
+			t.Fatalf("Unexpected message %q, error %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+}
+
+func TestASTMConnectionReceiveTimeout(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	astmConn.SetReceiverOptions(lis1a2.ReceiverOptions{ReceiveTimeout: 100 * time.Millisecond})
+	if err := mockConn.Inject([]byte(string([]byte{constants.ENQ}) + frame(1, "H|\\^&", false))); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	if _, err := astmConn.ReadMessage(time.Second); !errors.Is(err, lis1a2.ErrReceiveTimeout) {
+		t.Fatalf("Expected ErrReceiveTimeout, got %v", err)
+	}
+}
+
+func TestReceiverRequestInterrupt(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer mockConn.Disconnect()
+	messages := make(chan string, 1)
+	receiver := lis1a2.NewReceiver(&mockConn, func(message string, err error) { messages <- message })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = receiver.Listen(ctx) }()
+
+	receiver.RequestInterrupt()
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case message := <-messages:
+		if message != "H|\\^&\nL|1\n" {
+			t.Fatalf("Expected the frames sent after the interrupt request to be received, got %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.EOT, constants.ACK}) {
+		t.Fatalf("Expected the first frame to be answered with EOT, got %q", written)
+	}
+}
+
+func TestReceiverIncompleteRecord(t *testing.T) {
+	mockConn, messages := listenReceiver(t)
+	inbound := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "R|1|", false) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if !errors.Is(received.err, lis1a2.ErrIncompleteRecord) {
+			t.Fatalf("Expected ErrIncompleteRecord, got %q, %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the failure to be delivered")
+	}
+	// the partial record does not leak into the next message
+	inbound = string([]byte{constants.ENQ}) + frame(1, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if received.err != nil || received.message != "L|1\n" {
+			t.Fatalf("Unexpected message %q, error %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be delivered")
+	}
+}
+
+func TestReceiverTolerant(t *testing.T) {
+	// an older analyzer leaving the checksum out of the header and terminating the terminator record with LF only
+	header := "\x021H|\\^&\r\x03\r\n"
+	terminator := strings.TrimSuffix(frame(2, "L|1", true), "\r\n") + "\n"
+	inbound := string([]byte{constants.ENQ}) + header + terminator + string([]byte{constants.EOT})
+
+	mockConn, messages := listenReceiver(t)
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		t.Fatalf("Expected Strict to NAK the frames, got %q, %v", received.message, received.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.NAK, constants.NAK}) {
+		t.Fatalf("Expected Strict to NAK the frames, got %q", written)
+	}
+
+	mockConn, messages = listenReceiver(t, lis1a2.ReceiverOptions{Compatibility: lis1a2.Tolerant})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case received := <-messages:
+		if received.err != nil || received.message != "H|\\^&\nL|1\n" {
+			t.Fatalf("Unexpected message %q, error %v", received.message, received.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Tolerant to deliver the message")
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK, constants.ACK}) {
+		t.Fatalf("Expected Tolerant to ACK the frames, got %q", written)
+	}
+}
+
+func TestASTMConnectionTolerant(t *testing.T) {
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetReceiverOptions(lis1a2.ReceiverOptions{Compatibility: lis1a2.Tolerant})
+	})
+
+	corrupt := []byte(frame(2, "L|1", true))
+	corrupt[3] = 'X'
+	inbound := string([]byte{constants.ENQ}) + "\x021H|\\^&\r\x03\r\n" + string(corrupt) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	message, err := astmConn.ReadMessage(time.Second)
+	if err != nil || message != "H|\\^&\nL|1\n" {
+		t.Fatalf("Unexpected message %q, error %v", message, err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ACK, constants.ACK, constants.NAK, constants.ACK}) {
+		t.Fatalf("Expected the frame without checksum ACKed and the corrupt one NAKed, got %q", written)
+	}
+	if metrics := astmConn.Metrics(); metrics.ChecksumFailures != 1 {
+		t.Fatalf("Expected only the corrupt frame counted as a checksum failure, got %+v", metrics)
+	}
+}
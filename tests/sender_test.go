@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// connectSender creates a Sender over a mock connection answering ENQ and frames with reply
+func connectSender(t *testing.T, reply byte) (*connection.MockConnection, *lis1a2.Sender) {
+	t.Helper()
+	var mockConn = connection.NewMockConnection()
+	if err := mockConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = mockConn.Disconnect() })
+	mockConn.Listen()
+	mockConn.OnWrite(func(data []byte) {
+		if reply != 0 && data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{reply})
+		}
+	})
+	return &mockConn, lis1a2.NewSender(&mockConn)
+}
+
+func TestSenderSendMessage(t *testing.T) {
+	mockConn, sender := connectSender(t, constants.ACK)
+	if err := sender.SendMessage(context.Background(), []byte("H|\\^&\r\nL|1")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expected := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
+
+func TestSenderSendMessageContextDone(t *testing.T) {
+	// the receiver never replies, the context ends the establishment phase
+	mockConn, sender := connectSender(t, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sender.SendMessage(ctx, []byte("H|\\^&\nL|1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if written := mockConn.Written(); string(written) != string([]byte{constants.ENQ, constants.EOT}) {
+		t.Fatalf("Expected ENQ and EOT to be written, got %q", written)
+	}
+}
+
+func TestSenderAbortsAfterMaxAttempts(t *testing.T) {
+	mockConn, _ := connectSender(t, 0)
+	mockConn.OnWrite(func(data []byte) {
+		switch data[0] {
+		case constants.ENQ:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.STX:
+			_ = mockConn.Inject([]byte{constants.NAK})
+		}
+	})
+	sender := lis1a2.NewSender(mockConn, lis1a2.SenderOptions{MaxAttempts: 3})
+	err := sender.SendMessage(context.Background(), []byte("H|\\^&\nL|1"))
+	if !errors.Is(err, lis1a2.ErrTransmissionAborted) || errors.Is(err, lis1a2.ErrReceiverBusy) {
+		t.Fatalf("Expected ErrTransmissionAborted without ErrReceiverBusy, got %v", err)
+	}
+	header := frame(1, "H|\\^&", true)
+	expected := string([]byte{constants.ENQ}) + header + header + header + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected the NAKed frame to be sent 3 times and EOT, got %q", written)
+	}
+}
+
+func TestSenderAbortsEstablishment(t *testing.T) {
+	mockConn, _ := connectSender(t, constants.NAK)
+	sender := lis1a2.NewSender(mockConn, lis1a2.SenderOptions{BusyBackoff: 10 * time.Millisecond})
+	err := sender.SendMessage(context.Background(), []byte("H|\\^&\nL|1"))
+	if !errors.Is(err, lis1a2.ErrTransmissionAborted) || !errors.Is(err, lis1a2.ErrReceiverBusy) {
+		t.Fatalf("Expected ErrTransmissionAborted and ErrReceiverBusy, got %v", err)
+	}
+	expected := strings.Repeat(string([]byte{constants.ENQ}), constants.MaxSendAttempts) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected ENQ to be sent %v times and EOT, got %q", constants.MaxSendAttempts, written)
+	}
+}
+
+func TestSenderFrameReplyTimeout(t *testing.T) {
+	mockConn, _ := connectSender(t, 0)
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+	sender := lis1a2.NewSender(mockConn, lis1a2.SenderOptions{FrameReplyTimeout: 100 * time.Millisecond})
+	if err := sender.SendMessage(context.Background(), []byte("H|\\^&\nL|1")); !errors.Is(err, lis1a2.ErrReplyTimeout) {
+		t.Fatalf("Expected ErrReplyTimeout, got %v", err)
+	}
+	expected := string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected the transfer to be terminated after the timeout, got %q", written)
+	}
+}
+
+func TestSenderInterruptedByReceiver(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   lis1a2.InterruptPolicy
+		expected string
+	}{
+		{"after message", lis1a2.InterruptAfterMessage, string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + frame(2, "L|1", true) + string([]byte{constants.EOT})},
+		{"immediately", lis1a2.InterruptImmediately, string([]byte{constants.ENQ}) + frame(1, "H|\\^&", true) + string([]byte{constants.EOT})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockConn, _ := connectSender(t, 0)
+			mockConn.OnWrite(func(data []byte) {
+				switch data[0] {
+				case constants.ENQ:
+					_ = mockConn.Inject([]byte{constants.ACK})
+				case constants.STX:
+					_ = mockConn.Inject([]byte{constants.EOT})
+				}
+			})
+			sender := lis1a2.NewSender(mockConn, lis1a2.SenderOptions{InterruptPolicy: test.policy})
+			err := sender.SendMessage(context.Background(), []byte("H|\\^&\nL|1"))
+			if test.policy == lis1a2.InterruptImmediately && !errors.Is(err, lis1a2.ErrInterrupted) {
+				t.Fatalf("Expected ErrInterrupted, got %v", err)
+			}
+			if test.policy == lis1a2.InterruptAfterMessage && err != nil {
+				t.Fatalf("Expected the message to be sent, got %v", err)
+			}
+			if written := string(mockConn.Written()); written != test.expected {
+				t.Fatalf("Expected %q to be written, got %q", test.expected, written)
+			}
+		})
+	}
+}
+
+func TestSenderMaxFrameSize(t *testing.T) {
+	mockConn, _ := connectSender(t, constants.ACK)
+	sender := lis1a2.NewSender(mockConn, lis1a2.SenderOptions{MaxFrameSize: 4})
+	if err := sender.SendMessage(context.Background(), []byte("H|\\^&\nL|1")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	expected := string([]byte{constants.ENQ}) + frame(1, "H|\\^", false) + frame(2, "&", true) + frame(3, "L|1", true) + string([]byte{constants.EOT})
+	if written := string(mockConn.Written()); written != expected {
+		t.Fatalf("Expected %q to be written, got %q", expected, written)
+	}
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/simulator"
+)
+
+// startHostSimulator starts a HostSimulator on a free port and connects an instrument to it
+func startHostSimulator(t *testing.T) (*simulator.HostSimulator, *connection.TCPConnection) {
+	t.Helper()
+	host, err := simulator.NewHostSimulator("127.0.0.1", "0")
+	if err != nil {
+		t.Fatalf("Failed to start the simulator: %v", err)
+	}
+	t.Cleanup(func() { _ = host.Close() })
+	hostName, port, _ := net.SplitHostPort(host.Addr().String())
+	var instrument = connection.NewTCPConnection(hostName, port)
+	if err := instrument.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the simulator: %v", err)
+	}
+	t.Cleanup(func() { _ = instrument.Disconnect() })
+	instrument.Listen()
+	return host, &instrument
+}
+
+func TestHostSimulatorRecordsMessages(t *testing.T) {
+	host, instrument := startHostSimulator(t)
+	sender := lis1a2.NewSender(instrument)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := sender.SendRecords(ctx, []string{"H|\\^&", "L|1|N"}); err != nil {
+		t.Fatalf("Expected the message to be sent, got %v", err)
+	}
+	messages, err := host.WaitForMessages(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected a message to be recorded, got %v", err)
+	}
+	if messages[0].Err != nil || messages[0].Text != "H|\\^&\nL|1|N\n" {
+		t.Fatalf("Unexpected message recorded %q, %v", messages[0].Text, messages[0].Err)
+	}
+	if host.FramesReceived() != 2 {
+		t.Fatalf("Expected 2 frames received, got %d", host.FramesReceived())
+	}
+}
+
+func TestHostSimulatorScriptedBusyAndNAK(t *testing.T) {
+	host, instrument := startHostSimulator(t)
+	host.GoBusy(2)
+	host.NAKFrame(2)
+	sender := lis1a2.NewSender(instrument, lis1a2.SenderOptions{BusyBackoff: 10 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := sender.SendRecords(ctx, []string{"H|\\^&", "P|1", "L|1|N"}); err != nil {
+		t.Fatalf("Expected the message to be sent after the retries, got %v", err)
+	}
+	messages, err := host.WaitForMessages(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected a message to be recorded, got %v", err)
+	}
+	if messages[0].Text != "H|\\^&\nP|1\nL|1|N\n" {
+		t.Fatalf("Expected the NAKed frame to be retransmitted, got %q", messages[0].Text)
+	}
+	if host.FramesReceived() != 4 {
+		t.Fatalf("Expected 4 frames received with the retransmission, got %d", host.FramesReceived())
+	}
+}
+
+func TestHostSimulatorNAKsCorruptFrames(t *testing.T) {
+	_, instrument := startHostSimulator(t)
+	expectReply := func(data []byte, expected byte) {
+		t.Helper()
+		if err := instrument.Write(data); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		reply, err := instrument.ReadStringFromConnection()
+		if err != nil || reply != string([]byte{expected}) {
+			t.Fatalf("Expected %q, got %q, %v", expected, reply, err)
+		}
+	}
+	expectReply([]byte{constants.ENQ}, constants.ACK)
+	expectReply([]byte("\x021H|\\^&\r\x0300\r\n"), constants.NAK)
+}
+
+func TestHostSimulatorWaitForMessagesAfterClose(t *testing.T) {
+	host, _ := startHostSimulator(t)
+	_ = host.Close()
+	if _, err := host.WaitForMessages(context.Background(), 1); err != simulator.ErrClosed {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestInstrumentSimulatorSendsMultiFrameRecordsWithCorruptedFrames(t *testing.T) {
+	host, conn := startHostSimulator(t)
+	record := "R|1|^^^GLU|" + strings.Repeat("9", 30) + "|mg/dL"
+	instrument := simulator.NewInstrumentSimulator(conn, [][]string{{"H|\\^&", record, "L|1|N"}},
+		lis1a2.SenderOptions{MaxFrameSize: 16})
+	instrument.CorruptFrame(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := instrument.Send(ctx); err != nil {
+		t.Fatalf("Expected the message to be sent after retransmitting the corrupted frame, got %v", err)
+	}
+	messages, err := host.WaitForMessages(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected a message to be recorded, got %v", err)
+	}
+	if messages[0].Err != nil || messages[0].Text != "H|\\^&\n"+record+"\nL|1|N\n" {
+		t.Fatalf("Unexpected message recorded %q, %v", messages[0].Text, messages[0].Err)
+	}
+	// the header, the record over 3 frames, the terminator and the retransmission
+	if instrument.FramesSent() != 6 || host.FramesReceived() != 6 {
+		t.Fatalf("Expected 6 frames sent and received, got %d and %d", instrument.FramesSent(), host.FramesReceived())
+	}
+}
+
+func TestInstrumentSimulatorRunsOnASchedule(t *testing.T) {
+	host, conn := startHostSimulator(t)
+	instrument := simulator.NewInstrumentSimulator(conn, [][]string{{"H|\\^&", "L|1|N"}, {"H|\\^&", "L|1|F"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- instrument.Run(runCtx, 10*time.Millisecond) }()
+	messages, err := host.WaitForMessages(ctx, 3)
+	stop()
+	if err != nil {
+		t.Fatalf("Expected 3 messages to be recorded, got %v", err)
+	}
+	if messages[0].Text != "H|\\^&\nL|1|N\n" || messages[1].Text != "H|\\^&\nL|1|F\n" || messages[2].Text != messages[0].Text {
+		t.Fatalf("Expected the messages to be sent in turn, got %v", messages)
+	}
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected Run to return context.Canceled, got %v", err)
+	}
+}
+
+func TestInstrumentSimulatorWithoutMessages(t *testing.T) {
+	_, conn := startHostSimulator(t)
+	instrument := simulator.NewInstrumentSimulator(conn, nil)
+	if err := instrument.Send(context.Background()); err != simulator.ErrNoMessages {
+		t.Fatalf("Expected ErrNoMessages, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+	"github.com/therealriteshkudalkar/lis1a2/sink/mqtt"
+	"github.com/therealriteshkudalkar/lis1a2/sink/nats"
+)
+
+// flakySink fails the first attempts to publish, counting them
+type flakySink struct {
+	failures int
+	attempts int
+	err      error
+}
+
+func (flaky *flakySink) Publish(ctx context.Context, message lis1a2.ReceivedMessage) error {
+	flaky.attempts++
+	if flaky.attempts <= flaky.failures {
+		return flaky.err
+	}
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	options := sink.RetryOptions{InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+	flaky := &flakySink{failures: 3, err: errors.New("broker unavailable")}
+	if err := sink.Retry(flaky, options).Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); err != nil {
+		t.Fatalf("Expected the message published in the end, got %v", err)
+	}
+	if flaky.attempts != 4 {
+		t.Fatalf("Expected 4 attempts, got %v", flaky.attempts)
+	}
+
+	options.MaxAttempts = 2
+	flaky = &flakySink{failures: 3, err: errors.New("broker unavailable")}
+	if err := sink.Retry(flaky, options).Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); !errors.Is(err, flaky.err) || flaky.attempts != 2 {
+		t.Fatalf("Expected to give up after 2 attempts, got %v after %v", err, flaky.attempts)
+	}
+
+	flaky = &flakySink{failures: 3, err: sink.ErrUnpublishable}
+	if err := sink.Retry(flaky, options).Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); !errors.Is(err, sink.ErrUnpublishable) || flaky.attempts != 1 {
+		t.Fatalf("Expected an unpublishable message not to be retried, got %v after %v", err, flaky.attempts)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	flaky = &flakySink{failures: 1 << 20, err: errors.New("broker unavailable")}
+	if err := sink.Retry(flaky, sink.RetryOptions{InitialBackoff: time.Millisecond}).Publish(ctx, lis1a2.ReceivedMessage{Text: kafkaMessage}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected to give up once ctx is done, got %v", err)
+	}
+}
+
+// natsPublished is a message published to NATS
+type natsPublished struct {
+	subject string
+	data    string
+	headers map[string]string
+}
+
+type recordingNATS struct{ published []natsPublished }
+
+func (publisher *recordingNATS) Publish(ctx context.Context, subject string, data []byte, headers map[string]string) error {
+	publisher.published = append(publisher.published, natsPublished{subject: subject, data: string(data), headers: headers})
+	return nil
+}
+
+func TestNATSSink(t *testing.T) {
+	publisher := &recordingNATS{}
+	var natsSink sink.MessageSink = nats.NewSink(publisher, nats.Options{Subject: "lab.results", Format: sink.FormatRaw | sink.FormatJSON})
+	if err := natsSink.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if len(publisher.published) != 2 {
+		t.Fatalf("Expected the raw and the JSON messages, got %v", publisher.published)
+	}
+	raw := publisher.published[0]
+	if raw.subject != "lab.results.cobas" || raw.data != kafkaMessage || raw.headers[nats.HeaderFormat] != "raw" {
+		t.Fatalf("Unexpected raw message %+v", raw)
+	}
+	if publisher.published[1].headers[nats.HeaderFormat] != "json" {
+		t.Fatalf("Unexpected JSON message %+v", publisher.published[1])
+	}
+
+	subjects := nats.NewSink(publisher, nats.Options{Subject: "lab"})
+	if subject := subjects.Subject(lis1a2.ReceivedMessage{Text: "H|\\^&|||xn.1 a\nL|1\n"}); subject != "lab.xn_1_a" {
+		t.Fatalf("Expected the instrument ID to be a single token, got %q", subject)
+	}
+	if subject := subjects.Subject(lis1a2.ReceivedMessage{Text: "H|\\^&\nL|1\n"}); subject != "lab.unknown" {
+		t.Fatalf("Expected an unknown instrument, got %q", subject)
+	}
+}
+
+// mqttPublished is a message published to MQTT
+type mqttPublished struct {
+	topic    string
+	qos      byte
+	retained bool
+}
+
+type recordingMQTT struct{ published []mqttPublished }
+
+func (client *recordingMQTT) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	client.published = append(client.published, mqttPublished{topic: topic, qos: qos, retained: retained})
+	return nil
+}
+
+func TestMQTTSink(t *testing.T) {
+	client := &recordingMQTT{}
+	var mqttSink sink.MessageSink = mqtt.NewSink(client, mqtt.Options{Topic: "lab/results", QoS: mqtt.AtMostOnce, Format: sink.FormatRaw | sink.FormatJSON})
+	if err := mqttSink.Publish(context.Background(), lis1a2.ReceivedMessage{Text: "H|\\^&|||cobas/e411\nP|1\nL|1|N\n"}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	expected := []mqttPublished{{topic: "lab/results/cobas_e411/raw", qos: mqtt.AtLeastOnce}, {topic: "lab/results/cobas_e411/json", qos: mqtt.AtLeastOnce}}
+	if len(client.published) != 2 || client.published[0] != expected[0] || client.published[1] != expected[1] {
+		t.Fatalf("Expected %+v, got %+v", expected, client.published)
+	}
+	if err := mqttSink.Publish(context.Background(), lis1a2.ReceivedMessage{Err: lis1a2.ErrReceiveTimeout}); !errors.Is(err, lis1a2.ErrReceiveTimeout) {
+		t.Fatalf("Expected the error of the message, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// dialWithOptions connects to a local TCP server with the options, giving the connection of the server
+func dialWithOptions(t *testing.T, options connection.Options) (*connection.TCPConnection, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	tcpConn := connection.NewTCPConnection(host, port, options)
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect with the socket options: %v", err)
+	}
+	t.Cleanup(func() { _ = tcpConn.Disconnect() })
+	peer, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	t.Cleanup(func() { _ = peer.Close() })
+	tcpConn.Listen()
+	return &tcpConn, peer
+}
+
+func TestTCPSocketOptions(t *testing.T) {
+	tcpConn, peer := dialWithOptions(t, connection.Options{
+		KeepAlive:           30 * time.Second,
+		Nagle:               true,
+		Linger:              1500 * time.Millisecond,
+		SocketReceiveBuffer: 64 << 10,
+		SocketSendBuffer:    64 << 10,
+	})
+	if err := tcpConn.Write([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to write ENQ: %v", err)
+	}
+	received := make([]byte, 1)
+	if _, err := io.ReadFull(peer, received); err != nil || received[0] != constants.ENQ {
+		t.Fatalf("Expected ENQ, got %q, %v", received, err)
+	}
+}
+
+func TestTCPNegativeLingerResetsOnDisconnect(t *testing.T) {
+	tcpConn, peer := dialWithOptions(t, connection.Options{KeepAlive: -1, Linger: -1})
+	if err := tcpConn.Disconnect(); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Expected the connection to be reset, got %v", err)
+	}
+}
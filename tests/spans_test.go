@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// spanKey is the key under which recordingTracer puts the number of its spans in their context
+type spanKey struct{}
+
+// recordingTracer keeps the exchanges its spans ended with
+type recordingTracer struct {
+	mutex     sync.Mutex
+	started   int
+	exchanges []lis1a2.Exchange
+	parents   []context.Context
+}
+
+func (tracer *recordingTracer) StartExchange(ctx context.Context, direction lis1a2.ExchangeDirection) (context.Context, lis1a2.ExchangeSpan) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	tracer.started++
+	tracer.parents = append(tracer.parents, ctx)
+	return context.WithValue(ctx, spanKey{}, tracer.started), recordingSpan{tracer: tracer}
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+}
+
+func (span recordingSpan) End(exchange lis1a2.Exchange) {
+	span.tracer.mutex.Lock()
+	defer span.tracer.mutex.Unlock()
+	span.tracer.exchanges = append(span.tracer.exchanges, exchange)
+}
+
+func (tracer *recordingTracer) ended() []lis1a2.Exchange {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	return append([]lis1a2.Exchange(nil), tracer.exchanges...)
+}
+
+func TestExchangeTracerSpansMessagesSent(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	tracer := &recordingTracer{}
+	astmConn.SetExchangeTracer(tracer)
+	var frames atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.STX && frames.Add(1) == 2 {
+			_ = mockConn.Inject([]byte{constants.NAK})
+		} else if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+
+	type parentKey struct{}
+	ctx := context.WithValue(context.Background(), parentKey{}, "parent")
+	if err := astmConn.SendMessage(ctx, []string{"H|\\^&|||Cobas^8000", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	exchanges := tracer.ended()
+	if len(exchanges) != 1 {
+		t.Fatalf("Expected a single span, got %d", len(exchanges))
+	}
+	exchange := exchanges[0]
+	if exchange.Direction != lis1a2.ExchangeSent || exchange.InstrumentID != "Cobas" || exchange.Frames != 3 ||
+		exchange.Retries != 1 || exchange.Err != nil || exchange.Duration <= 0 {
+		t.Fatalf("Unexpected exchange %+v", exchange)
+	}
+	if tracer.parents[0].Value(parentKey{}) != "parent" {
+		t.Fatal("Expected the span to be started with the context given to SendMessage")
+	}
+	if attributes := exchange.Attributes(); attributes["lis1a2.instrument_id"] != "Cobas" || attributes["lis1a2.direction"] != "sent" {
+		t.Fatalf("Unexpected attributes %v", attributes)
+	}
+}
+
+func TestExchangeTracerCountsRetriesSent(t *testing.T) {
+	tracer := &recordingTracer{}
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetExchangeTracer(tracer)
+		astmConn.SetSenderOptions(lis1a2.SenderOptions{BusyBackoff: 10 * time.Millisecond})
+	})
+	// the receiver is busy on the first ENQ and NAKs the first frame once
+	var enqs, frames atomic.Int32
+	mockConn.OnWrite(func(data []byte) {
+		switch {
+		case data[0] == constants.ENQ && enqs.Add(1) == 1:
+			_ = mockConn.Inject([]byte{constants.NAK})
+		case data[0] == constants.STX && frames.Add(1) == 1:
+			_ = mockConn.Inject([]byte{constants.NAK})
+		case data[0] != constants.EOT:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	exchanges := tracer.ended()
+	if len(exchanges) != 1 || exchanges[0].Frames != 3 || exchanges[0].Retries != 2 {
+		t.Fatalf("Expected the ENQ and the frame sent again counted as retries, got %+v", exchanges)
+	}
+	if naks := astmConn.Metrics().NAKsReceived; naks != 2 {
+		t.Fatalf("Expected 2 NAKs received, got %d", naks)
+	}
+}
+
+func TestExchangeTracerCountsOnlyTheENQsSentAgain(t *testing.T) {
+	tracer := &recordingTracer{}
+	mockConn, astmConn := connectMock(t, func(astmConn *lis1a2.ASTMConnection) {
+		astmConn.SetExchangeTracer(tracer)
+		astmConn.SetSenderOptions(lis1a2.SenderOptions{MaxAttempts: 2, BusyBackoff: 10 * time.Millisecond})
+	})
+	// the receiver stays busy, the NAK of the last ENQ is not followed by another one
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] == constants.ENQ {
+			_ = mockConn.Inject([]byte{constants.NAK})
+		}
+	})
+
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); !errors.Is(err, lis1a2.ErrReceiverBusy) {
+		t.Fatalf("Expected the receiver busy, got %v", err)
+	}
+	exchanges := tracer.ended()
+	if len(exchanges) != 1 || exchanges[0].Frames != 0 || exchanges[0].Retries != 1 {
+		t.Fatalf("Expected the single ENQ sent again counted as a retry, got %+v", exchanges)
+	}
+}
+
+func TestExchangeTracerSpansMessagesReceived(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	tracer := &recordingTracer{}
+	astmConn.SetExchangeTracer(tracer)
+	received := make(chan lis1a2.ReceivedMessage, 1)
+	astmConn.OnMessage(func(message lis1a2.ReceivedMessage) { received <- message })
+
+	header := frame(1, "H|\\^&|||Architect^i2000", true)
+	corrupt := []byte(header)
+	corrupt[3] = 'X'
+	inbound := string([]byte{constants.ENQ}) + string(corrupt) + header + frame(2, "L|1", true) + string([]byte{constants.EOT})
+	if err := mockConn.Inject([]byte(inbound)); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	select {
+	case message := <-received:
+		if message.Err != nil || message.Context == nil || message.Context.Value(spanKey{}) != 1 {
+			t.Fatalf("Expected the message to carry the context of its span, got %+v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No message received")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(tracer.ended()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	exchanges := tracer.ended()
+	if len(exchanges) != 1 {
+		t.Fatalf("Expected a single span, got %d", len(exchanges))
+	}
+	exchange := exchanges[0]
+	if exchange.Direction != lis1a2.ExchangeReceived || exchange.InstrumentID != "Architect" || exchange.Frames != 3 ||
+		exchange.Retries != 1 || exchange.Err != nil {
+		t.Fatalf("Unexpected exchange %+v", exchange)
+	}
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// awaitState polls the state of the connection until it is the one expected
+func awaitState(t *testing.T, astmConn *lis1a2.ASTMConnection, expected lis1a2.ProtocolState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for astmConn.State() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the state %+v, got %+v", expected, astmConn.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStateWhileSending(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+	if state := astmConn.State(); state != (lis1a2.ProtocolState{Phase: lis1a2.PhaseIdle}) {
+		t.Fatalf("Expected idle before sending, got %+v", state)
+	}
+
+	// the first frame is NAKed once, the second one is answered once the sender awaits the reply
+	var mutex sync.Mutex
+	var seen []lis1a2.ProtocolState
+	frames := 0
+	mockConn.OnWrite(func(data []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seen = append(seen, astmConn.State())
+		switch data[0] {
+		case constants.ENQ:
+			_ = mockConn.Inject([]byte{constants.ACK})
+		case constants.STX:
+			frames++
+			switch frames {
+			case 1:
+				_ = mockConn.Inject([]byte{constants.NAK})
+			case 2:
+				_ = mockConn.Inject([]byte{constants.ACK})
+			default:
+				go func() {
+					for astmConn.State() != (lis1a2.ProtocolState{Phase: lis1a2.PhaseAwaitingACK, FrameNumber: 2}) {
+						time.Sleep(time.Millisecond)
+					}
+					_ = mockConn.Inject([]byte{constants.ACK})
+				}()
+			}
+		}
+	})
+	if err := astmConn.SendMessage(context.Background(), []string{"H|\\^&", "L|1"}); err != nil {
+		t.Fatalf("Failed to send the message: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []lis1a2.ProtocolState{
+		{Phase: lis1a2.PhaseEstablishing},
+		{Phase: lis1a2.PhaseSendingFrames, FrameNumber: 1},
+		{Phase: lis1a2.PhaseSendingFrames, FrameNumber: 1, Retries: 1},
+		{Phase: lis1a2.PhaseSendingFrames, FrameNumber: 2},
+		{Phase: lis1a2.PhaseTerminating},
+	}
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected the states %+v on the writes, got %+v", expected, seen)
+	}
+	for index := range expected {
+		if seen[index] != expected[index] {
+			t.Fatalf("Expected the states %+v on the writes, got %+v", expected, seen)
+		}
+	}
+	if state := astmConn.State(); state.Phase != lis1a2.PhaseIdle {
+		t.Fatalf("Expected idle once the message was sent, got %+v", state)
+	}
+}
+
+func TestStateWhileReceiving(t *testing.T) {
+	mockConn, astmConn := connectMock(t)
+
+	_ = mockConn.Inject([]byte{constants.ENQ})
+	awaitState(t, astmConn, lis1a2.ProtocolState{Phase: lis1a2.PhaseReceiving})
+	_ = mockConn.Inject([]byte(frame(1, "H|\\^&", true)))
+	awaitState(t, astmConn, lis1a2.ProtocolState{Phase: lis1a2.PhaseReceiving, FrameNumber: 1})
+
+	corrupt := []byte(frame(2, "L|1", true))
+	corrupt[len(corrupt)-3] ^= 0x01
+	_ = mockConn.Inject(corrupt)
+	awaitState(t, astmConn, lis1a2.ProtocolState{Phase: lis1a2.PhaseReceiving, FrameNumber: 1, Retries: 1})
+	_ = mockConn.Inject([]byte(frame(2, "L|1", true)))
+	awaitState(t, astmConn, lis1a2.ProtocolState{Phase: lis1a2.PhaseReceiving, FrameNumber: 2})
+
+	_ = mockConn.Inject([]byte{constants.EOT})
+	awaitState(t, astmConn, lis1a2.ProtocolState{Phase: lis1a2.PhaseIdle})
+	if phase := lis1a2.PhaseAwaitingACK.String(); phase != "awaiting_ack" {
+		t.Fatalf("Expected awaiting_ack, got %v", phase)
+	}
+}
@@ -1,9 +1,17 @@
 package tests
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+
 	"github.com/therealriteshkudalkar/lis1a2"
 	"github.com/therealriteshkudalkar/lis1a2/connection"
-	"log"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
 )
 
 func testTCPConnectDisconnect() {
@@ -17,9 +25,377 @@ func testTCPConnectDisconnect() {
 		}
 	}()
 
-	astmConn := lis1a2.NewASTMConnection(&tcpConn)
+	astmConn := lis1a2.NewASTMConnection(&tcpConn, false)
 	err := astmConn.Connect()
 	if err != nil {
 		return
 	}
 }
+
+func TestTCPDisconnectAfterPeerClosed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	var tcpConn = connection.NewTCPConnection(host, port)
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to TCP server: %v", err)
+	}
+	tcpConn.Listen()
+
+	// the read go routine disconnects on EOF, reading unblocks once it is done
+	if _, err := tcpConn.ReadStringFromConnection(); !errors.Is(err, connection.ErrConnectionClosed) {
+		t.Fatalf("Expected ErrConnectionClosed reading from a connection closed by the peer, got %v", err)
+	}
+	if tcpConn.IsConnected() {
+		t.Fatalf("Expected the connection to be disconnected")
+	}
+	if err := tcpConn.Disconnect(); err != nil {
+		t.Fatalf("Expected the second disconnect to succeed, got %v", err)
+	}
+	if err := tcpConn.Write([]byte{constants.ENQ}); !errors.Is(err, connection.ErrNotConnected) {
+		t.Fatalf("Expected ErrNotConnected writing after a disconnect, got %v", err)
+	}
+}
+
+func TestTCPPeerReset(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// closing without lingering sends a RST instead of a FIN
+		_, _ = conn.Read(make([]byte, 1))
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	var tcpConn = connection.NewTCPConnection(host, port)
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to TCP server: %v", err)
+	}
+	tcpConn.Listen()
+	_ = tcpConn.Write([]byte{constants.ENQ})
+	if _, err := tcpConn.ReadStringFromConnection(); !errors.Is(err, connection.ErrPeerReset) {
+		t.Fatalf("Expected ErrPeerReset reading from a connection reset by the peer, got %v", err)
+	}
+}
+
+func TestTCPListenerAcceptsIndependentConnections(t *testing.T) {
+	tcpListener, err := connection.NewTCPListener("127.0.0.1", "0")
+	if err != nil {
+		t.Fatalf("Failed to start listening: %v", err)
+	}
+	defer tcpListener.Close()
+	host, port, _ := net.SplitHostPort(tcpListener.Addr().String())
+
+	controlBytes := []byte{constants.ENQ, constants.EOT}
+	for _, controlByte := range controlBytes {
+		var client = connection.NewTCPConnection(host, port)
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Failed to connect to the listener: %v", err)
+		}
+		defer client.Disconnect()
+		client.Listen()
+		client.Write([]byte{controlByte})
+	}
+
+	for _, controlByte := range controlBytes {
+		accepted, err := tcpListener.Accept()
+		if err != nil {
+			t.Fatalf("Failed to accept: %v", err)
+		}
+		defer accepted.Disconnect()
+		if err := accepted.Connect(); err != nil {
+			t.Fatalf("Expected Connect on an accepted connection to succeed, got %v", err)
+		}
+		accepted.Listen()
+		str, err := accepted.ReadStringFromConnection()
+		if err != nil || str != string([]byte{controlByte}) {
+			t.Fatalf("Expected %q, got %q, %v", controlByte, str, err)
+		}
+	}
+}
+
+func TestTCPConnectionReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		// the first connection is dropped straight away, the second one gets an ENQ
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte{constants.ENQ})
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	disconnected := make(chan error, 1)
+	reconnected := make(chan int, 1)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	var tcpConn = connection.NewTCPConnectionWithReconnect(host, port, connection.ReconnectOptions{
+		MaxRetries:     3,
+		InitialBackoff: 10 * time.Millisecond,
+		OnDisconnected: func(err error) { disconnected <- err },
+		OnReconnected:  func(attempts int) { reconnected <- attempts },
+	})
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to TCP server: %v", err)
+	}
+	defer tcpConn.Disconnect()
+	tcpConn.Listen()
+
+	str, err := tcpConn.ReadStringFromConnection()
+	if err != nil || str != string([]byte{constants.ENQ}) {
+		t.Fatalf("Expected ENQ after reconnecting, got %q, %v", str, err)
+	}
+	if err := <-disconnected; err == nil {
+		t.Fatalf("Expected the disconnect event to carry the read error")
+	}
+	if attempts := <-reconnected; attempts != 1 {
+		t.Fatalf("Expected to reconnect on the first attempt, took %v", attempts)
+	}
+	if stats := tcpConn.Stats(); stats.Reconnects != 1 || stats.BytesRead != 1 {
+		t.Fatalf("Expected 1 reconnect and 1 byte read, got %+v", stats)
+	}
+}
+
+func TestTCPConnectionStats(t *testing.T) {
+	tcpListener, err := connection.NewTCPListener("127.0.0.1", "0")
+	if err != nil {
+		t.Fatalf("Failed to start listening: %v", err)
+	}
+	defer tcpListener.Close()
+	host, port, _ := net.SplitHostPort(tcpListener.Addr().String())
+	var client = connection.NewTCPConnection(host, port)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the listener: %v", err)
+	}
+	defer client.Disconnect()
+	client.Listen()
+	if stats := client.Stats(); !stats.LastActivity.IsZero() {
+		t.Fatalf("Expected no activity before any traffic, got %v", stats.LastActivity)
+	}
+	accepted, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer accepted.Disconnect()
+	accepted.Listen()
+
+	frame := protocol.EncodeFrame(1, []byte("H|\\^&"), true)
+	before := time.Now()
+	if err := client.Write(frame); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if _, err := accepted.ReadStringFromConnection(); err != nil {
+		t.Fatalf("Failed to read the frame: %v", err)
+	}
+	if err := accepted.Write([]byte{constants.ACK}); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if _, err := client.ReadStringFromConnection(); err != nil {
+		t.Fatalf("Failed to read the ACK: %v", err)
+	}
+
+	stats := client.Stats()
+	if stats.BytesWritten != int64(len(frame)) || stats.FramesWritten != 1 || stats.BytesRead != 1 || stats.FramesRead != 0 {
+		t.Fatalf("Unexpected client stats %+v", stats)
+	}
+	if stats.LastActivity.Before(before) {
+		t.Fatalf("Expected the last activity after %v, got %v", before, stats.LastActivity)
+	}
+	if stats := accepted.Stats(); stats.BytesRead != int64(len(frame)) || stats.FramesRead != 1 || stats.BytesWritten != 1 {
+		t.Fatalf("Unexpected accepted stats %+v", stats)
+	}
+}
+
+func TestTCPServerConnectionWrappedByASTMConnection(t *testing.T) {
+	// find a free port, the server connection only listens once Connect is called
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+
+	var serverConn = connection.NewTCPServerConnection(host, port)
+	astmConn := lis1a2.NewASTMConnection(&serverConn, false)
+	connected := make(chan error, 1)
+	go func() {
+		connected <- astmConn.Connect()
+	}()
+
+	var instrument net.Conn
+	for attempt := 0; attempt < 100; attempt++ {
+		if instrument, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect to the server connection: %v", err)
+	}
+	defer instrument.Close()
+	if err := <-connected; err != nil {
+		t.Fatalf("Failed to accept the connection: %v", err)
+	}
+	go astmConn.Listen()
+
+	_, _ = instrument.Write([]byte{constants.ENQ})
+	reply := make([]byte, 1)
+	if _, err := instrument.Read(reply); err != nil || reply[0] != constants.ACK {
+		t.Fatalf("Expected ACK to the ENQ, got %q, %v", reply, err)
+	}
+}
+
+func TestTCPConnectWithContextGivesUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+
+	// nobody dials in, so only the context ends the wait of the server connection
+	var serverConn = connection.NewTCPServerConnection(host, port)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := serverConn.ConnectWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if serverConn.IsConnected() {
+		t.Fatalf("Expected the connection not to be connected")
+	}
+}
+
+func TestTCPReadIdleTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		// the analyzer accepts the connection and never sends anything
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	var tcpConn = connection.NewTCPConnection(host, port, connection.Options{ReadIdleTimeout: 50 * time.Millisecond})
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to TCP server: %v", err)
+	}
+	defer tcpConn.Disconnect()
+	tcpConn.Listen()
+	if _, err := tcpConn.ReadStringFromConnection(); !errors.Is(err, connection.ErrReadIdleTimeout) {
+		t.Fatalf("Expected ErrReadIdleTimeout, got %v", err)
+	}
+	if tcpConn.IsConnected() {
+		t.Fatalf("Expected the idle connection to be disconnected")
+	}
+}
+
+func TestTCPWriteReturnsOnceSent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var data []byte
+		buffer := make([]byte, 64)
+		for {
+			count, err := conn.Read(buffer)
+			data = append(data, buffer[:count]...)
+			if err != nil {
+				received <- data
+				return
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	var tcpConn = connection.NewTCPConnection(host, port)
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to TCP server: %v", err)
+	}
+	tcpConn.Listen()
+	data := []byte(frame(1, "H|\\^&", true))
+	if err := tcpConn.Write(data); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	// nothing written is left behind in a queue when disconnecting right away
+	_ = tcpConn.Disconnect()
+	if got := <-received; string(got) != string(data) {
+		t.Fatalf("Expected the server to receive %q, got %q", data, got)
+	}
+}
+
+func TestTCPWriteTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		// the analyzer accepts the connection and never reads from it
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	var tcpConn = connection.NewTCPConnection(host, port, connection.Options{WriteTimeout: 100 * time.Millisecond})
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to TCP server: %v", err)
+	}
+	defer tcpConn.Disconnect()
+	defer func() { _ = (<-accepted).Close() }()
+	tcpConn.Listen()
+
+	// more than the socket buffers of both ends can hold
+	if err := tcpConn.Write(make([]byte, 64<<20)); !errors.Is(err, connection.ErrWriteTimeout) {
+		t.Fatalf("Expected ErrWriteTimeout, got %v", err)
+	}
+	if _, err := tcpConn.ReadStringFromConnection(); !errors.Is(err, connection.ErrWriteTimeout) {
+		t.Fatalf("Expected the reads to return ErrWriteTimeout, got %v", err)
+	}
+}
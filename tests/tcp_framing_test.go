@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+// connectToServerWriting starts a local TCP server which writes data to the first client,
+// and returns a listening TCPConnection connected to it
+func connectToServerWriting(t *testing.T, data string) *connection.TCPConnection {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(data))
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	tcpConn := connection.NewTCPConnection(host, port)
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the TCP server: %v", err)
+	}
+	tcpConn.Listen()
+	return &tcpConn
+}
+
+func TestReadValidFrames(t *testing.T) {
+	// intermediate frame terminated by ETB and end frame terminated by CR ETX
+	frames := []string{"\x021H|\\^&\x17EC\r\n", "\x022|||\r\x03B6\r\n"}
+	tcpConn := connectToServerWriting(t, frames[0]+frames[1])
+	for _, frame := range frames {
+		str, err := tcpConn.ReadStringFromConnection()
+		if err != nil {
+			t.Fatalf("Unexpected error for frame %q: %v", frame, err)
+		}
+		if str != frame {
+			t.Fatalf("Expected %q, got %q", frame, str)
+		}
+	}
+}
+
+func TestReadFrameWithChecksumMismatch(t *testing.T) {
+	frame := "\x021H|\\^&\r\x0300\r\n"
+	tcpConn := connectToServerWriting(t, frame)
+	str, err := tcpConn.ReadStringFromConnection()
+	if !errors.Is(err, connection.ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if str != frame {
+		t.Fatalf("Expected the corrupt frame %q to be returned, got %q", frame, str)
+	}
+}
+
+func TestReadStringFromConnectionContextDeadline(t *testing.T) {
+	tcpConn := connectToServerWriting(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tcpConn.ReadStringFromConnectionContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if err := tcpConn.Disconnect(); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if _, err := tcpConn.ReadStringFromConnectionContext(context.Background()); err == nil {
+		t.Fatalf("Expected an error reading from a disconnected connection")
+	}
+}
+
+func TestEncodedMessageRoundTrips(t *testing.T) {
+	records := []string{"H|\\^&", "R|1|" + strings.Repeat("9", constants.MaxFrameSize+10), "L|1|N"}
+	frames := protocol.EncodeMessage(records)
+	if len(frames) != 4 {
+		t.Fatalf("Expected 4 frames, got %v", len(frames))
+	}
+	var data []byte
+	data = append(data, protocol.ENQ()...)
+	for _, frame := range frames {
+		data = append(data, frame...)
+	}
+	data = append(data, protocol.EOT()...)
+
+	tcpConn := connectToServerWriting(t, string(data))
+	expected := append([][]byte{protocol.ENQ()}, append(frames, protocol.EOT())...)
+	for i, expectedData := range expected {
+		str, err := tcpConn.ReadStringFromConnection()
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", expectedData, err)
+		}
+		if str != string(expectedData) {
+			t.Fatalf("Expected %q, got %q", expectedData, str)
+		}
+		if i > 0 && i < len(expected)-1 && str[1] != byte('0'+i%8) {
+			t.Fatalf("Expected frame number %v, got %c", i%8, str[1])
+		}
+	}
+}
+
+func TestReadOverflowError(t *testing.T) {
+	enq := string([]byte{constants.ENQ})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the TCP server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(enq + enq + enq))
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	tcpConn := connection.NewTCPConnection(host, port, connection.Options{
+		ReadBufferSize: 1,
+		OverflowPolicy: connection.OverflowError,
+	})
+	if err := tcpConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the TCP server: %v", err)
+	}
+	defer tcpConn.Disconnect()
+	tcpConn.Listen()
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := tcpConn.ReadStringFromConnection(); !errors.Is(err, connection.ErrReadOverflow) {
+		t.Fatalf("Expected ErrReadOverflow, got %v", err)
+	}
+	if str, err := tcpConn.ReadStringFromConnection(); err != nil || str != enq {
+		t.Fatalf("Expected the frame which fitted in the buffer, got %q, %v", str, err)
+	}
+}
+
+func TestReadInterleavedControlBytesAndPartialFrames(t *testing.T) {
+	validFrame := "\x021H|\\^&\x17EC\r\n"
+	enq := string([]byte{constants.ENQ})
+	eot := string([]byte{constants.EOT})
+	type read struct {
+		data       string
+		incomplete bool
+	}
+	expected := []read{
+		{"\x021H|part", true},
+		{enq, false},
+		{validFrame, false},
+		{"\x022R|1", true},
+		{validFrame, false},
+		{"junk\r\n", true},
+		{"\x023L|1", true},
+		{eot, false},
+		{validFrame, false},
+	}
+	data := "\x021H|part" + enq + validFrame + "\x022R|1" + validFrame + "junk\r\n" + "\x023L|1" + eot + validFrame
+	tcpConn := connectToServerWriting(t, data)
+	for _, expectedRead := range expected {
+		str, err := tcpConn.ReadStringFromConnection()
+		if expectedRead.incomplete != errors.Is(err, connection.ErrIncompleteFrame) {
+			t.Fatalf("Unexpected error for %q: %v", str, err)
+		}
+		if !expectedRead.incomplete && err != nil {
+			t.Fatalf("Unexpected error for %q: %v", str, err)
+		}
+		if str != expectedRead.data {
+			t.Fatalf("Expected %q, got %q", expectedRead.data, str)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// newCertificate creates a self-signed certificate valid for 127.0.0.1 and the pool trusting it
+func newCertificate(t *testing.T, commonName string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestTLSConnection(t *testing.T) {
+	serverCert, serverPool := newCertificate(t, "server")
+	tlsListener, err := connection.NewTLSListener("127.0.0.1", "0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatalf("Failed to start listening: %v", err)
+	}
+	defer tlsListener.Close()
+	go func() {
+		accepted, err := tlsListener.Accept()
+		if err != nil {
+			return
+		}
+		accepted.Listen()
+		accepted.Write([]byte{constants.ENQ})
+	}()
+
+	host, port, _ := net.SplitHostPort(tlsListener.Addr().String())
+	var tlsConn = connection.NewTLSConnection(host, port, &tls.Config{RootCAs: serverPool})
+	if err := tlsConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer tlsConn.Disconnect()
+	tlsConn.Listen()
+	str, err := tlsConn.ReadStringFromConnection()
+	if err != nil || str != string([]byte{constants.ENQ}) {
+		t.Fatalf("Expected ENQ, got %q, %v", str, err)
+	}
+}
+
+func TestTLSConnectionRejectsUnknownServer(t *testing.T) {
+	serverCert, _ := newCertificate(t, "server")
+	_, otherPool := newCertificate(t, "other")
+	tlsListener, err := connection.NewTLSListener("127.0.0.1", "0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatalf("Failed to start listening: %v", err)
+	}
+	defer tlsListener.Close()
+	go func() {
+		accepted, err := tlsListener.Accept()
+		if err == nil {
+			accepted.Listen()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(tlsListener.Addr().String())
+	var tlsConn = connection.NewTLSConnection(host, port, &tls.Config{RootCAs: otherPool})
+	if err := tlsConn.Connect(); err == nil {
+		_ = tlsConn.Disconnect()
+		t.Fatalf("Expected the handshake to fail for an untrusted server")
+	}
+}
+
+func TestTLSOptionsVerifyServerName(t *testing.T) {
+	serverCert, _ := newCertificate(t, "server")
+	rootCAFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]})
+	if err := os.WriteFile(rootCAFile, pemData, 0600); err != nil {
+		t.Fatalf("Failed to write root CA file: %v", err)
+	}
+	tlsListener, err := connection.NewTLSListener("127.0.0.1", "0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatalf("Failed to start listening: %v", err)
+	}
+	defer tlsListener.Close()
+	go func() {
+		for {
+			accepted, err := tlsListener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Listen()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(tlsListener.Addr().String())
+
+	for serverName, valid := range map[string]bool{"127.0.0.1": true, "lis.example.com": false} {
+		config, err := connection.TLSOptions{RootCAFile: rootCAFile, ServerName: serverName}.Config()
+		if err != nil {
+			t.Fatalf("Failed to build the TLS config: %v", err)
+		}
+		var tlsConn = connection.NewTLSConnection(host, port, config)
+		err = tlsConn.Connect()
+		if err == nil {
+			_ = tlsConn.Disconnect()
+		}
+		if valid != (err == nil) {
+			t.Fatalf("Server name %v: expected valid %v, got %v", serverName, valid, err)
+		}
+	}
+}
+
+// writeKeyPair writes the certificate and its key as PEM files and returns their paths
+func writeKeyPair(t *testing.T, certificate tls.Certificate) (string, string) {
+	t.Helper()
+	keyDer, err := x509.MarshalECPrivateKey(certificate.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certFile := filepath.Join(t.TempDir(), "client.pem")
+	keyFile := filepath.Join(t.TempDir(), "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate file: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConnectionWithClientCertificate(t *testing.T) {
+	serverCert, serverPool := newCertificate(t, "server")
+	clientCert, clientPool := newCertificate(t, "client")
+	otherCert, _ := newCertificate(t, "other")
+	tlsListener, err := connection.NewTLSListener("127.0.0.1", "0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start listening: %v", err)
+	}
+	defer tlsListener.Close()
+	go func() {
+		for {
+			accepted, err := tlsListener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Listen()
+			accepted.Write([]byte{constants.ENQ})
+		}
+	}()
+	host, port, _ := net.SplitHostPort(tlsListener.Addr().String())
+
+	certFile, keyFile := writeKeyPair(t, clientCert)
+	config, err := connection.TLSOptions{ClientCertFile: certFile, ClientKeyFile: keyFile}.Config()
+	if err != nil {
+		t.Fatalf("Failed to build the TLS config: %v", err)
+	}
+	config.RootCAs = serverPool
+	var tlsConn = connection.NewTLSConnection(host, port, config)
+	if err := tlsConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect with a trusted client certificate: %v", err)
+	}
+	tlsConn.Listen()
+	str, err := tlsConn.ReadStringFromConnection()
+	if err != nil || str != string([]byte{constants.ENQ}) {
+		t.Fatalf("Expected ENQ, got %q, %v", str, err)
+	}
+	_ = tlsConn.Disconnect()
+
+	for name, certificates := range map[string][]tls.Certificate{"untrusted": {otherCert}, "missing": nil} {
+		var rejectedConn = connection.NewTLSConnection(host, port, &tls.Config{RootCAs: serverPool, Certificates: certificates})
+		err := rejectedConn.Connect()
+		if err == nil {
+			_ = rejectedConn.Disconnect()
+		}
+		if !errors.Is(err, connection.ErrClientCertificateRejected) {
+			t.Fatalf("Expected ErrClientCertificateRejected for a %v certificate, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+	"github.com/therealriteshkudalkar/lis1a2/protocol"
+)
+
+func TestDescribeNamesControlCharacters(t *testing.T) {
+	frame := protocol.EncodeFrame(1, []byte("H|\\^&"), true)
+	if described := protocol.Describe(frame); described != "<STX>1H|\\^&<CR><ETX>E5<CR><LF>" {
+		t.Fatalf("Unexpected description %q", described)
+	}
+	if described := protocol.Describe([]byte{constants.ENQ, 0x1B, 'A'}); described != "<ENQ><1B>A" {
+		t.Fatalf("Unexpected description %q", described)
+	}
+}
+
+func TestTracingConnectionTracesBothDirections(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	var trace strings.Builder
+	tracingConn := connection.NewTracingConnection(&mockConn, &trace)
+	if err := tracingConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer tracingConn.Disconnect()
+	tracingConn.Listen()
+	mockConn.OnWrite(func(data []byte) {
+		if data[0] != constants.EOT {
+			_ = mockConn.Inject([]byte{constants.ACK})
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := lis1a2.NewSender(tracingConn).SendRecords(ctx, []string{"H|\\^&"}); err != nil {
+		t.Fatalf("Expected the message to be sent, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")
+	expected := []string{"-> <ENQ>", "<- <ACK>", "-> <STX>1H|\\^&<CR><ETX>E5<CR><LF>", "<- <ACK>", "-> <EOT>"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines of trace, got %q", len(expected), trace.String())
+	}
+	timestamp := regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} `)
+	for index, line := range lines {
+		if !timestamp.MatchString(line) || !strings.HasSuffix(line, " "+expected[index]) {
+			t.Fatalf("Expected line %d of the trace to be a timestamp and %q, got %q", index+1, expected[index], line)
+		}
+	}
+}
+
+func TestTracingConnectionTracesErrors(t *testing.T) {
+	var mockConn = connection.NewMockConnection()
+	var trace strings.Builder
+	tracingConn := connection.NewTracingConnection(&mockConn, &trace)
+	_ = tracingConn.Connect()
+	_ = mockConn.Inject([]byte("\x021H|\\^&\r\x0300\r\n"))
+	if _, err := tracingConn.ReadStringFromConnection(); err == nil {
+		t.Fatal("Expected the corrupt frame to be returned with an error")
+	}
+	_ = tracingConn.Disconnect()
+	if err := tracingConn.Write([]byte{constants.ENQ}); err == nil {
+		t.Fatal("Expected writing after disconnecting to fail")
+	}
+	if !strings.Contains(trace.String(), "<- <STX>1H|\\^&<CR><ETX>00<CR><LF> (") ||
+		!strings.Contains(trace.String(), "-> ("+connection.ErrNotConnected.Error()+")") {
+		t.Fatalf("Expected the errors to be traced, got %q", trace.String())
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+func TestUnixConnectionFramesLikeTCP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.sock")
+	listener, err := connection.NewUnixListener(path)
+	if err != nil {
+		t.Fatalf("Failed to start the Unix listener: %v", err)
+	}
+	defer listener.Close()
+
+	frame := "\x021H|\\^&\r\x03E5\r\n"
+	unixConn := connection.NewUnixConnection(path)
+	if err := unixConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the Unix socket: %v", err)
+	}
+	defer unixConn.Disconnect()
+	unixConn.Listen()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer accepted.Disconnect()
+	accepted.Listen()
+
+	if err := unixConn.Write([]byte(frame[:4])); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := unixConn.Write([]byte(frame[4:] + string(constants.EOT))); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	for _, expected := range []string{frame, "\x04"} {
+		str, err := accepted.ReadStringFromConnection()
+		if err != nil || str != expected {
+			t.Fatalf("Expected %q, got %q, %v", expected, str, err)
+		}
+	}
+
+	if err := unixConn.Disconnect(); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if _, err := accepted.ReadStringFromConnection(); !errors.Is(err, connection.ErrConnectionClosed) {
+		t.Fatalf("Expected ErrConnectionClosed once the peer disconnected, got %v", err)
+	}
+}
+
+func TestUnixServerConnectionWaitsForAPeer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host.sock")
+	serverConn := connection.NewUnixServerConnection(path)
+	connected := make(chan error, 1)
+	go func() { connected <- serverConn.Connect() }()
+
+	var peer net.Conn
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		if peer, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed to dial the server connection: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer peer.Close()
+	if err := <-connected; err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer serverConn.Disconnect()
+	serverConn.Listen()
+	if _, err := peer.Write([]byte{constants.ENQ}); err != nil {
+		t.Fatalf("Failed to write ENQ: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if str, err := serverConn.ReadStringFromConnectionContext(ctx); err != nil || str != "\x05" {
+		t.Fatalf("Expected ENQ, got %q, %v", str, err)
+	}
+}
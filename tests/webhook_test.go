@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealriteshkudalkar/lis1a2"
+	"github.com/therealriteshkudalkar/lis1a2/sink"
+	"github.com/therealriteshkudalkar/lis1a2/sink/webhook"
+)
+
+func TestWebhookPost(t *testing.T) {
+	secret := []byte("s3cret")
+	posted := make(chan webhook.Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		if !webhook.Verify(secret, request.Header.Get(webhook.HeaderTimestamp), body, request.Header.Get(webhook.HeaderSignature)) {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if request.Header.Get("Authorization") != "Bearer token" || request.Header.Get("Content-Type") != "application/json" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var payload webhook.Payload
+		_ = json.Unmarshal(body, &payload)
+		posted <- payload
+	}))
+	defer server.Close()
+
+	webhookSink := webhook.NewSink(webhook.Options{URL: server.URL, Secret: secret, Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err := webhookSink.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	payload := <-posted
+	if payload.Instrument != "cobas" || payload.Text != kafkaMessage || payload.Message == nil || len(payload.Message.Records) != 5 {
+		t.Fatalf("Unexpected payload %+v", payload)
+	}
+
+	if webhook.Verify(secret, "1700000000", []byte("{}"), webhook.Sign([]byte("other"), "1700000000", []byte("{}"))) {
+		t.Fatalf("Expected a signature of another secret not to verify")
+	}
+}
+
+func TestWebhookStatuses(t *testing.T) {
+	status := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+	webhookSink := webhook.NewSink(webhook.Options{URL: server.URL})
+
+	status.Store(http.StatusServiceUnavailable)
+	err := webhookSink.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage})
+	if !errors.Is(err, webhook.ErrUnexpectedStatus) || errors.Is(err, sink.ErrUnpublishable) {
+		t.Fatalf("Expected a 503 to be retried, got %v", err)
+	}
+	status.Store(http.StatusUnprocessableEntity)
+	if err := webhookSink.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); !errors.Is(err, sink.ErrUnpublishable) {
+		t.Fatalf("Expected a 422 not to be retried, got %v", err)
+	}
+}
+
+func TestWebhookForwarderDeadLetter(t *testing.T) {
+	attempts := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if attempts.Add(1) == 1 {
+			writer.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "undelivered.jsonl")
+	deadLetter, err := sink.OpenFileDeadLetter(path)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer deadLetter.Close()
+	retry := sink.RetryOptions{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+
+	forwarder := webhook.NewForwarder(webhook.Options{URL: server.URL}, retry, deadLetter)
+	if err := forwarder.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage}); err != nil || attempts.Load() != 2 {
+		t.Fatalf("Expected the message posted on the second attempt, got %v after %v", err, attempts.Load())
+	}
+
+	server.Close()
+	err = forwarder.Publish(context.Background(), lis1a2.ReceivedMessage{Text: kafkaMessage})
+	if !errors.Is(err, sink.ErrDeadLettered) {
+		t.Fatalf("Expected the message dead-lettered, got %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var stored []map[string]any
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		stored = append(stored, line)
+	}
+	if len(stored) != 1 || stored[0]["text"] != kafkaMessage || stored[0]["error"] == "" {
+		t.Fatalf("Expected the message stored with its error, got %v", stored)
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/therealriteshkudalkar/lis1a2/connection"
+	"github.com/therealriteshkudalkar/lis1a2/constants"
+)
+
+// startWebSocketServer starts an HTTP server upgrading every request to a WebSocket handled by handle,
+// over TLS when secure is set, and gives its URL with the config trusting it
+func startWebSocketServer(t *testing.T, secure bool, handle func(conn net.Conn, reader *bufio.Reader, request *http.Request)) (string, *tls.Config) {
+	t.Helper()
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Upgrade") != "websocket" {
+			http.Error(writer, "not a websocket", http.StatusBadRequest)
+			return
+		}
+		conn, readWriter, err := http.NewResponseController(writer).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		digest := sha1.Sum([]byte(request.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		_, _ = readWriter.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n")
+		_ = readWriter.Flush()
+		handle(conn, readWriter.Reader, request)
+	})
+	var server *httptest.Server
+	if secure {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(server.Close)
+	if !secure {
+		return "ws" + strings.TrimPrefix(server.URL, "http"), nil
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	return "wss" + strings.TrimPrefix(server.URL, "https"), &tls.Config{RootCAs: roots, ServerName: "example.com"}
+}
+
+// readClientFrame reads a frame sent by the client, unmasking it
+func readClientFrame(reader *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("frame of the client not masked")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		extended := make([]byte, 2)
+		_, _ = io.ReadFull(reader, extended)
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	mask := make([]byte, 4)
+	_, _ = io.ReadFull(reader, mask)
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for index := range payload {
+		payload[index] ^= mask[index%4]
+	}
+	return header[0] & 0x0F, payload, nil
+}
+
+// serverFrame gives a frame of the server, unmasked
+func serverFrame(fin bool, opcode byte, payload string) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	return append([]byte{first, byte(len(payload))}, payload...)
+}
+
+func TestWebSocketConnectionStreamsFrames(t *testing.T) {
+	frame := "\x021H|\\^&\r\x03E5\r\n"
+	pongs := make(chan string, 1)
+	written := make(chan string, 1)
+	url, config := startWebSocketServer(t, true, func(conn net.Conn, reader *bufio.Reader, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer token" {
+			return
+		}
+		// a frame split over a message of two frames, with a ping in between
+		_, _ = conn.Write(serverFrame(true, 0x2, "\x05"))
+		_, _ = conn.Write(serverFrame(false, 0x2, frame[:6]))
+		_, _ = conn.Write(serverFrame(true, 0x9, "alive"))
+		_, _ = conn.Write(serverFrame(true, 0x0, frame[6:]))
+		if opcode, payload, err := readClientFrame(reader); err == nil && opcode == 0xA {
+			pongs <- string(payload)
+		}
+		if opcode, payload, err := readClientFrame(reader); err == nil && opcode == 0x2 {
+			written <- string(payload)
+		}
+		_, _ = conn.Write(serverFrame(true, 0x8, "\x03\xe8"))
+		_, _, _ = readClientFrame(reader)
+	})
+
+	wsConn := connection.NewWebSocketConnection(url, connection.WebSocketOptions{
+		TLSConfig: config,
+		Header:    http.Header{"Authorization": {"Bearer token"}},
+	})
+	if err := wsConn.Connect(); err != nil {
+		t.Fatalf("Failed to connect to the WebSocket server: %v", err)
+	}
+	defer wsConn.Disconnect()
+	wsConn.Listen()
+
+	for _, expected := range []string{"\x05", frame} {
+		str, err := wsConn.ReadStringFromConnection()
+		if err != nil {
+			t.Fatalf("Unexpected error reading %q: %v", expected, err)
+		}
+		if str != expected {
+			t.Fatalf("Expected %q, got %q", expected, str)
+		}
+	}
+	if pong := <-pongs; pong != "alive" {
+		t.Fatalf("Expected the ping to be answered with its payload, got %q", pong)
+	}
+	if err := wsConn.Write([]byte{constants.ACK}); err != nil {
+		t.Fatalf("Failed to write ACK: %v", err)
+	}
+	if message := <-written; message != "\x06" {
+		t.Fatalf("Expected ACK in a binary message, got %q", message)
+	}
+	if _, err := wsConn.ReadStringFromConnection(); !errors.Is(err, connection.ErrConnectionClosed) {
+		t.Fatalf("Expected ErrConnectionClosed once the server closed the WebSocket, got %v", err)
+	}
+}
+
+func TestWebSocketConnectionRefused(t *testing.T) {
+	url, _ := startWebSocketServer(t, false, func(net.Conn, *bufio.Reader, *http.Request) {})
+	wsConn := connection.NewWebSocketConnection(url+"/", connection.WebSocketOptions{
+		Subprotocols: []string{"astm"},
+	})
+	if err := wsConn.Connect(); !errors.Is(err, connection.ErrWebSocketHandshake) {
+		t.Fatalf("Expected ErrWebSocketHandshake when the server picks no subprotocol, got %v", err)
+	}
+
+	// plain HTTP without an upgrade
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	wsConn = connection.NewWebSocketConnection("ws"+strings.TrimPrefix(server.URL, "http"), connection.WebSocketOptions{})
+	if err := wsConn.Connect(); !errors.Is(err, connection.ErrWebSocketHandshake) {
+		t.Fatalf("Expected ErrWebSocketHandshake when the server does not upgrade, got %v", err)
+	}
+}